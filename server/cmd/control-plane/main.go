@@ -3,18 +3,23 @@ package main
 import (
 	"context"
 	"fmt"
-	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/novusgate/novusgate/internal/controlplane/api/rest"
+	"github.com/novusgate/novusgate/internal/controlplane/prober"
+	"github.com/novusgate/novusgate/internal/controlplane/reports"
 	"github.com/novusgate/novusgate/internal/controlplane/store"
+	"github.com/novusgate/novusgate/internal/controlplane/wgimport"
+	"github.com/novusgate/novusgate/internal/geoip"
 	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/shared/netutil"
 	"github.com/novusgate/novusgate/internal/wireguard"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -23,6 +28,7 @@ import (
 
 var (
 	cfgFile string
+	// version is overridden at build time: -ldflags "-X main.version=1.2.3"
 	version = "0.1.0"
 )
 
@@ -58,6 +64,29 @@ var initCmd = &cobra.Command{
 	RunE:  initNetwork,
 }
 
+var importCmd = &cobra.Command{
+	Use:   "import SERVER_CONF [CLIENT_CONF...]",
+	Short: "Import a WireGuard server config and its client configs as a network",
+	Long: `Import creates a network from an existing wg-quick server config, keeping
+its private key, port and address range, and a node for each [Peer]. Client
+configs, matched to peers by their keys, add the nodes' client settings; the
+file name becomes the node name. Their private keys are not stored.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: importNetwork,
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export NETWORK",
+	Short: "Export the configs of all nodes of a network as an archive",
+	Long: `Export writes a zip or tar.gz archive with the config and install script
+of every node of a network (by name or ID), plus a manifest.json with the
+nodes' addresses and expiry. Private keys are not stored: the configs carry a
+placeholder the install scripts fill from the key on the node. A password
+(--password or NOVUSGATE_EXPORT_PASSWORD) encrypts the zip entries with AES-256.`,
+	Args: cobra.ExactArgs(1),
+	RunE: exportNetwork,
+}
+
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print version",
@@ -71,29 +100,67 @@ func init() {
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default: /etc/novusgate/server.yaml)")
+	rootCmd.PersistentFlags().String("wireguard-backend", wireguard.BackendNetlink, "WireGuard backend: netlink, cli or simulated")
 	
 	// Serve command flags
 	serveCmd.Flags().String("listen", ":8080", "HTTP listen address")
 	serveCmd.Flags().String("grpc-listen", ":8443", "gRPC listen address")
 	serveCmd.Flags().String("database", "", "Database connection string")
+	serveCmd.Flags().String("geoip-db", "", "MaxMind-format GeoIP City/Country database (.mmdb)")
+	serveCmd.Flags().String("geoip-asn-db", "", "MaxMind-format GeoIP ASN database (.mmdb)")
+	serveCmd.Flags().Duration("reconcile-interval", rest.DefaultReconcileInterval, "How often live WireGuard peers are reconciled with the database (0 disables)")
+	serveCmd.Flags().String("unknown-peer-policy", rest.UnknownPeerReport, "What to do with live peers unknown to the database: report, import or remove")
+	serveCmd.Flags().String("report-schedule", "monthly", "Scheduled report period: daily, weekly, monthly or none")
+	serveCmd.Flags().String("port-range", fmt.Sprintf("%d-%d", rest.DefaultPortRangeStart, rest.DefaultPortRangeEnd), "UDP port range for new networks")
+	serveCmd.Flags().String("interface-prefix", rest.DefaultInterfacePrefix, "Interface name prefix for new networks (<prefix>0, <prefix>1, ...)")
+	serveCmd.Flags().String("probe-method", prober.MethodAuto, "Node latency probes: auto (ICMP, UDP without ICMP sockets), icmp, udp or none")
+	serveCmd.Flags().Duration("probe-retention", rest.DefaultProbeRetention, "How long node latency probe results are kept")
 	
 	// Init command flags
 	initCmd.Flags().String("name", "", "Network name (required)")
 	initCmd.Flags().String("cidr", "10.99.0.0/24", "Network CIDR")
+	initCmd.Flags().String("cidr6", "", "Optional IPv6 CIDR for a dual-stack network (e.g. fd00:99::/64)")
 	initCmd.Flags().String("database", "", "Database connection string")
 	initCmd.MarkFlagRequired("name")
 
+	// Import command flags
+	importCmd.Flags().String("name", "", "Network name (default: the server config file name)")
+	importCmd.Flags().String("endpoint", "", "Public host or host:port of the hub (default: the clients' Endpoint)")
+	importCmd.Flags().String("interface", "", "Interface name (default: the next free <prefix><n>)")
+	importCmd.Flags().String("interface-prefix", rest.DefaultInterfacePrefix, "Interface name prefix used when --interface is not set")
+	importCmd.Flags().Bool("dry-run", false, "Only print the import plan")
+	importCmd.Flags().String("database", "", "Database connection string")
+
+	// Export command flags
+	exportCmd.Flags().StringP("output", "o", "", "Archive path (default: <network>-configs.<format>)")
+	exportCmd.Flags().String("format", rest.ExportFormatZip, "Archive format: zip or tar.gz")
+	exportCmd.Flags().String("password", "", "Encrypt the archive with age using this passphrase (adds .age)")
+	exportCmd.Flags().String("api-url", "", "API URL the install scripts check in with (default: http://<WG_SERVER_ENDPOINT>:8080/api/v1)")
+	exportCmd.Flags().String("database", "", "Database connection string")
+
 	// Migrate command flags
 	migrateCmd.Flags().String("database", "", "Database connection string")
 
 	// Bind flags to viper
+	viper.BindPFlag("wireguard_backend", rootCmd.PersistentFlags().Lookup("wireguard-backend"))
 	viper.BindPFlag("listen", serveCmd.Flags().Lookup("listen"))
 	viper.BindPFlag("grpc_listen", serveCmd.Flags().Lookup("grpc-listen"))
 	viper.BindPFlag("database_url", serveCmd.Flags().Lookup("database"))
+	viper.BindPFlag("geoip_database", serveCmd.Flags().Lookup("geoip-db"))
+	viper.BindPFlag("geoip_asn_database", serveCmd.Flags().Lookup("geoip-asn-db"))
+	viper.BindPFlag("report_schedule", serveCmd.Flags().Lookup("report-schedule"))
+	viper.BindPFlag("reconcile_interval", serveCmd.Flags().Lookup("reconcile-interval"))
+	viper.BindPFlag("unknown_peer_policy", serveCmd.Flags().Lookup("unknown-peer-policy"))
+	viper.BindPFlag("port_range", serveCmd.Flags().Lookup("port-range"))
+	viper.BindPFlag("interface_prefix", serveCmd.Flags().Lookup("interface-prefix"))
+	viper.BindPFlag("probe_method", serveCmd.Flags().Lookup("probe-method"))
+	viper.BindPFlag("probe_retention", serveCmd.Flags().Lookup("probe-retention"))
 
 	rootCmd.AddCommand(serveCmd)
 	rootCmd.AddCommand(migrateCmd)
 	rootCmd.AddCommand(initCmd)
+	rootCmd.AddCommand(importCmd)
+	rootCmd.AddCommand(exportCmd)
 	rootCmd.AddCommand(versionCmd)
 }
 
@@ -160,8 +227,82 @@ func runServer(cmd *cobra.Command, args []string) error {
 		fmt.Printf("Warning: Failed to bootstrap system: %v\n", err)
 	}
 
+	// Optional GeoIP database
+	var geoDB *geoip.DB
+	geoPath := viper.GetString("geoip_database")
+	geoASNPath := viper.GetString("geoip_asn_database")
+	if geoPath != "" || geoASNPath != "" {
+		geoDB, err = geoip.Open(geoPath, geoASNPath)
+		if err != nil {
+			fmt.Printf("Warning: GeoIP disabled: %v\n", err)
+		} else {
+			defer geoDB.Close()
+			fmt.Println("  GeoIP: Enabled")
+		}
+	}
+
+	wgBackend := viper.GetString("wireguard_backend")
+	if !wireguard.ValidBackend(wgBackend) {
+		return fmt.Errorf("invalid wireguard backend %q: must be netlink, cli or simulated", wgBackend)
+	}
+	fmt.Printf("  WireGuard backend: %s\n", wgBackend)
+
+	// Peer reconciliation
+	reconcileInterval := viper.GetDuration("reconcile_interval")
+	if reconcileInterval == 0 {
+		reconcileInterval = -1 // Explicitly disabled
+	}
+	unknownPeerPolicy := viper.GetString("unknown_peer_policy")
+	if !rest.ValidUnknownPeerPolicy(unknownPeerPolicy) {
+		return fmt.Errorf("invalid unknown peer policy %q: must be import, remove or report", unknownPeerPolicy)
+	}
+
+	// Port and interface allocation of new networks
+	portRangeStart, portRangeEnd, err := rest.ParsePortRange(viper.GetString("port_range"))
+	if err != nil {
+		return err
+	}
+	interfacePrefix := viper.GetString("interface_prefix")
+	if !rest.ValidInterfacePrefix(interfacePrefix) {
+		return fmt.Errorf("invalid interface prefix %q: 1-10 letters, digits, _ or -, starting with a letter", interfacePrefix)
+	}
+	fmt.Printf("  Network ports: %d-%d, interfaces: %s<n>\n", portRangeStart, portRangeEnd, interfacePrefix)
+
+	// Node latency probing
+	probeMethod := viper.GetString("probe_method")
+	switch probeMethod {
+	case prober.MethodAuto, prober.MethodICMP, prober.MethodUDP, rest.ProbeMethodNone:
+	default:
+		return fmt.Errorf("invalid probe method %q: must be auto, icmp, udp or none", probeMethod)
+	}
+	probeRetention := viper.GetDuration("probe_retention")
+	if probeRetention <= 0 {
+		return fmt.Errorf("probe retention must be positive")
+	}
+
+	// Scheduled reports
+	reportSchedule := viper.GetString("report_schedule")
+	if reportSchedule == "none" {
+		reportSchedule = ""
+	}
+	if reportSchedule != "" && !reports.ValidSchedule(reportSchedule) {
+		return fmt.Errorf("invalid report schedule %q: must be daily, weekly, monthly or none", reportSchedule)
+	}
+
 	// Create REST API server (WireGuard managers are initialized internally by loadNetworks)
-	apiServer := rest.NewServer(db)
+	apiServer := rest.NewServer(db, rest.Config{
+		GeoIP:             geoDB,
+		Version:           version,
+		ReportSchedule:    reportSchedule,
+		WireGuardBackend:  wgBackend,
+		ReconcileInterval: reconcileInterval,
+		UnknownPeerPolicy: unknownPeerPolicy,
+		PortRangeStart:    portRangeStart,
+		PortRangeEnd:      portRangeEnd,
+		InterfacePrefix:   interfacePrefix,
+		ProbeMethod:       probeMethod,
+		ProbeRetention:    probeRetention,
+	})
 
 	// Ensure Admin Network manager is registered after bootstrap
 	// This handles the case where bootstrapSystem creates the network after loadNetworks runs
@@ -251,6 +392,7 @@ func runMigrations(cmd *cobra.Command, args []string) error {
 func initNetwork(cmd *cobra.Command, args []string) error {
 	name, _ := cmd.Flags().GetString("name")
 	cidr, _ := cmd.Flags().GetString("cidr")
+	cidr6, _ := cmd.Flags().GetString("cidr6")
 	databaseURL, _ := cmd.Flags().GetString("database")
 	
 	if databaseURL == "" {
@@ -285,9 +427,11 @@ func initNetwork(cmd *cobra.Command, args []string) error {
 	network := &models.Network{
 		Name:             name,
 		CIDR:             cidr,
+		CIDR6:            cidr6,
 		ServerPrivateKey: privateKey,
 		ServerPublicKey:  publicKey,
 		ServerEndpoint:   fmt.Sprintf("%s:51820", serverEndpoint),
+		ProbeInterval:    models.DefaultProbeInterval,
 	}
 	
 	if err := db.CreateNetwork(ctx, network); err != nil {
@@ -314,19 +458,22 @@ func initNetwork(cmd *cobra.Command, args []string) error {
 		fmt.Printf("  ID: %s\n", network.ID)
 		fmt.Printf("  Name: %s\n", network.Name)
 		fmt.Printf("  CIDR: %s\n", network.CIDR)
+		if network.CIDR6 != "" {
+			fmt.Printf("  IPv6 CIDR: %s\n", network.CIDR6)
+		}
 		fmt.Printf("  Server Public Key: %s\n", network.ServerPublicKey)
 		fmt.Printf("  Server Endpoint: %s\n", network.ServerEndpoint)
 	}
 	
 	// Initialize WireGuard for this network
 	// Initialize WireGuard for this network
-	wgManager := wireguard.NewManager("wg0")
-	// Calculate server IP (first IP in CIDR)
-	_, ipNet, _ := net.ParseCIDR(network.CIDR)
-	ip := ipNet.IP
-	// Increment IP to get .1 (hacky increment for IPv4)
-	ip[len(ip)-1]++ 
-	serverIP := fmt.Sprintf("%s/%s", ip.String(), strings.Split(network.CIDR, "/")[1])
+	wgManager := wireguard.NewBackend(viper.GetString("wireguard_backend"), "wg0")
+	// Calculate server IPs (first IP in each CIDR)
+	serverAddrs, err := netutil.ServerAddresses(network.CIDRs()...)
+	if err != nil {
+		return fmt.Errorf("invalid network CIDR: %w", err)
+	}
+	serverIP := strings.Join(serverAddrs, ", ")
 	serverPort := 51820
 	
 	// Create WireGuard config using the network's private key
@@ -345,6 +492,197 @@ func initNetwork(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+func importNetwork(cmd *cobra.Command, args []string) error {
+	name, _ := cmd.Flags().GetString("name")
+	endpoint, _ := cmd.Flags().GetString("endpoint")
+	interfaceName, _ := cmd.Flags().GetString("interface")
+	interfacePrefix, _ := cmd.Flags().GetString("interface-prefix")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	databaseURL, _ := cmd.Flags().GetString("database")
+	if databaseURL == "" {
+		databaseURL = viper.GetString("database_url")
+	}
+	if databaseURL == "" {
+		databaseURL = os.Getenv("DATABASE_URL")
+	}
+	if databaseURL == "" {
+		return fmt.Errorf("database connection string is required")
+	}
+
+	serverConfig, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(args[0]), filepath.Ext(args[0]))
+	}
+	var clients []wgimport.ClientFile
+	for _, path := range args[1:] {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		clients = append(clients, wgimport.ClientFile{
+			Name:   strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+			Config: string(content),
+		})
+	}
+
+	db, err := store.New(databaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	existing, err := db.ListNetworks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
+	}
+	if interfaceName == "" {
+		if !rest.ValidInterfacePrefix(interfacePrefix) {
+			return fmt.Errorf("invalid --interface-prefix %q: 1-10 letters, digits, _ or -, starting with a letter", interfacePrefix)
+		}
+		interfaceName = rest.NextInterfaceName(interfacePrefix, existing)
+	} else if !rest.ValidInterfaceName(interfaceName) {
+		return fmt.Errorf("invalid --interface %q: must be a prefix of a letter and up to 9 letters, digits, '_' or '-' followed by an index, at most 15 characters", interfaceName)
+	}
+	plan, err := wgimport.Build(wgimport.Options{
+		Name:          name,
+		ServerConfig:  string(serverConfig),
+		Clients:       clients,
+		Endpoint:      endpoint,
+		InterfaceName: interfaceName,
+	})
+	if err != nil {
+		return err
+	}
+	plan.Conflicts = append(plan.Conflicts, rest.ImportConflicts(ctx, db, plan, existing)...)
+
+	network := plan.Network
+	fmt.Printf("Network %s: %s, port %d, interface %s, endpoint %s\n",
+		network.Name, strings.Join(network.CIDRs(), ", "), network.ListenPort, network.InterfaceName, network.ServerEndpoint)
+	for _, peer := range plan.Peers {
+		clientNote := "no client config"
+		if peer.ClientFile != "" {
+			clientNote = "client config " + peer.ClientFile
+		}
+		fmt.Printf("  %-24s %-16s %s (%s)\n", peer.Name, peer.VirtualIP, peer.PublicKey, clientNote)
+	}
+	for _, warning := range plan.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	for _, conflict := range plan.Conflicts {
+		fmt.Printf("Conflict: %s\n", conflict)
+	}
+	if len(plan.Conflicts) > 0 {
+		return fmt.Errorf("import aborted: %d conflict(s)", len(plan.Conflicts))
+	}
+	if dryRun {
+		fmt.Println("Dry run: nothing imported")
+		return nil
+	}
+
+	if err := db.ImportNetwork(ctx, network, plan.Nodes); err != nil {
+		return fmt.Errorf("failed to import network: %w", err)
+	}
+	fmt.Printf("Network imported (ID: %s) with %d node(s)\n", network.ID, len(plan.Nodes))
+
+	// Serve the network right away; the old interface must be down so the port is free
+	wgManager := wireguard.NewBackend(viper.GetString("wireguard_backend"), network.InterfaceName)
+	serverAddrs, err := netutil.ServerAddresses(network.CIDRs()...)
+	if err != nil {
+		return fmt.Errorf("invalid network CIDR: %w", err)
+	}
+	if err := wgManager.CreateServerConfigWithKey(network.ServerPrivateKey, strings.Join(serverAddrs, ", "), network.ListenPort); err != nil {
+		fmt.Printf("Warning: Failed to create WireGuard config: %v\n", err)
+		return nil
+	}
+	if err := wgManager.AddPeers(plan.PeerConfigs()); err != nil {
+		fmt.Printf("Warning: Failed to add peers: %v\n", err)
+	}
+	fmt.Printf("WireGuard interface %s started on port %d\n", network.InterfaceName, network.ListenPort)
+	return nil
+}
+
+func exportNetwork(cmd *cobra.Command, args []string) error {
+	output, _ := cmd.Flags().GetString("output")
+	format, _ := cmd.Flags().GetString("format")
+	password, _ := cmd.Flags().GetString("password")
+	apiURL, _ := cmd.Flags().GetString("api-url")
+	databaseURL, _ := cmd.Flags().GetString("database")
+	if databaseURL == "" {
+		databaseURL = viper.GetString("database_url")
+	}
+	if databaseURL == "" {
+		databaseURL = os.Getenv("DATABASE_URL")
+	}
+	if databaseURL == "" {
+		return fmt.Errorf("database connection string is required")
+	}
+	if password == "" {
+		password = os.Getenv("NOVUSGATE_EXPORT_PASSWORD")
+	}
+	if apiURL == "" {
+		apiURL = fmt.Sprintf("http://%s:8080/api/v1", wireguard.GetServerEndpoint())
+	}
+
+	db, err := store.New(databaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	network, err := db.GetNetworkByName(ctx, args[0])
+	if err != nil {
+		return fmt.Errorf("failed to get network: %w", err)
+	}
+	if network == nil {
+		if _, parseErr := uuid.Parse(args[0]); parseErr == nil {
+			if network, err = db.GetNetwork(ctx, args[0]); err != nil {
+				return fmt.Errorf("failed to get network: %w", err)
+			}
+		}
+	}
+	if network == nil {
+		return fmt.Errorf("network %q not found", args[0])
+	}
+
+	if output == "" {
+		name := strings.Map(func(r rune) rune {
+			if r == '/' || r == ' ' {
+				return '_'
+			}
+			return r
+		}, network.Name)
+		output = name + "-configs" + rest.ExportFileExtension(strings.ToLower(format), password != "")
+	}
+	file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	manifest, err := rest.ExportNetwork(ctx, db, network, file, rest.ExportOptions{
+		Format:   format,
+		Password: password,
+		APIURL:   apiURL,
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(output)
+		return fmt.Errorf("failed to export configs: %w", err)
+	}
+
+	for _, node := range manifest.Nodes {
+		fmt.Printf("  %-24s %-16s %s\n", node.Name, node.VirtualIP, node.Status)
+	}
+	fmt.Printf("Exported %d config(s) of %s to %s (%d incomplete, %d skipped; see manifest.json)\n",
+		manifest.Exported, network.Name, output, manifest.Incomplete, manifest.Skipped)
+	return nil
+}
+
 func runMigrationSQL(db *store.Store) error {
 	// Migrations are handled by the store package
 	// This is a placeholder for when we add proper migration support
@@ -399,6 +737,7 @@ func bootstrapSystem(db *store.Store) error {
 	if cidr == "" {
 		return fmt.Errorf("ADMIN_CIDR environment variable is required (check .env)")
 	}
+	cidr6 := os.Getenv("ADMIN_CIDR6") // Optional, makes the admin network dual-stack
 	port := 51820
 	serverEndpoint := wireguard.GetServerEndpoint()
 
@@ -444,12 +783,10 @@ func bootstrapSystem(db *store.Store) error {
 
 		// Step 2: Derive public key from private key (or get from running interface)
 		if privateKey != "" {
-			// Derive public key from private key using wg pubkey
-			cmd := exec.Command("wg", "pubkey")
-			cmd.Stdin = strings.NewReader(privateKey)
-			output, err := cmd.Output()
+			// Derive public key from private key
+			derived, err := wireguard.PublicKeyFromPrivate(privateKey)
 			if err == nil {
-				publicKey = strings.TrimSpace(string(output))
+				publicKey = derived
 				fmt.Printf("Derived public key: %s\n", publicKey)
 			} else {
 				fmt.Printf("Warning: Failed to derive public key: %v\n", err)
@@ -458,7 +795,7 @@ func bootstrapSystem(db *store.Store) error {
 		
 		// Step 3: Fallback - try to get public key from running interface
 		if publicKey == "" {
-			mgr := wireguard.NewManager("wg0")
+			mgr := wireguard.NewBackend(viper.GetString("wireguard_backend"), "wg0")
 			if err := mgr.Init(); err == nil {
 				if existingPubKey, err := mgr.GetPublicKey(); err == nil && existingPubKey != "" {
 					publicKey = existingPubKey
@@ -481,11 +818,13 @@ func bootstrapSystem(db *store.Store) error {
 			ID:               "00000000-0000-0000-0000-000000000001",
 			Name:             "Admin Management Network",
 			CIDR:             cidr,
+			CIDR6:            cidr6,
 			ListenPort:       port,
 			InterfaceName:    "wg0",
 			ServerPrivateKey: privateKey,
 			ServerPublicKey:  publicKey,
 			ServerEndpoint:   fmt.Sprintf("%s:%d", serverEndpoint, port),
+			ProbeInterval:    models.DefaultProbeInterval,
 		}
 
 		if err := db.CreateNetwork(ctx, network); err != nil {
@@ -514,11 +853,8 @@ func bootstrapSystem(db *store.Store) error {
 			
 			// Derive public key
 			if actualPrivateKey != "" {
-				cmd := exec.Command("wg", "pubkey")
-				cmd.Stdin = strings.NewReader(actualPrivateKey)
-				output, err := cmd.Output()
-				if err == nil {
-					actualPublicKey = strings.TrimSpace(string(output))
+				if derived, err := wireguard.PublicKeyFromPrivate(actualPrivateKey); err == nil {
+					actualPublicKey = derived
 				}
 			}
 			
@@ -550,6 +886,12 @@ func bootstrapSystem(db *store.Store) error {
 				fmt.Println("SUCCESS: Network configuration updated. Please restart the service or reboot if issues persist.")
 			}
 		}
+		if cidr6 != "" && adminNet.CIDR6 != cidr6 {
+			fmt.Printf("Admin Network (wg0) IPv6 CIDR (%s) differs from ENV config (%s), updating...\n", adminNet.CIDR6, cidr6)
+			if err := db.UpdateNetworkCIDR6(ctx, adminNet.ID, cidr6); err != nil {
+				fmt.Printf("failed to update network IPv6 CIDR: %v\n", err)
+			}
+		}
 	}
 
 	return nil
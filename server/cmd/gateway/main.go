@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/wireguard"
+	"github.com/spf13/cobra"
+)
+
+// vpnRuleMarker is the iptables comment prefix of VPN firewall rules, shared
+// with the control plane
+const vpnRuleMarker = "novusgate-vpn-"
+
+var (
+	// version is overridden at build time: -ldflags "-X main.version=1.2.3"
+	version = "0.1.0"
+
+	serverURL string
+	token     string
+	interval  time.Duration
+	backend   string
+)
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+var rootCmd = &cobra.Command{
+	Use:   "novusgate-gateway",
+	Short: "novusgate Gateway Agent",
+	Long: `novusgate Gateway Agent runs a network gateway on a remote host:
+it applies the WireGuard interface, peers and VPN firewall rules served by
+the control plane and reports peer status back.`,
+	RunE: runAgent,
+}
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print version",
+	Run: func(cmd *cobra.Command, args []string) {
+		fmt.Printf("novusgate Gateway Agent v%s\n", version)
+	},
+}
+
+func init() {
+	rootCmd.Flags().StringVar(&serverURL, "server", os.Getenv("NOVUSGATE_SERVER"), "control plane URL, e.g. https://vpn.example.com")
+	rootCmd.Flags().StringVar(&token, "token", os.Getenv("NOVUSGATE_GATEWAY_TOKEN"), "gateway agent token")
+	rootCmd.Flags().DurationVar(&interval, "interval", 10*time.Second, "sync interval")
+	rootCmd.Flags().StringVar(&backend, "wireguard-backend", wireguard.BackendNetlink, "WireGuard backend: netlink, cli or simulated")
+
+	rootCmd.AddCommand(versionCmd)
+}
+
+// agent keeps the host in sync with the gateway state of the control plane
+type agent struct {
+	client   *http.Client
+	wg       wireguard.Backend
+	iface    models.GatewayInterface
+	firewall []models.FirewallCommand
+	active   *bool
+}
+
+func runAgent(cmd *cobra.Command, args []string) error {
+	if serverURL == "" || token == "" {
+		return fmt.Errorf("--server and --token are required")
+	}
+	if !wireguard.ValidBackend(backend) {
+		return fmt.Errorf("invalid wireguard backend %q", backend)
+	}
+	serverURL = strings.TrimSuffix(serverURL, "/")
+
+	if backend != wireguard.BackendSimulated {
+		runCommand("sysctl", "-w", "net.ipv4.ip_forward=1")
+		runCommand("sysctl", "-w", "net.ipv6.conf.all.forwarding=1")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a := &agent{client: &http.Client{Timeout: 15 * time.Second}}
+	fmt.Printf("Gateway agent v%s syncing with %s every %s\n", version, serverURL, interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := a.sync(ctx); err != nil {
+			fmt.Printf("Warning: sync failed: %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			fmt.Println("Gateway agent stopped")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync fetches the desired state, applies it and reports peer status
+func (a *agent) sync(ctx context.Context) error {
+	var state models.GatewayState
+	if err := a.request(ctx, "GET", "/api/v1/gateway/state", nil, &state); err != nil {
+		return fmt.Errorf("failed to fetch state: %w", err)
+	}
+
+	if a.active == nil || *a.active != state.Active {
+		if state.Active {
+			fmt.Println("This gateway is active: clients route through it")
+		} else {
+			fmt.Println("This gateway is on standby")
+		}
+		a.active = &state.Active
+	}
+
+	if err := a.applyInterface(state.Interface); err != nil {
+		return fmt.Errorf("failed to apply interface: %w", err)
+	}
+	if err := a.applyPeers(state.Peers); err != nil {
+		return fmt.Errorf("failed to apply peers: %w", err)
+	}
+	if err := a.applyFirewall(state.Firewall); err != nil {
+		return fmt.Errorf("failed to apply firewall rules: %w", err)
+	}
+	return a.report(ctx)
+}
+
+// applyInterface (re)configures the WireGuard interface when its settings changed
+func (a *agent) applyInterface(iface models.GatewayInterface) error {
+	if a.wg != nil && a.iface == iface {
+		return nil
+	}
+	if a.wg != nil && a.iface.Name != iface.Name {
+		if err := a.wg.Down(); err != nil {
+			fmt.Printf("Warning: failed to bring down %s: %v\n", a.iface.Name, err)
+		}
+	}
+
+	wg := wireguard.NewBackend(backend, iface.Name)
+	if err := wg.Init(); err != nil {
+		return err
+	}
+	if err := wg.CreateServerConfigWithKey(iface.PrivateKey, iface.Address, iface.ListenPort); err != nil {
+		return err
+	}
+	fmt.Printf("Interface %s configured (%s, port %d)\n", iface.Name, iface.Address, iface.ListenPort)
+	a.wg = wg
+	a.iface = iface
+	return nil
+}
+
+// applyPeers upserts the desired peers and removes all others
+func (a *agent) applyPeers(peers []models.GatewayPeer) error {
+	current, err := a.wg.GetPeers()
+	if err != nil {
+		return err
+	}
+
+	desired := make(map[string]bool, len(peers))
+	upsert := make([]wireguard.PeerConfig, 0, len(peers))
+	for _, peer := range peers {
+		desired[peer.PublicKey] = true
+		upsert = append(upsert, wireguard.PeerConfig{
+			PublicKey:    peer.PublicKey,
+			PresharedKey: peer.PresharedKey,
+			AllowedIPs:   peer.AllowedIPs,
+		})
+	}
+	var remove []string
+	for key := range current {
+		if !desired[key] {
+			remove = append(remove, key)
+		}
+	}
+
+	if len(upsert) > 0 {
+		if err := a.wg.AddPeers(upsert); err != nil {
+			return err
+		}
+	}
+	if len(remove) > 0 {
+		if err := a.wg.RemovePeers(remove); err != nil {
+			return err
+		}
+		fmt.Printf("Removed %d peer(s)\n", len(remove))
+	}
+	return nil
+}
+
+// applyFirewall replaces the VPN firewall rules in FORWARD when they changed
+func (a *agent) applyFirewall(commands []models.FirewallCommand) error {
+	if a.firewall != nil && reflect.DeepEqual(a.firewall, commands) {
+		return nil
+	}
+	if backend == wireguard.BackendSimulated {
+		a.firewall = commands
+		return nil
+	}
+
+	if err := clearCommentedRules("iptables", vpnRuleMarker); err != nil {
+		return err
+	}
+	// ip6tables may be unavailable on IPv4-only hosts
+	if err := clearCommentedRules("ip6tables", vpnRuleMarker); err != nil {
+		fmt.Printf("Warning: failed to clear IPv6 firewall rules: %v\n", err)
+	}
+	for _, command := range commands {
+		if command.Command != "iptables" && command.Command != "ip6tables" {
+			return fmt.Errorf("unexpected firewall command %q", command.Command)
+		}
+		if _, err := runCommand(command.Command, command.Args...); err != nil {
+			return fmt.Errorf("%s command failed: %w", command.Command, err)
+		}
+	}
+	fmt.Printf("Applied %d firewall rule(s)\n", len(commands))
+	a.firewall = commands
+	return nil
+}
+
+// report sends the live peer status to the control plane
+func (a *agent) report(ctx context.Context) error {
+	peers, err := a.wg.GetPeers()
+	if err != nil {
+		return fmt.Errorf("failed to read peers: %w", err)
+	}
+	stats := make([]models.GatewayPeerStats, 0, len(peers))
+	for _, peer := range peers {
+		stats = append(stats, models.GatewayPeerStats{
+			PublicKey:       peer.PublicKey,
+			Endpoint:        peer.Endpoint,
+			LatestHandshake: peer.LatestHandshakeTime,
+			TransferRx:      peer.TransferRx,
+			TransferTx:      peer.TransferTx,
+		})
+	}
+	body := map[string]interface{}{"peers": stats}
+	if err := a.request(ctx, "POST", "/api/v1/gateway/report", body, nil); err != nil {
+		return fmt.Errorf("failed to report peers: %w", err)
+	}
+	return nil
+}
+
+// request calls the gateway agent API, decoding the response into out
+func (a *agent) request(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, serverURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, apiErr.Error)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+// clearCommentedRules deletes the FORWARD rules whose comment contains marker
+func clearCommentedRules(command, marker string) error {
+	output, err := runCommand(command, "-L", "FORWARD", "-n", "-v", "--line-numbers")
+	if err != nil {
+		return err
+	}
+
+	var lineNumbers []int
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, marker) {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			if num, err := strconv.Atoi(fields[0]); err == nil {
+				lineNumbers = append(lineNumbers, num)
+			}
+		}
+	}
+
+	// Delete in reverse order to maintain line numbers
+	for i := len(lineNumbers) - 1; i >= 0; i-- {
+		runCommand(command, "-D", "FORWARD", strconv.Itoa(lineNumbers[i]))
+	}
+	return nil
+}
+
+// runCommand runs a host command and returns its output
+func runCommand(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).Output()
+	return string(out), err
+}
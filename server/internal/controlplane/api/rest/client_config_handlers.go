@@ -0,0 +1,98 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+// dnsSearchDomainPattern matches the search domains allowed next to DNS servers
+var dnsSearchDomainPattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?\.)*[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// validateClientConfig checks client config options before they are stored
+func validateClientConfig(options *models.ClientConfigOptions) error {
+	for _, dns := range options.DNS {
+		if net.ParseIP(dns) == nil && !dnsSearchDomainPattern.MatchString(dns) {
+			return fmt.Errorf("invalid dns entry %q: must be an IP address or search domain", dns)
+		}
+	}
+	if options.MTU != 0 && (options.MTU < 1280 || options.MTU > 9000) {
+		return fmt.Errorf("mtu must be between 1280 and 9000")
+	}
+	if options.PersistentKeepalive != nil && (*options.PersistentKeepalive < 0 || *options.PersistentKeepalive > 65535) {
+		return fmt.Errorf("persistent_keepalive must be between 0 and 65535 seconds")
+	}
+	if options.ListenPort < 0 || options.ListenPort > 65535 {
+		return fmt.Errorf("listen_port must be between 0 and 65535")
+	}
+
+	switch options.AllowedIPsMode {
+	case "", models.AllowedIPsSplit, models.AllowedIPsFull:
+		if len(options.CustomAllowedIPs) > 0 {
+			return fmt.Errorf("custom_allowed_ips requires allowed_ips_mode custom")
+		}
+	case models.AllowedIPsCustom:
+		if len(options.CustomAllowedIPs) == 0 {
+			return fmt.Errorf("allowed_ips_mode custom requires custom_allowed_ips")
+		}
+		for _, cidr := range options.CustomAllowedIPs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid custom allowed IP %q: %v", cidr, err)
+			}
+		}
+	default:
+		return fmt.Errorf("allowed_ips_mode must be split, full or custom")
+	}
+	return nil
+}
+
+// handleUpdateNetworkClientConfig replaces the client config defaults of a
+// network. Configs fetched afterwards use the new options.
+func (s *Server) handleUpdateNetworkClientConfig(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var options models.ClientConfigOptions
+	if err := json.NewDecoder(r.Body).Decode(&options); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := validateClientConfig(&options); err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	network, err := s.store.GetNetwork(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+
+	// DNS leak rules only allow the client config's DNS servers
+	if network.Egress.BlockDNSLeaks && len(dnsServerIPs(options.DNS)) == 0 {
+		errorResponse(w, http.StatusBadRequest, "the network blocks DNS leaks, dns servers are required")
+		return
+	}
+
+	if err := s.store.UpdateNetworkClientConfig(r.Context(), id, options); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to update client config")
+		return
+	}
+	network.ClientConfig = options
+
+	if network.Egress.BlockDNSLeaks {
+		if err := s.syncEgressRules(r.Context()); err != nil {
+			fmt.Printf("Warning: failed to apply DNS leak rules for %s: %v\n", network.Name, err)
+		}
+	}
+
+	jsonResponse(w, http.StatusOK, network)
+}
@@ -1,38 +1,153 @@
 package rest
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/controlplane/store"
 	"github.com/novusgate/novusgate/internal/shared/models"
 	"github.com/novusgate/novusgate/internal/wireguard"
-	"github.com/skip2/go-qrcode"
 )
 
+// recordConfigFetch remembers which server key the config handed to a node
+// contains, so key rotations can tell which nodes still need a new config
+func (s *Server) recordConfigFetch(ctx context.Context, node *models.Node, serverPublicKey string) {
+	if !wireguard.ValidKey(serverPublicKey) {
+		return // Placeholder key, the config is unusable anyway
+	}
+	if err := s.store.MarkNodeConfigFetched(ctx, node.ID, serverPublicKey); err != nil {
+		fmt.Printf("Warning: failed to record config fetch for node %s: %v\n", node.Name, err)
+	}
+}
+
+// nodeClientAllowedIPs returns the AllowedIPs of the active hub peer in a
+// node's client config, per its AllowedIPs mode
+func (s *Server) nodeClientAllowedIPs(ctx context.Context, node *models.Node, options models.ClientConfigOptions) []string {
+	if node.ExitNode && options.AllowedIPsMode == models.AllowedIPsFull {
+		// An exit node sends internet traffic out itself, never to the hub
+		options.AllowedIPsMode = models.AllowedIPsSplit
+	}
+
+	var allowedIPs []string
+	switch options.AllowedIPsMode {
+	case models.AllowedIPsFull:
+		allowedIPs = []string{"0.0.0.0/0", "::/0"}
+	case models.AllowedIPsCustom:
+		allowedIPs = options.CustomAllowedIPs
+	default:
+		// Split tunnel: only networks reachable through VPN firewall rules,
+		// minus the node's own LAN subnets when it is a subnet router
+		own := make(map[string]bool)
+		for _, cidr := range node.ApprovedRoutes {
+			own[cidr] = true
+		}
+		for _, cidr := range s.getRoutedNetworksForNode(ctx, node.NetworkID) {
+			if !own[cidr] {
+				allowedIPs = append(allowedIPs, cidr)
+			}
+		}
+	}
+
+	if node.ExitNode {
+		// Accept traffic from the ranges it carries
+		networkSources, hostSources := s.exitNodeSources(ctx, node)
+		seen := make(map[string]bool)
+		for _, cidr := range allowedIPs {
+			seen[cidr] = true
+		}
+		for _, cidr := range append(networkSources, hostSources...) {
+			if !seen[cidr] {
+				seen[cidr] = true
+				allowedIPs = append(allowedIPs, cidr)
+			}
+		}
+	}
+	return allowedIPs
+}
+
+// generateNodeConfig renders a node's client config and records the fetch
+func (s *Server) generateNodeConfig(ctx context.Context, node *models.Node, network *models.Network, privateKey, serverPublicKey, serverEndpoint string) string {
+	config := s.renderNodeConfig(ctx, node, network, privateKey, serverPublicKey, serverEndpoint)
+	s.recordConfigFetch(ctx, node, serverPublicKey)
+	return config
+}
+
+// renderNodeConfig renders a node's client config with the network's client
+// config defaults merged with the node's overrides
+func (s *Server) renderNodeConfig(ctx context.Context, node *models.Node, network *models.Network, privateKey, serverPublicKey, serverEndpoint string) string {
+	options := network.ClientConfig.Merge(node.ClientConfig)
+	allowedIPs := s.nodeClientAllowedIPs(ctx, node, options)
+
+	peerOptions := wireguard.PeerConfigOptions{
+		DNS:                 options.DNS,
+		MTU:                 options.MTU,
+		ListenPort:          options.ListenPort,
+		PersistentKeepalive: options.Keepalive(),
+	}
+	if node.ExitNode {
+		// Forward + NAT the internet traffic of the nodes it carries
+		peerOptions.PostUp, peerOptions.PostDown = exitNodeHooks(node)
+	}
+
+	// The active gateway gets the AllowedIPs, the others are standby peers
+	active, standby := s.networkGatewayPeers(ctx, network, serverPublicKey, serverEndpoint)
+	for _, peer := range standby {
+		peer.PresharedKey = node.PresharedKey
+		peer.PersistentKeepalive = peerOptions.PersistentKeepalive
+		peerOptions.Peers = append(peerOptions.Peers, peer)
+	}
+	if network.Mesh {
+		// Direct peers take precedence over the hub for their addresses
+		peers, err := s.meshPeers(ctx, node, network)
+		if err != nil {
+			fmt.Printf("Warning: failed to list mesh peers for node %s: %v\n", node.Name, err)
+		}
+		peerOptions.Peers = append(peerOptions.Peers, meshPeerConfigs(peers, peerOptions.PersistentKeepalive)...)
+	}
+
+	cfgGen := wireguard.NewConfigGenerator()
+	return cfgGen.GeneratePeerConfig(
+		privateKey,
+		active.PublicKey,
+		node.PresharedKey,
+		active.Endpoint,
+		strings.Join(nodeHostRoutes(node), ", "),
+		strings.Join(allowedIPs, ", "),
+		peerOptions,
+	)
+}
+
 // getRoutedNetworksForNode returns all network CIDRs that this node can reach
 // based on VPN firewall rules
-func (s *Server) getRoutedNetworksForNode(r *http.Request, networkID string) []string {
+func (s *Server) getRoutedNetworksForNode(ctx context.Context, networkID string) []string {
 	// Start with own network
-	network, err := s.store.GetNetwork(r.Context(), networkID)
+	network, err := s.store.GetNetwork(ctx, networkID)
 	if err != nil || network == nil {
 		return []string{}
 	}
 	
-	cidrs := []string{network.CIDR}
-	cidrSet := map[string]bool{network.CIDR: true}
+	cidrs := network.CIDRs()
+	cidrSet := make(map[string]bool)
+	for _, cidr := range cidrs {
+		cidrSet[cidr] = true
+	}
 	
 	// Get all VPN firewall rules
-	rules, err := s.store.ListVPNFirewallRules(r.Context())
+	rules, err := s.store.ListVPNFirewallRules(ctx)
 	if err != nil {
 		return cidrs
 	}
 	
 	// Get all networks for lookup
-	networks, err := s.store.ListNetworks(r.Context())
+	networks, err := s.store.ListNetworks(ctx)
 	if err != nil {
 		return cidrs
 	}
@@ -56,9 +171,9 @@ func (s *Server) getRoutedNetworksForNode(r *http.Request, networkID string) []s
 			if rule.SourceNetworkID != nil && *rule.SourceNetworkID == networkID {
 				isSource = true
 			}
-		case "node":
+		case "node", "subnet":
 			if rule.SourceNodeID != nil {
-				node, _ := s.store.GetNode(r.Context(), *rule.SourceNodeID)
+				node, _ := s.store.GetNode(ctx, *rule.SourceNodeID)
 				if node != nil && node.NetworkID == networkID {
 					isSource = true
 				}
@@ -73,28 +188,55 @@ func (s *Server) getRoutedNetworksForNode(r *http.Request, networkID string) []s
 		switch rule.DestType {
 		case "any":
 			for _, n := range networks {
-				if !cidrSet[n.CIDR] {
-					cidrs = append(cidrs, n.CIDR)
-					cidrSet[n.CIDR] = true
+				for _, cidr := range n.CIDRs() {
+					if !cidrSet[cidr] {
+						cidrs = append(cidrs, cidr)
+						cidrSet[cidr] = true
+					}
+				}
+			}
+			if routes, err := s.store.ListSubnetRoutes(ctx, ""); err == nil {
+				for _, route := range routes {
+					if route.Approved && !cidrSet[route.CIDR] {
+						cidrs = append(cidrs, route.CIDR)
+						cidrSet[route.CIDR] = true
+					}
 				}
 			}
 		case "network":
 			if rule.DestNetworkID != nil {
 				if n, ok := networkMap[*rule.DestNetworkID]; ok {
-					if !cidrSet[n.CIDR] {
-						cidrs = append(cidrs, n.CIDR)
-						cidrSet[n.CIDR] = true
+					for _, cidr := range n.CIDRs() {
+						if !cidrSet[cidr] {
+							cidrs = append(cidrs, cidr)
+							cidrSet[cidr] = true
+						}
 					}
 				}
 			}
 		case "node":
 			if rule.DestNodeID != nil {
-				node, _ := s.store.GetNode(r.Context(), *rule.DestNodeID)
+				node, _ := s.store.GetNode(ctx, *rule.DestNodeID)
 				if node != nil {
 					if n, ok := networkMap[node.NetworkID]; ok {
-						if !cidrSet[n.CIDR] {
-							cidrs = append(cidrs, n.CIDR)
-							cidrSet[n.CIDR] = true
+						for _, cidr := range n.CIDRs() {
+							if !cidrSet[cidr] {
+								cidrs = append(cidrs, cidr)
+								cidrSet[cidr] = true
+							}
+						}
+					}
+				}
+			}
+		case "subnet":
+			if rule.DestNodeID != nil {
+				node, _ := s.store.GetNode(ctx, *rule.DestNodeID)
+				if node != nil {
+					subnets, _ := nodeSubnetEndpoint(node, rule.DestIP)
+					for _, cidr := range subnets {
+						if !cidrSet[cidr] {
+							cidrs = append(cidrs, cidr)
+							cidrSet[cidr] = true
 						}
 					}
 				}
@@ -110,7 +252,8 @@ func (s *Server) getRoutedNetworksForNode(r *http.Request, networkID string) []s
 	return cidrs
 }
 
-// handleDownloadConfig returns the WireGuard config for a node
+// handleDownloadConfig returns the WireGuard config for a node. The private
+// key is not stored, so the config carries wireguard.PrivateKeyPlaceholder.
 func (s *Server) handleDownloadConfig(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
 	node, err := s.store.GetNode(r.Context(), id)
@@ -123,13 +266,6 @@ func (s *Server) handleDownloadConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get node's private key from labels
-	privateKey := node.Labels["wireguard_private_key"]
-	if privateKey == "" {
-		errorResponse(w, http.StatusBadRequest, "private key not found for this node")
-		return
-	}
-
 	// Get network to fetch server public key
 	network, err := s.store.GetNetwork(r.Context(), node.NetworkID)
 	if err != nil || network == nil {
@@ -162,116 +298,86 @@ func (s *Server) handleDownloadConfig(w http.ResponseWriter, r *http.Request) {
 		serverEndpoint = fmt.Sprintf("%s:%d", wireguard.GetServerEndpoint(), port)
 	}
 	
-	// Get all routed networks based on VPN firewall rules
-	allowedIPs := s.getRoutedNetworksForNode(r, node.NetworkID)
-	allowedIPsStr := strings.Join(allowedIPs, ", ")
-	
-	cfgGen := wireguard.NewConfigGenerator()
-	config := cfgGen.GeneratePeerConfig(
-		privateKey,
-		serverPublicKey,
-		serverEndpoint,
-		node.VirtualIP.String(),
-		allowedIPsStr,
-	)
+	config := s.generateNodeConfig(r.Context(), node, network, wireguard.PrivateKeyPlaceholder, serverPublicKey, serverEndpoint)
 
 	w.Header().Set("Content-Type", "text/plain")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.conf\"", node.Name))
 	w.Write([]byte(config))
 }
 
-// handleGetQRCode returns PNG QR code of the config
-func (s *Server) handleGetQRCode(w http.ResponseWriter, r *http.Request) {
-	id := mux.Vars(r)["id"]
-	node, err := s.store.GetNode(r.Context(), id)
-	if err != nil {
-		errorResponse(w, http.StatusInternalServerError, "failed to get node")
-		return
-	}
-	if node == nil {
-		errorResponse(w, http.StatusNotFound, "node not found")
-		return
-	}
-
-	privateKey := node.Labels["wireguard_private_key"]
-	if privateKey == "" {
-		errorResponse(w, http.StatusBadRequest, "private key not found")
+// addNodePeer adds a new node to its network's WireGuard interface; failures
+// are logged and left to the reconciler
+func (s *Server) addNodePeer(node *models.Node) {
+	mgr := s.getManager(node.NetworkID)
+	if mgr == nil {
+		fmt.Printf("[WG] WARNING: No manager found for network %s - peer NOT added to WireGuard!\n", node.NetworkID)
 		return
 	}
-
-	// Get network to fetch server public key
-	network, err := s.store.GetNetwork(r.Context(), node.NetworkID)
-	if err != nil || network == nil {
-		errorResponse(w, http.StatusInternalServerError, "failed to get network")
-		return
-	}
-
-	serverPublicKey := network.ServerPublicKey
-	if serverPublicKey == "" {
-		// Fallback: Try to get from running manager
-		mgr := s.getManager(network.ID)
-		if mgr != nil {
-			if key, err := mgr.GetPublicKey(); err == nil && key != "" {
-				serverPublicKey = key
-			}
-		}
-	}
-
-	if serverPublicKey == "" {
-		errorResponse(w, http.StatusInternalServerError, "server public key not configured")
-		return
-	}
-
-	serverEndpoint := network.ServerEndpoint
-	if serverEndpoint == "" {
-		port := network.ListenPort
-		if port == 0 {
-			port = wireguard.DefaultServerPort
-		}
-		serverEndpoint = fmt.Sprintf("%s:%d", wireguard.GetServerEndpoint(), port)
-	}
-
-	// Get all routed networks based on VPN firewall rules
-	allowedIPs := s.getRoutedNetworksForNode(r, node.NetworkID)
-	allowedIPsStr := strings.Join(allowedIPs, ", ")
-
-	cfgGen := wireguard.NewConfigGenerator()
-	config := cfgGen.GeneratePeerConfig(
-		privateKey,
-		serverPublicKey,
-		serverEndpoint,
-		node.VirtualIP.String(),
-		allowedIPsStr,
-	)
-
-	png, err := qrcode.Encode(config, qrcode.Medium, 256)
-	if err != nil {
-		errorResponse(w, http.StatusInternalServerError, "failed to generate QR code")
-		return
+	peer := nodePeerConfig(node)
+	fmt.Printf("[WG] Adding peer to interface: PublicKey=%s, AllowedIPs=%s\n", node.PublicKey, peer.AllowedIPs)
+	if err := mgr.AddPeers([]wireguard.PeerConfig{peer}); err != nil {
+		fmt.Printf("[WG] ERROR adding peer to interface: %v\n", err)
+	} else {
+		fmt.Printf("[WG] SUCCESS: Peer added to WireGuard interface\n")
 	}
-
-	w.Header().Set("Content-Type", "image/png")
-	w.Write(png)
 }
 
-// handleCreateServerWithConfig creates a node and returns config+keys
+// handleCreateServerWithConfig creates a node with the public_key generated
+// on the node and returns its config, which carries a private key
+// placeholder. The server never generates node keys; phones enroll with an
+// enrollment token or import a config filled with a keypair made on the client.
 func (s *Server) handleCreateServerWithConfig(w http.ResponseWriter, r *http.Request) {
 	networkID := mux.Vars(r)["networkId"]
 	
 	var req struct {
-		Name      string            `json:"name"`
-		Labels    map[string]string `json:"labels"`
-		ExpiresAt *time.Time        `json:"expires_at,omitempty"`
+		Name         string            `json:"name"`
+		Labels       map[string]string `json:"labels"`
+		ExpiresAt    *time.Time        `json:"expires_at,omitempty"`
+		PresharedKey bool              `json:"preshared_key"` // Generate a per-node preshared key
+		ClientConfig *models.ClientConfigOptions `json:"client_config,omitempty"` // Overrides of the network defaults
+		VirtualIP    string            `json:"virtual_ip,omitempty"`  // Static address instead of the next free one
+		VirtualIP6   string            `json:"virtual_ip6,omitempty"` // Static IPv6 address in dual-stack networks
+		PublicKey    string            `json:"public_key"`            // Generated on the node
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errorResponse(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
+	if req.PublicKey == "" {
+		errorResponse(w, http.StatusBadRequest, "public_key is required: generate the keypair on the node, or use an enrollment token for phones")
+		return
+	}
+	if !wireguard.ValidKey(req.PublicKey) {
+		errorResponse(w, http.StatusBadRequest, "invalid public_key")
+		return
+	}
+	var requestedIP, requestedIP6 net.IP
+	if req.VirtualIP != "" {
+		if requestedIP = net.ParseIP(req.VirtualIP); requestedIP == nil {
+			errorResponse(w, http.StatusBadRequest, "invalid virtual_ip")
+			return
+		}
+	}
+	if req.VirtualIP6 != "" {
+		if requestedIP6 = net.ParseIP(req.VirtualIP6); requestedIP6 == nil || requestedIP6.To4() != nil {
+			errorResponse(w, http.StatusBadRequest, "invalid virtual_ip6: must be an IPv6 address")
+			return
+		}
+	}
+	if req.ClientConfig != nil {
+		if err := validateClientConfig(req.ClientConfig); err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
 
-	// 1. Generate keys
-	privateKey, publicKey, err := wireguard.GenerateKeys()
-	if err != nil {
-		errorResponse(w, http.StatusInternalServerError, "failed to generate keys: "+err.Error())
+	// 1. Keys: the private key stays on the node
+	publicKey := req.PublicKey
+	if inUse, err := s.store.PublicKeyInUse(r.Context(), publicKey); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to check public key")
+		return
+	} else if inUse {
+		errorResponse(w, http.StatusConflict, store.ErrPublicKeyInUse.Error())
 		return
 	}
 
@@ -283,40 +389,34 @@ func (s *Server) handleCreateServerWithConfig(w http.ResponseWriter, r *http.Req
 		PublicKey: publicKey,
 		ExpiresAt: req.ExpiresAt,
 		Status:    "online",
+		ClientConfig: req.ClientConfig,
 	}
-	
-	// Allocate next available IP
-	ip, err := s.store.AllocateIP(r.Context(), networkID)
-	if err != nil {
-		errorResponse(w, http.StatusInternalServerError, "failed to allocate IP: "+err.Error())
-		return
-	}
-	node.VirtualIP = ip
-	if node.Labels == nil {
-		node.Labels = make(map[string]string)
+	if req.PresharedKey {
+		psk, err := wireguard.GeneratePresharedKey()
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "failed to generate preshared key: "+err.Error())
+			return
+		}
+		node.PresharedKey = psk
 	}
-	// Store Private Key in Labels for MVP (Not secure for production!)
-	node.Labels["wireguard_private_key"] = privateKey
 
-	if err := s.store.CreateNode(r.Context(), node); err != nil {
+	// Addresses are allocated in the same transaction (dual-stack networks
+	// also hand out an IPv6 address)
+	if err := s.store.CreateNodeWithAddresses(r.Context(), node, requestedIP, requestedIP6); err != nil {
+		if errors.Is(err, store.ErrAddressUnavailable) {
+			errorResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+		if strings.Contains(err.Error(), "duplicate key") {
+			errorResponse(w, http.StatusConflict, "a node with this name already exists in the network")
+			return
+		}
 		errorResponse(w, http.StatusInternalServerError, "failed to create node")
 		return
 	}
 
 	// 3. Add to WireGuard interface
-	mgr := s.getManager(networkID)
-	if mgr != nil {
-		allowedIPs := node.VirtualIP.String() + "/32"
-		fmt.Printf("[WG] Adding peer to interface: PublicKey=%s, AllowedIPs=%s\n", publicKey, allowedIPs)
-		if err := mgr.AddPeer(publicKey, allowedIPs); err != nil {
-			// Log error but continue (soft failure)
-			fmt.Printf("[WG] ERROR adding peer to interface: %v\n", err)
-		} else {
-			fmt.Printf("[WG] SUCCESS: Peer added to WireGuard interface\n")
-		}
-	} else {
-		fmt.Printf("[WG] WARNING: No manager found for network %s - peer NOT added to WireGuard!\n", networkID)
-	}
+	s.addNodePeer(node)
 
 	// 4. Generate Config - Use server's public key from network
 	network, err := s.store.GetNetwork(r.Context(), networkID)
@@ -338,25 +438,12 @@ func (s *Server) handleCreateServerWithConfig(w http.ResponseWriter, r *http.Req
 		serverEndpoint = fmt.Sprintf("%s:%d", wireguard.GetServerEndpoint(), port)
 	}
 
-	// Get all routed networks based on VPN firewall rules
-	allowedIPsList := s.getRoutedNetworksForNode(r, networkID)
-	allowedIPsStr := strings.Join(allowedIPsList, ", ")
-
-	cfgGen := wireguard.NewConfigGenerator()
-	config := cfgGen.GeneratePeerConfig(
-		privateKey,
-		serverPublicKey,
-		serverEndpoint,
-		node.VirtualIP.String(),
-		allowedIPsStr,
-	)
+	config := s.generateNodeConfig(r.Context(), node, network, wireguard.PrivateKeyPlaceholder, serverPublicKey, serverEndpoint)
 
-	response := map[string]interface{}{
+	jsonResponse(w, http.StatusCreated, map[string]interface{}{
 		"node":   node,
 		"config": config,
-	}
-	
-	jsonResponse(w, http.StatusCreated, response)
+	})
 }
 
 func (s *Server) handleNodeInstallScript(w http.ResponseWriter, r *http.Request) {
@@ -368,7 +455,6 @@ func (s *Server) handleNodeInstallScript(w http.ResponseWriter, r *http.Request)
 	}
 
 	// 1. Get Config
-	privateKey := node.Labels["wireguard_private_key"]
 	network, _ := s.store.GetNetwork(r.Context(), node.NetworkID)
 	
 	serverEndpoint := network.ServerEndpoint
@@ -395,23 +481,45 @@ func (s *Server) handleNodeInstallScript(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	// Get all routed networks based on VPN firewall rules
-	allowedIPsList := s.getRoutedNetworksForNode(r, node.NetworkID)
-	allowedIPsStr := strings.Join(allowedIPsList, ", ")
-
-	cfgGen := wireguard.NewConfigGenerator()
-	config := cfgGen.GeneratePeerConfig(
-		privateKey,
-		serverPublicKey,
-		serverEndpoint,
-		node.VirtualIP.String(),
-		allowedIPsStr,
-	)
+	config := s.generateNodeConfig(r.Context(), node, network, wireguard.PrivateKeyPlaceholder, serverPublicKey, serverEndpoint)
 
 	// 2. Build the enhanced install script
 	apiURL := fmt.Sprintf("http://%s/api/v1", r.Host) // Use host from request
+	script := nodeInstallScript(node, network, config, apiURL)
+
+	w.Header().Set("Content-Type", "text/x-shellscript")
+	w.Write([]byte(script))
+}
+
+// nodeInstallScript renders the install script that writes a node's config,
+// starts WireGuard and checks in with the API at apiURL. The config's private
+// key placeholder is filled from the key already on the host; check-in uses the
+// node token passed as argument or kept from an earlier run.
+func nodeInstallScript(node *models.Node, network *models.Network, config, apiURL string) string {
+	// wg-quick needs resolvconf to apply a DNS setting
+	resolvconfStep := ""
+	if len(network.ClientConfig.Merge(node.ClientConfig).DNS) > 0 {
+		resolvconfStep = `
+if ! command -v resolvconf &> /dev/null; then
+    apt-get update && apt-get install -y openresolv
+fi
+`
+	}
+
+	// Exit nodes keep forwarding enabled across reboots; NAT is in the config hooks
+	exitNodeStep := ""
+	if node.ExitNode {
+		exitNodeStep = `
+# Exit node: forward internet traffic of other nodes
+cat <<EOF > /etc/sysctl.d/99-novusgate-exit-node.conf
+net.ipv4.ip_forward = 1
+net.ipv6.conf.all.forwarding = 1
+EOF
+sysctl -q -p /etc/sysctl.d/99-novusgate-exit-node.conf
+`
+	}
 	
-	script := fmt.Sprintf(`#!/bin/bash
+	return fmt.Sprintf(`#!/bin/bash
 set -e
 
 echo "Starting novusgate Client Installation..."
@@ -420,13 +528,33 @@ echo "Starting novusgate Client Installation..."
 if ! command -v wg &> /dev/null; then
     apt-get update && apt-get install -y wireguard wireguard-tools curl
 fi
-
+%s
 # 2. Write Configuration
+# The private key never leaves this host: reuse the installed one
+umask 077
 mkdir -p /etc/wireguard
+if [ -s /etc/wireguard/wg0.key ]; then
+    PRIVATE_KEY=$(cat /etc/wireguard/wg0.key)
+elif [ -f /etc/wireguard/wg0.conf ]; then
+    PRIVATE_KEY=$(sed -n 's/^PrivateKey *= *//p' /etc/wireguard/wg0.conf)
+fi
+if [ -z "$PRIVATE_KEY" ]; then
+    echo "No WireGuard private key on this host. Enroll it with an enrollment token instead." >&2
+    exit 1
+fi
+echo "$PRIVATE_KEY" > /etc/wireguard/wg0.key
+NODE_TOKEN="$1"
+mkdir -p %s
+if [ -n "$NODE_TOKEN" ]; then
+    echo "$NODE_TOKEN" > %s
+elif [ -s %s ]; then
+    NODE_TOKEN=$(cat %s)
+fi
 cat <<EOF > /etc/wireguard/wg0.conf
 %s
 EOF
-
+sed -i "s|%s|$PRIVATE_KEY|" /etc/wireguard/wg0.conf
+%s
 # 3. Collect Metadata
 OS="Linux"
 ARCH=$(uname -m)
@@ -436,28 +564,35 @@ MAC=$(cat /sys/class/net/$(ip route get 8.8.8.8 | grep -oP 'dev \K\S+')/address
 echo "Reporting device info: $HOSTNAME ($OS $ARCH)..."
 
 # 4. Report to Server (Check-in)
-curl -s -X POST %s/nodes/%s/checkin \
-  -H "Content-Type: application/json" \
-  -d "{
-    \"node_info\": {
-      \"os\": \"$OS\",
-      \"architecture\": \"$ARCH\",
-      \"hostname\": \"$HOSTNAME\"
-    },
-    \"labels\": {
-      \"mac_address\": \"$MAC\",
-      \"auto_captured\": \"true\"
-    }
-  }"
+if [ -n "$NODE_TOKEN" ]; then
+    curl -s -X POST %s/nodes/%s/checkin \
+      -H "Content-Type: application/json" \
+      -H "Authorization: Bearer $NODE_TOKEN" \
+      -d "{
+        \"node_info\": {
+          \"os\": \"$OS\",
+          \"architecture\": \"$ARCH\",
+          \"hostname\": \"$HOSTNAME\"
+        },
+        \"labels\": {
+          \"mac_address\": \"$MAC\",
+          \"auto_captured\": \"true\"
+        }
+      }"
+else
+    echo "No node token: skipping check-in. Issue one with POST /nodes/{id}/token and pass it as the first argument." >&2
+fi
 
 # 5. Start Service
 systemctl enable wg-quick@wg0
 systemctl restart wg-quick@wg0
 
-echo "Installation complete! Device is now connected to the VPN network."
-`, config, apiURL, node.ID)
+# 6. Node agent: hourly check-in, answers scheduled key rotations
+if [ -n "$NODE_TOKEN" ]; then
+%s
+fi
 
-	w.Header().Set("Content-Type", "text/x-shellscript")
-	w.Write([]byte(script))
+echo "Installation complete! Device is now connected to the VPN network."
+`, resolvconfStep, path.Dir(nodeTokenFile), nodeTokenFile, nodeTokenFile, nodeTokenFile,
+		config, wireguard.PrivateKeyPlaceholder, exitNodeStep, apiURL, node.ID, nodeAgentStep(apiURL, node.ID))
 }
-
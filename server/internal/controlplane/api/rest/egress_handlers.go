@@ -0,0 +1,467 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/shared/netutil"
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+// egressRuleMarker prefixes the iptables comment of every egress rule
+const egressRuleMarker = "novusgate-egress-"
+
+// interfaceNamePattern matches valid Linux interface names
+var interfaceNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.@-]{1,15}$`)
+
+// validateEgress checks a network's egress settings before they are stored
+func validateEgress(network *models.Network, egress *models.EgressConfig) error {
+	switch egress.Mode {
+	case "", models.EgressDisabled:
+		return nil
+	case models.EgressNAT:
+	case models.EgressExitNode:
+		// The exit node itself is checked against the store by the caller
+		if egress.ExitNodeID == "" {
+			return fmt.Errorf("exit_node mode requires exit_node_id")
+		}
+		if egress.BlockDNSLeaks && len(dnsServerIPs(network.ClientConfig.DNS)) == 0 {
+			return fmt.Errorf("block_dns_leaks requires DNS servers in the network's client config")
+		}
+		return nil
+	default:
+		return fmt.Errorf("egress mode must be disabled, nat or exit_node")
+	}
+
+	// No interface NATs out of the default route's uplink
+	if egress.Interface != "" && !interfaceNamePattern.MatchString(egress.Interface) {
+		return fmt.Errorf("egress interface must be a valid interface name")
+	}
+	if egress.SourceIP != "" {
+		if ip := net.ParseIP(egress.SourceIP); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("source_ip must be an IPv4 address")
+		}
+	}
+	if egress.SourceIP6 != "" {
+		if ip := net.ParseIP(egress.SourceIP6); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("source_ip6 must be an IPv6 address")
+		}
+		if !hasIPv6Range(network) {
+			return fmt.Errorf("source_ip6 requires a network with an IPv6 range")
+		}
+	}
+	if egress.BlockDNSLeaks && len(dnsServerIPs(network.ClientConfig.DNS)) == 0 {
+		return fmt.Errorf("block_dns_leaks requires DNS servers in the network's client config")
+	}
+	return nil
+}
+
+// hasIPv6Range reports whether a network has an IPv6 address range
+func hasIPv6Range(network *models.Network) bool {
+	for _, cidr := range network.CIDRs() {
+		if netutil.IsIPv6(cidr) {
+			return true
+		}
+	}
+	return false
+}
+
+// dnsServerIPs returns the server addresses of a DNS list, skipping search domains
+func dnsServerIPs(dns []string) []string {
+	var ips []string
+	for _, entry := range dns {
+		if net.ParseIP(entry) != nil {
+			ips = append(ips, entry)
+		}
+	}
+	return ips
+}
+
+// handleUpdateNetworkEgress replaces the internet egress settings of a network
+// and re-applies the egress firewall rules
+func (s *Server) handleUpdateNetworkEgress(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var egress models.EgressConfig
+	if err := json.NewDecoder(r.Body).Decode(&egress); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	network, err := s.store.GetNetwork(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+
+	if err := validateEgress(network, &egress); err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if egress.Mode == models.EgressExitNode {
+		if _, err := s.checkExitNode(r.Context(), egress.ExitNodeID); err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if egress.Mode == "" {
+		egress.Mode = models.EgressDisabled
+	}
+
+	previous := network.Egress
+	if err := s.store.UpdateNetworkEgress(r.Context(), id, egress); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to update egress")
+		return
+	}
+	network.Egress = egress
+
+	if err := s.syncEgressRulesFor(r.Context(), id); err != nil {
+		// Keep the stored settings in line with what the host runs
+		if rbErr := s.store.UpdateNetworkEgress(r.Context(), id, previous); rbErr != nil {
+			fmt.Printf("[Egress] Failed to restore egress settings of %s: %v\n", network.Name, rbErr)
+		} else if syncErr := s.syncEgressRules(r.Context()); syncErr != nil {
+			fmt.Printf("[Egress] Failed to restore egress rules: %v\n", syncErr)
+		}
+		errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to apply egress rules: %v", err))
+		return
+	}
+
+	s.store.CreateFirewallAuditLog(r.Context(), "egress_updated", map[string]interface{}{
+		"network_id": id,
+		"mode":       egress.Mode,
+		"interface":  egress.Interface,
+	}, r.RemoteAddr)
+
+	// Egress only carries traffic that clients route through the tunnel
+	var warnings []string
+	if egress.Mode != models.EgressDisabled && network.ClientConfig.AllowedIPsMode != models.AllowedIPsFull {
+		warnings = append(warnings, "client configs of this network use split tunnel by default; set client_config allowed_ips_mode to full to route internet traffic through the hub")
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"network":  network,
+		"warnings": warnings,
+	})
+}
+
+// syncEgressRules replaces all egress NAT, forwarding and DNS leak rules, and
+// the exit node policy routing, with the ones for the current settings
+func (s *Server) syncEgressRules(ctx context.Context) error {
+	return s.syncEgressRulesFor(ctx, "")
+}
+
+// syncEgressRulesFor is syncEgressRules, but rules of the given network that
+// fail to apply are returned as an error instead of logged
+func (s *Server) syncEgressRulesFor(ctx context.Context, networkID string) error {
+	// The simulated backend never touches the host firewall
+	if s.wgBackend == wireguard.BackendSimulated {
+		return nil
+	}
+
+	s.egressMu.Lock()
+	defer s.egressMu.Unlock()
+
+	if err := clearCommentedRules("iptables", "nat", "POSTROUTING", egressRuleMarker); err != nil {
+		return fmt.Errorf("failed to clear egress NAT rules: %w", err)
+	}
+	if err := clearCommentedRules("iptables", "filter", "FORWARD", egressRuleMarker); err != nil {
+		return fmt.Errorf("failed to clear egress forward rules: %w", err)
+	}
+	// ip6tables may be unavailable on IPv4-only hosts
+	if err := clearCommentedRules("ip6tables", "nat", "POSTROUTING", egressRuleMarker); err != nil {
+		fmt.Printf("Warning: failed to clear IPv6 egress NAT rules: %v\n", err)
+	}
+	if err := clearCommentedRules("ip6tables", "filter", "FORWARD", egressRuleMarker); err != nil {
+		fmt.Printf("Warning: failed to clear IPv6 egress forward rules: %v\n", err)
+	}
+
+	networks, err := s.store.ListNetworks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	// Uplinks of the egress networks by address family (true for IPv6); only
+	// those families get forwarding enabled
+	uplinks := map[bool]map[string]bool{false: {}, true: {}}
+	requested := map[bool]bool{}
+
+	for _, network := range networks {
+		if network.InterfaceName == "" || network.RemoteHub {
+			continue // Remote hub traffic never passes this host
+		}
+		if network.Egress.BlockDNSLeaks {
+			if err := applyDNSLeakRules(network); err != nil {
+				if network.ID == networkID {
+					return fmt.Errorf("failed to apply DNS leak rules: %w", err)
+				}
+				fmt.Printf("Warning: failed to apply DNS leak rules for %s: %v\n", network.Name, err)
+			}
+		}
+		if !network.Egress.Enabled() {
+			continue
+		}
+		if err := applyEgressRules(network); err != nil {
+			if network.ID == networkID {
+				return err
+			}
+			fmt.Printf("Warning: failed to apply egress rules for %s: %v\n", network.Name, err)
+			continue
+		}
+		for _, cidr := range network.CIDRs() {
+			ipv6 := netutil.IsIPv6(cidr)
+			if uplink, err := egressUplink(network.Egress, ipv6); err == nil {
+				uplinks[ipv6][uplink] = true
+			}
+			if network.ID == networkID {
+				requested[ipv6] = true
+			}
+		}
+		uplink := network.Egress.Interface
+		if uplink == "" {
+			uplink = "the default route"
+		}
+		fmt.Printf("[Egress] Network %s: NAT via %s\n", network.Name, uplink)
+	}
+
+	s.syncExitRoutes(ctx, networks)
+
+	for _, ipv6 := range []bool{false, true} {
+		if len(uplinks[ipv6]) == 0 {
+			continue
+		}
+		if err := enableForwarding(ipv6, uplinks[ipv6]); err != nil {
+			if requested[ipv6] {
+				return err
+			}
+			fmt.Printf("Warning: %v\n", err)
+		}
+	}
+	execHostCommand("netfilter-persistent", "save")
+
+	return nil
+}
+
+// egressUplink returns the interface egress traffic of one address family
+// leaves through: the configured one or that of the default route
+func egressUplink(egress models.EgressConfig, ipv6 bool) (string, error) {
+	if egress.Interface != "" {
+		return egress.Interface, nil
+	}
+	return defaultRouteInterface(ipv6)
+}
+
+// enableForwarding turns on IP forwarding for one address family. With IPv6
+// forwarding on, the kernel ignores router advertisements unless accept_ra is
+// 2, so the uplinks are switched to 2 first to keep their SLAAC address and
+// default route.
+func enableForwarding(ipv6 bool, uplinks map[string]bool) error {
+	if !ipv6 {
+		if _, err := execHostCommand("sysctl", "-w", "net.ipv4.ip_forward=1"); err != nil {
+			return fmt.Errorf("failed to enable IPv4 forwarding: %w", err)
+		}
+		return nil
+	}
+	for uplink := range uplinks {
+		// sysctl keys use / for the dots of VLAN interface names
+		key := "net.ipv6.conf." + strings.ReplaceAll(uplink, ".", "/") + ".accept_ra=2"
+		if _, err := execHostCommand("sysctl", "-w", key); err != nil {
+			return fmt.Errorf("failed to keep accepting router advertisements on %s: %w", uplink, err)
+		}
+	}
+	if _, err := execHostCommand("sysctl", "-w", "net.ipv6.conf.all.forwarding=1"); err != nil {
+		return fmt.Errorf("failed to enable IPv6 forwarding: %w", err)
+	}
+	return nil
+}
+
+// applyEgressRules NATs a network's ranges out of its uplink interface and
+// allows the forwarded traffic in both directions
+func applyEgressRules(network *models.Network) error {
+	egress := network.Egress
+	comment := egressRuleMarker + network.ID
+
+	for _, cidr := range network.CIDRs() {
+		command := "iptables"
+		sourceIP := egress.SourceIP
+		if netutil.IsIPv6(cidr) {
+			command = "ip6tables"
+			sourceIP = egress.SourceIP6
+		}
+		uplink, err := egressUplink(egress, netutil.IsIPv6(cidr))
+		if err != nil {
+			return err
+		}
+
+		nat := []string{"-t", "nat", "-A", "POSTROUTING", "-s", cidr, "-o", uplink}
+		if sourceIP != "" {
+			nat = append(nat, "-j", "SNAT", "--to-source", sourceIP)
+		} else {
+			nat = append(nat, "-j", "MASQUERADE")
+		}
+		nat = append(nat, "-m", "comment", "--comment", comment)
+
+		rules := [][]string{
+			nat,
+			{"-A", "FORWARD", "-i", network.InterfaceName, "-o", uplink, "-s", cidr,
+				"-j", "ACCEPT", "-m", "comment", "--comment", comment},
+			{"-A", "FORWARD", "-i", uplink, "-o", network.InterfaceName, "-d", cidr,
+				"-m", "conntrack", "--ctstate", "RELATED,ESTABLISHED", "-j", "ACCEPT", "-m", "comment", "--comment", comment},
+		}
+		for _, args := range rules {
+			if _, err := execHostCommand(command, args...); err != nil {
+				return fmt.Errorf("%s command failed: %w", command, err)
+			}
+		}
+	}
+	return nil
+}
+
+// defaultRouteInterface returns the uplink interface of the host's default
+// route, the one the NAT hooks of older releases picked
+func defaultRouteInterface(ipv6 bool) (string, error) {
+	args := []string{"route", "get", "8.8.8.8"}
+	if ipv6 {
+		args = []string{"-6", "route", "get", "2001:4860:4860::8888"}
+	}
+	output, err := execHostCommand("ip", args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to find the default route: %w", err)
+	}
+	fields := strings.Fields(output)
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "dev" && interfaceNamePattern.MatchString(fields[i+1]) {
+			return fields[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("no interface in default route %q", strings.TrimSpace(output))
+}
+
+// removeOldNATRules deletes the NAT rules of the hooks RemoveNATHooks took out
+// of a running interface's config. The hooks found the uplink with a shell
+// command substitution; it is resolved here so the iptables commands run
+// without a shell. Anything but a plain iptables command is left to the admin.
+func removeOldNATRules(interfaceName string, commands []string) {
+	for _, command := range commands {
+		if strings.Contains(command, "$(") {
+			uplink, err := defaultRouteInterface(strings.HasPrefix(command, "ip6tables"))
+			if err != nil {
+				fmt.Printf("Warning: failed to remove old NAT rule of %s: %v\n", interfaceName, err)
+				continue
+			}
+			command = replaceCommandSubstitutions(command, uplink)
+		}
+		fields := strings.Fields(command)
+		if len(fields) == 0 || (fields[0] != "iptables" && fields[0] != "ip6tables") || strings.ContainsAny(command, "|;&<>$`'\"\\") {
+			fmt.Printf("Warning: not removing old NAT rule of %s, remove it by hand: %s\n", interfaceName, command)
+			continue
+		}
+		if _, err := execHostCommand(fields[0], fields[1:]...); err != nil {
+			fmt.Printf("Warning: failed to remove old NAT rule of %s: %v\n", interfaceName, err)
+		}
+	}
+}
+
+// replaceCommandSubstitutions replaces every $(...) in a shell command,
+// including nested parentheses, with value
+func replaceCommandSubstitutions(command, value string) string {
+	var sb strings.Builder
+	for {
+		start := strings.Index(command, "$(")
+		if start == -1 {
+			sb.WriteString(command)
+			return sb.String()
+		}
+		sb.WriteString(command[:start])
+		depth, end := 0, len(command)
+		for i := start + 1; i < len(command); i++ {
+			if command[i] == '(' {
+				depth++
+			} else if command[i] == ')' {
+				if depth--; depth == 0 {
+					end = i + 1
+					break
+				}
+			}
+		}
+		sb.WriteString(value)
+		command = command[end:]
+	}
+}
+
+// applyDNSLeakRules rejects DNS queries from a network's clients to any server
+// but the ones in its client config. The rules are inserted at the top of
+// FORWARD so the interface's blanket ACCEPT rules don't bypass them.
+func applyDNSLeakRules(network *models.Network) error {
+	comment := egressRuleMarker + network.ID
+	servers := dnsServerIPs(network.ClientConfig.DNS)
+
+	for _, ipv6 := range []bool{false, true} {
+		command := "iptables"
+		if ipv6 {
+			command = "ip6tables"
+			if !hasIPv6Range(network) {
+				continue
+			}
+		}
+
+		for _, protocol := range []string{"udp", "tcp"} {
+			// Inserted first so the ACCEPT rules below end up above it
+			reject := []string{"-I", "FORWARD", "1", "-i", network.InterfaceName, "-p", protocol, "--dport", "53",
+				"-j", "REJECT", "-m", "comment", "--comment", comment}
+			if _, err := execHostCommand(command, reject...); err != nil {
+				return fmt.Errorf("%s command failed: %w", command, err)
+			}
+			for _, server := range servers {
+				if netutil.IsIPv6(server) != ipv6 {
+					continue
+				}
+				accept := []string{"-I", "FORWARD", "1", "-i", network.InterfaceName, "-d", server, "-p", protocol, "--dport", "53",
+					"-j", "ACCEPT", "-m", "comment", "--comment", comment}
+				if _, err := execHostCommand(command, accept...); err != nil {
+					return fmt.Errorf("%s command failed: %w", command, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// clearCommentedRules deletes the rules of a chain whose comment contains
+// marker, using the given iptables binary (iptables or ip6tables)
+func clearCommentedRules(command, table, chain, marker string) error {
+	output, err := execHostCommand(command, "-t", table, "-L", chain, "-n", "-v", "--line-numbers")
+	if err != nil {
+		return err
+	}
+
+	var lineNumbers []int
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.Contains(line, marker) {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 0 {
+			if num, err := strconv.Atoi(fields[0]); err == nil {
+				lineNumbers = append(lineNumbers, num)
+			}
+		}
+	}
+
+	// Delete in reverse order to maintain line numbers
+	for i := len(lineNumbers) - 1; i >= 0; i-- {
+		execHostCommand(command, "-t", table, "-D", chain, strconv.Itoa(lineNumbers[i]))
+	}
+	return nil
+}
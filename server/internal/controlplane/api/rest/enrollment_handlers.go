@@ -0,0 +1,377 @@
+package rest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/controlplane/store"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+const (
+	// enrollPath and enrollScriptPath are called by nodes with an enrollment
+	// token instead of the admin token and API key
+	enrollPath       = "/api/v1/enroll"
+	enrollScriptPath = "/api/v1/enroll.sh"
+
+	// DefaultEnrollmentTokenTTL is how long an enrollment token is valid by default
+	DefaultEnrollmentTokenTTL = 24 * time.Hour
+	// maxEnrollmentTokenTTL bounds the validity of an enrollment token
+	maxEnrollmentTokenTTL = 30 * 24 * time.Hour
+)
+
+// isEnrollmentPath reports whether a request goes to the node enrollment API
+func isEnrollmentPath(path string) bool {
+	return path == enrollPath || path == enrollScriptPath
+}
+
+// newEnrollmentToken returns a random enrollment token and the hash that is stored
+func newEnrollmentToken() (token, tokenHash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(buf)
+	return token, hashEnrollmentToken(token), nil
+}
+
+// hashEnrollmentToken returns the stored form of an enrollment token
+func hashEnrollmentToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// enrollCommand returns the shell command that enrolls a host with a token
+func enrollCommand(r *http.Request, token string) string {
+	return fmt.Sprintf("curl -fsSL http://%s%s | sudo bash -s -- %s", r.Host, enrollScriptPath, token)
+}
+
+// handleCreateEnrollmentToken creates a one-time token a node enrolls with.
+// The token is only returned in this response.
+func (s *Server) handleCreateEnrollmentToken(w http.ResponseWriter, r *http.Request) {
+	networkID := mux.Vars(r)["networkId"]
+
+	var req struct {
+		Name         string                      `json:"name"` // Node name, empty lets the node choose
+		Labels       map[string]string           `json:"labels"`
+		ClientConfig *models.ClientConfigOptions `json:"client_config,omitempty"`
+		VirtualIP    string                      `json:"virtual_ip,omitempty"`
+		VirtualIP6   string                      `json:"virtual_ip6,omitempty"`
+		PresharedKey bool                        `json:"preshared_key"`
+		ExpiresAt    *time.Time                  `json:"expires_at,omitempty"` // Of the enrolled node
+		TTL          string                      `json:"ttl"`                  // Of the token, default 24h
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	ttl := DefaultEnrollmentTokenTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 || d > maxEnrollmentTokenTTL {
+			errorResponse(w, http.StatusBadRequest, fmt.Sprintf("ttl must be a duration up to %s", maxEnrollmentTokenTTL))
+			return
+		}
+		ttl = d
+	}
+	token := &models.EnrollmentToken{
+		NetworkID:     networkID,
+		Name:          strings.TrimSpace(req.Name),
+		Labels:        req.Labels,
+		ClientConfig:  req.ClientConfig,
+		PresharedKey:  req.PresharedKey,
+		NodeExpiresAt: req.ExpiresAt,
+		ExpiresAt:     time.Now().Add(ttl),
+	}
+	if token.Labels == nil {
+		token.Labels = make(map[string]string)
+	}
+	if req.VirtualIP != "" {
+		if token.VirtualIP = net.ParseIP(req.VirtualIP); token.VirtualIP == nil {
+			errorResponse(w, http.StatusBadRequest, "invalid virtual_ip")
+			return
+		}
+	}
+	if req.VirtualIP6 != "" {
+		if token.VirtualIP6 = net.ParseIP(req.VirtualIP6); token.VirtualIP6 == nil || token.VirtualIP6.To4() != nil {
+			errorResponse(w, http.StatusBadRequest, "invalid virtual_ip6: must be an IPv6 address")
+			return
+		}
+	}
+	if req.ClientConfig != nil {
+		if err := validateClientConfig(req.ClientConfig); err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	network, err := s.store.GetNetwork(r.Context(), networkID)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+
+	secret, tokenHash, err := newEnrollmentToken()
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+	if err := s.store.CreateEnrollmentToken(r.Context(), token, tokenHash); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to create enrollment token")
+		return
+	}
+	token.Token = secret
+
+	s.store.CreateFirewallAuditLog(r.Context(), "enrollment_token_created", map[string]interface{}{
+		"id":         token.ID,
+		"network_id": network.ID,
+		"name":       token.Name,
+		"expires_at": token.ExpiresAt,
+	}, r.RemoteAddr)
+
+	jsonResponse(w, http.StatusCreated, map[string]interface{}{
+		"token":          token,
+		"enroll_command": enrollCommand(r, secret),
+	})
+}
+
+// handleListEnrollmentTokens lists the enrollment tokens of a network
+func (s *Server) handleListEnrollmentTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := s.store.ListEnrollmentTokens(r.Context(), mux.Vars(r)["networkId"])
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list enrollment tokens")
+		return
+	}
+	jsonResponse(w, http.StatusOK, tokens)
+}
+
+// handleDeleteEnrollmentToken revokes an enrollment token
+func (s *Server) handleDeleteEnrollmentToken(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	token, err := s.store.GetEnrollmentToken(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get enrollment token")
+		return
+	}
+	if token == nil {
+		errorResponse(w, http.StatusNotFound, "enrollment token not found")
+		return
+	}
+	if err := s.store.DeleteEnrollmentToken(r.Context(), id); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to delete enrollment token")
+		return
+	}
+	s.store.CreateFirewallAuditLog(r.Context(), "enrollment_token_deleted", map[string]interface{}{
+		"id":         token.ID,
+		"network_id": token.NetworkID,
+		"status":     token.Status,
+	}, r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleEnroll registers a node with an enrollment token and the public key
+// it generated. The config carries wireguard.PrivateKeyPlaceholder; clients
+// accepting text/plain get the config alone and the node token and ID in the
+// X-Node-Token and X-Node-Id headers.
+func (s *Server) handleEnroll(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token     string           `json:"token"`
+		PublicKey string           `json:"public_key"`
+		Name      string           `json:"name"` // Used when the token sets no name
+		NodeInfo  *models.NodeInfo `json:"node_info"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Token == "" || !wireguard.ValidKey(req.PublicKey) {
+		errorResponse(w, http.StatusBadRequest, "token and a valid public_key are required")
+		return
+	}
+
+	token, err := s.store.GetEnrollmentTokenByHash(r.Context(), hashEnrollmentToken(req.Token))
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get enrollment token")
+		return
+	}
+	if token == nil || token.Status != "active" {
+		errorResponse(w, http.StatusUnauthorized, store.ErrEnrollmentTokenInvalid.Error())
+		return
+	}
+	network, err := s.store.GetNetwork(r.Context(), token.NetworkID)
+	if err != nil || network == nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+
+	name := token.Name
+	if name == "" {
+		name = strings.TrimSpace(req.Name)
+	}
+	if name == "" && req.NodeInfo != nil {
+		name = req.NodeInfo.Hostname
+	}
+	if name == "" {
+		errorResponse(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	node := &models.Node{
+		Name:         name,
+		Labels:       token.Labels,
+		PublicKey:    req.PublicKey,
+		Status:       "online",
+		NodeInfo:     req.NodeInfo,
+		ExpiresAt:    token.NodeExpiresAt,
+		ClientConfig: token.ClientConfig,
+	}
+	if node.Labels == nil {
+		node.Labels = make(map[string]string)
+	}
+	if token.PresharedKey {
+		if node.PresharedKey, err = wireguard.GeneratePresharedKey(); err != nil {
+			errorResponse(w, http.StatusInternalServerError, "failed to generate preshared key: "+err.Error())
+			return
+		}
+	}
+
+	nodeToken, nodeTokenHash, err := newNodeToken()
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to generate node token")
+		return
+	}
+	if err := s.store.EnrollNode(r.Context(), token.ID, node, nodeTokenHash); err != nil {
+		switch {
+		case errors.Is(err, store.ErrEnrollmentTokenInvalid):
+			errorResponse(w, http.StatusUnauthorized, err.Error())
+		case errors.Is(err, store.ErrPublicKeyInUse), errors.Is(err, store.ErrAddressUnavailable):
+			errorResponse(w, http.StatusConflict, err.Error())
+		case strings.Contains(err.Error(), "duplicate key"):
+			errorResponse(w, http.StatusConflict, "a node with this name already exists in the network")
+		default:
+			errorResponse(w, http.StatusInternalServerError, "failed to enroll node")
+		}
+		return
+	}
+	fmt.Printf("[Enroll] Node %s enrolled in network %s\n", node.Name, network.Name)
+	s.addNodePeer(node)
+
+	s.store.CreateFirewallAuditLog(r.Context(), "node_enrolled", map[string]interface{}{
+		"node_id":    node.ID,
+		"name":       node.Name,
+		"network_id": network.ID,
+		"token_id":   token.ID,
+		"public_key": node.PublicKey,
+	}, r.RemoteAddr)
+
+	serverPublicKey := network.ServerPublicKey
+	if serverPublicKey == "" {
+		if mgr := s.getManager(network.ID); mgr != nil {
+			if key, err := mgr.GetPublicKey(); err == nil {
+				serverPublicKey = key
+			}
+		}
+	}
+	config := s.generateNodeConfig(r.Context(), node, network, wireguard.PrivateKeyPlaceholder, serverPublicKey, hubEndpoint(network))
+
+	if strings.Contains(r.Header.Get("Accept"), "text/plain") {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set(nodeTokenHeader, nodeToken)
+		w.Header().Set(nodeIDHeader, node.ID)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(config))
+		return
+	}
+	jsonResponse(w, http.StatusCreated, map[string]interface{}{
+		"node":   node,
+		"config": config,
+		"token":  nodeToken,
+	})
+}
+
+// handleEnrollScript returns a script that generates a keypair on the host,
+// enrolls it with the token given as its argument and starts WireGuard
+func (s *Server) handleEnrollScript(w http.ResponseWriter, r *http.Request) {
+	apiURL := fmt.Sprintf("http://%s/api/v1", r.Host)
+	script := fmt.Sprintf(`#!/bin/bash
+set -e
+
+TOKEN="$1"
+if [ -z "$TOKEN" ]; then
+    echo "Usage: curl -fsSL %[1]s/enroll.sh | sudo bash -s -- TOKEN" >&2
+    exit 1
+fi
+
+echo "Enrolling with novusgate..."
+
+# 1. Install WireGuard
+if ! command -v wg &> /dev/null; then
+    apt-get update && apt-get install -y wireguard wireguard-tools curl
+fi
+
+# 2. Generate the keypair; the private key never leaves this host
+umask 077
+mkdir -p /etc/wireguard
+if [ ! -s /etc/wireguard/wg0.key ]; then
+    wg genkey > /etc/wireguard/wg0.key
+fi
+PUBLIC_KEY=$(wg pubkey < /etc/wireguard/wg0.key)
+
+# 3. Register the public key
+OS="Linux"
+ARCH=$(uname -m)
+HOSTNAME=$(hostname)
+HEADERS=$(mktemp)
+trap 'rm -f "$HEADERS"' EXIT
+CONFIG=$(curl -fsS -X POST %[1]s/enroll \
+  -D "$HEADERS" \
+  -H "Content-Type: application/json" \
+  -H "Accept: text/plain" \
+  -d "{
+    \"token\": \"$TOKEN\",
+    \"public_key\": \"$PUBLIC_KEY\",
+    \"node_info\": {
+      \"os\": \"$OS\",
+      \"architecture\": \"$ARCH\",
+      \"hostname\": \"$HOSTNAME\"
+    }
+  }")
+
+# 4. Write Configuration; node agents authenticate with the token in %[3]s
+mkdir -p %[4]s
+sed -n 's/^%[5]s: *//Ip' "$HEADERS" | tr -d '\r' > %[3]s
+NODE_ID=$(sed -n 's/^%[6]s: *//Ip' "$HEADERS" | tr -d '\r')
+echo "$CONFIG" | sed "s|%[2]s|$(cat /etc/wireguard/wg0.key)|" > /etc/wireguard/wg0.conf
+if grep -q '^DNS' /etc/wireguard/wg0.conf && ! command -v resolvconf &> /dev/null; then
+    apt-get update && apt-get install -y openresolv
+fi
+
+# 5. Start Service
+systemctl enable wg-quick@wg0
+systemctl restart wg-quick@wg0
+
+# 6. Node agent: hourly check-in, answers scheduled key rotations
+%[7]s
+echo "Enrollment complete! Device is now connected to the VPN network."
+`, apiURL, wireguard.PrivateKeyPlaceholder, nodeTokenFile, path.Dir(nodeTokenFile), nodeTokenHeader, nodeIDHeader,
+		nodeAgentStep(apiURL, "$NODE_ID"))
+
+	w.Header().Set("Content-Type", "text/x-shellscript")
+	w.Write([]byte(script))
+}
@@ -0,0 +1,245 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/shared/netutil"
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+// Policy routing on the hub for exit nodes. Every exit node gets its own
+// routing table with a default route into its network's interface.
+const (
+	exitRouteTableBase   = 52000 // First routing table used for exit nodes
+	exitRuleMainPriority = 5200  // Sources keep using the main table for everything but the default route
+	exitRuleNodePriority = 5210  // Individual nodes assigned to an exit node
+	exitRuleNetPriority  = 5220  // Networks assigned to an exit node
+)
+
+// exitNodeMark marks forwarded traffic on an exit node so only that is NATed
+const exitNodeMark = "0x4e47"
+
+// ipRuleLinePattern matches the priority and table of an `ip rule show` line
+var ipRuleLinePattern = regexp.MustCompile(`^(\d+):.*lookup (\S+)`)
+
+// exitNodeAllowedIPs returns the default routes an exit node's hub-side peer
+// needs so replies from the internet are accepted from it
+func exitNodeAllowedIPs(node *models.Node) []string {
+	if !node.ExitNode {
+		return nil
+	}
+	routes := []string{"0.0.0.0/0"}
+	if node.VirtualIP6 != nil {
+		routes = append(routes, "::/0")
+	}
+	return routes
+}
+
+// checkExitNode verifies that id refers to a node marked as exit node
+func (s *Server) checkExitNode(ctx context.Context, id string) (*models.Node, error) {
+	exitNode, err := s.store.GetNode(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get exit node: %w", err)
+	}
+	if exitNode == nil {
+		return nil, fmt.Errorf("exit node not found")
+	}
+	if !exitNode.ExitNode {
+		return nil, fmt.Errorf("node %s is not an exit node", exitNode.Name)
+	}
+	return exitNode, nil
+}
+
+// checkExitNodeFlag validates marking or unmarking a node as exit node. A
+// network has at most one exit node, since only one peer of an interface can
+// own 0.0.0.0/0, and an exit node still in use can't be unmarked.
+func (s *Server) checkExitNodeFlag(ctx context.Context, node *models.Node, exitNode bool) error {
+	if exitNode == node.ExitNode {
+		return nil
+	}
+	if exitNode {
+		nodes, err := s.store.ListNodes(ctx, node.NetworkID)
+		if err != nil {
+			return fmt.Errorf("failed to list nodes: %w", err)
+		}
+		for _, other := range nodes {
+			if other.ID != node.ID && other.ExitNode {
+				return fmt.Errorf("network already has exit node %s", other.Name)
+			}
+		}
+		if node.ExitNodeID != nil {
+			return fmt.Errorf("an exit node can't use another exit node")
+		}
+		return nil
+	}
+
+	users, err := s.store.ListExitNodeUsers(ctx, node.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list exit node users: %w", err)
+	}
+	if len(users) > 0 {
+		return fmt.Errorf("exit node is still used by %d node(s)", len(users))
+	}
+	networks, err := s.store.ListNetworks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
+	}
+	for _, network := range networks {
+		if network.Egress.Mode == models.EgressExitNode && network.Egress.ExitNodeID == node.ID {
+			return fmt.Errorf("exit node is still used by network %s", network.Name)
+		}
+	}
+	return nil
+}
+
+// exitNodeSources returns the address ranges whose internet traffic leaves
+// through an exit node: ranges of networks in exit_node mode and host
+// addresses of nodes assigned to it
+func (s *Server) exitNodeSources(ctx context.Context, exitNode *models.Node) (networks, hosts []string) {
+	allNetworks, err := s.store.ListNetworks(ctx)
+	if err != nil {
+		return nil, nil
+	}
+	for _, network := range allNetworks {
+		if network.Egress.Mode == models.EgressExitNode && network.Egress.ExitNodeID == exitNode.ID {
+			networks = append(networks, network.CIDRs()...)
+		}
+		nodes, err := s.store.ListNodes(ctx, network.ID)
+		if err != nil {
+			continue
+		}
+		for _, node := range nodes {
+			if node.ExitNodeID != nil && *node.ExitNodeID == exitNode.ID && node.ID != exitNode.ID && !nodeIsExpired(node) {
+				hosts = append(hosts, nodeHostRoutes(node)...)
+			}
+		}
+	}
+	return networks, hosts
+}
+
+// exitNodeHooks returns the PostUp/PostDown commands of an exit node's client
+// config: forwarding plus NAT of the traffic that arrives over the tunnel
+func exitNodeHooks(node *models.Node) (postUp, postDown []string) {
+	commands := []string{"iptables"}
+	postUp = []string{"sysctl -q -w net.ipv4.ip_forward=1"}
+	if node.VirtualIP6 != nil {
+		commands = append(commands, "ip6tables")
+		postUp = append(postUp, "sysctl -q -w net.ipv6.conf.all.forwarding=1")
+	}
+	for _, command := range commands {
+		rules := []string{
+			"-t mangle %s PREROUTING -i %%i -j MARK --set-mark " + exitNodeMark,
+			"-t nat %s POSTROUTING -m mark --mark " + exitNodeMark + " ! -o %%i -j MASQUERADE",
+			"%s FORWARD -i %%i -j ACCEPT",
+			"%s FORWARD -o %%i -m conntrack --ctstate RELATED,ESTABLISHED -j ACCEPT",
+		}
+		for _, rule := range rules {
+			postUp = append(postUp, command+" "+fmt.Sprintf(rule, "-A"))
+			postDown = append(postDown, command+" "+fmt.Sprintf(rule, "-D"))
+		}
+	}
+	return []string{strings.Join(postUp, "; ")}, []string{strings.Join(postDown, "; ")}
+}
+
+// syncExitRoutes replaces the hub's policy routing for exit nodes: traffic
+// from assigned networks and nodes that isn't covered by a more specific
+// route follows the default route into the exit node's interface
+func (s *Server) syncExitRoutes(ctx context.Context, networks []*models.Network) {
+	if s.wgBackend == wireguard.BackendSimulated {
+		return
+	}
+	clearExitRoutes("-4")
+	clearExitRoutes("-6")
+
+	networkByID := make(map[string]*models.Network, len(networks))
+	var exitNodes []*models.Node
+	for _, network := range networks {
+		networkByID[network.ID] = network
+		nodes, err := s.store.ListNodes(ctx, network.ID)
+		if err != nil {
+			continue
+		}
+		for _, node := range nodes {
+			if node.ExitNode && !nodeIsExpired(node) {
+				exitNodes = append(exitNodes, node)
+			}
+		}
+	}
+	sort.Slice(exitNodes, func(i, j int) bool { return exitNodes[i].ID < exitNodes[j].ID })
+
+	for i, exitNode := range exitNodes {
+		network := networkByID[exitNode.NetworkID]
+		if network == nil || network.InterfaceName == "" {
+			continue
+		}
+		table := strconv.Itoa(exitRouteTableBase + i)
+		networkSources, hostSources := s.exitNodeSources(ctx, exitNode)
+		if len(networkSources)+len(hostSources) == 0 {
+			continue
+		}
+
+		for _, family := range []string{"-4", "-6"} {
+			ipv6 := family == "-6"
+			if ipv6 && exitNode.VirtualIP6 == nil {
+				continue
+			}
+			if _, err := execHostCommand("ip", family, "route", "replace", "default", "dev", network.InterfaceName, "table", table); err != nil {
+				fmt.Printf("[ExitNode] Warning: failed to set default route of table %s: %v\n", table, err)
+				continue
+			}
+			for _, sources := range []struct {
+				cidrs    []string
+				priority int
+			}{{hostSources, exitRuleNodePriority}, {networkSources, exitRuleNetPriority}} {
+				for _, cidr := range sources.cidrs {
+					if netutil.IsIPv6(cidr) != ipv6 {
+						continue
+					}
+					execHostCommand("ip", family, "rule", "add", "from", cidr, "lookup", "main", "suppress_prefixlength", "0",
+						"priority", strconv.Itoa(exitRuleMainPriority))
+					if _, err := execHostCommand("ip", family, "rule", "add", "from", cidr, "lookup", table,
+						"priority", strconv.Itoa(sources.priority)); err != nil {
+						fmt.Printf("[ExitNode] Warning: failed to route %s via %s: %v\n", cidr, exitNode.Name, err)
+					}
+				}
+			}
+		}
+
+		// Replies from the internet arrive on the exit node's interface
+		execHostCommand("sysctl", "-w", fmt.Sprintf("net.ipv4.conf.%s.rp_filter=2", network.InterfaceName))
+		fmt.Printf("[ExitNode] %s (%s) carries internet traffic of %d range(s)\n",
+			exitNode.Name, network.InterfaceName, len(networkSources)+len(hostSources))
+	}
+}
+
+// clearExitRoutes removes the exit node rules and routing tables of one
+// address family ("-4" or "-6")
+func clearExitRoutes(family string) {
+	output, err := execHostCommand("ip", family, "rule", "show")
+	if err != nil {
+		return
+	}
+	tables := make(map[string]bool)
+	for _, line := range strings.Split(output, "\n") {
+		match := ipRuleLinePattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		switch priority, _ := strconv.Atoi(match[1]); priority {
+		case exitRuleMainPriority, exitRuleNodePriority, exitRuleNetPriority:
+			execHostCommand("ip", family, "rule", "del", "priority", match[1])
+			if match[2] != "main" {
+				tables[match[2]] = true
+			}
+		}
+	}
+	for table := range tables {
+		execHostCommand("ip", family, "route", "flush", "table", table)
+	}
+}
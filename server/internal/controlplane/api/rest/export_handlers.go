@@ -0,0 +1,386 @@
+package rest
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/controlplane/store"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/wireguard"
+	"github.com/skip2/go-qrcode"
+)
+
+// Archive formats of a network config export
+const (
+	ExportFormatZip   = "zip"
+	ExportFormatTarGz = "tar.gz"
+)
+
+// Export status of a node in the manifest
+const (
+	// ExportStatusIncomplete marks files that carry the private key placeholder
+	ExportStatusIncomplete = "incomplete"
+	// ExportStatusSkipped marks nodes without files
+	ExportStatusSkipped = "skipped"
+)
+
+var unsafeFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// ExportOptions selects the archive format of a config export. A password
+// encrypts the whole archive with age (scrypt passphrase), in either format.
+type ExportOptions struct {
+	Format   string
+	Password string
+	APIURL   string // API the install scripts check in with
+}
+
+// ExportedNode is a node entry of an export manifest
+type ExportedNode struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	VirtualIP  string     `json:"virtual_ip,omitempty"`
+	VirtualIP6 string     `json:"virtual_ip6,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Expired    bool       `json:"expired,omitempty"`
+	Status     string     `json:"status"`
+	Reason     string     `json:"reason,omitempty"`
+	Files      []string   `json:"files,omitempty"`
+}
+
+// ExportManifest describes the contents of a config export archive
+type ExportManifest struct {
+	NetworkID   string          `json:"network_id"`
+	NetworkName string          `json:"network_name"`
+	CIDRs       []string        `json:"cidrs"`
+	Endpoint    string          `json:"endpoint"`
+	ExportedAt  time.Time       `json:"exported_at"`
+	Encrypted   bool            `json:"encrypted"`
+	Exported    int             `json:"exported"`
+	Incomplete  int             `json:"incomplete"`
+	Skipped     int             `json:"skipped"`
+	Nodes       []*ExportedNode `json:"nodes"`
+}
+
+// ExportFileExtension returns the file extension of an export format,
+// with .age appended for password encrypted archives
+func ExportFileExtension(format string, encrypted bool) string {
+	ext := ".zip"
+	if format == ExportFormatTarGz {
+		ext = ".tar.gz"
+	}
+	if encrypted {
+		ext += ".age"
+	}
+	return ext
+}
+
+// normalizeExportOptions defaults the format to zip and validates it
+func normalizeExportOptions(opts *ExportOptions) error {
+	switch strings.ToLower(strings.TrimSpace(opts.Format)) {
+	case "", "zip":
+		opts.Format = ExportFormatZip
+	case "tar.gz", "tgz":
+		opts.Format = ExportFormatTarGz
+	default:
+		return fmt.Errorf("format must be zip or tar.gz")
+	}
+	return nil
+}
+
+// exportFile is a file of a node's export directory
+type exportFile struct {
+	name string
+	data []byte
+	mode int64
+}
+
+// archiveWriter adds files to a zip or tar.gz archive
+type archiveWriter interface {
+	add(name string, data []byte, mode int64) error
+	close() error
+}
+
+type zipArchive struct {
+	zw       *zip.Writer
+	modified time.Time
+}
+
+func (a *zipArchive) add(name string, data []byte, mode int64) error {
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: a.modified}
+	header.SetMode(os.FileMode(mode))
+	w, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (a *zipArchive) close() error {
+	return a.zw.Close()
+}
+
+type tarGzArchive struct {
+	gz       *gzip.Writer
+	tw       *tar.Writer
+	modified time.Time
+}
+
+func (a *tarGzArchive) add(name string, data []byte, mode int64) error {
+	if err := a.tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    mode,
+		Size:    int64(len(data)),
+		ModTime: a.modified,
+	}); err != nil {
+		return err
+	}
+	_, err := a.tw.Write(data)
+	return err
+}
+
+func (a *tarGzArchive) close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	return a.gz.Close()
+}
+
+// encryptedArchive encrypts the archive it wraps with age
+type encryptedArchive struct {
+	archiveWriter
+	enc io.WriteCloser
+}
+
+func (a *encryptedArchive) close() error {
+	if err := a.archiveWriter.close(); err != nil {
+		return err
+	}
+	return a.enc.Close()
+}
+
+func newArchiveWriter(w io.Writer, opts ExportOptions) (archiveWriter, error) {
+	var enc io.WriteCloser
+	if opts.Password != "" {
+		recipient, err := age.NewScryptRecipient(opts.Password)
+		if err != nil {
+			return nil, err
+		}
+		if enc, err = age.Encrypt(w, recipient); err != nil {
+			return nil, err
+		}
+		w = enc
+	}
+
+	now := time.Now()
+	var archive archiveWriter
+	if opts.Format == ExportFormatTarGz {
+		gz := gzip.NewWriter(w)
+		archive = &tarGzArchive{gz: gz, tw: tar.NewWriter(gz), modified: now}
+	} else {
+		archive = &zipArchive{zw: zip.NewWriter(w), modified: now}
+	}
+	if enc != nil {
+		return &encryptedArchive{archiveWriter: archive, enc: enc}, nil
+	}
+	return archive, nil
+}
+
+// exportFileName turns a node name into a file name, unique within the archive
+func exportFileName(name string, used map[string]bool) string {
+	base := strings.Trim(unsafeFileNameChars.ReplaceAllString(name, "_"), "._")
+	if base == "" {
+		base = "node"
+	}
+	fileName := base
+	for i := 2; used[strings.ToLower(fileName)]; i++ {
+		fileName = fmt.Sprintf("%s-%d", base, i)
+	}
+	used[strings.ToLower(fileName)] = true
+	return fileName
+}
+
+// writeNetworkExport writes the config, QR code and install script of every
+// node, and a manifest listing them. Private keys are not stored, so configs
+// and QR codes carry wireguard.PrivateKeyPlaceholder and are listed as
+// incomplete; nodes without an address are listed as skipped.
+func (s *Server) writeNetworkExport(ctx context.Context, w io.Writer, network *models.Network, opts ExportOptions) (*ExportManifest, error) {
+	if err := normalizeExportOptions(&opts); err != nil {
+		return nil, err
+	}
+	serverPublicKey := network.ServerPublicKey
+	if serverPublicKey == "" {
+		if mgr := s.getManager(network.ID); mgr != nil {
+			if key, err := mgr.GetPublicKey(); err == nil {
+				serverPublicKey = key
+			}
+		}
+	}
+	if serverPublicKey == "" {
+		return nil, fmt.Errorf("server public key not configured for this network")
+	}
+	serverEndpoint := hubEndpoint(network)
+
+	nodes, err := s.store.ListNodes(ctx, network.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	manifest := &ExportManifest{
+		NetworkID:   network.ID,
+		NetworkName: network.Name,
+		CIDRs:       network.CIDRs(),
+		Endpoint:    serverEndpoint,
+		ExportedAt:  time.Now().UTC(),
+		Encrypted:   opts.Password != "",
+		Nodes:       []*ExportedNode{},
+	}
+	archive, err := newArchiveWriter(w, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt archive: %w", err)
+	}
+	used := map[string]bool{"manifest.json": true}
+	for _, node := range nodes {
+		entry := &ExportedNode{
+			ID:        node.ID,
+			Name:      node.Name,
+			ExpiresAt: node.ExpiresAt,
+			Expired:   node.ExpiresAt != nil && node.ExpiresAt.Before(time.Now()),
+		}
+		if node.VirtualIP != nil {
+			entry.VirtualIP = node.VirtualIP.String()
+		}
+		if node.VirtualIP6 != nil {
+			entry.VirtualIP6 = node.VirtualIP6.String()
+		}
+		manifest.Nodes = append(manifest.Nodes, entry)
+		if node.VirtualIP == nil && node.VirtualIP6 == nil {
+			entry.Status = ExportStatusSkipped
+			entry.Reason = "node has no VPN address"
+			manifest.Skipped++
+			continue
+		}
+
+		// Exporting is not a fetch by the node
+		config := s.renderNodeConfig(ctx, node, network, wireguard.PrivateKeyPlaceholder, serverPublicKey, serverEndpoint)
+		dir := exportFileName(node.Name, used)
+		files := []exportFile{{dir + "/" + dir + ".conf", []byte(config), 0600}}
+		entry.Status = ExportStatusIncomplete
+		entry.Reason = "private key not stored: config and QR code carry " + wireguard.PrivateKeyPlaceholder +
+			", install.sh fills in the key already on the node"
+		// Large mesh configs may not fit in a QR code
+		if png, err := qrcode.Encode(config, qrcode.Medium, 256); err == nil {
+			files = append(files, exportFile{dir + "/" + dir + ".png", png, 0600})
+		} else {
+			entry.Reason = "private key not stored: config carries " + wireguard.PrivateKeyPlaceholder +
+				", install.sh fills in the key already on the node; no QR code: " + err.Error()
+		}
+		files = append(files, exportFile{dir + "/install.sh", []byte(nodeInstallScript(node, network, config, opts.APIURL)), 0700})
+		for _, f := range files {
+			if err := archive.add(f.name, f.data, f.mode); err != nil {
+				return nil, fmt.Errorf("failed to write %s: %w", f.name, err)
+			}
+			entry.Files = append(entry.Files, f.name)
+		}
+		manifest.Exported++
+		manifest.Incomplete++
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := archive.add("manifest.json", manifestJSON, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write manifest.json: %w", err)
+	}
+	if err := archive.close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// ExportNetwork writes the config export archive of a network from the
+// database alone, for the export CLI command
+func ExportNetwork(ctx context.Context, st *store.Store, network *models.Network, w io.Writer, opts ExportOptions) (*ExportManifest, error) {
+	s := &Server{store: st, managers: make(map[string]wireguard.Backend)}
+	return s.writeNetworkExport(ctx, w, network, opts)
+}
+
+// handleExportNetwork returns an archive with the config, QR code and install
+// script of every node of a network
+func (s *Server) handleExportNetwork(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req struct {
+		Format   string `json:"format"`   // zip (default) or tar.gz
+		Password string `json:"password"` // Encrypts the archive with age
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	network, err := s.store.GetNetwork(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+	opts := ExportOptions{
+		Format:   req.Format,
+		Password: req.Password,
+		APIURL:   fmt.Sprintf("http://%s/api/v1", r.Host),
+	}
+	if err := normalizeExportOptions(&opts); err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Buffered so a failure can still be reported as JSON
+	var buf bytes.Buffer
+	manifest, err := s.writeNetworkExport(r.Context(), &buf, network, opts)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to export configs: "+err.Error())
+		return
+	}
+	fmt.Printf("[Export] Network %s: %d config(s) exported (%d incomplete), %d skipped\n",
+		network.Name, manifest.Exported, manifest.Incomplete, manifest.Skipped)
+
+	s.store.CreateFirewallAuditLog(r.Context(), "network_configs_exported", map[string]interface{}{
+		"network_id": network.ID,
+		"name":       network.Name,
+		"format":     opts.Format,
+		"encrypted":  manifest.Encrypted,
+		"exported":   manifest.Exported,
+		"skipped":    manifest.Skipped,
+	}, r.RemoteAddr)
+
+	contentType := "application/zip"
+	switch {
+	case manifest.Encrypted:
+		contentType = "application/octet-stream"
+	case opts.Format == ExportFormatTarGz:
+		contentType = "application/gzip"
+	}
+	fileName := exportFileName(network.Name, map[string]bool{}) + "-configs" + ExportFileExtension(opts.Format, manifest.Encrypted)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", fileName))
+	w.Write(buf.Bytes())
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/shared/netutil"
 )
 
 // FirewallRule represents a parsed iptables rule
@@ -28,6 +29,7 @@ type FirewallRule struct {
 	OutInterface string `json:"out_interface,omitempty"`
 	Options     string `json:"options,omitempty"`
 	Protected   bool   `json:"protected"`   // Cannot be deleted
+	Managed     bool   `json:"managed"`     // Maintained by NovusGate (novusgate-* comment)
 }
 
 // ChainInfo represents information about an iptables chain
@@ -90,22 +92,19 @@ var protectedPorts = map[int]string{
 }
 
 // isProtectedRule checks if a rule is protected (SSH, WireGuard, Admin API)
-func isProtectedRule(rule FirewallRule) bool {
+func isProtectedRule(rule FirewallRule, protection ruleProtection) bool {
 	// Protect SSH rules
 	if rule.Port == "22" && rule.Target == "ACCEPT" {
 		return true
 	}
 	
-	// Protect WireGuard ports (51820+)
-	if rule.Port != "" {
-		port, err := strconv.Atoi(rule.Port)
-		if err == nil && port >= 51820 && port <= 51830 && rule.Target == "ACCEPT" {
-			return true
-		}
+	// Protect the WireGuard ports of the networks
+	if rule.Port != "" && rule.Target == "ACCEPT" && protection.coversPort(rule.Port) {
+		return true
 	}
 	
-	// Protect rules for wg+ interfaces (WireGuard)
-	if strings.HasPrefix(rule.Interface, "wg") || strings.HasPrefix(rule.InInterface, "wg") {
+	// Protect rules for WireGuard interfaces
+	if protection.isWireGuardInterface(rule.Interface) || protection.isWireGuardInterface(rule.InInterface) {
 		return true
 	}
 	
@@ -114,7 +113,7 @@ func isProtectedRule(rule FirewallRule) bool {
 
 
 // parseIptablesOutput parses the output of iptables -L -n -v --line-numbers
-func parseIptablesOutput(output string, chainName string) (*ChainInfo, error) {
+func parseIptablesOutput(output string, chainName string, protection ruleProtection) (*ChainInfo, error) {
 	lines := strings.Split(output, "\n")
 	chain := &ChainInfo{
 		Name:   chainName,
@@ -166,7 +165,8 @@ func parseIptablesOutput(output string, chainName string) (*ChainInfo, error) {
 		
 		rule := parseRuleLine(line, chainName)
 		if rule != nil {
-			rule.Protected = isProtectedRule(*rule)
+			rule.Protected = isProtectedRule(*rule, protection)
+			rule.Managed = strings.Contains(rule.Options, "novusgate-")
 			chain.Rules = append(chain.Rules, *rule)
 		}
 	}
@@ -289,6 +289,7 @@ func (s *Server) handleFirewallGetRules(w http.ResponseWriter, r *http.Request)
 		OpenPorts:  0,
 	}
 	
+	protection := s.ruleProtection(r.Context())
 	for _, chainName := range chains {
 		// Execute iptables command via nsenter (for Docker compatibility)
 		output, err := execHostCommand("iptables", "-L", chainName, "-n", "-v", "--line-numbers")
@@ -297,7 +298,7 @@ func (s *Server) handleFirewallGetRules(w http.ResponseWriter, r *http.Request)
 			return
 		}
 		
-		chain, err := parseIptablesOutput(output, chainName)
+		chain, err := parseIptablesOutput(output, chainName, protection)
 		if err != nil {
 			errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to parse %s chain: %v", chainName, err))
 			return
@@ -418,6 +419,12 @@ func (s *Server) handleFirewallClosePort(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	
+	// WireGuard ports of the networks are closed by deleting the network
+	if req.Protocol != "tcp" && !req.Force && s.ruleProtection(r.Context()).ports[req.Port] {
+		errorResponse(w, http.StatusForbidden, fmt.Sprintf("port %d is a WireGuard network port. Set force=true to override", req.Port))
+		return
+	}
+	
 	protocols := []string{req.Protocol}
 	if req.Protocol == "both" {
 		protocols = []string{"tcp", "udp"}
@@ -640,7 +647,7 @@ func (s *Server) handleFirewallDeleteRule(w http.ResponseWriter, r *http.Request
 	}
 	
 	// Parse and find the rule
-	chain, err := parseIptablesOutput(output, req.Chain)
+	chain, err := parseIptablesOutput(output, req.Chain, s.ruleProtection(r.Context()))
 	if err != nil {
 		errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to parse rules: %v", err))
 		return
@@ -757,6 +764,26 @@ func writeHostFile(path string, content string) error {
 
 // handleFirewallReset resets firewall to default NovusGate configuration
 func (s *Server) handleFirewallReset(w http.ResponseWriter, r *http.Request) {
+	ports, err := s.managedPorts(r.Context())
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to read WireGuard ports: %v", err))
+		return
+	}
+	
+	// The ports of the networks, tagged like the rules opened on network creation
+	var wireGuardRules strings.Builder
+	interfaces := []string{s.interfacePrefix + "+"}
+	for _, p := range ports {
+		fmt.Fprintf(&wireGuardRules, "-A INPUT -p udp --dport %d -m comment --comment %s -j ACCEPT\n", p.port, p.comment)
+		if !strings.HasPrefix(p.interfaceName, s.interfacePrefix) {
+			interfaces = append(interfaces, p.interfaceName)
+		}
+	}
+	var interfaceRules strings.Builder
+	for _, name := range interfaces {
+		fmt.Fprintf(&interfaceRules, "-A INPUT -i %s -j ACCEPT\n-A FORWARD -i %s -j ACCEPT\n-A FORWARD -o %s -j ACCEPT\n", name, name, name)
+	}
+	
 	// Default NovusGate firewall rules
 	defaultRules := `*filter
 :INPUT DROP [0:0]
@@ -774,9 +801,8 @@ func (s *Server) handleFirewallReset(w http.ResponseWriter, r *http.Request) {
 # Allow SSH
 -A INPUT -p tcp --dport 22 -j ACCEPT
 
-# Allow WireGuard (51820-51830)
--A INPUT -p udp --dport 51820:51830 -j ACCEPT
-
+# Allow WireGuard ports of the networks
+` + wireGuardRules.String() + `
 # Allow HTTP/HTTPS for web panel
 -A INPUT -p tcp --dport 80 -j ACCEPT
 -A INPUT -p tcp --dport 443 -j ACCEPT
@@ -788,16 +814,13 @@ func (s *Server) handleFirewallReset(w http.ResponseWriter, r *http.Request) {
 -A INPUT -p icmp -j ACCEPT
 
 # Allow all traffic from WireGuard interfaces
--A INPUT -i wg+ -j ACCEPT
--A FORWARD -i wg+ -j ACCEPT
--A FORWARD -o wg+ -j ACCEPT
-
+` + interfaceRules.String() + `
 COMMIT
 `
 	
 	// Create a temporary file for the rules
 	tmpFile := "/tmp/iptables-default.txt"
-	err := writeHostFile(tmpFile, defaultRules)
+	err = writeHostFile(tmpFile, defaultRules)
 	if err != nil {
 		errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to write temporary file: %v", err))
 		return
@@ -831,11 +854,11 @@ COMMIT
 type VPNFirewallRuleRequest struct {
 	Name            string  `json:"name"`
 	Description     string  `json:"description,omitempty"`
-	SourceType      string  `json:"source_type"`       // any, network, node, custom
+	SourceType      string  `json:"source_type"`       // any, network, node, subnet, custom
 	SourceNetworkID *string `json:"source_network_id,omitempty"`
 	SourceNodeID    *string `json:"source_node_id,omitempty"`
 	SourceIP        string  `json:"source_ip,omitempty"`
-	DestType        string  `json:"dest_type"`         // any, network, node, custom
+	DestType        string  `json:"dest_type"`         // any, network, node, subnet, custom
 	DestNetworkID   *string `json:"dest_network_id,omitempty"`
 	DestNodeID      *string `json:"dest_node_id,omitempty"`
 	DestIP          string  `json:"dest_ip,omitempty"`
@@ -853,14 +876,14 @@ func validateVPNFirewallRule(req *VPNFirewallRuleRequest) error {
 	}
 	
 	// Validate source type
-	validTypes := map[string]bool{"any": true, "network": true, "node": true, "custom": true}
+	validTypes := map[string]bool{"any": true, "network": true, "node": true, "subnet": true, "custom": true}
 	if !validTypes[req.SourceType] {
-		return fmt.Errorf("invalid source_type: must be any, network, node, or custom")
+		return fmt.Errorf("invalid source_type: must be any, network, node, subnet, or custom")
 	}
 	
 	// Validate destination type
 	if !validTypes[req.DestType] {
-		return fmt.Errorf("invalid dest_type: must be any, network, node, or custom")
+		return fmt.Errorf("invalid dest_type: must be any, network, node, subnet, or custom")
 	}
 	
 	// Validate source references based on type
@@ -873,6 +896,16 @@ func validateVPNFirewallRule(req *VPNFirewallRuleRequest) error {
 		if req.SourceNodeID == nil || *req.SourceNodeID == "" {
 			return fmt.Errorf("source_node_id is required when source_type is node")
 		}
+	case "subnet":
+		// source_node_id is the subnet router; source_ip optionally picks one of its subnets
+		if req.SourceNodeID == nil || *req.SourceNodeID == "" {
+			return fmt.Errorf("source_node_id is required when source_type is subnet")
+		}
+		if req.SourceIP != "" {
+			if _, _, err := net.ParseCIDR(req.SourceIP); err != nil {
+				return fmt.Errorf("invalid source_ip: must be one of the node's subnets")
+			}
+		}
 	case "custom":
 		if req.SourceIP == "" {
 			return fmt.Errorf("source_ip is required when source_type is custom")
@@ -895,6 +928,15 @@ func validateVPNFirewallRule(req *VPNFirewallRuleRequest) error {
 		if req.DestNodeID == nil || *req.DestNodeID == "" {
 			return fmt.Errorf("dest_node_id is required when dest_type is node")
 		}
+	case "subnet":
+		if req.DestNodeID == nil || *req.DestNodeID == "" {
+			return fmt.Errorf("dest_node_id is required when dest_type is subnet")
+		}
+		if req.DestIP != "" {
+			if _, _, err := net.ParseCIDR(req.DestIP); err != nil {
+				return fmt.Errorf("invalid dest_ip: must be one of the node's subnets")
+			}
+		}
 	case "custom":
 		if req.DestIP == "" {
 			return fmt.Errorf("dest_ip is required when dest_type is custom")
@@ -1135,36 +1177,99 @@ func (s *Server) handleVPNFirewallApply(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-// applyVPNFirewallRule applies a single VPN firewall rule to iptables
+// applyVPNFirewallRule applies a single VPN firewall rule to iptables, and to
+// ip6tables for the IPv6 side of the rule
 func (s *Server) applyVPNFirewallRule(ctx context.Context, rule *models.VPNFirewallRule) error {
-	// Build source IP/CIDR
-	sourceIP, err := s.resolveVPNRuleEndpoint(ctx, rule.SourceType, rule.SourceNetworkID, rule.SourceNodeID, rule.SourceIP)
+	commands, err := s.vpnFirewallRuleCommands(ctx, rule)
 	if err != nil {
-		return fmt.Errorf("failed to resolve source: %w", err)
+		return err
+	}
+	for _, command := range commands {
+		if _, err := execHostCommand(command.Command, command.Args...); err != nil {
+			return fmt.Errorf("%s command failed: %w", command.Command, err)
+		}
+	}
+	return nil
+}
+
+// vpnFirewallRuleCommands returns the FORWARD rules of a VPN firewall rule,
+// one per source/destination pair and address family
+func (s *Server) vpnFirewallRuleCommands(ctx context.Context, rule *models.VPNFirewallRule) ([]models.FirewallCommand, error) {
+	// Build source IPs/CIDRs (nil means any)
+	sourceIPs, err := s.resolveVPNRuleEndpoint(ctx, rule.SourceType, rule.SourceNetworkID, rule.SourceNodeID, rule.SourceIP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve source: %w", err)
 	}
 	
-	// Build destination IP/CIDR
-	destIP, err := s.resolveVPNRuleEndpoint(ctx, rule.DestType, rule.DestNetworkID, rule.DestNodeID, rule.DestIP)
+	// Build destination IPs/CIDRs (nil means any)
+	destIPs, err := s.resolveVPNRuleEndpoint(ctx, rule.DestType, rule.DestNetworkID, rule.DestNodeID, rule.DestIP)
 	if err != nil {
-		return fmt.Errorf("failed to resolve destination: %w", err)
+		return nil, fmt.Errorf("failed to resolve destination: %w", err)
+	}
+	
+	var commands []models.FirewallCommand
+	for _, ipv6 := range []bool{false, true} {
+		sources, ok := vpnRuleAddressesForFamily(sourceIPs, ipv6)
+		if !ok {
+			continue
+		}
+		dests, ok := vpnRuleAddressesForFamily(destIPs, ipv6)
+		if !ok {
+			continue
+		}
+		
+		for _, sourceIP := range sources {
+			for _, destIP := range dests {
+				commands = append(commands, vpnFirewallRuleEntry(rule, sourceIP, destIP, ipv6))
+			}
+		}
+	}
+	if len(commands) == 0 {
+		return nil, fmt.Errorf("source and destination have no address family in common")
 	}
 	
+	return commands, nil
+}
+
+// vpnRuleAddressesForFamily returns the rule addresses of one address family.
+// An "any" endpoint (nil) yields a single empty entry (no -s/-d match); ok is
+// false when the endpoint has no address of that family.
+func vpnRuleAddressesForFamily(addrs []string, ipv6 bool) ([]string, bool) {
+	if addrs == nil {
+		return []string{""}, true
+	}
+	var out []string
+	for _, addr := range addrs {
+		if netutil.IsIPv6(addr) == ipv6 {
+			out = append(out, addr)
+		}
+	}
+	return out, len(out) > 0
+}
+
+// vpnFirewallRuleEntry builds the iptables or ip6tables command appending one
+// FORWARD rule for a source/destination pair
+func vpnFirewallRuleEntry(rule *models.VPNFirewallRule, sourceIP, destIP string, ipv6 bool) models.FirewallCommand {
 	// Build iptables command
 	args := []string{"-A", "FORWARD"}
 	
 	// Add source
-	if sourceIP != "" && sourceIP != "0.0.0.0/0" {
+	if sourceIP != "" {
 		args = append(args, "-s", sourceIP)
 	}
 	
 	// Add destination
-	if destIP != "" && destIP != "0.0.0.0/0" {
+	if destIP != "" {
 		args = append(args, "-d", destIP)
 	}
 	
 	// Add protocol
 	if rule.Protocol != "all" && rule.Protocol != "" {
-		args = append(args, "-p", rule.Protocol)
+		protocol := rule.Protocol
+		if ipv6 && protocol == "icmp" {
+			protocol = "ipv6-icmp"
+		}
+		args = append(args, "-p", protocol)
 		
 		// Add port (only for tcp/udp)
 		if rule.Port != "" && (rule.Protocol == "tcp" || rule.Protocol == "udp") {
@@ -1183,55 +1288,69 @@ func (s *Server) applyVPNFirewallRule(ctx context.Context, rule *models.VPNFirew
 	// Add comment with rule ID for identification
 	args = append(args, "-m", "comment", "--comment", fmt.Sprintf("novusgate-vpn-%s", rule.ID))
 	
-	// Execute iptables command
-	_, err = execHostCommand("iptables", args...)
-	if err != nil {
-		return fmt.Errorf("iptables command failed: %w", err)
+	command := "iptables"
+	if ipv6 {
+		command = "ip6tables"
 	}
-	
-	return nil
+	return models.FirewallCommand{Command: command, Args: args}
 }
 
-// resolveVPNRuleEndpoint resolves the IP/CIDR for a VPN rule endpoint
-func (s *Server) resolveVPNRuleEndpoint(ctx context.Context, endpointType string, networkID, nodeID *string, customIP string) (string, error) {
+// resolveVPNRuleEndpoint resolves the IPs/CIDRs for a VPN rule endpoint. A
+// nil result means any address; networks and nodes may resolve to both an
+// IPv4 and an IPv6 entry.
+func (s *Server) resolveVPNRuleEndpoint(ctx context.Context, endpointType string, networkID, nodeID *string, customIP string) ([]string, error) {
 	switch endpointType {
 	case "any":
-		return "0.0.0.0/0", nil
+		return nil, nil
 	case "network":
 		if networkID == nil {
-			return "", fmt.Errorf("network_id is required for network type")
+			return nil, fmt.Errorf("network_id is required for network type")
 		}
 		network, err := s.store.GetNetwork(ctx, *networkID)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		if network == nil {
-			return "", fmt.Errorf("network not found")
+			return nil, fmt.Errorf("network not found")
 		}
-		return network.CIDR, nil
+		return network.CIDRs(), nil
 	case "node":
 		if nodeID == nil {
-			return "", fmt.Errorf("node_id is required for node type")
+			return nil, fmt.Errorf("node_id is required for node type")
 		}
 		node, err := s.store.GetNode(ctx, *nodeID)
 		if err != nil {
-			return "", err
+			return nil, err
 		}
 		if node == nil {
-			return "", fmt.Errorf("node not found")
+			return nil, fmt.Errorf("node not found")
+		}
+		return nodeHostRoutes(node), nil
+	case "subnet":
+		if nodeID == nil {
+			return nil, fmt.Errorf("node_id is required for subnet type")
 		}
-		return node.VirtualIP.String() + "/32", nil
+		node, err := s.store.GetNode(ctx, *nodeID)
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			return nil, fmt.Errorf("node not found")
+		}
+		return nodeSubnetEndpoint(node, customIP)
 	case "custom":
 		if customIP == "" {
-			return "", fmt.Errorf("custom IP is required for custom type")
+			return nil, fmt.Errorf("custom IP is required for custom type")
 		}
 		// Ensure CIDR notation
 		if !strings.Contains(customIP, "/") {
-			customIP = customIP + "/32"
+			if ip := net.ParseIP(customIP); ip != nil {
+				customIP = netutil.HostCIDR(ip)
+			}
 		}
-		return customIP, nil
+		return []string{customIP}, nil
 	default:
-		return "", fmt.Errorf("unknown endpoint type: %s", endpointType)
+		return nil, fmt.Errorf("unknown endpoint type: %s", endpointType)
 	}
 }
 
@@ -1281,10 +1400,10 @@ func (s *Server) syncPeerAllowedIPs(ctx context.Context, rules []*models.VPNFire
 		return fmt.Errorf("failed to list networks: %w", err)
 	}
 	
-	// Build a map of network ID to CIDR
-	networkCIDRs := make(map[string]string)
+	// Build a map of network ID to CIDRs (IPv4 and/or IPv6)
+	networkCIDRs := make(map[string][]string)
 	for _, net := range networks {
-		networkCIDRs[net.ID] = net.CIDR
+		networkCIDRs[net.ID] = net.CIDRs()
 	}
 	
 	// For each network, determine which other networks it should be able to reach
@@ -1296,28 +1415,28 @@ func (s *Server) syncPeerAllowedIPs(ctx context.Context, rules []*models.VPNFire
 			continue
 		}
 		
-		// Get source and destination network CIDRs
-		var sourceCIDRs, destCIDRs []string
+		// Get source network IDs and destination network CIDRs
+		var sourceNetIDs, destCIDRs []string
 		
 		switch rule.SourceType {
 		case "any":
 			// All networks
-			for _, cidr := range networkCIDRs {
-				sourceCIDRs = append(sourceCIDRs, cidr)
+			for id := range networkCIDRs {
+				sourceNetIDs = append(sourceNetIDs, id)
 			}
 		case "network":
 			if rule.SourceNetworkID != nil {
-				if cidr, ok := networkCIDRs[*rule.SourceNetworkID]; ok {
-					sourceCIDRs = append(sourceCIDRs, cidr)
+				if _, ok := networkCIDRs[*rule.SourceNetworkID]; ok {
+					sourceNetIDs = append(sourceNetIDs, *rule.SourceNetworkID)
 				}
 			}
-		case "node":
+		case "node", "subnet":
 			// For node, we need to find its network
 			if rule.SourceNodeID != nil {
 				node, _ := s.store.GetNode(ctx, *rule.SourceNodeID)
 				if node != nil {
-					if cidr, ok := networkCIDRs[node.NetworkID]; ok {
-						sourceCIDRs = append(sourceCIDRs, cidr)
+					if _, ok := networkCIDRs[node.NetworkID]; ok {
+						sourceNetIDs = append(sourceNetIDs, node.NetworkID)
 					}
 				}
 			}
@@ -1325,40 +1444,39 @@ func (s *Server) syncPeerAllowedIPs(ctx context.Context, rules []*models.VPNFire
 		
 		switch rule.DestType {
 		case "any":
-			for _, cidr := range networkCIDRs {
-				destCIDRs = append(destCIDRs, cidr)
+			for _, cidrs := range networkCIDRs {
+				destCIDRs = append(destCIDRs, cidrs...)
+			}
+			if routes, err := s.store.ListSubnetRoutes(ctx, ""); err == nil {
+				for _, route := range routes {
+					if route.Approved {
+						destCIDRs = append(destCIDRs, route.CIDR)
+					}
+				}
 			}
 		case "network":
 			if rule.DestNetworkID != nil {
-				if cidr, ok := networkCIDRs[*rule.DestNetworkID]; ok {
-					destCIDRs = append(destCIDRs, cidr)
-				}
+				destCIDRs = append(destCIDRs, networkCIDRs[*rule.DestNetworkID]...)
 			}
 		case "node":
 			if rule.DestNodeID != nil {
 				node, _ := s.store.GetNode(ctx, *rule.DestNodeID)
 				if node != nil {
-					if cidr, ok := networkCIDRs[node.NetworkID]; ok {
-						destCIDRs = append(destCIDRs, cidr)
-					}
+					destCIDRs = append(destCIDRs, networkCIDRs[node.NetworkID]...)
+				}
+			}
+		case "subnet":
+			if rule.DestNodeID != nil {
+				node, _ := s.store.GetNode(ctx, *rule.DestNodeID)
+				if node != nil {
+					subnets, _ := nodeSubnetEndpoint(node, rule.DestIP)
+					destCIDRs = append(destCIDRs, subnets...)
 				}
 			}
 		}
 		
 		// For each source network, add destination CIDRs to its routes
-		for _, srcCIDR := range sourceCIDRs {
-			// Find network ID by CIDR
-			var srcNetID string
-			for id, cidr := range networkCIDRs {
-				if cidr == srcCIDR {
-					srcNetID = id
-					break
-				}
-			}
-			if srcNetID == "" {
-				continue
-			}
-			
+		for _, srcNetID := range sourceNetIDs {
 			if networkRoutes[srcNetID] == nil {
 				networkRoutes[srcNetID] = make(map[string]bool)
 			}
@@ -1382,9 +1500,13 @@ func (s *Server) syncPeerAllowedIPs(ctx context.Context, rules []*models.VPNFire
 		}
 		
 		// Build AllowedIPs string: own network + all routed networks
-		allowedCIDRs := []string{network.CIDR}
+		allowedCIDRs := network.CIDRs()
+		own := make(map[string]bool)
+		for _, cidr := range allowedCIDRs {
+			own[cidr] = true
+		}
 		for cidr := range routes {
-			if cidr != network.CIDR {
+			if !own[cidr] {
 				allowedCIDRs = append(allowedCIDRs, cidr)
 			}
 		}
@@ -1398,7 +1520,7 @@ func (s *Server) syncPeerAllowedIPs(ctx context.Context, rules []*models.VPNFire
 		
 		for _, node := range nodes {
 			// Update peer's AllowedIPs to include all routed networks
-			nodeAllowedIPs := node.VirtualIP.String() + "/32"
+			nodeAllowedIPs := nodePeerConfig(node).AllowedIPs
 			// Note: On server side, peer AllowedIPs is just the node's IP
 			// The routing happens via iptables FORWARD rules
 			// But we need to ensure IP forwarding is enabled
@@ -1412,37 +1534,26 @@ func (s *Server) syncPeerAllowedIPs(ctx context.Context, rules []*models.VPNFire
 	
 	// Ensure IP forwarding is enabled
 	execHostCommand("sysctl", "-w", "net.ipv4.ip_forward=1")
+	execHostCommand("sysctl", "-w", "net.ipv6.conf.all.forwarding=1")
 	
 	return nil
 }
 
-// clearNovusGateVPNRules removes all NovusGate VPN rules from FORWARD chain
+// clearNovusGateVPNRules removes all NovusGate VPN rules from the IPv4 and
+// IPv6 FORWARD chains
 func (s *Server) clearNovusGateVPNRules() error {
-	// Get current FORWARD chain rules
-	output, err := execHostCommand("iptables", "-L", "FORWARD", "-n", "-v", "--line-numbers")
-	if err != nil {
+	if err := clearNovusGateVPNRulesWith("iptables"); err != nil {
 		return err
 	}
-	
-	// Find all rules with novusgate-vpn comment and delete them (in reverse order)
-	lines := strings.Split(output, "\n")
-	var lineNumbers []int
-	
-	for _, line := range lines {
-		if strings.Contains(line, "novusgate-vpn-") {
-			fields := strings.Fields(line)
-			if len(fields) > 0 {
-				if num, err := strconv.Atoi(fields[0]); err == nil {
-					lineNumbers = append(lineNumbers, num)
-				}
-			}
-		}
-	}
-	
-	// Delete in reverse order to maintain line numbers
-	for i := len(lineNumbers) - 1; i >= 0; i-- {
-		execHostCommand("iptables", "-D", "FORWARD", strconv.Itoa(lineNumbers[i]))
+	// ip6tables may be unavailable on IPv4-only hosts
+	if err := clearNovusGateVPNRulesWith("ip6tables"); err != nil {
+		fmt.Printf("Warning: failed to clear IPv6 VPN rules: %v\n", err)
 	}
-	
 	return nil
 }
+
+// clearNovusGateVPNRulesWith removes NovusGate VPN rules using the given
+// iptables binary (iptables or ip6tables)
+func clearNovusGateVPNRulesWith(command string) error {
+	return clearCommentedRules(command, "filter", "FORWARD", "novusgate-vpn-")
+}
@@ -0,0 +1,244 @@
+package rest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/shared/netutil"
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+// gatewayAgentPathPrefix is the API of gateway agents; it is authenticated
+// with per-gateway tokens instead of the admin token and API key
+const gatewayAgentPathPrefix = "/api/v1/gateway/"
+
+// gatewayReport is the latest peer stats a gateway agent reported
+type gatewayReport struct {
+	networkID  string
+	peers      map[string]wireguard.PeerStatus
+	reportedAt time.Time
+}
+
+// isGatewayAgentPath reports whether a request goes to the gateway agent API
+func isGatewayAgentPath(path string) bool {
+	return strings.HasPrefix(path, gatewayAgentPathPrefix)
+}
+
+// newGatewayToken returns a random agent token and the hash that is stored
+func newGatewayToken() (token, tokenHash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(buf)
+	return token, hashGatewayToken(token), nil
+}
+
+// hashGatewayToken returns the stored form of an agent token
+func hashGatewayToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authenticateGateway returns the gateway whose token the request carries
+func (s *Server) authenticateGateway(r *http.Request) (*models.Gateway, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return nil, fmt.Errorf("missing gateway token")
+	}
+	gateway, err := s.store.GetGatewayByTokenHash(r.Context(), hashGatewayToken(strings.TrimPrefix(auth, "Bearer ")))
+	if err != nil {
+		return nil, err
+	}
+	if gateway == nil {
+		return nil, fmt.Errorf("invalid gateway token")
+	}
+	return gateway, nil
+}
+
+// livePeers returns the live peer status of a network: the local interface
+// merged with recent reports of its gateway agents. For a peer known to
+// several hosts the one with the latest handshake wins.
+func (s *Server) livePeers(networkID string) map[string]wireguard.PeerStatus {
+	var peers map[string]wireguard.PeerStatus
+	if mgr := s.getManager(networkID); mgr != nil {
+		peers, _ = mgr.GetPeers()
+	}
+
+	s.gatewayReportsMu.RLock()
+	defer s.gatewayReportsMu.RUnlock()
+	for _, report := range s.gatewayReports {
+		if report.networkID != networkID || time.Since(report.reportedAt) > gatewayHeartbeatTimeout {
+			continue
+		}
+		if peers == nil {
+			peers = make(map[string]wireguard.PeerStatus)
+		}
+		for key, status := range report.peers {
+			if existing, ok := peers[key]; !ok || status.LatestHandshakeTime > existing.LatestHandshakeTime {
+				peers[key] = status
+			}
+		}
+	}
+	return peers
+}
+
+// gatewayState builds the desired state of a gateway agent's host
+func (s *Server) gatewayState(ctx context.Context, gateway *models.Gateway) (*models.GatewayState, error) {
+	network, err := s.store.GetNetwork(ctx, gateway.NetworkID)
+	if err != nil {
+		return nil, err
+	}
+	if network == nil {
+		return nil, fmt.Errorf("network not found")
+	}
+
+	_, portStr, err := net.SplitHostPort(gateway.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gateway endpoint: %w", err)
+	}
+	port, _ := strconv.Atoi(portStr)
+	// Gateways use the hub addresses: only the active one carries client traffic
+	addresses, err := netutil.ServerAddresses(network.CIDRs()...)
+	if err != nil {
+		return nil, err
+	}
+
+	peers, err := s.gatewayNodePeers(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := s.store.ListVPNFirewallRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	firewall := []models.FirewallCommand{}
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		commands, err := s.vpnFirewallRuleCommands(ctx, rule)
+		if err != nil {
+			continue // Not applied on the hub either
+		}
+		firewall = append(firewall, commands...)
+	}
+
+	return &models.GatewayState{
+		GatewayID: gateway.ID,
+		NetworkID: network.ID,
+		Active:    network.ActiveGatewayID != nil && *network.ActiveGatewayID == gateway.ID,
+		Interface: models.GatewayInterface{
+			Name:       network.InterfaceName,
+			PrivateKey: gateway.PrivateKey,
+			Address:    strings.Join(addresses, ", "),
+			ListenPort: port,
+		},
+		Peers:    peers,
+		Firewall: firewall,
+	}, nil
+}
+
+// handleIssueGatewayToken replaces the agent token of a gateway. The token is
+// only returned here; the previous one stops working.
+func (s *Server) handleIssueGatewayToken(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	gateway, err := s.store.GetGateway(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get gateway")
+		return
+	}
+	if gateway == nil {
+		errorResponse(w, http.StatusNotFound, "gateway not found")
+		return
+	}
+
+	token, tokenHash, err := newGatewayToken()
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+	if err := s.store.SetGatewayTokenHash(r.Context(), id, tokenHash); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to store token")
+		return
+	}
+	fmt.Printf("[Gateways] New agent token issued for gateway %s\n", gateway.Name)
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"gateway": gateway,
+		"token":   token,
+	})
+}
+
+// handleGatewayAgentState returns the interface, peer and firewall state the
+// calling gateway agent must apply. Fetching it counts as a heartbeat.
+func (s *Server) handleGatewayAgentState(w http.ResponseWriter, r *http.Request) {
+	gateway, err := s.authenticateGateway(r)
+	if err != nil {
+		errorResponse(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err := s.store.RecordGatewayHeartbeat(r.Context(), gateway.ID); err != nil {
+		fmt.Printf("[Gateways] Warning: failed to record heartbeat of %s: %v\n", gateway.Name, err)
+	}
+
+	state, err := s.gatewayState(r.Context(), gateway)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to build gateway state: %v", err))
+		return
+	}
+	jsonResponse(w, http.StatusOK, state)
+}
+
+// handleGatewayAgentReport stores the live peer stats of a gateway agent, used
+// for node status and traffic like the local interface's peers
+func (s *Server) handleGatewayAgentReport(w http.ResponseWriter, r *http.Request) {
+	gateway, err := s.authenticateGateway(r)
+	if err != nil {
+		errorResponse(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var req struct {
+		Peers []models.GatewayPeerStats `json:"peers"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if err := s.store.RecordGatewayHeartbeat(r.Context(), gateway.ID); err != nil {
+		fmt.Printf("[Gateways] Warning: failed to record heartbeat of %s: %v\n", gateway.Name, err)
+	}
+
+	report := &gatewayReport{
+		networkID:  gateway.NetworkID,
+		peers:      make(map[string]wireguard.PeerStatus, len(req.Peers)),
+		reportedAt: time.Now(),
+	}
+	for _, peer := range req.Peers {
+		report.peers[peer.PublicKey] = wireguard.PeerStatus{
+			PublicKey:           peer.PublicKey,
+			Endpoint:            peer.Endpoint,
+			LatestHandshakeTime: peer.LatestHandshake,
+			TransferRx:          peer.TransferRx,
+			TransferTx:          peer.TransferTx,
+		}
+	}
+	s.gatewayReportsMu.Lock()
+	s.gatewayReports[gateway.ID] = report
+	s.gatewayReportsMu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,528 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+const (
+	// gatewayCheckInterval is how often gateway health is evaluated
+	gatewayCheckInterval = 15 * time.Second
+	// gatewayHeartbeatTimeout marks a gateway unhealthy after missed heartbeats
+	gatewayHeartbeatTimeout = 45 * time.Second
+	// defaultGatewayPriority is used when a gateway is created without one
+	defaultGatewayPriority = 100
+)
+
+// hubEndpoint returns the endpoint of a network's built-in hub
+func hubEndpoint(network *models.Network) string {
+	if network.ServerEndpoint != "" {
+		return network.ServerEndpoint
+	}
+	port := network.ListenPort
+	if port == 0 {
+		port = wireguard.DefaultServerPort
+	}
+	return fmt.Sprintf("%s:%d", wireguard.GetServerEndpoint(), port)
+}
+
+// hubHealthy reports whether the built-in hub interface of a network is up
+func (s *Server) hubHealthy(network *models.Network) bool {
+	mgr := s.getManager(network.ID)
+	return mgr != nil && mgr.IsUp()
+}
+
+// gatewayHealthy reports whether a gateway sent a heartbeat recently
+func gatewayHealthy(gateway *models.Gateway) bool {
+	return gateway.LastSeen != nil && time.Since(*gateway.LastSeen) < gatewayHeartbeatTimeout
+}
+
+// networkGatewayPeers returns the client-side peer of the active gateway
+// (built-in hub or additional gateway) and the standby peers of the others,
+// which have no AllowedIPs. Remote hub networks have no built-in hub peer.
+func (s *Server) networkGatewayPeers(ctx context.Context, network *models.Network, hubPublicKey, hubEndpoint string) (wireguard.PeerConfig, []wireguard.PeerConfig) {
+	hub := wireguard.PeerConfig{PublicKey: hubPublicKey, Endpoint: hubEndpoint}
+	gateways, err := s.store.ListGateways(ctx, network.ID)
+	if err != nil {
+		fmt.Printf("Warning: failed to list gateways of %s: %v\n", network.Name, err)
+		return hub, nil
+	}
+
+	var peers []wireguard.PeerConfig
+	if !network.RemoteHub {
+		peers = append(peers, hub)
+	}
+	activeIdx := 0
+	for _, gateway := range gateways {
+		if network.ActiveGatewayID != nil && *network.ActiveGatewayID == gateway.ID {
+			activeIdx = len(peers)
+		}
+		peers = append(peers, wireguard.PeerConfig{PublicKey: gateway.PublicKey, Endpoint: gateway.Endpoint})
+	}
+	if len(peers) == 0 {
+		return hub, nil // Remote hub network without gateways yet
+	}
+
+	standby := append([]wireguard.PeerConfig{}, peers[:activeIdx]...)
+	standby = append(standby, peers[activeIdx+1:]...)
+	return peers[activeIdx], standby
+}
+
+// gatewayNodePeers returns the node peers every gateway of a network needs
+func (s *Server) gatewayNodePeers(ctx context.Context, networkID string) ([]models.GatewayPeer, error) {
+	nodes, err := s.store.ListNodes(ctx, networkID)
+	if err != nil {
+		return nil, err
+	}
+	peers := []models.GatewayPeer{}
+	for _, node := range nodes {
+		if nodeIsExpired(node) {
+			continue
+		}
+		for _, peer := range nodeDesiredPeers(node) {
+			peers = append(peers, models.GatewayPeer{
+				NodeID:       node.ID,
+				PublicKey:    peer.PublicKey,
+				PresharedKey: peer.PresharedKey,
+				AllowedIPs:   peer.AllowedIPs,
+			})
+		}
+	}
+	return peers, nil
+}
+
+// activateGateway makes a gateway (nil = built-in hub) the active gateway of a
+// network and tells the network's agents to move their AllowedIPs to it
+func (s *Server) activateGateway(ctx context.Context, network *models.Network, gateway *models.Gateway, reason string) error {
+	var gatewayID *string
+	name, publicKey, endpoint := "hub", network.ServerPublicKey, hubEndpoint(network)
+	if gateway != nil {
+		gatewayID = &gateway.ID
+		name, publicKey, endpoint = gateway.Name, gateway.PublicKey, gateway.Endpoint
+	}
+	if err := s.store.SetNetworkActiveGateway(ctx, network.ID, gatewayID); err != nil {
+		return err
+	}
+	network.ActiveGatewayID = gatewayID
+	fmt.Printf("[Gateways] Network %s: active gateway is now %s (%s)\n", network.Name, name, reason)
+
+	nodes, err := s.store.ListNodes(ctx, network.ID)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		if nodeIsExpired(node) {
+			continue
+		}
+		if err := s.store.CreateNodeEvent(ctx, &models.NodeEvent{
+			NodeID: node.ID,
+			Type:   models.NodeEventGatewayFailover,
+			Payload: map[string]interface{}{
+				"gateway_id":    gatewayID,
+				"public_key":    publicKey,
+				"endpoint":      endpoint,
+				"reason":        reason,
+				"gateways_path": fmt.Sprintf("/api/v1/nodes/%s/gateways", node.ID),
+			},
+		}); err != nil {
+			fmt.Printf("[Gateways] Warning: failed to queue event for %s: %v\n", node.Name, err)
+		}
+	}
+	return nil
+}
+
+// runGatewayMonitor periodically checks gateway health and fails over
+func (s *Server) runGatewayMonitor() {
+	ticker := time.NewTicker(gatewayCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.checkGateways(context.Background())
+	}
+}
+
+// checkGateways updates the health of all gateways and moves the active role
+// of a network to the preferred healthy gateway when its active one stops
+// responding. A recovered gateway does not take the role back by itself.
+func (s *Server) checkGateways(ctx context.Context) {
+	networks, err := s.store.ListNetworks(ctx)
+	if err != nil {
+		fmt.Printf("[Gateways] Failed to list networks: %v\n", err)
+		return
+	}
+
+	for _, network := range networks {
+		gateways, err := s.store.ListGateways(ctx, network.ID)
+		if err != nil || len(gateways) == 0 {
+			continue
+		}
+
+		var active *models.Gateway
+		for _, gateway := range gateways {
+			status := models.GatewayStatusUnhealthy
+			if gatewayHealthy(gateway) {
+				status = models.GatewayStatusHealthy
+			} else if gateway.LastSeen == nil {
+				status = models.GatewayStatusUnknown
+			}
+			if status != gateway.Status {
+				fmt.Printf("[Gateways] Gateway %s of %s is %s\n", gateway.Name, network.Name, status)
+				if err := s.store.UpdateGatewayStatus(ctx, gateway.ID, status); err != nil {
+					fmt.Printf("[Gateways] Warning: failed to update %s: %v\n", gateway.Name, err)
+				}
+				gateway.Status = status
+			}
+			if network.ActiveGatewayID != nil && *network.ActiveGatewayID == gateway.ID {
+				active = gateway
+			}
+		}
+
+		hubUp := s.hubHealthy(network)
+		if (active == nil && hubUp) || (active != nil && active.Status == models.GatewayStatusHealthy) {
+			continue
+		}
+
+		// The built-in hub (priority 0) is preferred over additional gateways
+		reason := "hub stopped responding"
+		if active != nil {
+			reason = fmt.Sprintf("%s stopped responding", active.Name)
+		} else if network.RemoteHub {
+			reason = "no active gateway"
+		}
+		if active != nil && hubUp {
+			if err := s.activateGateway(ctx, network, nil, reason); err != nil {
+				fmt.Printf("[Gateways] Failover of %s failed: %v\n", network.Name, err)
+			}
+			continue
+		}
+		for _, gateway := range gateways {
+			if gateway != active && gateway.Status == models.GatewayStatusHealthy {
+				if err := s.activateGateway(ctx, network, gateway, reason); err != nil {
+					fmt.Printf("[Gateways] Failover of %s failed: %v\n", network.Name, err)
+				}
+				break
+			}
+		}
+	}
+}
+
+// handleListGateways lists the gateways of a network and which one is active
+func (s *Server) handleListGateways(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	network, err := s.store.GetNetwork(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+
+	gateways, err := s.store.ListGateways(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list gateways")
+		return
+	}
+	if gateways == nil {
+		gateways = []*models.Gateway{}
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"active_gateway_id": network.ActiveGatewayID,
+		"hub_healthy":       s.hubHealthy(network),
+		"gateways":          gateways,
+	})
+}
+
+// handleCreateGateway adds a gateway to a network. Its private key and agent
+// token are only returned here, for provisioning the gateway host.
+func (s *Server) handleCreateGateway(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req struct {
+		Name     string `json:"name"`
+		Endpoint string `json:"endpoint"` // host:port clients connect to
+		Priority *int   `json:"priority"` // Lower is preferred, default 100
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		errorResponse(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	endpoints, err := normalizeEndpoints([]string{req.Endpoint})
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	priority := defaultGatewayPriority
+	if req.Priority != nil {
+		if *req.Priority < 1 {
+			errorResponse(w, http.StatusBadRequest, "priority must be at least 1 (0 is the built-in hub)")
+			return
+		}
+		priority = *req.Priority
+	}
+
+	network, err := s.store.GetNetwork(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+
+	privateKey, publicKey, err := wireguard.GenerateKeys()
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to generate keys")
+		return
+	}
+	token, tokenHash, err := newGatewayToken()
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+
+	gateway := &models.Gateway{
+		NetworkID:  id,
+		Name:       req.Name,
+		Endpoint:   endpoints[0],
+		PrivateKey: privateKey,
+		PublicKey:  publicKey,
+		Priority:   priority,
+	}
+	if err := s.store.CreateGateway(r.Context(), gateway); err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			errorResponse(w, http.StatusConflict, "a gateway with this name already exists in the network")
+			return
+		}
+		errorResponse(w, http.StatusInternalServerError, "failed to create gateway")
+		return
+	}
+	if err := s.store.SetGatewayTokenHash(r.Context(), gateway.ID, tokenHash); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to store token")
+		return
+	}
+	fmt.Printf("[Gateways] Gateway %s added to %s (%s)\n", gateway.Name, network.Name, gateway.Endpoint)
+
+	jsonResponse(w, http.StatusCreated, map[string]interface{}{
+		"gateway":     gateway,
+		"private_key": privateKey,
+		"token":       token,
+	})
+}
+
+// handleDeleteGateway removes a gateway; when it is active, the preferred
+// healthy gateway takes over first
+func (s *Server) handleDeleteGateway(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	gateway, err := s.store.GetGateway(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get gateway")
+		return
+	}
+	if gateway == nil {
+		errorResponse(w, http.StatusNotFound, "gateway not found")
+		return
+	}
+
+	network, err := s.store.GetNetwork(r.Context(), gateway.NetworkID)
+	if err != nil || network == nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network.ActiveGatewayID != nil && *network.ActiveGatewayID == gateway.ID {
+		var next *models.Gateway
+		if !s.hubHealthy(network) {
+			gateways, _ := s.store.ListGateways(r.Context(), network.ID)
+			for _, candidate := range gateways {
+				if candidate.ID != gateway.ID && gatewayHealthy(candidate) {
+					next = candidate
+					break
+				}
+			}
+		}
+		if err := s.activateGateway(r.Context(), network, next, fmt.Sprintf("%s was deleted", gateway.Name)); err != nil {
+			errorResponse(w, http.StatusInternalServerError, "failed to move the active gateway")
+			return
+		}
+	}
+
+	if err := s.store.DeleteGateway(r.Context(), id); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to delete gateway")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSetActiveGateway switches the active gateway of a network by hand,
+// e.g. back to the built-in hub after a failover ("gateway_id": "")
+func (s *Server) handleSetActiveGateway(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req struct {
+		GatewayID string `json:"gateway_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	network, err := s.store.GetNetwork(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+
+	var gateway *models.Gateway
+	if req.GatewayID == "" && network.RemoteHub {
+		errorResponse(w, http.StatusBadRequest, "gateway_id is required: this network has no built-in hub")
+		return
+	}
+	if req.GatewayID != "" {
+		gateway, err = s.store.GetGateway(r.Context(), req.GatewayID)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "failed to get gateway")
+			return
+		}
+		if gateway == nil || gateway.NetworkID != network.ID {
+			errorResponse(w, http.StatusBadRequest, "gateway not found in this network")
+			return
+		}
+	}
+
+	if err := s.activateGateway(r.Context(), network, gateway, "switched by an administrator"); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to switch the active gateway")
+		return
+	}
+	jsonResponse(w, http.StatusOK, network)
+}
+
+// handleGatewayHeartbeat records that a gateway is alive and returns the node
+// peers it must serve
+func (s *Server) handleGatewayHeartbeat(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	gateway, err := s.store.GetGateway(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get gateway")
+		return
+	}
+	if gateway == nil {
+		errorResponse(w, http.StatusNotFound, "gateway not found")
+		return
+	}
+
+	if err := s.store.RecordGatewayHeartbeat(r.Context(), id); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to record heartbeat")
+		return
+	}
+	now := time.Now()
+	gateway.LastSeen = &now
+	gateway.Status = models.GatewayStatusHealthy
+
+	network, err := s.store.GetNetwork(r.Context(), gateway.NetworkID)
+	if err != nil || network == nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	peers, err := s.gatewayNodePeers(r.Context(), network.ID)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list peers")
+		return
+	}
+	// Heartbeats are frequent and logged by proxies; agents get preshared
+	// keys from /gateway/state
+	for i := range peers {
+		peers[i].PresharedKey = ""
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"gateway": gateway,
+		"active":  network.ActiveGatewayID != nil && *network.ActiveGatewayID == gateway.ID,
+		"peers":   peers,
+	})
+}
+
+// handleGetNodeGateways returns the gateways of a node's network for
+// agent-driven failover: the active one carries allowed_ips, the others are
+// standby peers
+func (s *Server) handleGetNodeGateways(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := s.authenticateNode(r, id); err != nil {
+		errorResponse(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	node, err := s.store.GetNode(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get node")
+		return
+	}
+	if node == nil {
+		errorResponse(w, http.StatusNotFound, "node not found")
+		return
+	}
+
+	network, err := s.store.GetNetwork(r.Context(), node.NetworkID)
+	if err != nil || network == nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	gateways, err := s.store.ListGateways(r.Context(), network.ID)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list gateways")
+		return
+	}
+
+	hubStatus := models.GatewayStatusUnhealthy
+	if s.hubHealthy(network) {
+		hubStatus = models.GatewayStatusHealthy
+	}
+	entries := []map[string]interface{}{}
+	if !network.RemoteHub {
+		entries = append(entries, map[string]interface{}{
+			"id":         nil,
+			"name":       "hub",
+			"public_key": network.ServerPublicKey,
+			"endpoint":   hubEndpoint(network),
+			"priority":   0,
+			"status":     hubStatus,
+			"active":     network.ActiveGatewayID == nil,
+		})
+	}
+	for _, gateway := range gateways {
+		entries = append(entries, map[string]interface{}{
+			"id":         gateway.ID,
+			"name":       gateway.Name,
+			"public_key": gateway.PublicKey,
+			"endpoint":   gateway.Endpoint,
+			"priority":   gateway.Priority,
+			"status":     gateway.Status,
+			"active":     network.ActiveGatewayID != nil && *network.ActiveGatewayID == gateway.ID,
+		})
+	}
+
+	options := network.ClientConfig.Merge(node.ClientConfig)
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"active_gateway_id": network.ActiveGatewayID,
+		"allowed_ips":       s.nodeClientAllowedIPs(r.Context(), node, options),
+		"gateways":          entries,
+	})
+}
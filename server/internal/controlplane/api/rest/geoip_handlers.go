@@ -0,0 +1,149 @@
+package rest
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+// geoBucket is one row of a GeoIP aggregate (by country or by ASN)
+type geoBucket struct {
+	Key   string   `json:"key"`
+	Name  string   `json:"name,omitempty"`
+	Count int      `json:"count"`
+	IPs   []string `json:"ips"`
+}
+
+// collectBannedIPs returns all currently banned addresses (fail2ban jails and
+// permanent iptables bans), de-duplicated
+func (s *Server) collectBannedIPs() []string {
+	seen := make(map[string]bool)
+	var ips []string
+
+	if jails, err := listFail2BanJails(); err == nil {
+		for _, jail := range jails {
+			jailStatus, err := execHostCommand("fail2ban-client", "status", jail)
+			if err != nil {
+				continue
+			}
+			jailInfo := map[string]interface{}{}
+			parseFail2BanJailStatus(jailStatus, jailInfo)
+			banned, _ := jailInfo["banned_ips"].([]string)
+			for _, ip := range banned {
+				if !seen[ip] {
+					seen[ip] = true
+					ips = append(ips, ip)
+				}
+			}
+		}
+	}
+
+	if bans, err := s.listPermanentBans(); err == nil {
+		for _, ban := range bans {
+			ip, _ := ban["ip"].(string)
+			if ip != "" && !seen[ip] {
+				seen[ip] = true
+				ips = append(ips, ip)
+			}
+		}
+	}
+
+	return ips
+}
+
+// groupByGeo groups addresses using keyFn; addresses without GeoIP data land in "unknown"
+func (s *Server) groupByGeo(ips []string, keyFn func(geo *models.GeoInfo) (string, string)) []geoBucket {
+	buckets := make(map[string]*geoBucket)
+	for _, ip := range ips {
+		key, name := "unknown", ""
+		if geo := s.geo.Lookup(ip); geo != nil {
+			if k, n := keyFn(geo); k != "" {
+				key, name = k, n
+			}
+		}
+		b, ok := buckets[key]
+		if !ok {
+			b = &geoBucket{Key: key, Name: name, IPs: []string{}}
+			buckets[key] = b
+		}
+		b.Count++
+		b.IPs = append(b.IPs, ip)
+	}
+
+	result := make([]geoBucket, 0, len(buckets))
+	for _, b := range buckets {
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+func byCountry(geo *models.GeoInfo) (string, string) {
+	return geo.CountryCode, geo.Country
+}
+
+func byASN(geo *models.GeoInfo) (string, string) {
+	if geo.ASN == 0 {
+		return "", ""
+	}
+	return "AS" + strconv.FormatUint(uint64(geo.ASN), 10), geo.ASOrg
+}
+
+// handleBansByCountry aggregates banned IPs by country
+func (s *Server) handleBansByCountry(w http.ResponseWriter, r *http.Request) {
+	ips := s.collectBannedIPs()
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"geoip_enabled": s.geo.Enabled(),
+		"total":         len(ips),
+		"countries":     s.groupByGeo(ips, byCountry),
+	})
+}
+
+// handleBansByASN aggregates banned IPs by autonomous system
+func (s *Server) handleBansByASN(w http.ResponseWriter, r *http.Request) {
+	ips := s.collectBannedIPs()
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"geoip_enabled": s.geo.Enabled(),
+		"total":         len(ips),
+		"asns":          s.groupByGeo(ips, byASN),
+	})
+}
+
+// handleNodesByCountry aggregates connected node endpoints by country across all networks
+func (s *Server) handleNodesByCountry(w http.ResponseWriter, r *http.Request) {
+	networks, err := s.store.ListNetworks(r.Context())
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list networks")
+		return
+	}
+
+	var ips []string
+	for _, network := range networks {
+		nodes, err := s.store.ListNodes(r.Context(), network.ID)
+		if err != nil {
+			continue
+		}
+
+		peers := s.livePeers(network.ID)
+
+		for _, node := range nodes {
+			s.enrichNode(node, peers)
+			if node.PublicIP != "" {
+				ips = append(ips, node.PublicIP)
+			}
+		}
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"geoip_enabled": s.geo.Enabled(),
+		"total":         len(ips),
+		"countries":     s.groupByGeo(ips, byCountry),
+	})
+}
@@ -18,7 +18,9 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/novusgate/novusgate/internal/controlplane/store"
+	"github.com/novusgate/novusgate/internal/geoip"
 	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/shared/netutil"
 	"github.com/novusgate/novusgate/internal/wireguard"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -51,31 +53,123 @@ type PeerActivity struct {
 	LastSeen    time.Time
 }
 
+// Config holds optional settings for the REST API server
+type Config struct {
+	// GeoIP enriches public addresses (node endpoints, banned IPs) with
+	// country, city and ASN. Nil disables GeoIP lookups.
+	GeoIP *geoip.DB
+	// Version is the build version reported by health endpoints
+	Version string
+	// ReportSchedule is daily, weekly or monthly. Empty disables scheduled reports.
+	ReportSchedule string
+	// WireGuardBackend selects how interfaces are configured: netlink (default), cli or simulated
+	WireGuardBackend string
+	// ReconcileInterval is how often live peers are reconciled with the database.
+	// Zero uses DefaultReconcileInterval; negative disables the periodic loop.
+	ReconcileInterval time.Duration
+	// UnknownPeerPolicy is report (default), import or remove. Existing
+	// WireGuard setups are brought in with the import command instead.
+	UnknownPeerPolicy string
+	// PortRangeStart and PortRangeEnd bound the UDP ports of new networks.
+	// Zero uses DefaultPortRangeStart-DefaultPortRangeEnd.
+	PortRangeStart int
+	PortRangeEnd   int
+	// InterfacePrefix names new network interfaces (<prefix><index>). Empty uses "wg".
+	InterfacePrefix string
+	// ProbeMethod is how the hub probes node latency: auto (default), icmp,
+	// udp or none
+	ProbeMethod string
+	// ProbeRetention is how long probe results are kept. Zero uses DefaultProbeRetention.
+	ProbeRetention time.Duration
+}
+
 // Server is the REST API server
 type Server struct {
 	store        *store.Store
 	router       *mux.Router
-	managers   map[string]*wireguard.Manager
+	managers   map[string]wireguard.Backend
 	managersMu sync.RWMutex
 	peerActivity map[string]*PeerActivity
 	activityMu   sync.RWMutex
+	geo          *geoip.DB
+	version      string
+	startedAt    time.Time
+	wgBackend    string
+
+	reconcileInterval time.Duration
+	unknownPeerPolicy string
+	portRangeStart    int
+	portRangeEnd      int
+	interfacePrefix   string
+	reconcileMu       sync.Mutex // Serializes reconciliation runs
+	egressMu          sync.Mutex // Serializes egress firewall syncs
+	gatewayReports    map[string]*gatewayReport // Latest peer stats per gateway agent
+	gatewayReportsMu  sync.RWMutex
+	lastReconcile     *ReconcileResult
+	lastReconcileMu   sync.RWMutex
+	probeRetention    time.Duration
+	latestProbes      map[string]*models.NodeProbe // Latest probe round per node
+	latestProbesMu    sync.RWMutex
 }
 
 // NewServer creates a new REST API server
-func NewServer(store *store.Store) *Server {
+func NewServer(store *store.Store, cfg Config) *Server {
+	if cfg.WireGuardBackend == "" {
+		cfg.WireGuardBackend = wireguard.BackendNetlink
+	}
+	if cfg.ReconcileInterval == 0 {
+		cfg.ReconcileInterval = DefaultReconcileInterval
+	}
+	if cfg.UnknownPeerPolicy == "" {
+		cfg.UnknownPeerPolicy = UnknownPeerReport
+	}
+	if cfg.PortRangeStart == 0 && cfg.PortRangeEnd == 0 {
+		cfg.PortRangeStart, cfg.PortRangeEnd = DefaultPortRangeStart, DefaultPortRangeEnd
+	}
+	if cfg.InterfacePrefix == "" {
+		cfg.InterfacePrefix = DefaultInterfacePrefix
+	}
+	if cfg.ProbeRetention == 0 {
+		cfg.ProbeRetention = DefaultProbeRetention
+	}
 	s := &Server{
 		store:        store,
 		router:       mux.NewRouter(),
-		managers:     make(map[string]*wireguard.Manager),
+		managers:     make(map[string]wireguard.Backend),
 		peerActivity: make(map[string]*PeerActivity),
+		geo:          cfg.GeoIP,
+		version:      cfg.Version,
+		startedAt:    time.Now(),
+		wgBackend:    cfg.WireGuardBackend,
+
+		reconcileInterval: cfg.ReconcileInterval,
+		unknownPeerPolicy: cfg.UnknownPeerPolicy,
+		portRangeStart:    cfg.PortRangeStart,
+		portRangeEnd:      cfg.PortRangeEnd,
+		interfacePrefix:   cfg.InterfacePrefix,
+		gatewayReports:    make(map[string]*gatewayReport),
+		probeRetention:    cfg.ProbeRetention,
+		latestProbes:      make(map[string]*models.NodeProbe),
 	}
 	s.setupRoutes()
 	// Initialize existing networks from DB
 	go s.loadNetworks()
+	go s.runTrafficSampler()
+	if cfg.ReportSchedule != "" {
+		go s.runReportScheduler(cfg.ReportSchedule)
+	}
+	if cfg.ReconcileInterval > 0 {
+		go s.runReconciler(cfg.ReconcileInterval)
+	}
+	go s.runKeyRotationMonitor()
+	go s.runGatewayMonitor()
+	if cfg.ProbeMethod != ProbeMethodNone && s.wgBackend != wireguard.BackendSimulated {
+		go s.runProber(cfg.ProbeMethod)
+	}
 	return s
 }
 
-// loadNetworks initializes managers for existing networks and imports/syncs peers
+// loadNetworks initializes managers for existing networks and reconciles their peers
 func (s *Server) loadNetworks() {
 	// Give DB a moment to come up
 	time.Sleep(2 * time.Second)
@@ -88,11 +182,11 @@ func (s *Server) loadNetworks() {
 	}
 	
 	for _, network := range networks {
-		if network.InterfaceName == "" {
+		if network.InterfaceName == "" || network.RemoteHub {
 			continue
 		}
 		// Initialize manager
-		mgr := wireguard.NewManager(network.InterfaceName)
+		mgr := s.newManager(network.InterfaceName)
 		if err := mgr.Init(); err != nil {
 			fmt.Printf("Warning: WireGuard tools missing for %s\n", network.Name)
 			continue
@@ -109,91 +203,74 @@ func (s *Server) loadNetworks() {
 		s.managers[network.ID] = mgr
 		s.managersMu.Unlock()
 		
+		// Egress NAT moved from wg-quick hooks to the control plane
+		if m, ok := mgr.(*wireguard.Manager); ok {
+			removed, err := m.RemoveNATHooks()
+			if err != nil {
+				fmt.Printf("Warning: failed to remove NAT hooks of %s: %v\n", network.InterfaceName, err)
+			}
+			removeOldNATRules(network.InterfaceName, removed)
+		}
+		
 		// Ensure interface is UP
 		if err := mgr.Up(); err != nil {
 			fmt.Printf("Note: Interface %s might already be up: %v\n", network.InterfaceName, err)
 		} else {
 			fmt.Printf("Network %s (%s) is UP on port %d\n", network.Name, network.InterfaceName, port)
 		}
+	}
 
-		// SYNC: Import existing peers from WireGuard into DB
-		peers, err := mgr.GetPeers()
-		if err == nil && len(peers) > 0 {
-			fmt.Printf("Syncing %d peers from %s to database...\n", len(peers), network.InterfaceName)
-			
-			// Get existing DB nodes to avoid duplicates
-			dbNodes, err := s.store.ListNodes(ctx, network.ID)
-			if err != nil {
-				fmt.Printf("Failed to list DB nodes: %v\n", err)
-				continue
-			}
-			
-			existingKeys := make(map[string]bool)
-			for _, n := range dbNodes {
-				existingKeys[n.PublicKey] = true
-			}
-			
-			for pubKey, peer := range peers {
-				if !existingKeys[pubKey] {
-					// Import this peer
-					fmt.Printf("Importing peer %s...\n", pubKey)
-					
-					// Parse IP from AllowedIPs (first one if comma separated)
-					ips := strings.Split(peer.AllowedIPs, ",")
-					if len(ips) == 0 {
-						fmt.Printf("Warning: No AllowedIPs for peer %s, skipping import\n", pubKey)
-						continue
-					}
-					
-					// Take first IP and remove CIDR suffix if present
-					ipStr := strings.TrimSpace(ips[0])
-					if idx := strings.Index(ipStr, "/"); idx != -1 {
-						ipStr = ipStr[:idx]
-					}
-					
-					virtualIP := net.ParseIP(ipStr)
-					if virtualIP == nil {
-						fmt.Printf("Warning: Invalid IP %s for peer %s, skipping import\n", ipStr, pubKey)
-						continue
-					}
-
-					// Create Node
-					hostname := "imported-client"
-					// Try to give a better name for admin network
-					if network.InterfaceName == "wg1" {
-						hostname = "Admin Client"
-					} else {
-						hostname = fmt.Sprintf("Imported Node (%s)", ipStr)
-					}
+	// Bring live peers in line with the database, then keep them there
+	result := s.reconcile(ctx, "startup", "")
+	fmt.Printf("[Reconcile] Startup: %d added, %d updated, %d removed, %d imported, %d reported\n",
+		result.Added, result.Updated, result.Removed, result.Imported, result.Reported)
+	if result.Reported > 0 {
+		fmt.Printf("[Reconcile] %d live peer(s) are unknown to the database; bring existing WireGuard configs in with 'novusgate-server import'\n", result.Reported)
+	}
 
-					node := &models.Node{
-						NetworkID: network.ID,
-						Name:      hostname,
-						PublicKey: pubKey,
-						VirtualIP: virtualIP,
-						Status:    models.NodeStatusOnline, // Assume online if present in WG
-						NodeInfo: &models.NodeInfo{
-							Hostname:     hostname,
-							OS:           "unknown",
-							Architecture: "unknown",
-						},
-					}
-					
-					if err := s.store.CreateNode(ctx, node); err != nil {
-						fmt.Printf("Failed to import node %s: %v\n", pubKey, err)
-					} else {
-						fmt.Printf("Successfully imported node %s (%s)\n", hostname, ipStr)
-						existingKeys[pubKey] = true // Mark as done
-					}
-				}
-			}
+	if s.wgBackend != wireguard.BackendSimulated {
+		if err := s.syncNetworkPorts(ctx); err != nil {
+			fmt.Printf("Warning: failed to open WireGuard ports: %v\n", err)
 		}
+		if err := s.syncEgressRules(ctx); err != nil {
+			fmt.Printf("Warning: failed to apply egress rules: %v\n", err)
+		}
+	}
+}
+
+// startNetworkInterface writes the hub config of a new network with its key,
+// brings the interface up and registers its manager
+func (s *Server) startNetworkInterface(network *models.Network) {
+	mgr := s.newManager(network.InterfaceName)
+	if err := mgr.Init(); err != nil {
+		fmt.Printf("Warning: WireGuard tools not available: %v\n", err)
+		return
+	}
+	
+	// Server addresses are the first usable IP of each CIDR
+	serverAddrs, _ := netutil.ServerAddresses(network.CIDRs()...)
+	serverAddr := strings.Join(serverAddrs, ", ")
+	
+	if err := mgr.CreateServerConfigWithKey(network.ServerPrivateKey, serverAddr, network.ListenPort); err != nil {
+		fmt.Printf("Warning: Failed to create WireGuard config for %s: %v\n", network.Name, err)
+	} else {
+		// Writing the config brings the interface up
+		fmt.Printf("WireGuard interface %s created for network %s\n", network.InterfaceName, network.Name)
 	}
+	
+	s.managersMu.Lock()
+	s.managers[network.ID] = mgr
+	s.managersMu.Unlock()
+}
+
+// newManager creates a WireGuard backend for an interface using the configured kind
+func (s *Server) newManager(interfaceName string) wireguard.Backend {
+	return wireguard.NewBackend(s.wgBackend, interfaceName)
 }
 
 // getManager returns the WireGuard manager for a network
 // If manager doesn't exist but network does, it creates and registers one
-func (s *Server) getManager(networkID string) *wireguard.Manager {
+func (s *Server) getManager(networkID string) wireguard.Backend {
 	s.managersMu.RLock()
 	mgr := s.managers[networkID]
 	s.managersMu.RUnlock()
@@ -205,12 +282,12 @@ func (s *Server) getManager(networkID string) *wireguard.Manager {
 	// Manager not found - try to create it from network config
 	ctx := context.Background()
 	network, err := s.store.GetNetwork(ctx, networkID)
-	if err != nil || network == nil || network.InterfaceName == "" {
+	if err != nil || network == nil || network.InterfaceName == "" || network.RemoteHub {
 		return nil
 	}
 	
 	// Create and register manager
-	newMgr := wireguard.NewManager(network.InterfaceName)
+	newMgr := s.newManager(network.InterfaceName)
 	if err := newMgr.Init(); err != nil {
 		fmt.Printf("Warning: Failed to init WireGuard manager for %s: %v\n", network.Name, err)
 		return nil
@@ -245,8 +322,33 @@ func (s *Server) setupRoutes() {
 	// Networks
 	api.HandleFunc("/networks", s.handleListNetworks).Methods("GET")
 	api.HandleFunc("/networks", s.handleCreateNetwork).Methods("POST")
+	api.HandleFunc("/networks/import", s.handleImportNetwork).Methods("POST")
 	api.HandleFunc("/networks/{id}", s.handleGetNetwork).Methods("GET")
+	api.HandleFunc("/networks/{id}", s.handleUpdateNetwork).Methods("PUT")
 	api.HandleFunc("/networks/{id}", s.handleDeleteNetwork).Methods("DELETE")
+	api.HandleFunc("/networks/{id}/rotate-key", s.handleRotateNetworkKey).Methods("POST")
+	api.HandleFunc("/networks/{id}/key-rotations", s.handleListKeyRotations).Methods("GET")
+	api.HandleFunc("/networks/{id}/client-config", s.handleUpdateNetworkClientConfig).Methods("PUT")
+	api.HandleFunc("/networks/{id}/egress", s.handleUpdateNetworkEgress).Methods("PUT")
+	api.HandleFunc("/networks/{id}/mesh", s.handleUpdateNetworkMesh).Methods("PUT")
+	api.HandleFunc("/networks/{id}/probing", s.handleUpdateNetworkProbing).Methods("PUT")
+	api.HandleFunc("/networks/{id}/gateways", s.handleListGateways).Methods("GET")
+	api.HandleFunc("/networks/{id}/gateways", s.handleCreateGateway).Methods("POST")
+	api.HandleFunc("/networks/{id}/active-gateway", s.handleSetActiveGateway).Methods("PUT")
+	api.HandleFunc("/gateways/{id}", s.handleDeleteGateway).Methods("DELETE")
+	api.HandleFunc("/gateways/{id}/heartbeat", s.handleGatewayHeartbeat).Methods("POST")
+	api.HandleFunc("/gateways/{id}/token", s.handleIssueGatewayToken).Methods("POST")
+
+	// IP address management
+	api.HandleFunc("/networks/{id}/export", s.handleExportNetwork).Methods("POST")
+	api.HandleFunc("/networks/{id}/ipam", s.handleGetNetworkIPAM).Methods("GET")
+	api.HandleFunc("/networks/{id}/ipam/reservations", s.handleListIPReservations).Methods("GET")
+	api.HandleFunc("/networks/{id}/ipam/reservations", s.handleCreateIPReservation).Methods("POST")
+	api.HandleFunc("/ipam/reservations/{id}", s.handleDeleteIPReservation).Methods("DELETE")
+
+	// Gateway agents (authenticated with their gateway token)
+	api.HandleFunc("/gateway/state", s.handleGatewayAgentState).Methods("GET")
+	api.HandleFunc("/gateway/report", s.handleGatewayAgentReport).Methods("POST")
 	
 	// Nodes
 	api.HandleFunc("/networks/{networkId}/nodes", s.handleListNodes).Methods("GET")
@@ -254,21 +356,39 @@ func (s *Server) setupRoutes() {
 	api.HandleFunc("/nodes/{id}", s.handleUpdateNode).Methods("PUT", "PATCH")
 	api.HandleFunc("/nodes/{id}", s.handleDeleteNode).Methods("DELETE")
 	api.HandleFunc("/nodes/{id}/checkin", s.handleNodeCheckIn).Methods("POST")
+	api.HandleFunc("/nodes/{id}/rotate-key", s.handleRotateNodeKey).Methods("POST")
+	api.HandleFunc("/nodes/{id}/routes", s.handleUpdateNodeRoutes).Methods("PUT")
+	api.HandleFunc("/nodes/{id}/token", s.handleIssueNodeToken).Methods("POST")
+	api.HandleFunc("/nodes/{id}/peers", s.handleGetNodePeers).Methods("GET")
+	api.HandleFunc("/nodes/{id}/gateways", s.handleGetNodeGateways).Methods("GET")
+	api.HandleFunc("/nodes/{id}/latency", s.handleGetNodeLatency).Methods("GET")
+	api.HandleFunc("/routes", s.handleListSubnetRoutes).Methods("GET")
 	
 	// WireGuard Config & Utils
 	api.HandleFunc("/nodes/{id}/config", s.handleDownloadConfig).Methods("GET")
-	api.HandleFunc("/nodes/{id}/qrcode", s.handleGetQRCode).Methods("GET")
 	api.HandleFunc("/nodes/{id}/install.sh", s.handleNodeInstallScript).Methods("GET")
 	api.HandleFunc("/networks/{networkId}/servers", s.handleCreateServerWithConfig).Methods("POST")
 
-	// Health check
+	// Enrollment tokens: nodes register a locally generated public key
+	api.HandleFunc("/networks/{networkId}/enrollment-tokens", s.handleListEnrollmentTokens).Methods("GET")
+	api.HandleFunc("/networks/{networkId}/enrollment-tokens", s.handleCreateEnrollmentToken).Methods("POST")
+	api.HandleFunc("/enrollment-tokens/{id}", s.handleDeleteEnrollmentToken).Methods("DELETE")
+	api.HandleFunc("/enroll", s.handleEnroll).Methods("POST")
+	api.HandleFunc("/enroll.sh", s.handleEnrollScript).Methods("GET")
+
+	// Health checks
 	s.router.HandleFunc("/health", s.handleHealth).Methods("GET")
+	s.router.HandleFunc("/livez", s.handleLivez).Methods("GET")
+	s.router.HandleFunc("/readyz", s.handleReadyz).Methods("GET")
 	
 	// Debug endpoint - shows WireGuard interface status
 	api.HandleFunc("/debug/wireguard/{networkId}", s.handleDebugWireGuard).Methods("GET")
 	
 	// Sync endpoint - syncs DB peers to WireGuard interface
 	api.HandleFunc("/networks/{networkId}/sync", s.handleSyncPeers).Methods("POST")
+	api.HandleFunc("/reconcile", s.handleGetReconcileStatus).Methods("GET")
+	api.HandleFunc("/reconcile", s.handleRunReconcile).Methods("POST")
+	api.HandleFunc("/reconcile/drift", s.handleGetReconcileDrift).Methods("GET")
 	
 	// Auth
 	s.router.HandleFunc("/api/v1/auth/login", s.handleLogin).Methods("POST", "OPTIONS")
@@ -295,9 +415,19 @@ func (s *Server) setupRoutes() {
 	api.HandleFunc("/system/fail2ban/reload", s.handleFail2BanReload).Methods("POST")
 	api.HandleFunc("/system/fail2ban/ping", s.handleFail2BanPing).Methods("GET")
 	api.HandleFunc("/system/fail2ban/ban-history", s.handleFail2BanHistory).Methods("GET")
+	api.HandleFunc("/system/fail2ban/bans/by-country", s.handleBansByCountry).Methods("GET")
+	api.HandleFunc("/system/fail2ban/bans/by-asn", s.handleBansByASN).Methods("GET")
 	
 	// All Networks Stats (for dashboard)
 	api.HandleFunc("/stats/overview", s.handleStatsOverview).Methods("GET")
+	api.HandleFunc("/stats/nodes/by-country", s.handleNodesByCountry).Methods("GET")
+
+	// Reports
+	api.HandleFunc("/reports", s.handleListReports).Methods("GET")
+	api.HandleFunc("/reports", s.handleGenerateReport).Methods("POST")
+	api.HandleFunc("/reports/{id}", s.handleGetReport).Methods("GET")
+	api.HandleFunc("/reports/{id}", s.handleDeleteReport).Methods("DELETE")
+	api.HandleFunc("/reports/{id}/download", s.handleDownloadReport).Methods("GET")
 
 	// Host Firewall Management
 	api.HandleFunc("/firewall/host/rules", s.handleFirewallGetRules).Methods("GET")
@@ -532,19 +662,41 @@ func (s *Server) handleListNetworks(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleCreateNetwork(w http.ResponseWriter, r *http.Request) {
-	var network models.Network
+	network := models.Network{ProbeInterval: models.DefaultProbeInterval}
 	if err := json.NewDecoder(r.Body).Decode(&network); err != nil {
 		errorResponse(w, http.StatusBadRequest, "invalid request body")
 		return
 	}
+	if err := validateProbeInterval(network.ProbeInterval); err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
-	// Validate CIDR format
-	_, newNet, err := net.ParseCIDR(network.CIDR)
+	// Validate CIDR format (and the optional IPv6 range of a dual-stack network)
+	newNets, err := parseNetworkCIDRs(network.CIDR, network.CIDR6)
 	if err != nil {
-		errorResponse(w, http.StatusBadRequest, "invalid CIDR format: "+err.Error())
+		errorResponse(w, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	if err := validateClientConfig(&network.ClientConfig); err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := validateEgress(&network, &network.Egress); err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if network.Egress.Mode == "" {
+		network.Egress.Mode = models.EgressDisabled
+	}
+	if network.Egress.Mode == models.EgressExitNode {
+		if _, err := s.checkExitNode(r.Context(), network.Egress.ExitNodeID); err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
 	// Dynamic Allocation Logic
 	existing, err := s.store.ListNetworks(r.Context())
 	if err != nil {
@@ -552,52 +704,20 @@ func (s *Server) handleCreateNetwork(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check for CIDR overlap with existing networks
-	for _, existingNet := range existing {
-		_, existingCIDR, err := net.ParseCIDR(existingNet.CIDR)
-		if err != nil {
-			continue // Skip invalid existing CIDRs
-		}
-		
-		// Check if networks overlap
-		if networksOverlap(newNet, existingCIDR) {
-			errorResponse(w, http.StatusConflict, fmt.Sprintf(
-				"CIDR %s overlaps with existing network '%s' (%s)",
-				network.CIDR, existingNet.Name, existingNet.CIDR,
-			))
-			return
-		}
-	}
-	
-	// 1. Assign Interface Name (wg0, wg1, etc)
-	// Find highest 'wgN'
-	maxIdx := -1
-	usedPorts := make(map[int]bool)
-	
-	for _, net := range existing {
-		if strings.HasPrefix(net.InterfaceName, "wg") {
-			var idx int
-			if _, err := fmt.Sscanf(net.InterfaceName, "wg%d", &idx); err == nil {
-				if idx > maxIdx {
-					maxIdx = idx
-				}
-			}
-		}
-		if net.ListenPort > 0 {
-			usedPorts[net.ListenPort] = true
-		}
+	// Check for CIDR overlap with existing networks and routed subnets
+	if err := s.cidrConflict(r.Context(), existing, newNets, ""); err != nil {
+		errorResponse(w, http.StatusConflict, err.Error())
+		return
 	}
 	
-	newIdx := maxIdx + 1
-	network.InterfaceName = fmt.Sprintf("wg%d", newIdx)
+	// 1. Assign Interface Name (<prefix>N after the highest index)
+	network.InterfaceName = s.allocateInterfaceName(existing)
 	
-	// 2. Assign Port (Start at 51820)
-	port := 51820
-	for {
-		if !usedPorts[port] {
-			break
-		}
-		port++
+	// 2. Assign the lowest free port of the configured range
+	port, err := s.allocateListenPort(r.Context(), existing)
+	if err != nil {
+		errorResponse(w, http.StatusConflict, err.Error())
+		return
 	}
 	network.ListenPort = port
 	
@@ -619,32 +739,22 @@ func (s *Server) handleCreateNetwork(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// 5. Initialize WireGuard Interface
-	mgr := wireguard.NewManager(network.InterfaceName)
-	if err := mgr.Init(); err != nil {
-		fmt.Printf("Warning: WireGuard tools not available: %v\n", err)
+	// 5. Initialize WireGuard Interface; remote hub networks are served by
+	// gateway agents only
+	if network.RemoteHub {
+		fmt.Printf("Network %s is served by remote gateways\n", network.Name)
 	} else {
-		// Create WireGuard config with the generated key
-		// Calculate server IP (first usable IP in CIDR)
-		_, ipNet, _ := net.ParseCIDR(network.CIDR)
-		serverIP := ipNet.IP
-		serverIP[len(serverIP)-1]++ // .1
-		serverAddr := fmt.Sprintf("%s/24", serverIP.String())
+		s.startNetworkInterface(&network)
 		
-		if err := mgr.CreateServerConfigWithKey(privateKey, serverAddr, port); err != nil {
-			fmt.Printf("Warning: Failed to create WireGuard config for %s: %v\n", network.Name, err)
-		} else {
-			fmt.Printf("WireGuard interface %s created for network %s\n", network.InterfaceName, network.Name)
-			// Bring up the interface
-			if err := mgr.Up(); err != nil {
-				fmt.Printf("Warning: Failed to bring up %s: %v\n", network.InterfaceName, err)
-			}
+		if err := s.openNetworkPort(&network); err != nil {
+			fmt.Printf("Warning: failed to open port %d in the host firewall: %v\n", port, err)
+		}
+	}
+	
+	if network.Egress.Mode != models.EgressDisabled || network.Egress.BlockDNSLeaks {
+		if err := s.syncEgressRules(r.Context()); err != nil {
+			fmt.Printf("Warning: failed to apply egress rules for %s: %v\n", network.Name, err)
 		}
-		
-		// Register manager
-		s.managersMu.Lock()
-		s.managers[network.ID] = mgr
-		s.managersMu.Unlock()
 	}
 	
 	jsonResponse(w, http.StatusCreated, network)
@@ -685,8 +795,8 @@ func (s *Server) handleDeleteNetwork(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Bring down WireGuard interface
-	if network.InterfaceName != "" {
-		mgr := wireguard.NewManager(network.InterfaceName)
+	if network.InterfaceName != "" && !network.RemoteHub {
+		mgr := s.newManager(network.InterfaceName)
 		if err := mgr.Down(); err != nil {
 			fmt.Printf("Warning: Failed to bring down %s: %v\n", network.InterfaceName, err)
 		}
@@ -698,6 +808,10 @@ func (s *Server) handleDeleteNetwork(w http.ResponseWriter, r *http.Request) {
 		s.managersMu.Lock()
 		delete(s.managers, id)
 		s.managersMu.Unlock()
+		
+		if err := s.closeNetworkPort(id); err != nil {
+			fmt.Printf("Warning: failed to close port %d in the host firewall: %v\n", network.ListenPort, err)
+		}
 	}
 	
 	// Delete from DB
@@ -706,6 +820,13 @@ func (s *Server) handleDeleteNetwork(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	// Drop the network's egress rules
+	if network.Egress.Mode != models.EgressDisabled || network.Egress.BlockDNSLeaks {
+		if err := s.syncEgressRules(r.Context()); err != nil {
+			fmt.Printf("Warning: failed to sync egress rules: %v\n", err)
+		}
+	}
+	
 	fmt.Printf("Network %s (%s) deleted\n", network.Name, network.InterfaceName)
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -720,17 +841,11 @@ func (s *Server) handleListNodes(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Fetch real-time status from WireGuard
-	var peers map[string]wireguard.PeerStatus
-	mgr := s.getManager(networkID)
-	if mgr != nil {
-		p, err := mgr.GetPeers()
-		if err == nil {
-			peers = p
-		}
-	}
+	peers := s.livePeers(networkID)
 
 	for i := range nodes {
 		s.enrichNode(nodes[i], peers)
+		nodes[i].Latency = s.latestProbe(nodes[i].ID)
 	}
 
 	jsonResponse(w, http.StatusOK, nodes)
@@ -749,15 +864,8 @@ func (s *Server) handleGetNode(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Enrich with real-time status
-	var peers map[string]wireguard.PeerStatus
-	mgr := s.getManager(node.NetworkID)
-	if mgr != nil {
-		p, err := mgr.GetPeers()
-		if err == nil {
-			peers = p
-		}
-	}
-	s.enrichNode(node, peers)
+	s.enrichNode(node, s.livePeers(node.NetworkID))
+	node.Latency = s.latestProbe(node.ID)
 
 	jsonResponse(w, http.StatusOK, node)
 }
@@ -768,17 +876,9 @@ func (s *Server) enrichNode(node *models.Node, peers map[string]wireguard.PeerSt
 	isExpired := false
 	if node.ExpiresAt != nil && !node.ExpiresAt.IsZero() {
 		if time.Now().After(*node.ExpiresAt) {
+			// The reconciler removes the live peer
 			node.Status = models.NodeStatusExpired
 			isExpired = true
-			
-			// If expired and session exists in WG, remove it
-			mgr := s.getManager(node.NetworkID)
-			if mgr != nil && peers != nil {
-				if _, ok := peers[node.PublicKey]; ok {
-					fmt.Printf("Enforcing expiration for node %s (%s)\n", node.Name, node.ID)
-					mgr.RemovePeer(node.PublicKey)
-				}
-			}
 		}
 	}
 
@@ -791,6 +891,7 @@ func (s *Server) enrichNode(node *models.Node, peers map[string]wireguard.PeerSt
 			}
 			if ep != "(none)" && ep != "" {
 				node.PublicIP = ep
+				node.Geo = s.geo.Lookup(ep)
 			}
 			
 			node.TransferRx = status.TransferRx
@@ -829,7 +930,7 @@ func (s *Server) enrichNode(node *models.Node, peers map[string]wireguard.PeerSt
 			if !isOnline && status.LatestHandshakeTime > 0 {
 				hsTime := time.Unix(status.LatestHandshakeTime, 0)
 				// Use handshake time if it's recent
-				if time.Since(hsTime) < 150*time.Second {
+				if time.Since(hsTime) < onlineHandshakeWindow {
 					isOnline = true
 					node.LastSeen = hsTime
 				}
@@ -872,6 +973,10 @@ func (s *Server) handleUpdateNode(w http.ResponseWriter, r *http.Request) {
 		ExpiresAt *string          `json:"expires_at"` // ISO string or null to remove
 		Status    *string          `json:"status"`
 		NodeInfo  *models.NodeInfo `json:"node_info"`
+		KeyRotationDays *int       `json:"key_rotation_days"` // 0 disables automatic key rotation
+		ClientConfig json.RawMessage `json:"client_config"`   // Overrides of the network defaults, null removes them
+		ExitNode     *bool           `json:"exit_node"`       // Mark the node as exit node
+		ExitNodeID   *string         `json:"exit_node_id"`    // Exit node for this node's internet traffic, "" clears it
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errorResponse(w, http.StatusBadRequest, "invalid request body")
@@ -884,6 +989,57 @@ func (s *Server) handleUpdateNode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.KeyRotationDays != nil {
+		if *req.KeyRotationDays < 0 {
+			errorResponse(w, http.StatusBadRequest, "key_rotation_days must not be negative")
+			return
+		}
+		node.KeyRotationDays = *req.KeyRotationDays
+	}
+
+	exitChanged := false
+	if req.ExitNodeID != nil {
+		switch {
+		case *req.ExitNodeID == "":
+			node.ExitNodeID = nil
+		case *req.ExitNodeID == node.ID:
+			errorResponse(w, http.StatusBadRequest, "a node can't be its own exit node")
+			return
+		default:
+			if _, err := s.checkExitNode(r.Context(), *req.ExitNodeID); err != nil {
+				errorResponse(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			node.ExitNodeID = req.ExitNodeID
+		}
+		exitChanged = true
+	}
+	if req.ExitNode != nil {
+		if err := s.checkExitNodeFlag(r.Context(), node, *req.ExitNode); err != nil {
+			errorResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+		exitChanged = exitChanged || node.ExitNode != *req.ExitNode
+		node.ExitNode = *req.ExitNode
+	}
+
+	if len(req.ClientConfig) > 0 {
+		if string(req.ClientConfig) == "null" {
+			node.ClientConfig = nil
+		} else {
+			var options models.ClientConfigOptions
+			if err := json.Unmarshal(req.ClientConfig, &options); err != nil {
+				errorResponse(w, http.StatusBadRequest, "invalid client_config")
+				return
+			}
+			if err := validateClientConfig(&options); err != nil {
+				errorResponse(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			node.ClientConfig = &options
+		}
+	}
+
 	// Update name if provided
 	if req.Name != nil && *req.Name != "" {
 		node.Name = *req.Name
@@ -911,7 +1067,7 @@ func (s *Server) handleUpdateNode(w http.ResponseWriter, r *http.Request) {
 			mgr := s.getManager(node.NetworkID)
 			if mgr != nil {
 				fmt.Printf("Reactivating node %s, adding to WireGuard\n", node.Name)
-				if err := mgr.AddPeer(node.PublicKey, node.VirtualIP.String()+"/32"); err != nil {
+				if err := mgr.AddPeers([]wireguard.PeerConfig{nodePeerConfig(node)}); err != nil {
 					fmt.Printf("Warning: failed to add reactivated peer to WireGuard: %v\n", err)
 				}
 			}
@@ -936,7 +1092,7 @@ func (s *Server) handleUpdateNode(w http.ResponseWriter, r *http.Request) {
 			if mgr != nil {
 				fmt.Printf("Extending expired node %s, reactivating and adding to WireGuard\n", node.Name)
 				node.Status = models.NodeStatusPending
-				if err := mgr.AddPeer(node.PublicKey, node.VirtualIP.String()+"/32"); err != nil {
+				if err := mgr.AddPeers([]wireguard.PeerConfig{nodePeerConfig(node)}); err != nil {
 					fmt.Printf("Warning: failed to add reactivated peer to WireGuard: %v\n", err)
 				}
 			}
@@ -964,13 +1120,16 @@ func (s *Server) handleUpdateNode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Enrich with real-time data before returning
-	mgr := s.getManager(node.NetworkID)
-	var peers map[string]wireguard.PeerStatus
-	if mgr != nil {
-		peers, _ = mgr.GetPeers()
+	// Exit node changes affect peer AllowedIPs and the hub's policy routing
+	if exitChanged {
+		s.reconcile(r.Context(), "routes", node.NetworkID)
+		if err := s.syncEgressRules(r.Context()); err != nil {
+			fmt.Printf("Warning: failed to sync exit node routing: %v\n", err)
+		}
 	}
-	s.enrichNode(node, peers)
+
+	// Enrich with real-time data before returning
+	s.enrichNode(node, s.livePeers(node.NetworkID))
 	jsonResponse(w, http.StatusOK, node)
 }
 
@@ -995,20 +1154,38 @@ func (s *Server) handleDeleteNode(w http.ResponseWriter, r *http.Request) {
 			fmt.Printf("Warning: failed to remove peer from WireGuard: %v\n", err)
 			// Continue with deletion anyway
 		}
+		// The previous key of a rotation grace period
+		if node.PreviousPublicKey != "" {
+			if err := mgr.RemovePeer(node.PreviousPublicKey); err != nil {
+				fmt.Printf("Warning: failed to remove previous peer key from WireGuard: %v\n", err)
+			}
+		}
 	}
 
 	if err := s.store.DeleteNode(r.Context(), id); err != nil {
 		errorResponse(w, http.StatusInternalServerError, "failed to delete node")
 		return
 	}
+	// Drop the policy routing of a deleted exit node or exit node user
+	if node.ExitNode || node.ExitNodeID != nil {
+		if err := s.syncEgressRules(r.Context()); err != nil {
+			fmt.Printf("Warning: failed to sync exit node routing: %v\n", err)
+		}
+	}
 	jsonResponse(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
 
 func (s *Server) handleNodeCheckIn(w http.ResponseWriter, r *http.Request) {
 	id := mux.Vars(r)["id"]
+	if err := s.authenticateNode(r, id); err != nil {
+		errorResponse(w, http.StatusUnauthorized, err.Error())
+		return
+	}
 	var req struct {
 		NodeInfo *models.NodeInfo  `json:"node_info"`
 		Labels   map[string]string `json:"labels"`
+		AdvertisedRoutes *[]string `json:"advertised_routes"` // LAN subnets behind the node, routed once approved
+		Endpoints *[]string `json:"endpoints"` // host:port addresses other nodes can reach it on (mesh mode)
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errorResponse(w, http.StatusBadRequest, "invalid request body")
@@ -1053,21 +1230,53 @@ func (s *Server) handleNodeCheckIn(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	jsonResponse(w, http.StatusOK, node)
-}
+	// Subnet routers report the subnets they can route; an admin approves them
+	if req.AdvertisedRoutes != nil {
+		advertised, err := normalizeSubnets(*req.AdvertisedRoutes)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if !sameAllowedIPs(strings.Join(advertised, ","), strings.Join(node.AdvertisedRoutes, ",")) {
+			if err := s.setNodeRoutes(r.Context(), node, advertised, node.ApprovedRoutes); err != nil {
+				errorResponse(w, http.StatusInternalServerError, "failed to update advertised routes")
+				return
+			}
+		}
+	}
+
+	// Mesh peers connect to the endpoints a node reports
+	if req.Endpoints != nil {
+		endpoints, err := normalizeEndpoints(*req.Endpoints)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if strings.Join(endpoints, ",") != strings.Join(node.Endpoints, ",") {
+			if err := s.store.UpdateNodeEndpoints(r.Context(), node.ID, endpoints); err != nil {
+				errorResponse(w, http.StatusInternalServerError, "failed to update endpoints")
+				return
+			}
+			node.Endpoints = endpoints
+		}
+	}
 
+	// Deliver queued events (e.g. server key rotations) to the agent
+	events, err := s.store.TakeNodeEvents(r.Context(), node.ID)
+	if err != nil {
+		fmt.Printf("Warning: failed to load events for node %s: %v\n", node.Name, err)
+	}
+
+	jsonResponse(w, http.StatusOK, struct {
+		*models.Node
+		Events []*models.NodeEvent `json:"events,omitempty"`
+	}{node, events})
+}
 
 
 
 
 
-// Health check
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	jsonResponse(w, http.StatusOK, map[string]string{
-		"status":  "healthy",
-		"version": "0.1.0",
-	})
-}
 
 // System Info - CPU, RAM, Disk
 func (s *Server) handleSystemInfo(w http.ResponseWriter, r *http.Request) {
@@ -1197,7 +1406,7 @@ func (s *Server) handleFail2BanStatus(w http.ResponseWriter, r *http.Request) {
 	result["installed"] = true
 	
 	// Check if running
-	statusOut, err := execHostCommand("fail2ban-client", "status")
+	jails, err := listFail2BanJails()
 	if err != nil {
 		result["error"] = "fail2ban service not responding"
 		jsonResponse(w, http.StatusOK, result)
@@ -1205,7 +1414,43 @@ func (s *Server) handleFail2BanStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	result["running"] = true
 	
-	// Parse jails
+	// Get details for each jail
+	jailDetails := []map[string]interface{}{}
+	for _, jail := range jails {
+		jailInfo := map[string]interface{}{
+			"name": jail,
+		}
+		
+		jailStatus, err := execHostCommand("fail2ban-client", "status", jail)
+		if err == nil {
+			parseFail2BanJailStatus(jailStatus, jailInfo)
+		}
+		
+		// Attach GeoIP details for banned addresses
+		if ips, ok := jailInfo["banned_ips"].([]string); ok && s.geo.Enabled() {
+			details := make([]map[string]interface{}, 0, len(ips))
+			for _, ip := range ips {
+				details = append(details, map[string]interface{}{
+					"ip":  ip,
+					"geo": s.geo.Lookup(ip),
+				})
+			}
+			jailInfo["banned_ip_details"] = details
+		}
+		jailDetails = append(jailDetails, jailInfo)
+	}
+	result["jails"] = jailDetails
+	
+	jsonResponse(w, http.StatusOK, result)
+}
+
+// listFail2BanJails returns the names of all active fail2ban jails
+func listFail2BanJails() ([]string, error) {
+	statusOut, err := execHostCommand("fail2ban-client", "status")
+	if err != nil {
+		return nil, err
+	}
+	
 	jails := []string{}
 	for _, line := range strings.Split(statusOut, "\n") {
 		if strings.Contains(line, "Jail list:") {
@@ -1221,75 +1466,64 @@ func (s *Server) handleFail2BanStatus(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
-	
-	// Get details for each jail
-	jailDetails := []map[string]interface{}{}
-	for _, jail := range jails {
-		jailInfo := map[string]interface{}{
-			"name": jail,
-		}
+	return jails, nil
+}
+
+// parseFail2BanJailStatus parses `fail2ban-client status <jail>` output into jailInfo
+func parseFail2BanJailStatus(jailStatus string, jailInfo map[string]interface{}) {
+	// Parse banned IPs and stats - handle different fail2ban output formats
+	for _, line := range strings.Split(jailStatus, "\n") {
+		line = strings.TrimSpace(line)
+		// Remove tree characters like |- and `-
+		line = strings.TrimPrefix(line, "|- ")
+		line = strings.TrimPrefix(line, "|  |- ")
+		line = strings.TrimPrefix(line, "|  `- ")
+		line = strings.TrimPrefix(line, "`- ")
 		
-		jailStatus, err := execHostCommand("fail2ban-client", "status", jail)
-		if err == nil {
-			// Parse banned IPs and stats - handle different fail2ban output formats
-			for _, line := range strings.Split(jailStatus, "\n") {
-				line = strings.TrimSpace(line)
-				// Remove tree characters like |- and `-
-				line = strings.TrimPrefix(line, "|- ")
-				line = strings.TrimPrefix(line, "|  |- ")
-				line = strings.TrimPrefix(line, "|  `- ")
-				line = strings.TrimPrefix(line, "`- ")
-				
-				if strings.HasPrefix(line, "Currently banned:") {
-					parts := strings.SplitN(line, ":", 2)
-					if len(parts) == 2 {
-						var count int
-						fmt.Sscanf(strings.TrimSpace(parts[1]), "%d", &count)
-						jailInfo["banned_count"] = count
-					}
-				}
-				if strings.HasPrefix(line, "Total banned:") {
-					parts := strings.SplitN(line, ":", 2)
-					if len(parts) == 2 {
-						var count int
-						fmt.Sscanf(strings.TrimSpace(parts[1]), "%d", &count)
-						jailInfo["total_banned"] = count
-					}
-				}
-				if strings.HasPrefix(line, "Banned IP list:") {
-					parts := strings.SplitN(line, ":", 2)
-					if len(parts) == 2 {
-						ips := strings.TrimSpace(parts[1])
-						if ips != "" {
-							jailInfo["banned_ips"] = strings.Fields(ips)
-						} else {
-							jailInfo["banned_ips"] = []string{}
-						}
-					}
-				}
-				if strings.HasPrefix(line, "Currently failed:") {
-					parts := strings.SplitN(line, ":", 2)
-					if len(parts) == 2 {
-						var count int
-						fmt.Sscanf(strings.TrimSpace(parts[1]), "%d", &count)
-						jailInfo["failed_count"] = count
-					}
-				}
-				if strings.HasPrefix(line, "Total failed:") {
-					parts := strings.SplitN(line, ":", 2)
-					if len(parts) == 2 {
-						var count int
-						fmt.Sscanf(strings.TrimSpace(parts[1]), "%d", &count)
-						jailInfo["total_failed"] = count
-					}
+		if strings.HasPrefix(line, "Currently banned:") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				var count int
+				fmt.Sscanf(strings.TrimSpace(parts[1]), "%d", &count)
+				jailInfo["banned_count"] = count
+			}
+		}
+		if strings.HasPrefix(line, "Total banned:") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				var count int
+				fmt.Sscanf(strings.TrimSpace(parts[1]), "%d", &count)
+				jailInfo["total_banned"] = count
+			}
+		}
+		if strings.HasPrefix(line, "Banned IP list:") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				ips := strings.TrimSpace(parts[1])
+				if ips != "" {
+					jailInfo["banned_ips"] = strings.Fields(ips)
+				} else {
+					jailInfo["banned_ips"] = []string{}
 				}
 			}
 		}
-		jailDetails = append(jailDetails, jailInfo)
+		if strings.HasPrefix(line, "Currently failed:") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				var count int
+				fmt.Sscanf(strings.TrimSpace(parts[1]), "%d", &count)
+				jailInfo["failed_count"] = count
+			}
+		}
+		if strings.HasPrefix(line, "Total failed:") {
+			parts := strings.SplitN(line, ":", 2)
+			if len(parts) == 2 {
+				var count int
+				fmt.Sscanf(strings.TrimSpace(parts[1]), "%d", &count)
+				jailInfo["total_failed"] = count
+			}
+		}
 	}
-	result["jails"] = jailDetails
-	
-	jsonResponse(w, http.StatusOK, result)
 }
 
 // Fail2Ban Logs
@@ -1422,11 +1656,7 @@ func (s *Server) handleStatsOverview(w http.ResponseWriter, r *http.Request) {
 		}
 		
 		// Get WireGuard peers for real-time status
-		var peers map[string]wireguard.PeerStatus
-		mgr := s.getManager(network.ID)
-		if mgr != nil {
-			peers, _ = mgr.GetPeers()
-		}
+		peers := s.livePeers(network.ID)
 		
 		netOnline := 0
 		netOffline := 0
@@ -1557,61 +1787,31 @@ func (s *Server) handleDebugWireGuard(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, http.StatusOK, result)
 }
 
-// handleSyncPeers syncs all DB nodes to WireGuard interface
+// handleSyncPeers reconciles one network immediately
 func (s *Server) handleSyncPeers(w http.ResponseWriter, r *http.Request) {
 	networkID := mux.Vars(r)["networkId"]
 	
-	// Get manager
-	mgr := s.getManager(networkID)
-	if mgr == nil {
+	if s.getManager(networkID) == nil {
 		errorResponse(w, http.StatusInternalServerError, "WireGuard manager not available for this network")
 		return
 	}
 	
-	// Get all nodes from DB
-	nodes, err := s.store.ListNodes(r.Context(), networkID)
-	if err != nil {
-		errorResponse(w, http.StatusInternalServerError, "failed to list nodes: "+err.Error())
-		return
-	}
-	
-	// Get current WireGuard peers
-	currentPeers, err := mgr.GetPeers()
-	if err != nil {
-		errorResponse(w, http.StatusInternalServerError, "failed to get WireGuard peers: "+err.Error())
-		return
-	}
+	result := s.reconcile(r.Context(), "manual", networkID)
 	
-	added := 0
-	skipped := 0
-	errors := []string{}
-	
-	for _, node := range nodes {
-		if node.PublicKey == "" {
-			continue
-		}
-		
-		// Check if already in WireGuard
-		if _, exists := currentPeers[node.PublicKey]; exists {
-			skipped++
-			continue
-		}
-		
-		// Add to WireGuard
-		allowedIPs := node.VirtualIP.String() + "/32"
-		if err := mgr.AddPeer(node.PublicKey, allowedIPs); err != nil {
-			errors = append(errors, fmt.Sprintf("failed to add %s: %v", node.Name, err))
-		} else {
-			added++
-			fmt.Printf("[SYNC] Added peer %s (%s) to WireGuard\n", node.Name, node.PublicKey[:16]+"...")
+	errors := result.Errors
+	for _, d := range result.Drift {
+		if d.Error != "" {
+			errors = append(errors, fmt.Sprintf("%s %s: %s", d.Kind, d.PublicKey, d.Error))
 		}
 	}
 	
 	jsonResponse(w, http.StatusOK, map[string]interface{}{
-		"added":   added,
-		"skipped": skipped,
-		"errors":  errors,
-		"total":   len(nodes),
+		"added":    result.Added,
+		"updated":  result.Updated,
+		"removed":  result.Removed,
+		"imported": result.Imported,
+		"errors":   errors,
+		"drift":    result.Drift,
 	})
 }
 
@@ -1630,8 +1830,8 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 		
-		// Skip auth for health check and login
-		if r.URL.Path == "/health" ||
+		// Skip auth for health checks and login
+		if isHealthPath(r.URL.Path) || isGatewayAgentPath(r.URL.Path) || isNodeAgentPath(r.URL.Path) || isEnrollmentPath(r.URL.Path) ||
 		   strings.HasSuffix(r.URL.Path, "/login") {
 			next.ServeHTTP(w, r)
 			return
@@ -1658,6 +1858,11 @@ func AuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// isHealthPath reports whether the path is an unauthenticated health endpoint
+func isHealthPath(path string) bool {
+	return path == "/health" || path == "/livez" || path == "/readyz"
+}
+
 // APIKeyMiddleware validates the X-API-Key header
 func APIKeyMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -1678,7 +1883,7 @@ func APIKeyMiddleware(next http.Handler) http.Handler {
 		}
 
 		// Public paths that don't need API Key
-		if r.URL.Path == "/health" ||
+		if isHealthPath(r.URL.Path) || isGatewayAgentPath(r.URL.Path) || isNodeAgentPath(r.URL.Path) || isEnrollmentPath(r.URL.Path) ||
 		   strings.HasSuffix(r.URL.Path, "/login") {
 			next.ServeHTTP(w, r)
 			return
@@ -1937,13 +2142,26 @@ func (s *Server) handleFail2BanUpdateWhitelist(w http.ResponseWriter, r *http.Re
 
 // Fail2Ban Get Permanent Bans - Get list of permanently banned IPs via iptables
 func (s *Server) handleFail2BanGetPermanentBans(w http.ResponseWriter, r *http.Request) {
-	// Get iptables rules that DROP traffic
-	out, err := execHostCommand("iptables", "-L", "INPUT", "-n", "--line-numbers")
+	bannedIPs, err := s.listPermanentBans()
 	if err != nil {
 		errorResponse(w, http.StatusInternalServerError, "failed to get iptables rules: "+err.Error())
 		return
 	}
 	
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"permanent_bans": bannedIPs,
+		"count":          len(bannedIPs),
+	})
+}
+
+// listPermanentBans returns the INPUT DROP rules that target a specific source address
+func (s *Server) listPermanentBans() ([]map[string]interface{}, error) {
+	// Get iptables rules that DROP traffic
+	out, err := execHostCommand("iptables", "-L", "INPUT", "-n", "--line-numbers")
+	if err != nil {
+		return nil, err
+	}
+	
 	var bannedIPs []map[string]interface{}
 	lines := strings.Split(out, "\n")
 	
@@ -1963,16 +2181,14 @@ func (s *Server) handleFail2BanGetPermanentBans(w http.ResponseWriter, r *http.R
 						"ip":          sourceIP,
 						"target":      "DROP",
 						"protocol":    fields[2],
+						"geo":         s.geo.Lookup(sourceIP),
 					})
 				}
 			}
 		}
 	}
 	
-	jsonResponse(w, http.StatusOK, map[string]interface{}{
-		"permanent_bans": bannedIPs,
-		"count":          len(bannedIPs),
-	})
+	return bannedIPs, nil
 }
 
 // Fail2Ban Remove Permanent Ban - Remove a permanently banned IP from iptables
@@ -2115,12 +2331,24 @@ func (s *Server) handleFail2BanHistory(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	
+	history := s.readFail2BanHistory(jail, limit)
+	
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"history": history,
+		"count":   len(history),
+		"jail":    jail,
+	})
+}
+
+// readFail2BanHistory returns the last `limit` ban/unban entries from the fail2ban log,
+// newest first. An empty jail matches all jails.
+func (s *Server) readFail2BanHistory(jail string, limit int) []map[string]string {
 	// Get ban/unban history from fail2ban log
 	var grepPattern string
 	if jail != "" {
-		grepPattern = fmt.Sprintf("\\[%s\\].*\\(Ban\\|Unban\\)", jail)
+		grepPattern = fmt.Sprintf("\\[%s\\].*(Ban|Unban)", jail)
 	} else {
-		grepPattern = "\\(Ban\\|Unban\\)"
+		grepPattern = "(Ban|Unban)"
 	}
 	
 	out, _ := execHostCommand("sh", "-c", fmt.Sprintf("grep -E '%s' /var/log/fail2ban.log 2>/dev/null | tail -n %d", grepPattern, limit))
@@ -2163,6 +2391,15 @@ func (s *Server) handleFail2BanHistory(w http.ResponseWriter, r *http.Request) {
 			lastPart := parts[len(parts)-1]
 			if net.ParseIP(lastPart) != nil {
 				entry["ip"] = lastPart
+				if geo := s.geo.Lookup(lastPart); geo != nil {
+					entry["country_code"] = geo.CountryCode
+					entry["country"] = geo.Country
+					entry["city"] = geo.City
+					if geo.ASN != 0 {
+						entry["asn"] = strconv.FormatUint(uint64(geo.ASN), 10)
+						entry["as_org"] = geo.ASOrg
+					}
+				}
 			}
 		}
 		
@@ -2174,9 +2411,5 @@ func (s *Server) handleFail2BanHistory(w http.ResponseWriter, r *http.Request) {
 		history[i], history[j] = history[j], history[i]
 	}
 	
-	jsonResponse(w, http.StatusOK, map[string]interface{}{
-		"history": history,
-		"count":   len(history),
-		"jail":    jail,
-	})
+	return history
 }
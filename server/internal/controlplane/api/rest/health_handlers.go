@@ -0,0 +1,200 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+// readinessTimeout bounds how long a single readiness check may take
+const readinessTimeout = 5 * time.Second
+
+// ComponentStatus is the result of a single readiness check
+type ComponentStatus struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`   // ok, fail
+	Critical  bool   `json:"critical"` // A failing critical component makes the server not ready
+	Message   string `json:"message,omitempty"`
+	LatencyMs int64  `json:"latency_ms"`
+}
+
+type readinessCheck struct {
+	name     string
+	critical bool
+	run      func(ctx context.Context) (string, error)
+}
+
+// Health check (kept for backwards compatibility, equivalent to /livez)
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, http.StatusOK, map[string]string{
+		"status":  "healthy",
+		"version": s.version,
+	})
+}
+
+// handleLivez reports that the process is up and serving HTTP
+func (s *Server) handleLivez(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"status":         "alive",
+		"version":        s.version,
+		"uptime_seconds": int64(time.Since(s.startedAt).Seconds()),
+	})
+}
+
+// handleReadyz runs all dependency checks and returns a per-component report.
+// It responds 503 if any critical component fails, so it can be used directly
+// by Docker healthchecks and load balancers.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := s.readinessChecks(r.Context())
+
+	results := make([]ComponentStatus, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check readinessCheck) {
+			defer wg.Done()
+			results[i] = runReadinessCheck(r.Context(), check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	ready := true
+	for _, result := range results {
+		if result.Critical && result.Status != "ok" {
+			ready = false
+		}
+	}
+
+	status := http.StatusOK
+	overall := "ready"
+	if !ready {
+		status = http.StatusServiceUnavailable
+		overall = "not_ready"
+	}
+
+	jsonResponse(w, status, map[string]interface{}{
+		"status":     overall,
+		"version":    s.version,
+		"components": results,
+	})
+}
+
+func runReadinessCheck(parent context.Context, check readinessCheck) ComponentStatus {
+	ctx, cancel := context.WithTimeout(parent, readinessTimeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan ComponentStatus, 1)
+	go func() {
+		msg, err := check.run(ctx)
+		result := ComponentStatus{Name: check.name, Critical: check.critical, Status: "ok", Message: msg}
+		if err != nil {
+			result.Status = "fail"
+			result.Message = err.Error()
+		}
+		done <- result
+	}()
+
+	var result ComponentStatus
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result = ComponentStatus{Name: check.name, Critical: check.critical, Status: "fail", Message: "check timed out"}
+	}
+	result.LatencyMs = time.Since(start).Milliseconds()
+	return result
+}
+
+// readinessChecks builds the list of checks; one per WireGuard interface plus the fixed dependencies
+func (s *Server) readinessChecks(ctx context.Context) []readinessCheck {
+	checks := []readinessCheck{
+		{name: "database", critical: true, run: func(ctx context.Context) (string, error) {
+			if err := s.store.Ping(ctx); err != nil {
+				return "", fmt.Errorf("database unreachable: %w", err)
+			}
+			return "connected", nil
+		}},
+		{name: "migrations", critical: true, run: func(ctx context.Context) (string, error) {
+			applied, pending, err := s.store.MigrationStatus(ctx)
+			if err != nil {
+				return "", err
+			}
+			if len(pending) > 0 {
+				return "", fmt.Errorf("%d pending migration(s): %s", len(pending), strings.Join(pending, ", "))
+			}
+			return fmt.Sprintf("%d applied", len(applied)), nil
+		}},
+		{name: "wireguard", critical: true, run: func(ctx context.Context) (string, error) {
+			if err := wireguard.CheckBackend(s.wgBackend); err != nil {
+				return "", err
+			}
+			return s.wgBackend + " backend available", nil
+		}},
+		{name: "iptables", critical: true, run: func(ctx context.Context) (string, error) {
+			// The simulated backend never touches the host firewall
+			if s.wgBackend == wireguard.BackendSimulated {
+				return "skipped (simulated backend)", nil
+			}
+			if _, err := execHostCommand("iptables", "-L", "INPUT", "-n"); err != nil {
+				return "", fmt.Errorf("iptables not usable: %w", err)
+			}
+			return "available", nil
+		}},
+		{name: "fail2ban", critical: false, run: func(ctx context.Context) (string, error) {
+			out, err := execHostCommand("fail2ban-client", "ping")
+			if err != nil {
+				return "", fmt.Errorf("fail2ban not responding: %w", err)
+			}
+			if !strings.Contains(out, "pong") {
+				return "", fmt.Errorf("unexpected reply: %s", strings.TrimSpace(out))
+			}
+			return "pong", nil
+		}},
+	}
+
+	networks, err := s.store.ListNetworks(ctx)
+	if err != nil {
+		// The database check reports the underlying problem
+		return checks
+	}
+	for _, network := range networks {
+		// Remote hubs and active remote gateways serve the network elsewhere
+		if network.InterfaceName == "" || network.RemoteHub || network.ActiveGatewayID != nil {
+			continue
+		}
+		iface := network.InterfaceName
+		checks = append(checks, readinessCheck{
+			name:     "interface:" + iface,
+			critical: true,
+			run: func(ctx context.Context) (string, error) {
+				if s.wgBackend == wireguard.BackendSimulated {
+					if !s.newManager(iface).IsUp() {
+						return "", fmt.Errorf("interface %s is down", iface)
+					}
+					return "up (simulated)", nil
+				}
+				return interfaceState(iface)
+			},
+		})
+	}
+
+	return checks
+}
+
+// interfaceState checks that a network interface exists and is up
+func interfaceState(name string) (string, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", fmt.Errorf("interface %s not found", name)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return "", fmt.Errorf("interface %s is down", name)
+	}
+	return "up", nil
+}
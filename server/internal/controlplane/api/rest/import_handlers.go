@@ -0,0 +1,165 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/novusgate/novusgate/internal/controlplane/store"
+	"github.com/novusgate/novusgate/internal/controlplane/wgimport"
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+// handleImportNetwork creates a network from an existing wg-quick server
+// config, keeping its keys and port, and a node for each peer. Client configs
+// add the nodes' client settings; their private keys are not stored.
+// Conflicts with existing networks and subnet routes are reported and nothing
+// is created; with dry_run the plan is returned without applying it.
+func (s *Server) handleImportNetwork(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	var req struct {
+		Name           string                `json:"name"`
+		ServerConfig   string                `json:"server_config"`
+		Clients        []wgimport.ClientFile `json:"clients"`
+		ServerEndpoint string                `json:"server_endpoint"` // host or host:port
+		InterfaceName  string                `json:"interface_name"`  // Defaults to the next free one
+		DryRun         bool                  `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	existing, err := s.store.ListNetworks(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list networks")
+		return
+	}
+	interfaceName := strings.TrimSpace(req.InterfaceName)
+	if interfaceName == "" {
+		interfaceName = s.allocateInterfaceName(existing)
+	} else if !ValidInterfaceName(interfaceName) {
+		errorResponse(w, http.StatusBadRequest, "interface_name must be a prefix of a letter and up to 9 letters, digits, '_' or '-' followed by an index, at most 15 characters")
+		return
+	}
+
+	plan, err := wgimport.Build(wgimport.Options{
+		Name:          req.Name,
+		ServerConfig:  req.ServerConfig,
+		Clients:       req.Clients,
+		Endpoint:      strings.TrimSpace(req.ServerEndpoint),
+		InterfaceName: interfaceName,
+	})
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	plan.Conflicts = append(plan.Conflicts, s.importConflicts(ctx, plan, existing)...)
+
+	if req.DryRun {
+		jsonResponse(w, http.StatusOK, plan)
+		return
+	}
+	if len(plan.Conflicts) > 0 {
+		jsonResponse(w, http.StatusConflict, map[string]interface{}{
+			"error":     "the import conflicts with existing networks",
+			"conflicts": plan.Conflicts,
+			"plan":      plan,
+		})
+		return
+	}
+
+	network := plan.Network
+	if err := s.store.ImportNetwork(ctx, network, plan.Nodes); err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			errorResponse(w, http.StatusConflict, "failed to import network: "+err.Error())
+			return
+		}
+		errorResponse(w, http.StatusInternalServerError, "failed to import network: "+err.Error())
+		return
+	}
+	fmt.Printf("Network %s imported with %d node(s)\n", network.Name, len(plan.Nodes))
+
+	s.startNetworkInterface(network)
+	result := s.reconcile(ctx, "import", network.ID)
+	plan.Warnings = append(plan.Warnings, result.Errors...)
+	if err := s.openNetworkPort(network); err != nil {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("failed to open port %d in the host firewall: %v", network.ListenPort, err))
+	}
+	for _, warning := range plan.Warnings {
+		fmt.Printf("[Import] Warning: %s\n", warning)
+	}
+
+	s.store.CreateFirewallAuditLog(ctx, "network_imported", map[string]interface{}{
+		"network_id":  network.ID,
+		"name":        network.Name,
+		"listen_port": network.ListenPort,
+		"cidrs":       network.CIDRs(),
+		"nodes":       len(plan.Nodes),
+	}, r.RemoteAddr)
+
+	jsonResponse(w, http.StatusCreated, plan)
+}
+
+// ImportConflicts returns the conflicts of an import plan with the networks,
+// overlap interfaces and approved subnet routes in the database, for the
+// import CLI command
+func ImportConflicts(ctx context.Context, st *store.Store, plan *wgimport.Plan, existing []*models.Network) []string {
+	s := &Server{store: st}
+	return s.importConflicts(ctx, plan, existing)
+}
+
+// importConflicts checks an import plan like a new network: its name,
+// interface and port against other networks and running overlap interfaces,
+// and its ranges and peer routes with cidrConflict
+func (s *Server) importConflicts(ctx context.Context, plan *wgimport.Plan, existing []*models.Network) []string {
+	var conflicts []string
+	network := plan.Network
+
+	usedPorts := s.overlapListenPorts(ctx)
+	for _, n := range existing {
+		if n.Name == network.Name {
+			conflicts = append(conflicts, fmt.Sprintf("a network named '%s' already exists", n.Name))
+		}
+		if n.InterfaceName != "" && n.InterfaceName == network.InterfaceName {
+			conflicts = append(conflicts, fmt.Sprintf("interface %s is used by network '%s'", n.InterfaceName, n.Name))
+		}
+		if n.ListenPort == network.ListenPort {
+			conflicts = append(conflicts, fmt.Sprintf("port %d is used by network '%s'", n.ListenPort, n.Name))
+		}
+	}
+	if rotations, err := s.store.ListOverlappingKeyRotations(ctx); err == nil {
+		for _, rotation := range rotations {
+			if rotation.OverlapInterface == network.InterfaceName {
+				conflicts = append(conflicts, fmt.Sprintf("interface %s is used by a key rotation overlap", network.InterfaceName))
+			}
+		}
+	}
+	if usedPorts[network.ListenPort] {
+		conflicts = append(conflicts, fmt.Sprintf("port %d is used by a key rotation overlap interface", network.ListenPort))
+	}
+
+	var ranges []*net.IPNet
+	for _, cidr := range network.CIDRs() {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			ranges = append(ranges, ipNet)
+		}
+	}
+	if err := s.cidrConflict(ctx, existing, ranges, ""); err != nil {
+		conflicts = append(conflicts, err.Error())
+	}
+	for _, peer := range plan.Peers {
+		for _, route := range peer.Routes {
+			if _, ipNet, err := net.ParseCIDR(route); err == nil {
+				if err := s.cidrConflict(ctx, existing, []*net.IPNet{ipNet}, ""); err != nil {
+					conflicts = append(conflicts, fmt.Sprintf("peer %s: %v", peer.Name, err))
+				}
+			}
+		}
+	}
+	return conflicts
+}
@@ -0,0 +1,332 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/shared/netutil"
+)
+
+// addressItem is a used, reserved or excluded address range of a network
+// range, before it is split into address map blocks
+type addressItem struct {
+	start, end net.IP
+	status     string
+	node       *models.Node
+}
+
+// addressStatusRank orders overlapping items: the first one listed wins
+var addressStatusRank = map[string]int{
+	"network":                    0,
+	"hub":                        1,
+	"broadcast":                  2,
+	"node":                       3,
+	models.IPReservationExcluded: 4,
+	models.IPReservationReserved: 5,
+}
+
+func ipToInt(ip net.IP) *big.Int {
+	return new(big.Int).SetBytes(ip.To16())
+}
+
+// intToIP converts back to an address of the family of like
+func intToIP(n *big.Int, like net.IP) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	n.FillBytes(ip)
+	if like.To4() != nil {
+		return ip.To4()
+	}
+	return ip
+}
+
+// addressUtilization counts and maps the addresses of one network range
+func addressUtilization(cidr string, nodes []*models.Node, reservations []*models.IPReservation) (*models.AddressUtilization, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	base := ipNet.IP
+	broadcast := make(net.IP, len(base))
+	for i := range broadcast {
+		broadcast[i] = base[i] | ^ipNet.Mask[i]
+	}
+
+	items := []addressItem{{start: base, end: base, status: "network"}}
+	if hub := netutil.NextIP(base); ipNet.Contains(hub) && !hub.Equal(broadcast) {
+		items = append(items, addressItem{start: hub, end: hub, status: "hub"})
+	}
+	if ones, bits := ipNet.Mask.Size(); bits == 32 && ones < 31 {
+		items = append(items, addressItem{start: broadcast, end: broadcast, status: "broadcast"})
+	}
+	for _, node := range nodes {
+		for _, ip := range node.Addresses() {
+			if ipNet.Contains(ip) {
+				items = append(items, addressItem{start: ip, end: ip, status: "node", node: node})
+			}
+		}
+	}
+	for _, r := range reservations {
+		if ipNet.Contains(r.Start) && ipNet.Contains(r.End) {
+			items = append(items, addressItem{start: r.Start, end: r.End, status: r.Kind})
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return addressStatusRank[items[i].status] < addressStatusRank[items[j].status]
+	})
+
+	// Every item start and end+1 begins a new block
+	limit := new(big.Int).Add(ipToInt(broadcast), big.NewInt(1))
+	bounds := []*big.Int{ipToInt(base), limit}
+	for _, item := range items {
+		bounds = append(bounds, ipToInt(item.start), new(big.Int).Add(ipToInt(item.end), big.NewInt(1)))
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i].Cmp(bounds[j]) < 0 })
+
+	counts := map[string]*big.Int{}
+	for _, status := range []string{"node", "free", models.IPReservationReserved, models.IPReservationExcluded} {
+		counts[status] = new(big.Int)
+	}
+	util := &models.AddressUtilization{CIDR: ipNet.String(), Map: []*models.AddressBlock{}}
+	for i := 0; i+1 < len(bounds); i++ {
+		if bounds[i].Cmp(bounds[i+1]) == 0 {
+			continue
+		}
+		start := intToIP(bounds[i], base)
+		end := intToIP(new(big.Int).Sub(bounds[i+1], big.NewInt(1)), base)
+
+		block := &models.AddressBlock{Start: start, End: end, Status: "free"}
+		for _, item := range items {
+			if netutil.CompareIP(item.start, start) <= 0 && netutil.CompareIP(start, item.end) <= 0 {
+				block.Status = item.status
+				if item.node != nil {
+					block.NodeID, block.NodeName = item.node.ID, item.node.Name
+				}
+				break
+			}
+		}
+		size := netutil.RangeSize(start, end)
+		if count, ok := counts[block.Status]; ok {
+			count.Add(count, size)
+		}
+
+		// Adjacent blocks of the same kind are merged, nodes stay single
+		if n := len(util.Map); n > 0 && block.Status != "node" && util.Map[n-1].Status == block.Status {
+			util.Map[n-1].End = end
+			continue
+		}
+		util.Map = append(util.Map, block)
+	}
+	for _, block := range util.Map {
+		block.Size = netutil.RangeSize(block.Start, block.End).String()
+	}
+
+	util.Total = netutil.RangeSize(base, broadcast).String()
+	util.Used = counts["node"].String()
+	util.Free = counts["free"].String()
+	util.Reserved = counts[models.IPReservationReserved].String()
+	util.Excluded = counts[models.IPReservationExcluded].String()
+	return util, nil
+}
+
+// handleGetNetworkIPAM returns the address utilization and address map of
+// each range of a network, with its reservations
+func (s *Server) handleGetNetworkIPAM(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	network, err := s.store.GetNetwork(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+	nodes, err := s.store.ListNodes(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list nodes")
+		return
+	}
+	reservations, err := s.store.ListIPReservations(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list IP reservations")
+		return
+	}
+
+	ranges := []*models.AddressUtilization{}
+	for _, cidr := range network.CIDRs() {
+		util, err := addressUtilization(cidr, nodes, reservations)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("invalid network range %s", cidr))
+			return
+		}
+		ranges = append(ranges, util)
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"network_id":   network.ID,
+		"ranges":       ranges,
+		"reservations": reservations,
+	})
+}
+
+// handleListIPReservations lists the reserved and excluded ranges of a network
+func (s *Server) handleListIPReservations(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	reservations, err := s.store.ListIPReservations(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list IP reservations")
+		return
+	}
+	jsonResponse(w, http.StatusOK, reservations)
+}
+
+// handleCreateIPReservation reserves or excludes an address, a start-end range
+// or a CIDR block of a network. Excluded ranges must not hold nodes.
+func (s *Server) handleCreateIPReservation(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req struct {
+		Start       string `json:"start"`
+		End         string `json:"end"`  // Defaults to start
+		CIDR        string `json:"cidr"` // Instead of start/end
+		Kind        string `json:"kind"` // reserved (default) or excluded
+		Description string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Kind == "" {
+		req.Kind = models.IPReservationReserved
+	}
+	if req.Kind != models.IPReservationReserved && req.Kind != models.IPReservationExcluded {
+		errorResponse(w, http.StatusBadRequest, "kind must be reserved or excluded")
+		return
+	}
+
+	var start, end net.IP
+	if req.CIDR != "" {
+		_, block, err := net.ParseCIDR(strings.TrimSpace(req.CIDR))
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, "invalid cidr")
+			return
+		}
+		start = block.IP
+		end = make(net.IP, len(block.IP))
+		for i := range end {
+			end[i] = block.IP[i] | ^block.Mask[i]
+		}
+	} else {
+		start = net.ParseIP(strings.TrimSpace(req.Start))
+		end = start
+		if req.End != "" {
+			end = net.ParseIP(strings.TrimSpace(req.End))
+		}
+		if start == nil || end == nil {
+			errorResponse(w, http.StatusBadRequest, "start (and optional end) must be IP addresses, or set cidr")
+			return
+		}
+	}
+	if (start.To4() == nil) != (end.To4() == nil) || netutil.CompareIP(start, end) > 0 {
+		errorResponse(w, http.StatusBadRequest, "start must not be after end and both must be of one address family")
+		return
+	}
+
+	network, err := s.store.GetNetwork(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+	inRange := false
+	for _, cidr := range network.CIDRs() {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil && ipNet.Contains(start) && ipNet.Contains(end) {
+			first, last := netutil.NodeRange(ipNet)
+			if netutil.CompareIP(start, first) < 0 || netutil.CompareIP(end, last) > 0 {
+				errorResponse(w, http.StatusBadRequest, fmt.Sprintf("the range must lie within the node addresses %s-%s", first, last))
+				return
+			}
+			inRange = true
+		}
+	}
+	if !inRange {
+		errorResponse(w, http.StatusBadRequest, "the range must lie within one of the network's ranges")
+		return
+	}
+
+	reservations, err := s.store.ListIPReservations(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list IP reservations")
+		return
+	}
+	for _, other := range reservations {
+		if netutil.CompareIP(start, other.End) <= 0 && netutil.CompareIP(other.Start, end) <= 0 {
+			errorResponse(w, http.StatusConflict, fmt.Sprintf("overlaps %s range %s-%s", other.Kind, other.Start, other.End))
+			return
+		}
+	}
+
+	reservation := &models.IPReservation{
+		NetworkID:   id,
+		Start:       start,
+		End:         end,
+		Kind:        req.Kind,
+		Description: strings.TrimSpace(req.Description),
+	}
+	if req.Kind == models.IPReservationExcluded {
+		nodes, err := s.store.ListNodes(r.Context(), id)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "failed to list nodes")
+			return
+		}
+		var holders []string
+		for _, node := range nodes {
+			for _, ip := range node.Addresses() {
+				if reservation.Contains(ip) {
+					holders = append(holders, fmt.Sprintf("%s (%s)", node.Name, ip))
+				}
+			}
+		}
+		if len(holders) > 0 {
+			errorResponse(w, http.StatusConflict, "the range holds nodes: "+strings.Join(holders, ", "))
+			return
+		}
+	}
+
+	if err := s.store.CreateIPReservation(r.Context(), reservation); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to create IP reservation")
+		return
+	}
+	fmt.Printf("[IPAM] Network %s: %s %s-%s\n", network.Name, reservation.Kind, reservation.Start, reservation.End)
+
+	jsonResponse(w, http.StatusCreated, reservation)
+}
+
+// handleDeleteIPReservation releases a reserved or excluded range
+func (s *Server) handleDeleteIPReservation(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	reservation, err := s.store.GetIPReservation(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get IP reservation")
+		return
+	}
+	if reservation == nil {
+		errorResponse(w, http.StatusNotFound, "IP reservation not found")
+		return
+	}
+	if err := s.store.DeleteIPReservation(r.Context(), id); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to delete IP reservation")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,642 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/controlplane/store"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/shared/netutil"
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+// keyRotationCheckInterval is how often overlap interfaces are checked for
+// migrated nodes and expiry, and scheduled node key rotations are requested
+const keyRotationCheckInterval = time.Minute
+
+// nodeKeyRotationRetry is how long a node has to answer a scheduled key
+// rotation request before it is asked again
+const nodeKeyRotationRetry = 24 * time.Hour
+
+// overlapInterfaceName returns the name of the interface that keeps serving
+// the old server key of a network during a rotation overlap window
+func overlapInterfaceName(interfaceName string) string {
+	name := interfaceName + "-old"
+	if len(name) > 15 { // IFNAMSIZ - 1
+		name = name[:15]
+	}
+	return name
+}
+
+// endpointWithPort replaces the port of a host:port endpoint
+func endpointWithPort(endpoint string, port int) string {
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil || host == "" {
+		host = wireguard.GetServerEndpoint()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// overlapListenPorts returns the UDP ports held by running overlap interfaces
+func (s *Server) overlapListenPorts(ctx context.Context) map[int]bool {
+	ports := make(map[int]bool)
+	rotations, err := s.store.ListOverlappingKeyRotations(ctx)
+	if err != nil {
+		return ports
+	}
+	for _, rotation := range rotations {
+		ports[rotation.OldListenPort] = true
+	}
+	return ports
+}
+
+// handleRotateNetworkKey replaces a network's server keypair. Nodes must
+// fetch a new config; nodes using a check-in agent are notified with a
+// server_key_rotated event. With overlap_minutes the old key keeps running on
+// a second interface on the old port while the network moves to a new port.
+func (s *Server) handleRotateNetworkKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var req struct {
+		OverlapMinutes int `json:"overlap_minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.OverlapMinutes < 0 {
+		errorResponse(w, http.StatusBadRequest, "overlap_minutes must not be negative")
+		return
+	}
+
+	ctx := r.Context()
+	network, err := s.store.GetNetwork(ctx, id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+
+	overlapping, err := s.store.ListOverlappingKeyRotations(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list key rotations")
+		return
+	}
+	for _, rotation := range overlapping {
+		if rotation.NetworkID == network.ID {
+			errorResponse(w, http.StatusConflict, fmt.Sprintf(
+				"a previous rotation is still in its overlap window until %s",
+				rotation.OverlapUntil.Format(time.RFC3339)))
+			return
+		}
+	}
+
+	privateKey, publicKey, err := wireguard.GenerateKeys()
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to generate WireGuard keys: "+err.Error())
+		return
+	}
+
+	oldPort := network.ListenPort
+	if oldPort == 0 {
+		oldPort = wireguard.DefaultServerPort
+	}
+	rotation := &models.KeyRotation{
+		NetworkID:     network.ID,
+		OldPublicKey:  network.ServerPublicKey,
+		NewPublicKey:  publicKey,
+		OldListenPort: oldPort,
+		NewListenPort: oldPort,
+		Status:        models.KeyRotationCompleted,
+		InitiatedBy:   r.RemoteAddr,
+	}
+
+	if req.OverlapMinutes > 0 {
+		// The old key stays on the old port; the network moves to a free port
+		networks, err := s.store.ListNetworks(ctx)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "failed to list networks")
+			return
+		}
+		newPort, err := s.allocateListenPort(ctx, networks)
+		if err != nil {
+			errorResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		until := time.Now().Add(time.Duration(req.OverlapMinutes) * time.Minute)
+		rotation.OldPrivateKey = network.ServerPrivateKey
+		rotation.NewListenPort = newPort
+		rotation.OverlapInterface = overlapInterfaceName(network.InterfaceName)
+		rotation.OverlapUntil = &until
+		rotation.Status = models.KeyRotationOverlap
+	} else {
+		now := time.Now()
+		rotation.CompletedAt = &now
+	}
+
+	endpoint := endpointWithPort(network.ServerEndpoint, rotation.NewListenPort)
+	payload := map[string]interface{}{
+		"network_id":        network.ID,
+		"server_public_key": publicKey,
+		"server_endpoint":   endpoint,
+	}
+	if rotation.OverlapUntil != nil {
+		payload["overlap_until"] = rotation.OverlapUntil
+	}
+	if err := s.store.RotateNetworkKey(ctx, rotation, privateKey, endpoint, payload); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to rotate network key: "+err.Error())
+		return
+	}
+	fmt.Printf("[KeyRotation] Network %s: server key rotated (%s -> %s)\n", network.Name, rotation.OldPublicKey, publicKey)
+
+	// Apply the new key (and port) to the running interface; peers are kept
+	var warnings []string
+	if mgr := s.getManager(network.ID); mgr != nil {
+		serverAddrs, _ := netutil.ServerAddresses(network.CIDRs()...)
+		if err := mgr.CreateServerConfigWithKey(privateKey, strings.Join(serverAddrs, ", "), rotation.NewListenPort); err != nil {
+			warnings = append(warnings, fmt.Sprintf("failed to apply new key to %s: %v", network.InterfaceName, err))
+		}
+	} else {
+		warnings = append(warnings, "no WireGuard interface for network, new key is only stored")
+	}
+
+	if rotation.Status == models.KeyRotationOverlap {
+		if err := s.startOverlapInterface(ctx, network, rotation); err != nil {
+			warnings = append(warnings, fmt.Sprintf("failed to start overlap interface %s: %v", rotation.OverlapInterface, err))
+		}
+		// The old port stays open under the overlap rule until it ends
+		if !network.RemoteHub {
+			if err := s.openHostPort(overlapPortRuleMarker+network.ID, rotation.OldListenPort); err != nil {
+				warnings = append(warnings, fmt.Sprintf("failed to keep port %d open: %v", rotation.OldListenPort, err))
+			}
+			moved := *network
+			moved.ListenPort = rotation.NewListenPort
+			if err := s.openNetworkPort(&moved); err != nil {
+				warnings = append(warnings, fmt.Sprintf("failed to open port %d in the host firewall: %v", moved.ListenPort, err))
+			}
+		}
+	}
+	for _, warning := range warnings {
+		fmt.Printf("[KeyRotation] Warning: %s\n", warning)
+	}
+
+	nodes, _ := s.store.ListNodes(ctx, network.ID)
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"rotation":          rotation,
+		"server_public_key": publicKey,
+		"server_endpoint":   endpoint,
+		"nodes_notified":    len(nodes),
+		"warnings":          warnings,
+	})
+}
+
+// handleListKeyRotations returns the rotation history of a network and which
+// nodes have fetched a config with the current server key
+func (s *Server) handleListKeyRotations(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	network, err := s.store.GetNetwork(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+
+	rotations, err := s.store.ListKeyRotations(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list key rotations")
+		return
+	}
+	if rotations == nil {
+		rotations = []*models.KeyRotation{}
+	}
+	nodes, err := s.store.ListNodeConfigStatus(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list node config status")
+		return
+	}
+	if nodes == nil {
+		nodes = []*models.NodeConfigStatus{}
+	}
+
+	upToDate := 0
+	for _, n := range nodes {
+		if n.UpToDate {
+			upToDate++
+		}
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"server_public_key": network.ServerPublicKey,
+		"rotations":         rotations,
+		"nodes":             nodes,
+		"up_to_date":        upToDate,
+		"pending":           len(nodes) - upToDate,
+	})
+}
+
+// startOverlapInterface brings up the overlap interface with the old key and
+// all active peers, routing their addresses through it until they migrate
+func (s *Server) startOverlapInterface(ctx context.Context, network *models.Network, rotation *models.KeyRotation) error {
+	mgr := s.newManager(rotation.OverlapInterface)
+	if err := mgr.Init(); err != nil {
+		return err
+	}
+	// No address: the overlap interface only carries existing sessions
+	if err := mgr.CreateServerConfigWithKey(rotation.OldPrivateKey, "", rotation.OldListenPort); err != nil {
+		return err
+	}
+
+	nodes, err := s.store.ListNodes(ctx, network.ID)
+	if err != nil {
+		return err
+	}
+	var peers []wireguard.PeerConfig
+	for _, node := range nodes {
+		if nodeIsExpired(node) {
+			continue
+		}
+		peers = append(peers, nodePeerConfig(node))
+	}
+	if err := mgr.AddPeers(peers); err != nil {
+		return err
+	}
+	for _, peer := range peers {
+		s.setOverlapRoutes(rotation.OverlapInterface, peer.AllowedIPs, true)
+	}
+	fmt.Printf("[KeyRotation] Overlap interface %s serving old key on port %d until %s\n",
+		rotation.OverlapInterface, rotation.OldListenPort, rotation.OverlapUntil.Format(time.RFC3339))
+	return nil
+}
+
+// stopOverlapInterface tears down an overlap interface and its config file
+func (s *Server) stopOverlapInterface(rotation *models.KeyRotation) {
+	mgr := s.newManager(rotation.OverlapInterface)
+	if err := mgr.Down(); err != nil {
+		fmt.Printf("[KeyRotation] Warning: failed to bring down %s: %v\n", rotation.OverlapInterface, err)
+	}
+	os.Remove(fmt.Sprintf("/etc/wireguard/%s.conf", rotation.OverlapInterface))
+	if err := s.closeHostPort(overlapPortRuleMarker + rotation.NetworkID); err != nil {
+		fmt.Printf("[KeyRotation] Warning: failed to close port %d: %v\n", rotation.OldListenPort, err)
+	}
+}
+
+// setOverlapRoutes adds or removes host routes that send a not yet migrated
+// node's traffic through the overlap interface
+func (s *Server) setOverlapRoutes(interfaceName, allowedIPs string, add bool) {
+	if s.wgBackend == wireguard.BackendSimulated {
+		return
+	}
+	action := "del"
+	if add {
+		action = "replace"
+	}
+	for _, cidr := range strings.Split(allowedIPs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" || strings.HasSuffix(cidr, "/0") {
+			continue // Exit node default routes stay on the main interface
+		}
+		args := []string{"route", action, cidr, "dev", interfaceName}
+		if netutil.IsIPv6(cidr) {
+			args = append([]string{"-6"}, args...)
+		}
+		if _, err := execHostCommand("ip", args...); err != nil && add {
+			fmt.Printf("[KeyRotation] Warning: failed to route %s via %s: %v\n", cidr, interfaceName, err)
+		}
+	}
+}
+
+// runKeyRotationMonitor periodically retires overlap interfaces, completes
+// node key grace periods and requests scheduled node key rotations
+func (s *Server) runKeyRotationMonitor() {
+	ticker := time.NewTicker(keyRotationCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.checkKeyRotationOverlaps(context.Background())
+		s.completeNodeKeyGraces(context.Background())
+		s.requestScheduledNodeKeys(context.Background())
+	}
+}
+
+// completeNodeKeyGraces moves the addresses of rotated nodes to their new key
+// once it completed a handshake (or the grace period ended) and drops the old
+// key. The reconciler does the same, but it may be disabled.
+func (s *Server) completeNodeKeyGraces(ctx context.Context) {
+	ids, err := s.store.ListNodesInKeyGrace(ctx)
+	if err != nil {
+		fmt.Printf("[KeyRotation] Failed to list nodes in a key grace period: %v\n", err)
+		return
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	// Serialized with rotations and reconciliation, which change the same peers
+	s.reconcileMu.Lock()
+	defer s.reconcileMu.Unlock()
+
+	live := make(map[string]map[string]wireguard.PeerStatus)
+	for _, id := range ids {
+		node, err := s.store.GetNode(ctx, id)
+		if err != nil || node == nil || node.PreviousPublicKey == "" {
+			continue
+		}
+		peers, ok := live[node.NetworkID]
+		if !ok {
+			peers = s.livePeers(node.NetworkID)
+			live[node.NetworkID] = peers
+		}
+		if !nodeKeyGraceOver(node, peers) {
+			continue
+		}
+
+		if err := s.store.ClearNodePreviousKey(ctx, node.ID); err != nil {
+			fmt.Printf("[KeyRotation] Failed to end key grace period of %s: %v\n", node.Name, err)
+			continue
+		}
+		oldKey := node.PreviousPublicKey
+		node.PreviousPublicKey, node.PreviousKeyExpiresAt = "", nil
+
+		if mgr := s.getManager(node.NetworkID); mgr != nil {
+			if !nodeIsExpired(node) {
+				if err := mgr.AddPeers([]wireguard.PeerConfig{nodePeerConfig(node)}); err != nil {
+					fmt.Printf("[KeyRotation] Warning: failed to move addresses of %s to its new key: %v\n", node.Name, err)
+				}
+			}
+			if err := mgr.RemovePeers([]string{oldKey}); err != nil {
+				fmt.Printf("[KeyRotation] Warning: failed to remove old key of %s: %v\n", node.Name, err)
+			}
+		}
+		fmt.Printf("[KeyRotation] Node %s: new key in use, old key removed\n", node.Name)
+	}
+}
+
+// checkKeyRotationOverlaps moves nodes that completed a handshake with the new
+// key off the overlap interface and ends overlap windows that expired or have
+// no nodes left
+func (s *Server) checkKeyRotationOverlaps(ctx context.Context) {
+	rotations, err := s.store.ListOverlappingKeyRotations(ctx)
+	if err != nil {
+		fmt.Printf("[KeyRotation] Failed to list rotations: %v\n", err)
+		return
+	}
+
+	for _, rotation := range rotations {
+		network, err := s.store.GetNetwork(ctx, rotation.NetworkID)
+		if err != nil {
+			continue
+		}
+		if network == nil || rotation.OverlapUntil == nil || time.Now().After(*rotation.OverlapUntil) {
+			s.finishKeyRotation(ctx, rotation, "overlap window ended")
+			continue
+		}
+
+		overlap := s.newManager(rotation.OverlapInterface)
+		if err := overlap.Init(); err != nil {
+			continue
+		}
+		if !overlap.IsUp() {
+			// Resume after a restart from the persisted config
+			if err := overlap.Up(); err != nil {
+				fmt.Printf("[KeyRotation] Warning: failed to bring up %s: %v\n", rotation.OverlapInterface, err)
+				continue
+			}
+		}
+		overlapPeers, err := overlap.GetPeers()
+		if err != nil {
+			continue
+		}
+
+		var mainPeers map[string]wireguard.PeerStatus
+		if mgr := s.getManager(network.ID); mgr != nil {
+			mainPeers, _ = mgr.GetPeers()
+		}
+
+		var migrated []string
+		for key, peer := range overlapPeers {
+			if current, ok := mainPeers[key]; ok && current.LatestHandshakeTime >= rotation.CreatedAt.Unix() {
+				migrated = append(migrated, key)
+				s.setOverlapRoutes(rotation.OverlapInterface, peer.AllowedIPs, false)
+				continue
+			}
+			s.setOverlapRoutes(rotation.OverlapInterface, peer.AllowedIPs, true)
+		}
+		if len(migrated) > 0 {
+			if err := overlap.RemovePeers(migrated); err != nil {
+				fmt.Printf("[KeyRotation] Warning: failed to remove migrated peers from %s: %v\n", rotation.OverlapInterface, err)
+			} else {
+				fmt.Printf("[KeyRotation] %d node(s) of %s migrated to the new key\n", len(migrated), network.Name)
+			}
+		}
+		if len(migrated) == len(overlapPeers) {
+			s.finishKeyRotation(ctx, rotation, "all nodes migrated")
+		}
+	}
+}
+
+// finishKeyRotation stops the overlap interface and completes the rotation
+func (s *Server) finishKeyRotation(ctx context.Context, rotation *models.KeyRotation, reason string) {
+	s.stopOverlapInterface(rotation)
+	if err := s.store.CompleteKeyRotation(ctx, rotation.ID); err != nil {
+		fmt.Printf("[KeyRotation] Failed to complete rotation %s: %v\n", rotation.ID, err)
+		return
+	}
+	fmt.Printf("[KeyRotation] Rotation %s completed: %s\n", rotation.ID, reason)
+}
+
+// rotateNodeKey switches a node to a public key it generated, keeping its ID,
+// addresses, labels and firewall rules. With a grace period the old key keeps
+// working until the node connects with the new one; otherwise the peer is
+// swapped immediately.
+func (s *Server) rotateNodeKey(ctx context.Context, node *models.Node, publicKey string, grace time.Duration) error {
+	// Keep the reconciler from seeing the new key before it is stored
+	s.reconcileMu.Lock()
+	defer s.reconcileMu.Unlock()
+
+	var stale []string // Keys to drop from the interface right away
+	if node.PreviousPublicKey != "" {
+		stale = append(stale, node.PreviousPublicKey) // A running grace period is cut short
+	}
+	now := time.Now()
+	oldKey := node.PublicKey
+	node.PublicKey = publicKey
+	node.KeyRotatedAt = &now
+	if grace > 0 && oldKey != "" && !nodeIsExpired(node) {
+		until := now.Add(grace)
+		node.PreviousPublicKey, node.PreviousKeyExpiresAt = oldKey, &until
+	} else {
+		node.PreviousPublicKey, node.PreviousKeyExpiresAt = "", nil
+		if oldKey != "" {
+			stale = append(stale, oldKey)
+		}
+	}
+	if err := s.store.UpdateNodeKey(ctx, node); err != nil {
+		return fmt.Errorf("failed to store new key: %w", err)
+	}
+
+	if mgr := s.getManager(node.NetworkID); mgr != nil {
+		if !nodeIsExpired(node) {
+			if err := mgr.AddPeers(nodeDesiredPeers(node)); err != nil {
+				fmt.Printf("[KeyRotation] Warning: failed to add new key of %s: %v\n", node.Name, err)
+			}
+		}
+		if err := mgr.RemovePeers(stale); err != nil {
+			fmt.Printf("[KeyRotation] Warning: failed to remove old key of %s: %v\n", node.Name, err)
+		}
+	}
+
+	payload := map[string]interface{}{
+		"public_key":  publicKey,
+		"config_path": fmt.Sprintf("/api/v1/nodes/%s/config", node.ID),
+	}
+	if node.PreviousKeyExpiresAt != nil {
+		payload["previous_key_expires_at"] = node.PreviousKeyExpiresAt
+	}
+	if err := s.store.CreateNodeEvent(ctx, &models.NodeEvent{
+		NodeID:  node.ID,
+		Type:    models.NodeEventNodeKeyRotated,
+		Payload: payload,
+	}); err != nil {
+		fmt.Printf("[KeyRotation] Warning: failed to queue event for %s: %v\n", node.Name, err)
+	}
+
+	fmt.Printf("[KeyRotation] Node %s: key rotated (%s -> %s)\n", node.Name, oldKey, publicKey)
+	return nil
+}
+
+// handleRotateNodeKey switches a node to the new public key it generated and
+// returns the new config. grace_minutes keeps the old key accepted meanwhile;
+// key_rotation_days (0 disables) schedules rotation requests to the node.
+func (s *Server) handleRotateNodeKey(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := s.authenticateNode(r, id); err != nil {
+		errorResponse(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	var req struct {
+		PublicKey       string `json:"public_key"` // Generated on the node
+		GraceMinutes    int    `json:"grace_minutes"`
+		KeyRotationDays *int   `json:"key_rotation_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if !wireguard.ValidKey(req.PublicKey) {
+		errorResponse(w, http.StatusBadRequest, "public_key of the node's new keypair is required")
+		return
+	}
+	if req.GraceMinutes < 0 || (req.KeyRotationDays != nil && *req.KeyRotationDays < 0) {
+		errorResponse(w, http.StatusBadRequest, "grace_minutes and key_rotation_days must not be negative")
+		return
+	}
+	// The rotation schedule is an admin setting; nodes only submit keys
+	if req.KeyRotationDays != nil && !isAdminRequest(r) {
+		errorResponse(w, http.StatusForbidden, "key_rotation_days requires admin credentials")
+		return
+	}
+
+	node, err := s.store.GetNode(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get node")
+		return
+	}
+	if node == nil {
+		errorResponse(w, http.StatusNotFound, "node not found")
+		return
+	}
+
+	if req.KeyRotationDays != nil {
+		node.KeyRotationDays = *req.KeyRotationDays
+		if err := s.store.UpdateNode(r.Context(), node); err != nil {
+			errorResponse(w, http.StatusInternalServerError, "failed to update node")
+			return
+		}
+	}
+
+	if req.PublicKey == node.PublicKey {
+		errorResponse(w, http.StatusBadRequest, "public_key is the node's current key")
+		return
+	}
+	if inUse, err := s.store.PublicKeyInUse(r.Context(), req.PublicKey); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to check public key")
+		return
+	} else if inUse {
+		errorResponse(w, http.StatusConflict, store.ErrPublicKeyInUse.Error())
+		return
+	}
+
+	if err := s.rotateNodeKey(r.Context(), node, req.PublicKey, time.Duration(req.GraceMinutes)*time.Minute); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to rotate node key: "+err.Error())
+		return
+	}
+
+	network, err := s.store.GetNetwork(r.Context(), node.NetworkID)
+	if err != nil || network == nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network for config generation")
+		return
+	}
+	serverEndpoint := network.ServerEndpoint
+	if serverEndpoint == "" {
+		port := network.ListenPort
+		if port == 0 {
+			port = wireguard.DefaultServerPort
+		}
+		serverEndpoint = fmt.Sprintf("%s:%d", wireguard.GetServerEndpoint(), port)
+	}
+
+	config := s.generateNodeConfig(r.Context(), node, network, wireguard.PrivateKeyPlaceholder, network.ServerPublicKey, serverEndpoint)
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"node":   node,
+		"config": config,
+	})
+}
+
+// requestScheduledNodeKeys asks nodes whose rotation interval has elapsed for
+// a new public key with a node_key_rotation_due event. The node answers through
+// POST /nodes/{id}/rotate-key; unanswered requests are repeated after
+// nodeKeyRotationRetry, as events are dropped once delivered.
+func (s *Server) requestScheduledNodeKeys(ctx context.Context) {
+	ids, err := s.store.ListNodesDueForKeyRotation(ctx, nodeKeyRotationRetry)
+	if err != nil {
+		fmt.Printf("[KeyRotation] Failed to list nodes due for rotation: %v\n", err)
+		return
+	}
+	for _, id := range ids {
+		node, err := s.store.GetNode(ctx, id)
+		if err != nil || node == nil {
+			continue
+		}
+		if err := s.store.CreateNodeEvent(ctx, &models.NodeEvent{
+			NodeID: node.ID,
+			Type:   models.NodeEventKeyRotationDue,
+			Payload: map[string]interface{}{
+				"public_key":  node.PublicKey,
+				"rotate_path": fmt.Sprintf("/api/v1/nodes/%s/rotate-key", node.ID),
+			},
+		}); err != nil {
+			fmt.Printf("[KeyRotation] Failed to request key rotation of %s: %v\n", node.Name, err)
+			continue
+		}
+		if err := s.store.MarkNodeKeyRotationRequested(ctx, node.ID); err != nil {
+			fmt.Printf("[KeyRotation] Warning: failed to mark key rotation of %s requested: %v\n", node.Name, err)
+		}
+		fmt.Printf("[KeyRotation] Node %s: key rotation due, new public key requested\n", node.Name)
+	}
+}
@@ -0,0 +1,280 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/controlplane/prober"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+const (
+	// ProbeMethodNone disables latency probing
+	ProbeMethodNone = "none"
+	// DefaultProbeRetention is how long probe results are kept by default
+	DefaultProbeRetention = 7 * 24 * time.Hour
+
+	// probeTick is how often the prober looks for networks due for a round
+	probeTick = 5 * time.Second
+	// probeConcurrency bounds the nodes probed at the same time
+	probeConcurrency = 32
+	// minProbeInterval and maxProbeInterval bound the per-network interval (seconds)
+	minProbeInterval = 5
+	maxProbeInterval = 3600
+	// onlineHandshakeWindow is how recent a handshake of an online node is
+	onlineHandshakeWindow = 150 * time.Second
+)
+
+// validateProbeInterval accepts 0 (disabled) or 5-3600 seconds
+func validateProbeInterval(seconds int) error {
+	if seconds != 0 && (seconds < minProbeInterval || seconds > maxProbeInterval) {
+		return fmt.Errorf("probe_interval must be 0 (disabled) or %d-%d seconds", minProbeInterval, maxProbeInterval)
+	}
+	return nil
+}
+
+// latestProbe returns the latest probe round of a node, nil if never probed
+func (s *Server) latestProbe(nodeID string) *models.NodeProbe {
+	s.latestProbesMu.RLock()
+	defer s.latestProbesMu.RUnlock()
+	return s.latestProbes[nodeID]
+}
+
+// runProber probes the online nodes of each network at the network's probe
+// interval and drops results older than the retention
+func (s *Server) runProber(method string) {
+	// Give DB a moment to come up
+	time.Sleep(2 * time.Second)
+
+	p, err := prober.New(method)
+	if err != nil {
+		fmt.Printf("[Probe] Latency probing disabled: %v\n", err)
+		return
+	}
+	fmt.Printf("[Probe] Probing node latency with %s\n", p.Describe())
+
+	ctx := context.Background()
+	if latest, err := s.store.LatestNodeProbes(ctx); err == nil {
+		s.latestProbesMu.Lock()
+		for _, probe := range latest {
+			s.latestProbes[probe.NodeID] = probe
+		}
+		s.latestProbesMu.Unlock()
+	}
+
+	lastRound := make(map[string]time.Time)
+	var lastCleanup time.Time
+	ticker := time.NewTicker(probeTick)
+	defer ticker.Stop()
+	for range ticker.C {
+		networks, err := s.store.ListNetworks(ctx)
+		if err != nil {
+			fmt.Printf("[Probe] Failed to list networks: %v\n", err)
+			continue
+		}
+		for _, network := range networks {
+			// Replies of nodes behind a remote gateway do not come back to the hub
+			if network.ProbeInterval <= 0 || network.InterfaceName == "" || network.RemoteHub || network.ActiveGatewayID != nil {
+				continue
+			}
+			if time.Since(lastRound[network.ID]) < time.Duration(network.ProbeInterval)*time.Second {
+				continue
+			}
+			lastRound[network.ID] = time.Now()
+			s.probeNetwork(ctx, p, network)
+		}
+
+		if time.Since(lastCleanup) > time.Hour {
+			lastCleanup = time.Now()
+			if n, err := s.store.DeleteNodeProbesBefore(ctx, time.Now().Add(-s.probeRetention)); err != nil {
+				fmt.Printf("[Probe] Failed to delete old probe results: %v\n", err)
+			} else if n > 0 {
+				fmt.Printf("[Probe] Deleted %d probe result(s) older than %s\n", n, s.probeRetention)
+			}
+		}
+	}
+}
+
+// probeNetwork runs one probe round against every online node of a network
+// over its tunnel
+func (s *Server) probeNetwork(ctx context.Context, p *prober.Prober, network *models.Network) {
+	peers := s.livePeers(network.ID)
+	if len(peers) == 0 {
+		return
+	}
+	nodes, err := s.store.ListNodes(ctx, network.ID)
+	if err != nil {
+		fmt.Printf("[Probe] Failed to list nodes of %s: %v\n", network.Name, err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, probeConcurrency)
+	for _, node := range nodes {
+		status, ok := peers[node.PublicKey]
+		if !ok || !recentHandshake(status) {
+			continue
+		}
+		if node.ExpiresAt != nil && time.Now().After(*node.ExpiresAt) {
+			continue
+		}
+		target := node.VirtualIP
+		if target == nil {
+			target = node.VirtualIP6
+		}
+		if target == nil {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(node *models.Node, target net.IP) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			s.recordProbe(ctx, node, target, p.Probe(ctx, target, prober.DefaultOptions))
+		}(node, target)
+	}
+	wg.Wait()
+}
+
+// recordProbe stores a probe round and makes it the node's latest
+func (s *Server) recordProbe(ctx context.Context, node *models.Node, target net.IP, result prober.Result) {
+	probe := &models.NodeProbe{
+		NodeID:      node.ID,
+		ProbedAt:    time.Now(),
+		Method:      result.Method,
+		Target:      target,
+		Sent:        result.Sent,
+		Received:    result.Received,
+		LossPercent: result.Loss(),
+	}
+	if avg, min, max, jitter, ok := result.Stats(); ok {
+		probe.RTTAvgMs, probe.RTTMinMs, probe.RTTMaxMs, probe.JitterMs = &avg, &min, &max, &jitter
+	}
+	if err := s.store.CreateNodeProbe(ctx, probe); err != nil {
+		fmt.Printf("[Probe] Failed to store probe of %s: %v\n", node.Name, err)
+	}
+
+	s.latestProbesMu.Lock()
+	s.latestProbes[node.ID] = probe
+	s.latestProbesMu.Unlock()
+}
+
+// recentHandshake reports whether a live peer handshook recently enough to
+// count as online
+func recentHandshake(status wireguard.PeerStatus) bool {
+	return status.LatestHandshakeTime > 0 &&
+		time.Since(time.Unix(status.LatestHandshakeTime, 0)) < onlineHandshakeWindow
+}
+
+// handleGetNodeLatency returns the probe rounds of a node since a time
+// (?since=24h or RFC 3339, default 24h) with averages over them
+func (s *Server) handleGetNodeLatency(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	node, err := s.store.GetNode(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get node")
+		return
+	}
+	if node == nil {
+		errorResponse(w, http.StatusNotFound, "node not found")
+		return
+	}
+
+	since := time.Now().Add(-24 * time.Hour)
+	if value := r.URL.Query().Get("since"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, value); err == nil {
+			since = t
+		} else {
+			errorResponse(w, http.StatusBadRequest, "since must be a duration (24h) or an RFC 3339 time")
+			return
+		}
+	}
+
+	probes, err := s.store.ListNodeProbes(r.Context(), id, since)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list probe results")
+		return
+	}
+
+	// Averages weighted by answered probes; loss over all probes sent
+	var sent, received, jitterRounds int
+	var rttSum, jitterSum float64
+	for _, probe := range probes {
+		sent += probe.Sent
+		received += probe.Received
+		if probe.RTTAvgMs != nil {
+			rttSum += *probe.RTTAvgMs * float64(probe.Received)
+		}
+		if probe.JitterMs != nil {
+			jitterSum += *probe.JitterMs
+			jitterRounds++
+		}
+	}
+	summary := map[string]interface{}{
+		"rounds":   len(probes),
+		"sent":     sent,
+		"received": received,
+	}
+	if sent > 0 {
+		summary["loss_percent"] = float64(sent-received) / float64(sent) * 100
+	}
+	if received > 0 {
+		summary["rtt_avg_ms"] = rttSum / float64(received)
+	}
+	if jitterRounds > 0 {
+		summary["jitter_avg_ms"] = jitterSum / float64(jitterRounds)
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"node_id": node.ID,
+		"since":   since,
+		"summary": summary,
+		"probes":  probes,
+	})
+}
+
+// handleUpdateNetworkProbing sets the latency probe interval of a network
+func (s *Server) handleUpdateNetworkProbing(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req struct {
+		Interval *int `json:"interval"` // Seconds, 0 disables probing
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Interval == nil {
+		errorResponse(w, http.StatusBadRequest, "interval is required")
+		return
+	}
+	if err := validateProbeInterval(*req.Interval); err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	network, err := s.store.GetNetwork(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+
+	if err := s.store.UpdateNetworkProbeInterval(r.Context(), id, *req.Interval); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to update probe interval")
+		return
+	}
+	network.ProbeInterval = *req.Interval
+	fmt.Printf("Probe interval of network %s set to %ds\n", network.Name, network.ProbeInterval)
+
+	jsonResponse(w, http.StatusOK, network)
+}
@@ -0,0 +1,256 @@
+package rest
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+// maxNodeEndpoints limits the endpoints a node may report on check-in
+const maxNodeEndpoints = 8
+
+// normalizeEndpoints validates host:port endpoints reported by a node and
+// returns them without duplicates, in the reported order
+func normalizeEndpoints(endpoints []string) ([]string, error) {
+	if len(endpoints) > maxNodeEndpoints {
+		return nil, fmt.Errorf("at most %d endpoints can be reported", maxNodeEndpoints)
+	}
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, endpoint := range endpoints {
+		host, portStr, err := net.SplitHostPort(strings.TrimSpace(endpoint))
+		if err != nil || host == "" {
+			return nil, fmt.Errorf("invalid endpoint %q: expected host:port", endpoint)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid endpoint %q: port must be 1-65535", endpoint)
+		}
+		endpoint = net.JoinHostPort(host, portStr)
+		if !seen[endpoint] {
+			seen[endpoint] = true
+			out = append(out, endpoint)
+		}
+	}
+	return out, nil
+}
+
+// meshRule is an enabled VPN firewall rule with resolved endpoints (nil = any)
+type meshRule struct {
+	accept  bool
+	sources []string
+	dests   []string
+}
+
+// meshRules resolves the enabled VPN firewall rules in priority order
+func (s *Server) meshRules(ctx context.Context) ([]meshRule, error) {
+	rules, err := s.store.ListVPNFirewallRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []meshRule
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		sources, err := s.resolveVPNRuleEndpoint(ctx, rule.SourceType, rule.SourceNetworkID, rule.SourceNodeID, rule.SourceIP)
+		if err != nil {
+			continue // Not applied on the hub either
+		}
+		dests, err := s.resolveVPNRuleEndpoint(ctx, rule.DestType, rule.DestNetworkID, rule.DestNodeID, rule.DestIP)
+		if err != nil {
+			continue
+		}
+		out = append(out, meshRule{accept: rule.Action == "accept", sources: sources, dests: dests})
+	}
+	return out, nil
+}
+
+// ruleEndpointMatches reports whether a resolved rule endpoint contains one of
+// the node's VPN addresses
+func ruleEndpointMatches(cidrs []string, node *models.Node) bool {
+	if cidrs == nil {
+		return true
+	}
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		for _, ip := range node.Addresses() {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// meshPairAllowed reports whether two nodes of a network may peer directly.
+// Traffic inside a network is accepted unless a drop or reject rule matches
+// first, in either direction; such pairs keep going through the hub, where the
+// rule is enforced. Protocol and port filters of rules are not considered.
+func meshPairAllowed(rules []meshRule, a, b *models.Node) bool {
+	for _, pair := range [][2]*models.Node{{a, b}, {b, a}} {
+		for _, rule := range rules {
+			if ruleEndpointMatches(rule.sources, pair[0]) && ruleEndpointMatches(rule.dests, pair[1]) {
+				if !rule.accept {
+					return false
+				}
+				break
+			}
+		}
+	}
+	return true
+}
+
+// meshPeers returns the nodes a node of a mesh network connects to directly.
+// A pair needs reported endpoints on both sides: a peer's addresses are only
+// routed to it when it can be dialed, and it only accepts our traffic when we
+// are its peer too. Other pairs keep reaching each other through the hub.
+func (s *Server) meshPeers(ctx context.Context, node *models.Node, network *models.Network) ([]*models.MeshPeer, error) {
+	peers := []*models.MeshPeer{}
+	if !network.Mesh || nodeIsExpired(node) {
+		return peers, nil
+	}
+	nodes, err := s.store.ListNodes(ctx, network.ID)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := s.meshRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, other := range nodes {
+		if other.ID == node.ID || nodeIsExpired(other) || !wireguard.ValidKey(other.PublicKey) {
+			continue
+		}
+		if len(node.Endpoints) == 0 || len(other.Endpoints) == 0 {
+			continue
+		}
+		if !meshPairAllowed(rules, node, other) {
+			continue
+		}
+		peers = append(peers, &models.MeshPeer{
+			NodeID:       other.ID,
+			Name:         other.Name,
+			PublicKey:    other.PublicKey,
+			PresharedKey: meshPairPSK(node, other),
+			Endpoint:     other.Endpoints[0],
+			Endpoints:    other.Endpoints,
+			AllowedIPs:   nodeHostRoutes(other),
+		})
+	}
+	return peers, nil
+}
+
+// meshPairPSK derives the preshared key of a direct pair from the preshared
+// keys the two nodes use with the hub, so both sides get the same key and a
+// pair is as protected as the nodes' hub tunnels. Empty if neither has one.
+func meshPairPSK(a, b *models.Node) string {
+	if a.PresharedKey == "" && b.PresharedKey == "" {
+		return ""
+	}
+	if a.ID > b.ID {
+		a, b = b, a
+	}
+	mac := hmac.New(sha256.New, []byte(a.PresharedKey+":"+b.PresharedKey))
+	mac.Write([]byte("novusgate-mesh:" + a.ID + ":" + b.ID))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// meshPeerConfigs converts mesh peers to [Peer] sections of a client config
+func meshPeerConfigs(peers []*models.MeshPeer, keepalive int) []wireguard.PeerConfig {
+	var configs []wireguard.PeerConfig
+	for _, peer := range peers {
+		configs = append(configs, wireguard.PeerConfig{
+			PublicKey:           peer.PublicKey,
+			AllowedIPs:          strings.Join(peer.AllowedIPs, ", "),
+			PresharedKey:        peer.PresharedKey,
+			Endpoint:            peer.Endpoint,
+			PersistentKeepalive: keepalive,
+		})
+	}
+	return configs
+}
+
+// handleUpdateNetworkMesh turns mesh mode of a network on or off
+func (s *Server) handleUpdateNetworkMesh(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Enabled == nil {
+		errorResponse(w, http.StatusBadRequest, "enabled is required")
+		return
+	}
+
+	network, err := s.store.GetNetwork(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+
+	if err := s.store.UpdateNetworkMesh(r.Context(), id, *req.Enabled); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to update mesh mode")
+		return
+	}
+	network.Mesh = *req.Enabled
+	fmt.Printf("Mesh mode of network %s set to %v\n", network.Name, network.Mesh)
+
+	jsonResponse(w, http.StatusOK, network)
+}
+
+// handleGetNodePeers returns the direct peers of a node, so agents can update
+// their interface when nodes join, leave or change endpoints
+func (s *Server) handleGetNodePeers(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if err := s.authenticateNode(r, id); err != nil {
+		errorResponse(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	node, err := s.store.GetNode(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get node")
+		return
+	}
+	if node == nil {
+		errorResponse(w, http.StatusNotFound, "node not found")
+		return
+	}
+
+	network, err := s.store.GetNetwork(r.Context(), node.NetworkID)
+	if err != nil || network == nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+
+	peers, err := s.meshPeers(r.Context(), node, network)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list peers")
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"mesh":                 network.Mesh,
+		"peers":                peers,
+		"persistent_keepalive": network.ClientConfig.Merge(node.ClientConfig).Keepalive(),
+	})
+}
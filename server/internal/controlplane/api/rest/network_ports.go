@@ -0,0 +1,261 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+// Defaults for the UDP ports and interface names given to new networks
+const (
+	DefaultPortRangeStart  = 51820
+	DefaultPortRangeEnd    = 51919
+	DefaultInterfacePrefix = "wg"
+)
+
+// networkPortRuleMarker prefixes the comment of the managed host firewall
+// rule that accepts a network's WireGuard port
+const networkPortRuleMarker = "novusgate-port-"
+
+// overlapPortRuleMarker prefixes the comment of the rule that keeps the old
+// port of a key rotation overlap open
+const overlapPortRuleMarker = "novusgate-port-old-"
+
+var (
+	interfacePrefixPattern    = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,9}$`)
+	interfaceNameIndexPattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]{0,9}[0-9]+$`)
+)
+
+// managedPort is a WireGuard port the host firewall must accept
+type managedPort struct {
+	comment       string
+	port          int
+	interfaceName string
+}
+
+// ParsePortRange parses a "start-end" UDP port range
+func ParsePortRange(value string) (start, end int, err error) {
+	parts := strings.SplitN(strings.TrimSpace(value), "-", 2)
+	start, err = strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err == nil {
+		end = start
+		if len(parts) == 2 {
+			end, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		}
+	}
+	if err != nil || start < 1 || end > 65535 || start > end {
+		return 0, 0, fmt.Errorf("invalid port range %q: must be start-end within 1-65535", value)
+	}
+	return start, end, nil
+}
+
+// ValidInterfacePrefix reports whether prefix leaves room for an index in
+// the 15 characters of an interface name
+func ValidInterfacePrefix(prefix string) bool {
+	return interfacePrefixPattern.MatchString(prefix)
+}
+
+// ValidInterfaceName reports whether name has the shape of a generated
+// interface name: a valid prefix followed by an index, at most 15 characters
+func ValidInterfaceName(name string) bool {
+	return len(name) <= 15 && interfaceNameIndexPattern.MatchString(name)
+}
+
+// NextInterfaceName returns prefix with the next index after the highest one
+// in use by the networks
+func NextInterfaceName(prefix string, networks []*models.Network) string {
+	maxIdx := -1
+	for _, n := range networks {
+		if !strings.HasPrefix(n.InterfaceName, prefix) {
+			continue
+		}
+		if idx, err := strconv.Atoi(strings.TrimPrefix(n.InterfaceName, prefix)); err == nil && idx > maxIdx {
+			maxIdx = idx
+		}
+	}
+	return fmt.Sprintf("%s%d", prefix, maxIdx+1)
+}
+
+// allocateInterfaceName returns the next interface name with the configured prefix
+func (s *Server) allocateInterfaceName(networks []*models.Network) string {
+	return NextInterfaceName(s.interfacePrefix, networks)
+}
+
+// allocateListenPort returns the lowest port of the configured range that no
+// network or key rotation overlap interface uses
+func (s *Server) allocateListenPort(ctx context.Context, networks []*models.Network) (int, error) {
+	used := s.overlapListenPorts(ctx)
+	for _, n := range networks {
+		if n.ListenPort > 0 {
+			used[n.ListenPort] = true
+		}
+	}
+	for port := s.portRangeStart; port <= s.portRangeEnd; port++ {
+		if !used[port] {
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no free UDP port left in %d-%d", s.portRangeStart, s.portRangeEnd)
+}
+
+// managedPorts lists the ports of networks with a local interface and of
+// running overlap interfaces
+func (s *Server) managedPorts(ctx context.Context) ([]managedPort, error) {
+	networks, err := s.store.ListNetworks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ports []managedPort
+	for _, n := range networks {
+		if n.InterfaceName == "" || n.RemoteHub {
+			continue
+		}
+		port := n.ListenPort
+		if port == 0 {
+			port = wireguard.DefaultServerPort
+		}
+		ports = append(ports, managedPort{comment: networkPortRuleMarker + n.ID, port: port, interfaceName: n.InterfaceName})
+	}
+
+	rotations, err := s.store.ListOverlappingKeyRotations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, rotation := range rotations {
+		ports = append(ports, managedPort{
+			comment:       overlapPortRuleMarker + rotation.NetworkID,
+			port:          rotation.OldListenPort,
+			interfaceName: rotation.OverlapInterface,
+		})
+	}
+	return ports, nil
+}
+
+// openHostPort accepts a UDP port in the host firewall under a managed
+// comment, replacing the rule previously opened with that comment
+func (s *Server) openHostPort(comment string, port int) error {
+	if s.wgBackend == wireguard.BackendSimulated {
+		return nil
+	}
+	if err := clearCommentedRules("iptables", "filter", "INPUT", comment); err != nil {
+		return err
+	}
+	if _, err := execHostCommand("iptables", "-A", "INPUT", "-p", "udp", "--dport", strconv.Itoa(port),
+		"-j", "ACCEPT", "-m", "comment", "--comment", comment); err != nil {
+		return fmt.Errorf("iptables command failed: %w", err)
+	}
+	execHostCommand("netfilter-persistent", "save")
+	return nil
+}
+
+// closeHostPort removes the managed rules with a comment
+func (s *Server) closeHostPort(comment string) error {
+	if s.wgBackend == wireguard.BackendSimulated {
+		return nil
+	}
+	if err := clearCommentedRules("iptables", "filter", "INPUT", comment); err != nil {
+		return err
+	}
+	execHostCommand("netfilter-persistent", "save")
+	return nil
+}
+
+// openNetworkPort accepts a network's WireGuard port in the host firewall,
+// replacing the rule opened for its previous port
+func (s *Server) openNetworkPort(network *models.Network) error {
+	return s.openHostPort(networkPortRuleMarker+network.ID, network.ListenPort)
+}
+
+// closeNetworkPort removes the rules of a deleted network's ports
+func (s *Server) closeNetworkPort(networkID string) error {
+	if err := s.closeHostPort(networkPortRuleMarker + networkID); err != nil {
+		return err
+	}
+	return s.closeHostPort(overlapPortRuleMarker + networkID)
+}
+
+// syncNetworkPorts replaces all managed port rules with the ports in the
+// database, dropping rules of networks deleted meanwhile
+func (s *Server) syncNetworkPorts(ctx context.Context) error {
+	if s.wgBackend == wireguard.BackendSimulated {
+		return nil
+	}
+	ports, err := s.managedPorts(ctx)
+	if err != nil {
+		return err
+	}
+	if err := clearCommentedRules("iptables", "filter", "INPUT", networkPortRuleMarker); err != nil {
+		return err
+	}
+	for _, p := range ports {
+		if _, err := execHostCommand("iptables", "-A", "INPUT", "-p", "udp", "--dport", strconv.Itoa(p.port),
+			"-j", "ACCEPT", "-m", "comment", "--comment", p.comment); err != nil {
+			return fmt.Errorf("iptables command failed: %w", err)
+		}
+	}
+	execHostCommand("netfilter-persistent", "save")
+	return nil
+}
+
+// ruleProtection decides which host firewall rules are protected: SSH, the
+// WireGuard ports of the networks and rules of WireGuard interfaces
+type ruleProtection struct {
+	ports           map[int]bool
+	interfaces      map[string]bool
+	interfacePrefix string
+}
+
+// ruleProtection reads the WireGuard ports and interfaces from the database
+func (s *Server) ruleProtection(ctx context.Context) ruleProtection {
+	p := ruleProtection{
+		ports:           make(map[int]bool),
+		interfaces:      make(map[string]bool),
+		interfacePrefix: s.interfacePrefix,
+	}
+	ports, err := s.managedPorts(ctx)
+	if err != nil {
+		fmt.Printf("Warning: failed to read WireGuard ports: %v\n", err)
+	}
+	for _, port := range ports {
+		p.ports[port.port] = true
+		p.interfaces[port.interfaceName] = true
+	}
+	return p
+}
+
+// coversPort reports whether a rule port ("51820", "51820:51830" or
+// "80,51820") includes a WireGuard port
+func (p ruleProtection) coversPort(rulePort string) bool {
+	for _, part := range strings.Split(rulePort, ",") {
+		bounds := strings.SplitN(part, ":", 2)
+		low, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			continue
+		}
+		high := low
+		if len(bounds) == 2 {
+			if high, err = strconv.Atoi(bounds[1]); err != nil {
+				continue
+			}
+		}
+		for port := range p.ports {
+			if port >= low && port <= high {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isWireGuardInterface matches interface names and iptables wildcards (wg+)
+func (p ruleProtection) isWireGuardInterface(name string) bool {
+	if name == "" {
+		return false
+	}
+	return p.interfaces[name] || strings.HasPrefix(name, p.interfacePrefix)
+}
@@ -0,0 +1,141 @@
+package rest
+
+import (
+	"testing"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		value     string
+		wantStart int
+		wantEnd   int
+		wantErr   bool
+	}{
+		{value: "51820-51900", wantStart: 51820, wantEnd: 51900},
+		{value: " 51820 - 51900 ", wantStart: 51820, wantEnd: 51900},
+		{value: "51820", wantStart: 51820, wantEnd: 51820},
+		{value: "1-65535", wantStart: 1, wantEnd: 65535},
+		{value: "0-100", wantErr: true},
+		{value: "51820-65536", wantErr: true},
+		{value: "51900-51820", wantErr: true},
+		{value: "-51820", wantErr: true},
+		{value: "51820-", wantErr: true},
+		{value: "51820:51900", wantErr: true},
+		{value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			start, end, err := ParsePortRange(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParsePortRange(%q) = %d, %d, want an error", tt.value, start, end)
+				}
+				return
+			}
+			if err != nil || start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("ParsePortRange(%q) = %d, %d, %v, want %d, %d", tt.value, start, end, err, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestCoversPort(t *testing.T) {
+	p := ruleProtection{ports: map[int]bool{51820: true, 51825: true}}
+	tests := []struct {
+		rulePort string
+		want     bool
+	}{
+		{rulePort: "51820", want: true},
+		{rulePort: "51821"},
+		{rulePort: "51821:51830", want: true},
+		{rulePort: "51800:51819"},
+		{rulePort: "80,443,51825", want: true},
+		{rulePort: "80,443"},
+		{rulePort: "1:65535", want: true},
+		{rulePort: "http,51820", want: true},
+		{rulePort: "51820:x"},
+		{rulePort: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rulePort, func(t *testing.T) {
+			if got := p.coversPort(tt.rulePort); got != tt.want {
+				t.Errorf("coversPort(%q) = %v, want %v", tt.rulePort, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsWireGuardInterface(t *testing.T) {
+	p := ruleProtection{interfaces: map[string]bool{"office0": true}, interfacePrefix: "wg"}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "office0", want: true},
+		{name: "wg0", want: true},
+		{name: "wg+", want: true},
+		{name: "eth0"},
+		{name: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.isWireGuardInterface(tt.name); got != tt.want {
+				t.Errorf("isWireGuardInterface(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextInterfaceName(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		used   []string
+		want   string
+	}{
+		{name: "no networks", prefix: "wg", want: "wg0"},
+		{name: "after the highest index", prefix: "wg", used: []string{"wg0", "wg3", "wg1"}, want: "wg4"},
+		{name: "other prefixes are ignored", prefix: "wg", used: []string{"office7", "wgx", "wg2"}, want: "wg3"},
+		{name: "custom prefix", prefix: "ng", used: []string{"wg5"}, want: "ng0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var networks []*models.Network
+			for _, name := range tt.used {
+				networks = append(networks, &models.Network{InterfaceName: name})
+			}
+			if got := NextInterfaceName(tt.prefix, networks); got != tt.want {
+				t.Errorf("NextInterfaceName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidInterfaceName(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "wg0", want: true},
+		{name: "novusgate_12", want: true},
+		{name: "abcdefghij12345", want: true},
+		{name: "abcdefghij123456"},
+		{name: "wg"},
+		{name: "0wg1"},
+		{name: "wg 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidInterfaceName(tt.name); got != tt.want {
+				t.Errorf("ValidInterfaceName(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,522 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/controlplane/store"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/shared/netutil"
+)
+
+// parseNetworkCIDRs validates the address ranges of a network: cidr may be
+// IPv4 or IPv6, the optional cidr6 makes an IPv4 network dual-stack
+func parseNetworkCIDRs(cidr, cidr6 string) ([]*net.IPNet, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR format: %v", err)
+	}
+	nets := []*net.IPNet{ipNet}
+
+	if cidr6 != "" {
+		if netutil.IsIPv6(cidr) {
+			return nil, fmt.Errorf("cidr6 can only be set when cidr is an IPv4 range")
+		}
+		_, ipNet6, err := net.ParseCIDR(cidr6)
+		if err != nil || ipNet6.IP.To4() != nil {
+			return nil, fmt.Errorf("invalid cidr6: must be an IPv6 CIDR")
+		}
+		nets = append(nets, ipNet6)
+	}
+	return nets, nil
+}
+
+// cidrConflict returns an error when one of the candidate ranges overlaps
+// another network (except excludeID) or an approved routed subnet
+func (s *Server) cidrConflict(ctx context.Context, existing []*models.Network, candidates []*net.IPNet, excludeID string) error {
+	for _, existingNet := range existing {
+		if existingNet.ID == excludeID {
+			continue
+		}
+		for _, cidr := range existingNet.CIDRs() {
+			_, existingCIDR, err := net.ParseCIDR(cidr)
+			if err != nil {
+				continue // Skip invalid existing CIDRs
+			}
+			for _, candidate := range candidates {
+				if networksOverlap(candidate, existingCIDR) {
+					return fmt.Errorf("CIDR %s overlaps with existing network '%s' (%s)",
+						candidate.String(), existingNet.Name, cidr)
+				}
+			}
+		}
+	}
+
+	// Approved subnets of subnet routers are taken too
+	if routes, err := s.store.ListSubnetRoutes(ctx, ""); err == nil {
+		for _, route := range routes {
+			_, routeNet, err := net.ParseCIDR(route.CIDR)
+			if err != nil || !route.Approved {
+				continue
+			}
+			for _, candidate := range candidates {
+				if networksOverlap(candidate, routeNet) {
+					return fmt.Errorf("CIDR %s overlaps with subnet %s routed by '%s'",
+						candidate.String(), route.CIDR, route.NodeName)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// planRenumber maps one address family of a network's nodes from oldCIDR to
+// newCIDR. Nodes keep their host part where it fits in the new range and is
+// not excluded; the others get the lowest free addresses outside reservations.
+// An empty newCIDR drops the family.
+func planRenumber(nodes []*models.Node, address func(*models.Node) net.IP, oldCIDR, newCIDR string, reservations []*models.IPReservation) (map[string]net.IP, error) {
+	plan := make(map[string]net.IP, len(nodes))
+	if newCIDR == "" {
+		for _, node := range nodes {
+			plan[node.ID] = nil
+		}
+		return plan, nil
+	}
+
+	_, newNet, err := net.ParseCIDR(newCIDR)
+	if err != nil {
+		return nil, err
+	}
+	var oldNet *net.IPNet
+	if oldCIDR != "" {
+		_, oldNet, _ = net.ParseCIDR(oldCIDR)
+	}
+	first, _ := netutil.NodeRange(newNet)
+
+	used := make(map[string]bool)
+	var pending []*models.Node
+	for _, node := range nodes {
+		var candidate net.IP
+		if ip := address(node); ip != nil && oldNet != nil {
+			candidate = netutil.RemapIP(ip, oldNet, newNet)
+		}
+		if candidate == nil || store.CheckNodeAddress(newNet, candidate, used, reservations) != nil {
+			pending = append(pending, node)
+			continue
+		}
+		plan[node.ID] = candidate
+		used[candidate.String()] = true
+	}
+
+	next := first
+	for _, node := range pending {
+		if next = store.NextFreeIP(newNet, next, used, reservations); next == nil {
+			return nil, fmt.Errorf("%s has no room for the %d nodes of the network", newCIDR, len(nodes))
+		}
+		plan[node.ID] = next
+		used[next.String()] = true
+	}
+	return plan, nil
+}
+
+// remapRuleAddress moves a custom VPN firewall rule address (IP or CIDR) into
+// the new ranges of a renumbered network; node addresses follow their node
+// and a rule for a whole old range covers the whole new one. moved is false
+// for addresses outside the old ranges, including prefixes wider than them,
+// ok is false when the address does not fit the new range.
+func remapRuleAddress(value string, hosts map[string]net.IP, ranges map[*net.IPNet]*net.IPNet) (remapped string, moved, ok bool) {
+	if ip := net.ParseIP(value); ip != nil {
+		value = netutil.HostCIDR(ip)
+	}
+	ip, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return "", false, false
+	}
+	ones, bits := ipNet.Mask.Size()
+	for from, to := range ranges {
+		fromOnes, _ := from.Mask.Size()
+		if !from.Contains(ip) || ones < fromOnes {
+			continue
+		}
+		if newIP, found := hosts[ip.String()]; ones == bits && found && newIP != nil {
+			return netutil.HostCIDR(newIP), true, true
+		}
+		toOnes, _ := to.Mask.Size()
+		if ones == fromOnes {
+			return to.String(), true, true
+		}
+		if newIP := netutil.RemapIP(ipNet.IP, from, to); newIP != nil && ones >= toOnes {
+			return fmt.Sprintf("%s/%d", newIP.String(), ones), true, true
+		}
+		return "", true, false
+	}
+	return "", false, false
+}
+
+// addressInRanges reports whether ip lies in one of the CIDRs
+func addressInRanges(ip net.IP, cidrs []string) bool {
+	for _, cidr := range cidrs {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseServerEndpoint validates a host or host:port endpoint; a missing port
+// is filled in with port
+func parseServerEndpoint(value string, port int) (string, error) {
+	value = strings.TrimSpace(value)
+	if ip := net.ParseIP(value); ip != nil || (value != "" && !strings.Contains(value, ":")) {
+		return net.JoinHostPort(value, strconv.Itoa(port)), nil
+	}
+	host, portStr, err := net.SplitHostPort(value)
+	if err != nil || host == "" {
+		return "", fmt.Errorf("server_endpoint must be a host or host:port")
+	}
+	if p, err := strconv.Atoi(portStr); err != nil || p < 1 || p > 65535 {
+		return "", fmt.Errorf("server_endpoint port must be 1-65535")
+	}
+	return net.JoinHostPort(host, portStr), nil
+}
+
+// handleUpdateNetwork changes a network's name, endpoint, listen port or
+// address ranges. A range change renumbers every node; with dry_run the
+// renumber plan is returned without applying anything. Nodes whose client
+// config changed are listed in redistribute and get a network_updated event.
+func (s *Server) handleUpdateNetwork(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	ctx := r.Context()
+
+	var req struct {
+		Name           *string `json:"name"`
+		ServerEndpoint *string `json:"server_endpoint"` // host or host:port
+		ListenPort     *int    `json:"listen_port"`
+		CIDR           *string `json:"cidr"`
+		CIDR6          *string `json:"cidr6"` // Empty removes the IPv6 range
+		DryRun         bool    `json:"dry_run"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	network, err := s.store.GetNetwork(ctx, id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get network")
+		return
+	}
+	if network == nil {
+		errorResponse(w, http.StatusNotFound, "network not found")
+		return
+	}
+	networks, err := s.store.ListNetworks(ctx)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list networks")
+		return
+	}
+
+	updated := *network
+	if req.Name != nil {
+		updated.Name = strings.TrimSpace(*req.Name)
+		if updated.Name == "" {
+			errorResponse(w, http.StatusBadRequest, "name must not be empty")
+			return
+		}
+		for _, other := range networks {
+			if other.ID != network.ID && other.Name == updated.Name {
+				errorResponse(w, http.StatusConflict, "a network with this name already exists")
+				return
+			}
+		}
+	}
+
+	if req.ListenPort != nil && *req.ListenPort != network.ListenPort {
+		if *req.ListenPort < 1 || *req.ListenPort > 65535 {
+			errorResponse(w, http.StatusBadRequest, "listen_port must be between 1 and 65535")
+			return
+		}
+		usedPorts := s.overlapListenPorts(ctx)
+		for _, other := range networks {
+			if other.ID != network.ID {
+				usedPorts[other.ListenPort] = true
+			}
+		}
+		if usedPorts[*req.ListenPort] {
+			errorResponse(w, http.StatusConflict, fmt.Sprintf("port %d is already used by another interface", *req.ListenPort))
+			return
+		}
+		updated.ListenPort = *req.ListenPort
+		// An endpoint on the old port follows it; a forwarded port is kept
+		if _, port, err := net.SplitHostPort(network.ServerEndpoint); err == nil && port == strconv.Itoa(network.ListenPort) {
+			updated.ServerEndpoint = endpointWithPort(network.ServerEndpoint, updated.ListenPort)
+		}
+	}
+	if req.ServerEndpoint != nil {
+		endpoint, err := parseServerEndpoint(*req.ServerEndpoint, updated.ListenPort)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		updated.ServerEndpoint = endpoint
+	}
+
+	if req.CIDR != nil {
+		updated.CIDR = strings.TrimSpace(*req.CIDR)
+	}
+	if req.CIDR6 != nil {
+		updated.CIDR6 = strings.TrimSpace(*req.CIDR6)
+	}
+	rangesChanged := updated.CIDR != network.CIDR || updated.CIDR6 != network.CIDR6
+	portChanged := updated.ListenPort != network.ListenPort
+	endpointChanged := updated.ServerEndpoint != network.ServerEndpoint
+
+	if rangesChanged {
+		newNets, err := parseNetworkCIDRs(updated.CIDR, updated.CIDR6)
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := s.cidrConflict(ctx, networks, newNets, network.ID); err != nil {
+			errorResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+		if updated.Egress.BlockDNSLeaks || updated.Egress.Mode != models.EgressDisabled {
+			if err := validateEgress(&updated, &updated.Egress); err != nil {
+				errorResponse(w, http.StatusBadRequest, "egress settings do not fit the new ranges: "+err.Error())
+				return
+			}
+		}
+	}
+
+	// The overlap interface of a key rotation serves the old port and addresses
+	if rangesChanged || portChanged {
+		overlapping, err := s.store.ListOverlappingKeyRotations(ctx)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "failed to list key rotations")
+			return
+		}
+		for _, rotation := range overlapping {
+			if rotation.NetworkID == network.ID {
+				errorResponse(w, http.StatusConflict, "a key rotation is in its overlap window; wait for it to complete")
+				return
+			}
+		}
+	}
+
+	nodes, err := s.store.ListNodes(ctx, network.ID)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list nodes")
+		return
+	}
+	reservations, err := s.store.ListIPReservations(ctx, network.ID)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list IP reservations")
+		return
+	}
+	if rangesChanged {
+		for _, reservation := range reservations {
+			if !addressInRanges(reservation.Start, updated.CIDRs()) || !addressInRanges(reservation.End, updated.CIDRs()) {
+				errorResponse(w, http.StatusConflict, fmt.Sprintf(
+					"IP reservation %s-%s is outside the new ranges; delete it first", reservation.Start, reservation.End))
+				return
+			}
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return bytes.Compare(nodes[i].VirtualIP.To16(), nodes[j].VirtualIP.To16()) < 0
+	})
+
+	// Renumber plan: every node whose addresses change
+	changes := []*models.NodeAddressChange{}
+	if rangesChanged {
+		plan4, plan6 := map[string]net.IP{}, map[string]net.IP{}
+		if updated.CIDR != network.CIDR {
+			if plan4, err = planRenumber(nodes, func(n *models.Node) net.IP { return n.VirtualIP }, network.CIDR, updated.CIDR, reservations); err != nil {
+				errorResponse(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		if updated.CIDR6 != network.CIDR6 {
+			if plan6, err = planRenumber(nodes, func(n *models.Node) net.IP { return n.VirtualIP6 }, network.CIDR6, updated.CIDR6, reservations); err != nil {
+				errorResponse(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		for _, node := range nodes {
+			newIP, ok := plan4[node.ID]
+			if !ok {
+				newIP = node.VirtualIP
+			}
+			newIP6, ok := plan6[node.ID]
+			if !ok {
+				newIP6 = node.VirtualIP6
+			}
+			if newIP.Equal(node.VirtualIP) && newIP6.Equal(node.VirtualIP6) {
+				continue
+			}
+			changes = append(changes, &models.NodeAddressChange{
+				NodeID:   node.ID,
+				NodeName: node.Name,
+				OldIP:    node.VirtualIP,
+				NewIP:    newIP,
+				OldIP6:   node.VirtualIP6,
+				NewIP6:   newIP6,
+			})
+		}
+	}
+
+	// Range and endpoint changes end up in every client config
+	redistribute := []map[string]interface{}{}
+	if rangesChanged || endpointChanged {
+		for _, node := range nodes {
+			redistribute = append(redistribute, map[string]interface{}{"id": node.ID, "name": node.Name})
+		}
+	}
+
+	if req.DryRun {
+		jsonResponse(w, http.StatusOK, map[string]interface{}{
+			"network":      updated,
+			"dry_run":      true,
+			"renumber":     changes,
+			"redistribute": redistribute,
+		})
+		return
+	}
+
+	var payload map[string]interface{}
+	if len(redistribute) > 0 {
+		payload = map[string]interface{}{
+			"network_id":      network.ID,
+			"server_endpoint": updated.ServerEndpoint,
+			"renumbered":      len(changes) > 0,
+		}
+	}
+	if err := s.store.UpdateNetworkSettings(ctx, &updated, changes, payload); err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			errorResponse(w, http.StatusConflict, "network name or node address already in use")
+			return
+		}
+		errorResponse(w, http.StatusInternalServerError, "failed to update network: "+err.Error())
+		return
+	}
+	fmt.Printf("Network %s updated (renumbered %d node(s))\n", updated.Name, len(changes))
+
+	var warnings []string
+	if rangesChanged {
+		warnings = append(warnings, s.remapFirewallRules(ctx, network, &updated, changes)...)
+	}
+
+	// Apply the new port and addresses to the interface, then the peers
+	if rangesChanged || portChanged {
+		if mgr := s.getManager(network.ID); mgr != nil {
+			serverAddrs, _ := netutil.ServerAddresses(updated.CIDRs()...)
+			if err := mgr.CreateServerConfigWithKey(updated.ServerPrivateKey, strings.Join(serverAddrs, ", "), updated.ListenPort); err != nil {
+				warnings = append(warnings, fmt.Sprintf("failed to reconfigure %s: %v", updated.InterfaceName, err))
+			}
+			result := s.reconcile(ctx, "network_updated", network.ID)
+			warnings = append(warnings, result.Errors...)
+		}
+	}
+	if portChanged && !updated.RemoteHub {
+		if err := s.openNetworkPort(&updated); err != nil {
+			warnings = append(warnings, fmt.Sprintf("failed to open port %d in the host firewall: %v", updated.ListenPort, err))
+		}
+	}
+	if rangesChanged {
+		if err := s.syncVPNFirewallRules(ctx); err != nil {
+			warnings = append(warnings, fmt.Sprintf("failed to apply VPN firewall rules: %v", err))
+		}
+		if err := s.syncEgressRules(ctx); err != nil {
+			warnings = append(warnings, fmt.Sprintf("failed to apply egress rules: %v", err))
+		}
+	}
+	for _, warning := range warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+
+	s.store.CreateFirewallAuditLog(ctx, "network_updated", map[string]interface{}{
+		"network_id":  network.ID,
+		"name":        updated.Name,
+		"endpoint":    updated.ServerEndpoint,
+		"listen_port": updated.ListenPort,
+		"cidrs":       updated.CIDRs(),
+		"renumbered":  len(changes),
+	}, r.RemoteAddr)
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"network":      updated,
+		"renumber":     changes,
+		"redistribute": redistribute,
+		"warnings":     warnings,
+	})
+}
+
+// remapFirewallRules moves the custom addresses of VPN firewall rules that
+// point into a renumbered network. It returns warnings for rules that could
+// not be moved.
+func (s *Server) remapFirewallRules(ctx context.Context, old, updated *models.Network, changes []*models.NodeAddressChange) []string {
+	hosts := make(map[string]net.IP)
+	for _, change := range changes {
+		if change.OldIP != nil {
+			hosts[change.OldIP.String()] = change.NewIP
+		}
+		if change.OldIP6 != nil {
+			hosts[change.OldIP6.String()] = change.NewIP6
+		}
+	}
+	ranges := make(map[*net.IPNet]*net.IPNet)
+	for _, pair := range [][2]string{{old.CIDR, updated.CIDR}, {old.CIDR6, updated.CIDR6}} {
+		if pair[0] == "" || pair[1] == "" || pair[0] == pair[1] {
+			continue
+		}
+		_, from, err1 := net.ParseCIDR(pair[0])
+		_, to, err2 := net.ParseCIDR(pair[1])
+		if err1 == nil && err2 == nil {
+			ranges[from] = to
+		}
+	}
+
+	rules, err := s.store.ListVPNFirewallRules(ctx)
+	if err != nil {
+		return []string{fmt.Sprintf("failed to list VPN firewall rules: %v", err)}
+	}
+	var warnings []string
+	for _, rule := range rules {
+		changed := false
+		for _, endpoint := range []struct {
+			kind    string
+			address *string
+		}{{rule.SourceType, &rule.SourceIP}, {rule.DestType, &rule.DestIP}} {
+			if endpoint.kind != "custom" {
+				continue // Network and node endpoints are resolved on every sync
+			}
+			address := endpoint.address
+			remapped, moved, ok := remapRuleAddress(*address, hosts, ranges)
+			if !moved {
+				continue
+			}
+			if !ok {
+				warnings = append(warnings, fmt.Sprintf("VPN firewall rule %s: %s does not fit the new range", rule.Name, *address))
+				continue
+			}
+			*address = remapped
+			changed = true
+		}
+		if !changed {
+			continue
+		}
+		if err := s.store.UpdateVPNFirewallRule(ctx, rule); err != nil {
+			warnings = append(warnings, fmt.Sprintf("failed to update VPN firewall rule %s: %v", rule.Name, err))
+		}
+	}
+	return warnings
+}
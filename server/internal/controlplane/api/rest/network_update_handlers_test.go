@@ -0,0 +1,189 @@
+package rest
+
+import (
+	"net"
+	"testing"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+func mustCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ipNet
+}
+
+func TestPlanRenumber(t *testing.T) {
+	nodes := func(ips ...string) []*models.Node {
+		var list []*models.Node
+		for i, ip := range ips {
+			list = append(list, &models.Node{ID: string(rune('a' + i)), VirtualIP: net.ParseIP(ip)})
+		}
+		return list
+	}
+	tests := []struct {
+		name         string
+		nodes        []*models.Node
+		oldCIDR      string
+		newCIDR      string
+		reservations []*models.IPReservation
+		want         map[string]string
+		wantErr      bool
+	}{
+		{
+			name:    "host parts are kept",
+			nodes:   nodes("10.0.0.2", "10.0.0.50"),
+			oldCIDR: "10.0.0.0/24",
+			newCIDR: "10.5.0.0/16",
+			want:    map[string]string{"a": "10.5.0.2", "b": "10.5.0.50"},
+		},
+		{
+			name:    "host parts that do not fit get the lowest free addresses",
+			nodes:   nodes("10.0.0.2", "10.0.0.200", "10.0.0.3"),
+			oldCIDR: "10.0.0.0/24",
+			newCIDR: "10.5.0.0/25",
+			want:    map[string]string{"a": "10.5.0.2", "b": "10.5.0.4", "c": "10.5.0.3"},
+		},
+		{
+			name:    "broadcast address of a smaller range moves",
+			nodes:   nodes("10.0.0.127"),
+			oldCIDR: "10.0.0.0/24",
+			newCIDR: "10.5.0.0/25",
+			want:    map[string]string{"a": "10.5.0.2"},
+		},
+		{
+			name:         "excluded addresses move, reserved ones are kept",
+			nodes:        nodes("10.0.0.10", "10.0.0.20"),
+			oldCIDR:      "10.0.0.0/24",
+			newCIDR:      "10.5.0.0/24",
+			reservations: []*models.IPReservation{{Start: net.ParseIP("10.5.0.2"), End: net.ParseIP("10.5.0.10"), Kind: models.IPReservationReserved}, {Start: net.ParseIP("10.5.0.20"), End: net.ParseIP("10.5.0.20"), Kind: models.IPReservationExcluded}},
+			want:         map[string]string{"a": "10.5.0.10", "b": "10.5.0.11"},
+		},
+		{
+			name:    "new family gets addresses in node order",
+			nodes:   nodes("10.0.0.2", "10.0.0.3"),
+			newCIDR: "fd00::/64",
+			want:    map[string]string{"a": "fd00::2", "b": "fd00::3"},
+		},
+		{
+			name:    "dropped family",
+			nodes:   nodes("10.0.0.2"),
+			oldCIDR: "10.0.0.0/24",
+			want:    map[string]string{"a": ""},
+		},
+		{
+			name:    "smallest range that fits",
+			nodes:   nodes("10.0.0.2", "10.0.0.3", "10.0.0.4"),
+			oldCIDR: "10.0.0.0/24",
+			newCIDR: "10.5.0.0/29",
+			want:    map[string]string{"a": "10.5.0.2", "b": "10.5.0.3", "c": "10.5.0.4"},
+		},
+		{
+			name:    "no room left",
+			nodes:   nodes("10.0.0.2", "10.0.0.3"),
+			oldCIDR: "10.0.0.0/24",
+			newCIDR: "10.5.0.0/30",
+			wantErr: true,
+		},
+		{
+			name:    "invalid range",
+			nodes:   nodes("10.0.0.2"),
+			oldCIDR: "10.0.0.0/24",
+			newCIDR: "10.5.0.0",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planRenumber(tt.nodes, func(n *models.Node) net.IP { return n.VirtualIP }, tt.oldCIDR, tt.newCIDR, tt.reservations)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("planRenumber() = %v, want an error", plan)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("planRenumber() error = %v", err)
+			}
+			if len(plan) != len(tt.want) {
+				t.Fatalf("planRenumber() = %v, want %v", plan, tt.want)
+			}
+			for id, want := range tt.want {
+				ip, found := plan[id]
+				got := ""
+				if ip != nil {
+					got = ip.String()
+				}
+				if !found || got != want {
+					t.Errorf("node %s: got %q, want %q", id, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRemapRuleAddress(t *testing.T) {
+	ranges := map[*net.IPNet]*net.IPNet{
+		mustCIDR(t, "10.0.0.0/24"): mustCIDR(t, "10.5.0.0/16"),
+		mustCIDR(t, "fd00::/64"):   mustCIDR(t, "fd05::/64"),
+	}
+	hosts := map[string]net.IP{
+		"10.0.0.7": net.ParseIP("10.5.0.2"),
+		"fd00::7":  net.ParseIP("fd05::2"),
+	}
+	tests := []struct {
+		value     string
+		want      string
+		wantMoved bool
+		wantOK    bool
+	}{
+		{value: "10.0.0.7", want: "10.5.0.2/32", wantMoved: true, wantOK: true},
+		{value: "10.0.0.7/32", want: "10.5.0.2/32", wantMoved: true, wantOK: true},
+		{value: "10.0.0.8", want: "10.5.0.8/32", wantMoved: true, wantOK: true},
+		{value: "10.0.0.128/25", want: "10.5.0.128/25", wantMoved: true, wantOK: true},
+		{value: "10.0.0.0/24", want: "10.5.0.0/16", wantMoved: true, wantOK: true},
+		{value: "fd00::7", want: "fd05::2/128", wantMoved: true, wantOK: true},
+		{value: "fd00::8/127", want: "fd05::8/127", wantMoved: true, wantOK: true},
+		{value: "192.168.1.0/24"},
+		{value: "10.0.0.0/16"},
+		{value: "not-an-address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, moved, ok := remapRuleAddress(tt.value, hosts, ranges)
+			if got != tt.want || moved != tt.wantMoved || ok != tt.wantOK {
+				t.Errorf("remapRuleAddress(%q) = %q, %v, %v, want %q, %v, %v", tt.value, got, moved, ok, tt.want, tt.wantMoved, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRemapRuleAddressToSmallerRange(t *testing.T) {
+	ranges := map[*net.IPNet]*net.IPNet{mustCIDR(t, "10.0.0.0/24"): mustCIDR(t, "10.5.0.0/25")}
+	tests := []struct {
+		value     string
+		want      string
+		wantMoved bool
+		wantOK    bool
+	}{
+		{value: "10.0.0.100", want: "10.5.0.100/32", wantMoved: true, wantOK: true},
+		{value: "10.0.0.200", wantMoved: true},
+		{value: "10.0.0.0/24", want: "10.5.0.0/25", wantMoved: true, wantOK: true},
+		{value: "10.0.0.128/25", wantMoved: true},
+		{value: "10.0.0.64/26", want: "10.5.0.64/26", wantMoved: true, wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, moved, ok := remapRuleAddress(tt.value, nil, ranges)
+			if got != tt.want || moved != tt.wantMoved || ok != tt.wantOK {
+				t.Errorf("remapRuleAddress(%q) = %q, %v, %v, want %q, %v, %v", tt.value, got, moved, ok, tt.want, tt.wantMoved, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package rest
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+const (
+	// nodeTokenHeader and nodeIDHeader carry the node token and ID in
+	// text/plain enrollment responses
+	nodeTokenHeader = "X-Node-Token"
+	nodeIDHeader    = "X-Node-Id"
+	// nodeTokenFile is where the node scripts keep the node token
+	nodeTokenFile = "/etc/novusgate/node.token"
+)
+
+// nodeAgentEndpoints are the node endpoints a node calls for itself; they take
+// the node's own token as well as the admin token and API key
+var nodeAgentEndpoints = map[string]bool{
+	"peers":      true,
+	"gateways":   true,
+	"checkin":    true,
+	"rotate-key": true,
+}
+
+// isNodeAgentPath reports whether a request goes to a node agent endpoint
+func isNodeAgentPath(path string) bool {
+	rest := strings.TrimPrefix(path, "/api/v1/nodes/")
+	if rest == path {
+		return false
+	}
+	parts := strings.Split(rest, "/")
+	return len(parts) == 2 && parts[0] != "" && nodeAgentEndpoints[parts[1]]
+}
+
+// newNodeToken returns a random node agent token and the hash that is stored
+func newNodeToken() (token, tokenHash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = hex.EncodeToString(buf)
+	return token, hashNodeToken(token), nil
+}
+
+// hashNodeToken returns the stored form of a node agent token
+func hashNodeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// isAdminRequest reports whether a request carries the admin token and, when
+// one is configured, the API key
+func isAdminRequest(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		return false
+	}
+	return apiKey == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-API-Key")), []byte(apiKey)) == 1
+}
+
+// authenticateNode checks that a request to a node agent endpoint comes from
+// an admin or carries the token of node id
+func (s *Server) authenticateNode(r *http.Request, id string) error {
+	if isAdminRequest(r) {
+		return nil
+	}
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return fmt.Errorf("missing node token")
+	}
+	node, err := s.store.GetNodeByTokenHash(r.Context(), hashNodeToken(strings.TrimPrefix(auth, "Bearer ")))
+	if err != nil {
+		return err
+	}
+	if node == nil || node.ID != id {
+		return fmt.Errorf("invalid node token")
+	}
+	return nil
+}
+
+// handleIssueNodeToken replaces a node's agent token and returns the new one.
+// Earlier tokens of the node stop working.
+func (s *Server) handleIssueNodeToken(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	node, err := s.store.GetNode(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get node")
+		return
+	}
+	if node == nil {
+		errorResponse(w, http.StatusNotFound, "node not found")
+		return
+	}
+
+	token, tokenHash, err := newNodeToken()
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to generate token")
+		return
+	}
+	if err := s.store.SetNodeTokenHash(r.Context(), id, tokenHash); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to store token")
+		return
+	}
+	fmt.Printf("[Nodes] New agent token issued for node %s\n", node.Name)
+
+	s.store.CreateFirewallAuditLog(r.Context(), "node_token_issued", map[string]interface{}{
+		"node_id":    node.ID,
+		"name":       node.Name,
+		"network_id": node.NetworkID,
+	}, r.RemoteAddr)
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"node":  node,
+		"token": token,
+	})
+}
+
+// nodeAgentStep renders the script step that installs the node agent: a
+// systemd timer checking in hourly with the node token, which answers
+// node_key_rotation_due events with a new keypair generated on the host.
+// nodeID may be a shell expression.
+func nodeAgentStep(apiURL, nodeID string) string {
+	return fmt.Sprintf(`cat <<EOF > %[3]s/agent.env
+API_URL=%[1]s
+NODE_ID=%[2]s
+EOF
+cat <<'EOF' > /usr/local/sbin/novusgate-agent
+#!/bin/bash
+# Checks in with novusgate and rotates the WireGuard key when asked to
+set -e
+. %[3]s/agent.env
+NODE_TOKEN=$(cat %[4]s)
+RESPONSE=$(curl -fsS -X POST "$API_URL/nodes/$NODE_ID/checkin" \
+  -H "Content-Type: application/json" \
+  -H "Authorization: Bearer $NODE_TOKEN" \
+  -d '{}')
+if echo "$RESPONSE" | grep -q '"%[5]s"'; then
+    umask 077
+    NEW_KEY=$(wg genkey)
+    NEW_PUBLIC_KEY=$(echo "$NEW_KEY" | wg pubkey)
+    curl -fsS -X POST "$API_URL/nodes/$NODE_ID/rotate-key" \
+      -H "Content-Type: application/json" \
+      -H "Authorization: Bearer $NODE_TOKEN" \
+      -d "{\"public_key\": \"$NEW_PUBLIC_KEY\", \"grace_minutes\": 10}" > /dev/null
+    echo "$NEW_KEY" > /etc/wireguard/wg0.key
+    sed -i "s|^PrivateKey *=.*|PrivateKey = $NEW_KEY|" /etc/wireguard/wg0.conf
+    wg set wg0 private-key /etc/wireguard/wg0.key
+    echo "WireGuard key rotated"
+fi
+EOF
+chmod 700 /usr/local/sbin/novusgate-agent
+cat <<EOF > /etc/systemd/system/novusgate-agent.service
+[Unit]
+Description=novusgate node check-in
+After=network-online.target wg-quick@wg0.service
+
+[Service]
+Type=oneshot
+ExecStart=/usr/local/sbin/novusgate-agent
+EOF
+cat <<EOF > /etc/systemd/system/novusgate-agent.timer
+[Unit]
+Description=Hourly novusgate node check-in
+
+[Timer]
+OnBootSec=5min
+OnUnitActiveSec=1h
+
+[Install]
+WantedBy=timers.target
+EOF
+systemctl daemon-reload
+systemctl enable --now novusgate-agent.timer
+`, apiURL, nodeID, path.Dir(nodeTokenFile), nodeTokenFile, models.NodeEventKeyRotationDue)
+}
@@ -0,0 +1,514 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/novusgate/novusgate/internal/controlplane/wgimport"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/shared/netutil"
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+// Policies for live peers that don't belong to any node in the database
+const (
+	UnknownPeerImport = "import" // Create a node for the peer like a config import
+	UnknownPeerRemove = "remove" // Remove the peer from the interface
+	UnknownPeerReport = "report" // Leave the peer alone and only report it
+)
+
+// Drift kinds detected by the reconciler
+const (
+	DriftMissing    = "missing"       // Node in DB but no live peer
+	DriftAllowedIPs = "allowed_ips"   // Live peer has the wrong AllowedIPs
+	DriftExpired    = "expired"       // Live peer belongs to an expired node
+	DriftUnknown    = "unknown"       // Live peer matches no node
+	DriftRotatedKey = "rotated_key"   // Live peer uses a node key that was rotated out
+	DriftPSK        = "preshared_key" // Live peer has a different or no preshared key
+)
+
+// DefaultReconcileInterval is used when no interval is configured
+const DefaultReconcileInterval = 30 * time.Second
+
+// ValidUnknownPeerPolicy reports whether p is a supported unknown peer policy
+func ValidUnknownPeerPolicy(p string) bool {
+	switch p {
+	case UnknownPeerImport, UnknownPeerRemove, UnknownPeerReport:
+		return true
+	}
+	return false
+}
+
+// ReconcileDrift is one difference between the database and a live interface
+type ReconcileDrift struct {
+	NetworkID   string `json:"network_id"`
+	NetworkName string `json:"network_name"`
+	Kind        string `json:"kind"`
+	PublicKey   string `json:"public_key"`
+	NodeID      string `json:"node_id,omitempty"`
+	NodeName    string `json:"node_name,omitempty"`
+	Expected    string `json:"expected,omitempty"`
+	Actual      string `json:"actual,omitempty"`
+	Action      string `json:"action"` // added, updated, removed, imported, reported, failed
+	Error       string `json:"error,omitempty"`
+}
+
+// ReconcileResult summarizes one reconciliation run
+type ReconcileResult struct {
+	Trigger           string           `json:"trigger"` // startup, scheduled, manual, routes
+	StartedAt         time.Time        `json:"started_at"`
+	FinishedAt        time.Time        `json:"finished_at"`
+	DurationMs        int64            `json:"duration_ms"`
+	UnknownPeerPolicy string           `json:"unknown_peer_policy"`
+	Networks          int              `json:"networks"`
+	Added             int              `json:"added"`
+	Updated           int              `json:"updated"`
+	Removed           int              `json:"removed"`
+	Imported          int              `json:"imported"`
+	Reported          int              `json:"reported"`
+	Drift             []ReconcileDrift `json:"drift"`
+	Errors            []string         `json:"errors"`
+}
+
+func newReconcileResult(trigger, policy string) *ReconcileResult {
+	return &ReconcileResult{
+		Trigger:           trigger,
+		StartedAt:         time.Now(),
+		UnknownPeerPolicy: policy,
+		Drift:             []ReconcileDrift{},
+		Errors:            []string{},
+	}
+}
+
+func (r *ReconcileResult) finish() {
+	r.FinishedAt = time.Now()
+	r.DurationMs = r.FinishedAt.Sub(r.StartedAt).Milliseconds()
+}
+
+// record adds a drift entry and updates the counters
+func (r *ReconcileResult) record(d ReconcileDrift) {
+	switch d.Action {
+	case "added":
+		r.Added++
+	case "updated":
+		r.Updated++
+	case "removed":
+		r.Removed++
+	case "imported":
+		r.Imported++
+	case "reported":
+		r.Reported++
+	}
+	r.Drift = append(r.Drift, d)
+}
+
+// nodePeerConfig returns the hub-side peer configuration a node should have
+func nodePeerConfig(node *models.Node) wireguard.PeerConfig {
+	// Approved LAN subnets of a subnet router are routed to it as well
+	allowedIPs := append(nodeHostRoutes(node), node.ApprovedRoutes...)
+	// An exit node's replies come from anywhere on the internet
+	allowedIPs = append(allowedIPs, exitNodeAllowedIPs(node)...)
+	return wireguard.PeerConfig{
+		PublicKey:    node.PublicKey,
+		AllowedIPs:   strings.Join(allowedIPs, ","),
+		PresharedKey: node.PresharedKey,
+	}
+}
+
+// nodeHostRoutes returns the single-host prefixes of all VPN addresses of a
+// node (/32 for IPv4, /128 for IPv6)
+func nodeHostRoutes(node *models.Node) []string {
+	var routes []string
+	for _, ip := range node.Addresses() {
+		routes = append(routes, netutil.HostCIDR(ip))
+	}
+	return routes
+}
+
+// nodeDesiredPeers returns the hub-side peers of a node. During a key rotation
+// grace period the old key keeps the node's addresses and the new key is
+// configured without AllowedIPs, so it can complete a handshake and take over.
+func nodeDesiredPeers(node *models.Node) []wireguard.PeerConfig {
+	want := nodePeerConfig(node)
+	if node.PreviousPublicKey == "" {
+		return []wireguard.PeerConfig{want}
+	}
+	old := want
+	old.PublicKey = node.PreviousPublicKey
+	want.AllowedIPs = ""
+	return []wireguard.PeerConfig{old, want}
+}
+
+// nodeKeyGraceOver reports whether a node's previous key can be dropped: the
+// grace period ended or the new key completed a handshake since the rotation
+func nodeKeyGraceOver(node *models.Node, live map[string]wireguard.PeerStatus) bool {
+	if node.PreviousKeyExpiresAt == nil || time.Now().After(*node.PreviousKeyExpiresAt) {
+		return true
+	}
+	peer, ok := live[node.PublicKey]
+	if !ok || peer.LatestHandshakeTime == 0 {
+		return false
+	}
+	return node.KeyRotatedAt == nil || peer.LatestHandshakeTime >= node.KeyRotatedAt.Unix()
+}
+
+// nodeIsExpired reports whether a node must not have a live peer
+func nodeIsExpired(node *models.Node) bool {
+	if node.Status == models.NodeStatusExpired {
+		return true
+	}
+	return node.ExpiresAt != nil && !node.ExpiresAt.IsZero() && time.Now().After(*node.ExpiresAt)
+}
+
+// runReconciler reconciles all networks every interval
+func (s *Server) runReconciler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		result := s.reconcile(context.Background(), "scheduled", "")
+		if changed := result.Added + result.Updated + result.Removed + result.Imported; changed > 0 || len(result.Errors) > 0 {
+			fmt.Printf("[Reconcile] %d added, %d updated, %d removed, %d imported, %d errors\n",
+				result.Added, result.Updated, result.Removed, result.Imported, len(result.Errors))
+		}
+	}
+}
+
+// reconcile diffs desired peers (from the DB) against live peers and fixes
+// the interfaces. If networkID is set only that network is reconciled. Runs are
+// serialized, and the result of a run over all networks is kept as the last run.
+func (s *Server) reconcile(ctx context.Context, trigger, networkID string) *ReconcileResult {
+	s.reconcileMu.Lock()
+	defer s.reconcileMu.Unlock()
+
+	result := newReconcileResult(trigger, s.unknownPeerPolicy)
+	defer func() {
+		result.finish()
+		// A single network run would hide the drift of all other networks
+		if networkID != "" {
+			return
+		}
+		s.lastReconcileMu.Lock()
+		s.lastReconcile = result
+		s.lastReconcileMu.Unlock()
+	}()
+
+	var networks []*models.Network
+	if networkID != "" {
+		network, err := s.store.GetNetwork(ctx, networkID)
+		if err != nil || network == nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("network %s not found", networkID))
+			return result
+		}
+		networks = []*models.Network{network}
+	} else {
+		var err error
+		networks, err = s.store.ListNetworks(ctx)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("failed to list networks: %v", err))
+			return result
+		}
+	}
+
+	for _, network := range networks {
+		if network.InterfaceName == "" || network.RemoteHub {
+			continue // Gateway agents keep remote hub peers in sync
+		}
+		result.Networks++
+		if err := s.reconcileNetwork(ctx, network, result); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", network.Name, err))
+		}
+	}
+	return result
+}
+
+func (s *Server) reconcileNetwork(ctx context.Context, network *models.Network, result *ReconcileResult) error {
+	mgr := s.getManager(network.ID)
+	if mgr == nil {
+		return fmt.Errorf("WireGuard manager not available")
+	}
+
+	live, err := mgr.GetPeers()
+	if err != nil {
+		return fmt.Errorf("failed to read live peers: %w", err)
+	}
+	nodes, err := s.store.ListNodes(ctx, network.ID)
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	drift := func(kind, key string) ReconcileDrift {
+		return ReconcileDrift{NetworkID: network.ID, NetworkName: network.Name, Kind: kind, PublicKey: key}
+	}
+
+	var toApply []wireguard.PeerConfig
+	var applyDrift []ReconcileDrift
+	var toRemove []string
+	var removeDrift []ReconcileDrift
+
+	known := make(map[string]bool)
+	names := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		names[node.Name] = true
+		if node.PublicKey == "" {
+			continue
+		}
+		known[node.PublicKey] = true
+
+		// A rotated node keeps its old key until the new one is in use
+		if node.PreviousPublicKey != "" {
+			if nodeKeyGraceOver(node, live) {
+				if err := s.store.ClearNodePreviousKey(ctx, node.ID); err != nil {
+					result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", node.Name, err))
+				}
+				if _, ok := live[node.PreviousPublicKey]; ok {
+					d := drift(DriftRotatedKey, node.PreviousPublicKey)
+					d.NodeID, d.NodeName = node.ID, node.Name
+					d.Action = "removed"
+					toRemove = append(toRemove, node.PreviousPublicKey)
+					removeDrift = append(removeDrift, d)
+				}
+				node.PreviousPublicKey = ""
+			} else {
+				known[node.PreviousPublicKey] = true
+			}
+		}
+
+		if nodeIsExpired(node) {
+			for _, key := range []string{node.PublicKey, node.PreviousPublicKey} {
+				if _, isLive := live[key]; key != "" && isLive {
+					d := drift(DriftExpired, key)
+					d.NodeID, d.NodeName = node.ID, node.Name
+					d.Action = "removed"
+					toRemove = append(toRemove, key)
+					removeDrift = append(removeDrift, d)
+				}
+			}
+			continue
+		}
+
+		for _, want := range nodeDesiredPeers(node) {
+			peer, isLive := live[want.PublicKey]
+			if !isLive {
+				d := drift(DriftMissing, want.PublicKey)
+				d.NodeID, d.NodeName = node.ID, node.Name
+				d.Expected = want.AllowedIPs
+				d.Action = "added"
+				toApply = append(toApply, want)
+				applyDrift = append(applyDrift, d)
+				continue
+			}
+
+			update := false
+			if want.AllowedIPs != "" && !sameAllowedIPs(peer.AllowedIPs, want.AllowedIPs) {
+				d := drift(DriftAllowedIPs, want.PublicKey)
+				d.NodeID, d.NodeName = node.ID, node.Name
+				d.Expected, d.Actual = want.AllowedIPs, peer.AllowedIPs
+				d.Action = "updated"
+				applyDrift = append(applyDrift, d)
+				update = true
+			}
+			// Keys are secret, so the drift only says whether one is set
+			if peer.PresharedKey != want.PresharedKey {
+				d := drift(DriftPSK, want.PublicKey)
+				d.NodeID, d.NodeName = node.ID, node.Name
+				d.Expected, d.Actual = pskState(want.PresharedKey), pskState(peer.PresharedKey)
+				d.Action = "updated"
+				applyDrift = append(applyDrift, d)
+				want.ClearPresharedKey = want.PresharedKey == ""
+				update = true
+			}
+			if update {
+				toApply = append(toApply, want)
+			}
+		}
+	}
+
+	for key, peer := range live {
+		if known[key] {
+			continue
+		}
+		d := drift(DriftUnknown, key)
+		d.Actual = peer.AllowedIPs
+
+		switch s.unknownPeerPolicy {
+		case UnknownPeerRemove:
+			d.Action = "removed"
+			toRemove = append(toRemove, key)
+			removeDrift = append(removeDrift, d)
+		case UnknownPeerImport:
+			node, err := s.importPeer(ctx, network, peer, names)
+			if err != nil {
+				d.Action, d.Error = "failed", err.Error()
+			} else {
+				d.Action = "imported"
+				d.NodeID, d.NodeName = node.ID, node.Name
+			}
+			result.record(d)
+		default:
+			d.Action = "reported"
+			result.record(d)
+		}
+	}
+
+	// Apply changes in two batches
+	applyErr := mgr.AddPeers(toApply)
+	for _, d := range applyDrift {
+		if applyErr != nil {
+			d.Action, d.Error = "failed", applyErr.Error()
+		}
+		result.record(d)
+	}
+	removeErr := mgr.RemovePeers(toRemove)
+	for _, d := range removeDrift {
+		if removeErr != nil {
+			d.Action, d.Error = "failed", removeErr.Error()
+		}
+		result.record(d)
+	}
+
+	s.syncSubnetRoutes(network, nodes)
+
+	if applyErr != nil {
+		return applyErr
+	}
+	return removeErr
+}
+
+// importPeer creates a node for a live peer that has no database record the
+// way a config import does: its AllowedIPs in the network's ranges become its
+// addresses (checked against used and excluded ones), other subnets approved
+// routes, and it keeps its preshared key. names holds the taken node names.
+func (s *Server) importPeer(ctx context.Context, network *models.Network, peer wireguard.PeerStatus, names map[string]bool) (*models.Node, error) {
+	_, primary, err := net.ParseCIDR(network.CIDR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network CIDR: %w", err)
+	}
+	var secondary *net.IPNet
+	if network.CIDR6 != "" {
+		_, secondary, _ = net.ParseCIDR(network.CIDR6)
+	}
+
+	node, warnings := wgimport.PeerNode(wireguard.PeerConfig{
+		PublicKey:    peer.PublicKey,
+		PresharedKey: peer.PresharedKey,
+		AllowedIPs:   peer.AllowedIPs,
+	}, primary, secondary)
+	for _, warning := range warnings {
+		fmt.Printf("[Reconcile] Import warning: %s\n", warning)
+	}
+	if node.VirtualIP == nil {
+		return nil, fmt.Errorf("AllowedIPs %q have no address in %s", peer.AllowedIPs, primary)
+	}
+	node.NetworkID = network.ID
+	node.Name = wgimport.UniqueName(names, wgimport.PeerName("", node, 0))
+	node.NodeInfo = &models.NodeInfo{Hostname: node.Name, OS: "unknown", Architecture: "unknown"}
+
+	// Routes that clash with other networks stay advertised for an admin to review
+	var routeNets []*net.IPNet
+	for _, route := range node.ApprovedRoutes {
+		if _, ipNet, err := net.ParseCIDR(route); err == nil {
+			routeNets = append(routeNets, ipNet)
+		}
+	}
+	if len(routeNets) > 0 {
+		networks, err := s.store.ListNetworks(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list networks: %w", err)
+		}
+		if err := s.cidrConflict(ctx, networks, routeNets, network.ID); err != nil {
+			fmt.Printf("[Reconcile] Routes of %s left unapproved: %v\n", node.Name, err)
+			node.ApprovedRoutes = nil
+		}
+	}
+
+	if err := s.store.CreateNodeWithAddresses(ctx, node, node.VirtualIP, node.VirtualIP6); err != nil {
+		delete(names, node.Name)
+		return nil, fmt.Errorf("failed to create node: %w", err)
+	}
+	if len(node.AdvertisedRoutes) > 0 {
+		if err := s.store.UpdateNodeRoutes(ctx, node.ID, node.AdvertisedRoutes, node.ApprovedRoutes); err != nil {
+			fmt.Printf("[Reconcile] Warning: failed to store routes of %s: %v\n", node.Name, err)
+		}
+	}
+	fmt.Printf("[Reconcile] Imported peer %s as %s\n", peer.PublicKey, node.Name)
+	return node, nil
+}
+
+// pskState describes a preshared key in drift entries without revealing it
+func pskState(key string) string {
+	if key == "" {
+		return "none"
+	}
+	return "set"
+}
+
+// sameAllowedIPs compares two comma separated AllowedIPs lists ignoring order and spacing
+func sameAllowedIPs(a, b string) bool {
+	normalize := func(s string) string {
+		var parts []string
+		for _, p := range strings.Split(s, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				parts = append(parts, p)
+			}
+		}
+		sort.Strings(parts)
+		return strings.Join(parts, ",")
+	}
+	return normalize(a) == normalize(b)
+}
+
+// handleGetReconcileStatus returns the last reconciliation result
+func (s *Server) handleGetReconcileStatus(w http.ResponseWriter, r *http.Request) {
+	s.lastReconcileMu.RLock()
+	last := s.lastReconcile
+	s.lastReconcileMu.RUnlock()
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"interval_seconds":    int64(s.reconcileInterval.Seconds()),
+		"unknown_peer_policy": s.unknownPeerPolicy,
+		"last_run":            last,
+	})
+}
+
+// handleGetReconcileDrift returns the drift found by the last run, optionally
+// filtered by network_id and kind
+func (s *Server) handleGetReconcileDrift(w http.ResponseWriter, r *http.Request) {
+	s.lastReconcileMu.RLock()
+	last := s.lastReconcile
+	s.lastReconcileMu.RUnlock()
+
+	networkID := r.URL.Query().Get("network_id")
+	kind := r.URL.Query().Get("kind")
+
+	drift := []ReconcileDrift{}
+	var checkedAt *time.Time
+	if last != nil {
+		checkedAt = &last.FinishedAt
+		for _, d := range last.Drift {
+			if networkID != "" && d.NetworkID != networkID {
+				continue
+			}
+			if kind != "" && d.Kind != kind {
+				continue
+			}
+			drift = append(drift, d)
+		}
+	}
+
+	jsonResponse(w, http.StatusOK, map[string]interface{}{
+		"checked_at": checkedAt,
+		"drift":      drift,
+	})
+}
+
+// handleRunReconcile runs a reconciliation immediately (?network_id= limits it to one network)
+func (s *Server) handleRunReconcile(w http.ResponseWriter, r *http.Request) {
+	result := s.reconcile(r.Context(), "manual", r.URL.Query().Get("network_id"))
+	jsonResponse(w, http.StatusOK, result)
+}
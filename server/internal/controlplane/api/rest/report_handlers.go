@@ -0,0 +1,433 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/controlplane/reports"
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+const (
+	// reportTopTalkers is how many nodes are listed in the top talkers section
+	reportTopTalkers = 10
+	// reportCheckInterval is how often the scheduler looks for missing reports
+	reportCheckInterval = time.Hour
+	// trafficSampleInterval is how often per-node transfer counters are sampled
+	trafficSampleInterval = 15 * time.Minute
+	// trafficSampleRetention is how long traffic samples are kept; it covers
+	// regenerating the monthly reports of the past year
+	trafficSampleRetention = 400 * 24 * time.Hour
+	// fail2banLogTimeLayout is the timestamp prefix of fail2ban log lines
+	fail2banLogTimeLayout = "2006-01-02 15:04:05,000"
+)
+
+var safeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// runReportScheduler generates the last completed period's report for every
+// network that doesn't have one yet. It checks hourly, so reports missed while
+// the server was down are produced after restart.
+func (s *Server) runReportScheduler(schedule string) {
+	// Let loadNetworks finish first
+	time.Sleep(time.Minute)
+
+	for {
+		s.generateScheduledReports(context.Background(), schedule)
+		time.Sleep(reportCheckInterval)
+	}
+}
+
+// runTrafficSampler records the transfer counters of every network's nodes
+// so reports can compute bandwidth within their own period, even when they
+// are generated long after it ended
+func (s *Server) runTrafficSampler() {
+	// Let loadNetworks finish first
+	time.Sleep(time.Minute)
+
+	ctx := context.Background()
+	var lastCleanup time.Time
+	for {
+		networks, err := s.store.ListNetworks(ctx)
+		if err != nil {
+			fmt.Printf("[Reports] Failed to list networks: %v\n", err)
+		}
+		for _, network := range networks {
+			if err := s.sampleTraffic(ctx, network); err != nil {
+				fmt.Printf("[Reports] Failed to sample traffic of %s: %v\n", network.Name, err)
+			}
+		}
+
+		if time.Since(lastCleanup) > 24*time.Hour {
+			lastCleanup = time.Now()
+			if n, err := s.store.DeleteTrafficSamplesBefore(ctx, time.Now().Add(-trafficSampleRetention)); err != nil {
+				fmt.Printf("[Reports] Failed to delete old traffic samples: %v\n", err)
+			} else if n > 0 {
+				fmt.Printf("[Reports] Deleted %d traffic sample(s) older than %s\n", n, trafficSampleRetention)
+			}
+		}
+		time.Sleep(trafficSampleInterval)
+	}
+}
+
+// sampleTraffic stores the current transfer counters of a network's nodes
+func (s *Server) sampleTraffic(ctx context.Context, network *models.Network) error {
+	peers := s.livePeers(network.ID)
+	if len(peers) == 0 {
+		return nil
+	}
+	nodes, err := s.store.ListNodes(ctx, network.ID)
+	if err != nil {
+		return err
+	}
+
+	counters := make(map[string]models.ReportCounter)
+	for _, node := range nodes {
+		if peer, ok := peers[node.PublicKey]; ok {
+			counters[node.ID] = models.ReportCounter{Rx: peer.TransferRx, Tx: peer.TransferTx}
+		}
+	}
+	return s.store.CreateTrafficSamples(ctx, network.ID, time.Now(), counters)
+}
+
+func (s *Server) generateScheduledReports(ctx context.Context, schedule string) {
+	start, end, err := reports.LastCompletedPeriod(schedule, time.Now())
+	if err != nil {
+		fmt.Printf("[Reports] %v\n", err)
+		return
+	}
+
+	networks, err := s.store.ListNetworks(ctx)
+	if err != nil {
+		fmt.Printf("[Reports] Failed to list networks: %v\n", err)
+		return
+	}
+
+	for _, network := range networks {
+		exists, err := s.store.ReportExists(ctx, network.ID, start, end)
+		if err != nil || exists {
+			continue
+		}
+		if _, err := s.generateReport(ctx, network, schedule, start, end); err != nil {
+			fmt.Printf("[Reports] Failed to generate %s report for %s: %v\n", schedule, network.Name, err)
+			continue
+		}
+		fmt.Printf("[Reports] Generated %s report for %s (%s - %s)\n", schedule, network.Name,
+			start.Format("2006-01-02"), end.Format("2006-01-02"))
+	}
+}
+
+// generateReport collects report data for a network and period and stores it.
+//
+// Bandwidth figures come from the traffic samples taken within the period, so
+// they are accurate to one sample interval however late the report is made.
+func (s *Server) generateReport(ctx context.Context, network *models.Network, period string, start, end time.Time) (*models.Report, error) {
+	nodes, err := s.store.ListNodes(ctx, network.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	previous, err := s.store.GetLatestReport(ctx, network.ID, start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load previous report: %w", err)
+	}
+
+	// Count traffic up to now in a report for the running period
+	if end.After(time.Now()) {
+		if err := s.sampleTraffic(ctx, network); err != nil {
+			return nil, fmt.Errorf("failed to sample traffic: %w", err)
+		}
+	}
+	traffic, err := s.store.NodeTrafficBetween(ctx, network.ID, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load traffic samples: %w", err)
+	}
+
+	peers := s.livePeers(network.ID)
+
+	data := &models.ReportData{
+		NetworkCIDR: network.CIDR,
+		Nodes: models.ReportNodeSummary{
+			ByStatus: make(map[string]int),
+			Created:  []models.ReportNode{},
+			Expired:  []models.ReportNode{},
+		},
+		TopTalkers:      []models.ReportTalker{},
+		FirewallChanges: []models.ReportFirewallChange{},
+	}
+
+	previousTotal := 0
+	for _, node := range nodes {
+		if !node.CreatedAt.Before(end) {
+			continue // Created after the period
+		}
+		data.Nodes.Total++
+		if node.CreatedAt.Before(start) {
+			previousTotal++
+		}
+
+		s.enrichNode(node, peers)
+		data.Nodes.ByStatus[string(node.Status)]++
+
+		ref := models.ReportNode{
+			ID:        node.ID,
+			Name:      node.Name,
+			VirtualIP: node.VirtualIP.String(),
+			CreatedAt: node.CreatedAt,
+			ExpiresAt: node.ExpiresAt,
+		}
+		if !node.CreatedAt.Before(start) {
+			data.Nodes.Created = append(data.Nodes.Created, ref)
+		}
+		if node.ExpiresAt != nil && !node.ExpiresAt.Before(start) && node.ExpiresAt.Before(end) {
+			data.Nodes.Expired = append(data.Nodes.Expired, ref)
+		}
+
+		rx, tx := traffic[node.ID].Rx, traffic[node.ID].Tx
+		if rx+tx > 0 {
+			data.TopTalkers = append(data.TopTalkers, models.ReportTalker{
+				NodeID:    node.ID,
+				Name:      node.Name,
+				VirtualIP: node.VirtualIP.String(),
+				RxBytes:   rx,
+				TxBytes:   tx,
+				Total:     rx + tx,
+			})
+		}
+	}
+
+	// Nodes deleted during the period are only visible through the previous report
+	if previous != nil && previous.Data != nil {
+		previousTotal = previous.Data.Nodes.Total
+	}
+	data.Nodes.PreviousTotal = previousTotal
+	data.Nodes.Change = data.Nodes.Total - previousTotal
+
+	sort.Slice(data.TopTalkers, func(i, j int) bool {
+		return data.TopTalkers[i].Total > data.TopTalkers[j].Total
+	})
+	if len(data.TopTalkers) > reportTopTalkers {
+		data.TopTalkers = data.TopTalkers[:reportTopTalkers]
+	}
+
+	data.Bans = s.collectBanStats(start, end)
+
+	auditLogs, err := s.store.ListFirewallAuditLogs(ctx, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list firewall audit log: %w", err)
+	}
+	for _, entry := range auditLogs {
+		data.FirewallChanges = append(data.FirewallChanges, models.ReportFirewallChange{
+			Time:    entry.CreatedAt,
+			Action:  entry.Action,
+			Details: entry.Details,
+			UserIP:  entry.UserIP,
+		})
+	}
+
+	report := &models.Report{
+		NetworkID:   network.ID,
+		NetworkName: network.Name,
+		Period:      period,
+		PeriodStart: start,
+		PeriodEnd:   end,
+		Data:        data,
+	}
+	if err := s.store.SaveReport(ctx, report); err != nil {
+		return nil, fmt.Errorf("failed to save report: %w", err)
+	}
+	return report, nil
+}
+
+// collectBanStats aggregates fail2ban ban/unban log entries within [start, end)
+func (s *Server) collectBanStats(start, end time.Time) models.ReportBanSummary {
+	stats := models.ReportBanSummary{
+		ByJail: make(map[string]int),
+	}
+	if s.geo.Enabled() {
+		stats.ByCountry = make(map[string]int)
+	}
+
+	uniqueIPs := make(map[string]bool)
+	for _, entry := range s.readFail2BanHistory("", 100000) {
+		ts, err := time.ParseInLocation(fail2banLogTimeLayout, entry["timestamp"], time.Local)
+		if err != nil || ts.Before(start) || !ts.Before(end) {
+			continue
+		}
+
+		switch entry["action"] {
+		case "ban":
+			stats.Bans++
+			stats.ByJail[entry["jail"]]++
+			if ip := entry["ip"]; ip != "" {
+				uniqueIPs[ip] = true
+			}
+			if stats.ByCountry != nil {
+				country := entry["country_code"]
+				if country == "" {
+					country = "unknown"
+				}
+				stats.ByCountry[country]++
+			}
+		case "unban":
+			stats.Unbans++
+		}
+	}
+	stats.UniqueIPs = len(uniqueIPs)
+	return stats
+}
+
+// handleListReports lists generated reports, optionally filtered by network_id
+func (s *Server) handleListReports(w http.ResponseWriter, r *http.Request) {
+	list, err := s.store.ListReports(r.Context(), r.URL.Query().Get("network_id"))
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list reports")
+		return
+	}
+	if list == nil {
+		list = []*models.Report{}
+	}
+	jsonResponse(w, http.StatusOK, list)
+}
+
+// handleGenerateReport generates reports on demand.
+// Without network_id a report is generated for every network. Without explicit
+// start/end the last completed period of the requested schedule is used.
+func (s *Server) handleGenerateReport(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		NetworkID   string     `json:"network_id"`
+		Period      string     `json:"period"` // daily, weekly, monthly (default) or custom
+		PeriodStart *time.Time `json:"period_start,omitempty"`
+		PeriodEnd   *time.Time `json:"period_end,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	var start, end time.Time
+	if req.PeriodStart != nil || req.PeriodEnd != nil {
+		if req.PeriodStart == nil || req.PeriodEnd == nil || !req.PeriodStart.Before(*req.PeriodEnd) {
+			errorResponse(w, http.StatusBadRequest, "period_start and period_end are both required and start must be before end")
+			return
+		}
+		start, end = *req.PeriodStart, *req.PeriodEnd
+		if req.Period == "" {
+			req.Period = "custom"
+		}
+	} else {
+		if req.Period == "" {
+			req.Period = reports.ScheduleMonthly
+		}
+		var err error
+		start, end, err = reports.LastCompletedPeriod(req.Period, time.Now())
+		if err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	var networks []*models.Network
+	if req.NetworkID != "" {
+		network, err := s.store.GetNetwork(r.Context(), req.NetworkID)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "failed to get network")
+			return
+		}
+		if network == nil {
+			errorResponse(w, http.StatusNotFound, "network not found")
+			return
+		}
+		networks = []*models.Network{network}
+	} else {
+		var err error
+		networks, err = s.store.ListNetworks(r.Context())
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, "failed to list networks")
+			return
+		}
+	}
+
+	generated := []*models.Report{}
+	for _, network := range networks {
+		report, err := s.generateReport(r.Context(), network, req.Period, start, end)
+		if err != nil {
+			errorResponse(w, http.StatusInternalServerError, fmt.Sprintf("failed to generate report for %s: %v", network.Name, err))
+			return
+		}
+		generated = append(generated, report)
+	}
+
+	jsonResponse(w, http.StatusCreated, generated)
+}
+
+// handleGetReport returns a report with its data as JSON
+func (s *Server) handleGetReport(w http.ResponseWriter, r *http.Request) {
+	report, err := s.store.GetReport(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get report")
+		return
+	}
+	if report == nil {
+		errorResponse(w, http.StatusNotFound, "report not found")
+		return
+	}
+	jsonResponse(w, http.StatusOK, report)
+}
+
+// handleDownloadReport renders a report as a file (format=json|csv|html)
+func (s *Server) handleDownloadReport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	contentType, ext, err := reports.ContentType(format)
+	if err != nil {
+		errorResponse(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	report, err := s.store.GetReport(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get report")
+		return
+	}
+	if report == nil {
+		errorResponse(w, http.StatusNotFound, "report not found")
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := reports.Render(&buf, report, format); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to render report: "+err.Error())
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s-%s.%s",
+		safeFilenameChars.ReplaceAllString(report.NetworkName, "_"),
+		report.Period,
+		report.PeriodStart.UTC().Format("2006-01-02"),
+		ext)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	w.Write(buf.Bytes())
+}
+
+// handleDeleteReport deletes a stored report
+func (s *Server) handleDeleteReport(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.DeleteReport(r.Context(), mux.Vars(r)["id"]); err != nil {
+		if err == sql.ErrNoRows {
+			errorResponse(w, http.StatusNotFound, "report not found")
+			return
+		}
+		errorResponse(w, http.StatusInternalServerError, "failed to delete report")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
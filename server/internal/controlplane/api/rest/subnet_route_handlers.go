@@ -0,0 +1,242 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/shared/netutil"
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+// normalizeSubnets validates a list of subnets and returns them in canonical
+// form (network address), without duplicates
+func normalizeSubnets(subnets []string) ([]string, error) {
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, subnet := range subnets {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(subnet))
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %q: %v", subnet, err)
+		}
+		if ones, _ := ipNet.Mask.Size(); ones == 0 {
+			return nil, fmt.Errorf("subnet %q is a default route", subnet)
+		}
+		cidr := ipNet.String()
+		if !seen[cidr] {
+			seen[cidr] = true
+			out = append(out, cidr)
+		}
+	}
+	return out, nil
+}
+
+// nodeSubnetEndpoint resolves a "subnet" VPN firewall rule endpoint: all
+// approved subnets of the router node, or only the selected one
+func nodeSubnetEndpoint(node *models.Node, subnet string) ([]string, error) {
+	if subnet == "" {
+		if len(node.ApprovedRoutes) == 0 {
+			return nil, fmt.Errorf("node %s has no approved subnets", node.Name)
+		}
+		return node.ApprovedRoutes, nil
+	}
+	if normalized, err := normalizeSubnets([]string{subnet}); err == nil {
+		for _, cidr := range node.ApprovedRoutes {
+			if cidr == normalized[0] {
+				return []string{cidr}, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("subnet %s is not an approved subnet of node %s", subnet, node.Name)
+}
+
+// cidrsOverlap reports whether two CIDRs share any address
+func cidrsOverlap(a, b string) bool {
+	_, netA, errA := net.ParseCIDR(a)
+	_, netB, errB := net.ParseCIDR(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return networksOverlap(netA, netB)
+}
+
+// checkSubnetOverlap makes sure subnets approved for a node don't overlap any
+// network range or the approved subnets of other nodes
+func (s *Server) checkSubnetOverlap(ctx context.Context, nodeID string, subnets []string) error {
+	networks, err := s.store.ListNetworks(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
+	}
+	routes, err := s.store.ListSubnetRoutes(ctx, "")
+	if err != nil {
+		return fmt.Errorf("failed to list subnet routes: %w", err)
+	}
+
+	for _, subnet := range subnets {
+		for _, network := range networks {
+			for _, cidr := range network.CIDRs() {
+				if cidrsOverlap(subnet, cidr) {
+					return fmt.Errorf("subnet %s overlaps network %s (%s)", subnet, network.Name, cidr)
+				}
+			}
+		}
+		for _, route := range routes {
+			if route.Approved && route.NodeID != nodeID && cidrsOverlap(subnet, route.CIDR) {
+				return fmt.Errorf("subnet %s overlaps %s routed by %s", subnet, route.CIDR, route.NodeName)
+			}
+		}
+	}
+	return nil
+}
+
+// setNodeRoutes stores a node's advertised and approved subnets and applies
+// them to the hub. Approved subnets that are no longer advertised are dropped.
+func (s *Server) setNodeRoutes(ctx context.Context, node *models.Node, advertised, approved []string) error {
+	isAdvertised := make(map[string]bool, len(advertised))
+	for _, cidr := range advertised {
+		isAdvertised[cidr] = true
+	}
+	kept := []string{}
+	for _, cidr := range approved {
+		if isAdvertised[cidr] {
+			kept = append(kept, cidr)
+		}
+	}
+
+	if err := s.store.UpdateNodeRoutes(ctx, node.ID, advertised, kept); err != nil {
+		return err
+	}
+	node.AdvertisedRoutes = advertised
+	node.ApprovedRoutes = kept
+
+	// Peer AllowedIPs and host routes follow the approved subnets
+	s.reconcile(ctx, "routes", node.NetworkID)
+	return nil
+}
+
+// handleListSubnetRoutes lists advertised subnets and their approval state
+func (s *Server) handleListSubnetRoutes(w http.ResponseWriter, r *http.Request) {
+	routes, err := s.store.ListSubnetRoutes(r.Context(), r.URL.Query().Get("network_id"))
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to list subnet routes")
+		return
+	}
+	jsonResponse(w, http.StatusOK, routes)
+}
+
+// handleUpdateNodeRoutes sets the subnets a node advertises and/or the ones an
+// admin approved. Only approved subnets are routed.
+func (s *Server) handleUpdateNodeRoutes(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req struct {
+		Advertised *[]string `json:"advertised"`
+		Approved   *[]string `json:"approved"` // Must be a subset of the advertised subnets
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errorResponse(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	node, err := s.store.GetNode(r.Context(), id)
+	if err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to get node")
+		return
+	}
+	if node == nil {
+		errorResponse(w, http.StatusNotFound, "node not found")
+		return
+	}
+
+	advertised := node.AdvertisedRoutes
+	if req.Advertised != nil {
+		if advertised, err = normalizeSubnets(*req.Advertised); err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	approved := node.ApprovedRoutes
+	if req.Approved != nil {
+		if approved, err = normalizeSubnets(*req.Approved); err != nil {
+			errorResponse(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		isAdvertised := make(map[string]bool, len(advertised))
+		for _, cidr := range advertised {
+			isAdvertised[cidr] = true
+		}
+		for _, cidr := range approved {
+			if !isAdvertised[cidr] {
+				errorResponse(w, http.StatusBadRequest, fmt.Sprintf("subnet %s is not advertised by this node", cidr))
+				return
+			}
+		}
+		if err := s.checkSubnetOverlap(r.Context(), node.ID, approved); err != nil {
+			errorResponse(w, http.StatusConflict, err.Error())
+			return
+		}
+	}
+
+	if err := s.setNodeRoutes(r.Context(), node, advertised, approved); err != nil {
+		errorResponse(w, http.StatusInternalServerError, "failed to update routes")
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, node)
+}
+
+// syncSubnetRoutes points the host routes of a network's approved subnets at
+// its interface and removes routes of subnets that are no longer approved
+func (s *Server) syncSubnetRoutes(network *models.Network, nodes []*models.Node) {
+	if s.wgBackend == wireguard.BackendSimulated {
+		return
+	}
+
+	want := make(map[string]bool)
+	for _, node := range nodes {
+		if nodeIsExpired(node) {
+			continue
+		}
+		for _, cidr := range node.ApprovedRoutes {
+			want[cidr] = true
+		}
+	}
+
+	for _, family := range []string{"-4", "-6"} {
+		output, err := execHostCommand("ip", family, "route", "show", "dev", network.InterfaceName, "proto", "static")
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(output, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			cidr := fields[0]
+			if !strings.Contains(cidr, "/") {
+				if ip := net.ParseIP(cidr); ip != nil {
+					cidr = netutil.HostCIDR(ip)
+				}
+			}
+			if !want[cidr] {
+				execHostCommand("ip", family, "route", "del", cidr, "dev", network.InterfaceName)
+			}
+		}
+	}
+
+	for cidr := range want {
+		family := "-4"
+		if netutil.IsIPv6(cidr) {
+			family = "-6"
+		}
+		if _, err := execHostCommand("ip", family, "route", "replace", cidr, "dev", network.InterfaceName, "proto", "static"); err != nil {
+			fmt.Printf("[Routes] Warning: failed to route %s via %s: %v\n", cidr, network.InterfaceName, err)
+		}
+	}
+}
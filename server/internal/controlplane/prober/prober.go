@@ -0,0 +1,283 @@
+// Package prober measures round-trip times to nodes with ICMP echo requests
+// or UDP probes answered by ICMP port unreachable messages.
+package prober
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Probe methods
+const (
+	MethodAuto = "auto" // ICMP when a socket can be opened, UDP otherwise
+	MethodICMP = "icmp"
+	MethodUDP  = "udp"
+)
+
+// udpProbePort is the first traceroute port, normally closed on nodes
+const udpProbePort = 33434
+
+// Options shape one probe round
+type Options struct {
+	Count    int           // Probes per round
+	Interval time.Duration // Between the probes of a round
+	Timeout  time.Duration // Wait for each reply
+}
+
+// DefaultOptions sends five probes 200ms apart with a one second timeout
+var DefaultOptions = Options{Count: 5, Interval: 200 * time.Millisecond, Timeout: time.Second}
+
+// Result is the outcome of one probe round
+type Result struct {
+	Method   string
+	Sent     int
+	Received int
+	RTTs     []time.Duration // Of the answered probes, in order
+}
+
+// Loss returns the share of unanswered probes in percent
+func (r Result) Loss() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Sent-r.Received) / float64(r.Sent) * 100
+}
+
+// Stats returns the average, minimum and maximum RTT and the jitter (mean
+// difference of consecutive RTTs) in milliseconds; ok is false without replies
+func (r Result) Stats() (avg, min, max, jitter float64, ok bool) {
+	if len(r.RTTs) == 0 {
+		return 0, 0, 0, 0, false
+	}
+	min = math.Inf(1)
+	var sum, diffs float64
+	for i, rtt := range r.RTTs {
+		ms := float64(rtt) / float64(time.Millisecond)
+		sum += ms
+		min = math.Min(min, ms)
+		max = math.Max(max, ms)
+		if i > 0 {
+			diffs += math.Abs(ms - float64(r.RTTs[i-1])/float64(time.Millisecond))
+		}
+	}
+	avg = sum / float64(len(r.RTTs))
+	if len(r.RTTs) > 1 {
+		jitter = diffs / float64(len(r.RTTs)-1)
+	}
+	return avg, min, max, jitter, true
+}
+
+// Prober sends probes with one method
+type Prober struct {
+	method string
+	// ICMP socket networks for IPv4 and IPv6 targets ("udp4"/"udp6" ping
+	// sockets or "ip4:icmp"/"ip6:ipv6-icmp" raw sockets), empty when neither
+	// is permitted and that family falls back to UDP probes
+	socket4, socket6 string
+}
+
+var echoID uint32
+
+// New returns a prober for method. ICMP uses unprivileged ping sockets
+// (net.ipv4.ping_group_range) and falls back to raw sockets, checked per
+// address family; a family without either uses UDP probes. Auto uses UDP
+// probes when no ICMP socket is permitted at all.
+func New(method string) (*Prober, error) {
+	switch method {
+	case MethodUDP:
+		return &Prober{method: MethodUDP}, nil
+	case MethodICMP, MethodAuto, "":
+		p := &Prober{method: MethodICMP, socket4: icmpSocket(false), socket6: icmpSocket(true)}
+		if p.socket4 != "" || p.socket6 != "" {
+			return p, nil
+		}
+		if method == MethodICMP {
+			return nil, fmt.Errorf("no ICMP socket permitted: allow ping sockets with sysctl net.ipv4.ping_group_range or run with CAP_NET_RAW")
+		}
+		return &Prober{method: MethodUDP}, nil
+	default:
+		return nil, fmt.Errorf("unknown probe method %q: must be auto, icmp or udp", method)
+	}
+}
+
+// icmpSocket returns the first ICMP socket network of a family that can be
+// opened, or "" when none can
+func icmpSocket(ipv6 bool) string {
+	networks, address := []string{"udp4", "ip4:icmp"}, "0.0.0.0"
+	if ipv6 {
+		networks, address = []string{"udp6", "ip6:ipv6-icmp"}, "::"
+	}
+	for _, network := range networks {
+		conn, err := icmp.ListenPacket(network, address)
+		if err == nil {
+			conn.Close()
+			return network
+		}
+	}
+	return ""
+}
+
+// Method returns the probe method in use
+func (p *Prober) Method() string {
+	return p.method
+}
+
+// Describe returns the method with the socket kind per family, for logs
+func (p *Prober) Describe() string {
+	if p.method == MethodUDP {
+		return "UDP probes"
+	}
+	return fmt.Sprintf("ICMP (IPv4: %s, IPv6: %s)", describeSocket(p.socket4), describeSocket(p.socket6))
+}
+
+func describeSocket(network string) string {
+	switch {
+	case network == "":
+		return "UDP probes"
+	case strings.HasPrefix(network, "ip"):
+		return "raw sockets"
+	default:
+		return "unprivileged ping sockets"
+	}
+}
+
+// Probe sends one round of probes to ip
+func (p *Prober) Probe(ctx context.Context, ip net.IP, opts Options) Result {
+	if p.method == MethodUDP {
+		return p.probeUDP(ctx, ip, opts)
+	}
+	return p.probeICMP(ctx, ip, opts)
+}
+
+// probeICMP sends echo requests over one socket and matches the replies by
+// sequence number (and identifier on raw sockets; the kernel sets and
+// filters it on ping sockets)
+func (p *Prober) probeICMP(ctx context.Context, ip net.IP, opts Options) Result {
+	network, address, proto := p.socket4, "0.0.0.0", 1
+	var echoType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		network, address, proto = p.socket6, "::", 58
+		echoType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+	if network == "" {
+		return p.probeUDP(ctx, ip, opts) // No ICMP socket for this family
+	}
+	privileged := strings.HasPrefix(network, "ip")
+
+	result := Result{Method: MethodICMP}
+	conn, err := icmp.ListenPacket(network, address)
+	if err != nil {
+		result.Sent = opts.Count
+		return result
+	}
+	defer conn.Close()
+
+	var dst net.Addr = &net.UDPAddr{IP: ip}
+	if privileged {
+		dst = &net.IPAddr{IP: ip}
+	}
+	id := int(atomic.AddUint32(&echoID, 1) & 0xffff)
+	reply := make([]byte, 1500)
+
+	for seq := 1; seq <= opts.Count; seq++ {
+		if seq > 1 && !sleep(ctx, opts.Interval) {
+			break
+		}
+		msg := icmp.Message{Type: echoType, Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("novusgate-probe")}}
+		data, err := msg.Marshal(nil)
+		if err != nil {
+			break
+		}
+		start := time.Now()
+		result.Sent++
+		if _, err := conn.WriteTo(data, dst); err != nil {
+			continue
+		}
+		conn.SetReadDeadline(start.Add(opts.Timeout))
+		for {
+			n, peer, err := conn.ReadFrom(reply)
+			if err != nil {
+				break // Timeout: the probe is lost
+			}
+			if !addrIP(peer).Equal(ip) {
+				continue
+			}
+			parsed, err := icmp.ParseMessage(proto, reply[:n])
+			if err != nil || parsed.Type != replyType {
+				continue
+			}
+			echo, ok := parsed.Body.(*icmp.Echo)
+			if !ok || echo.Seq != seq || (privileged && echo.ID != id) {
+				continue
+			}
+			result.Received++
+			result.RTTs = append(result.RTTs, time.Since(start))
+			break
+		}
+	}
+	return result
+}
+
+// probeUDP sends datagrams to a closed port; the ICMP port unreachable reply
+// surfaces as ECONNREFUSED on the connected socket
+func (p *Prober) probeUDP(ctx context.Context, ip net.IP, opts Options) Result {
+	result := Result{Method: MethodUDP}
+	conn, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: ip, Port: udpProbePort})
+	if err != nil {
+		result.Sent = opts.Count
+		return result
+	}
+	defer conn.Close()
+
+	buf := make([]byte, 512)
+	for seq := 1; seq <= opts.Count; seq++ {
+		if seq > 1 && !sleep(ctx, opts.Interval) {
+			break
+		}
+		start := time.Now()
+		result.Sent++
+		if _, err := conn.Write([]byte("novusgate-probe")); err != nil {
+			continue
+		}
+		conn.SetReadDeadline(start.Add(opts.Timeout))
+		if _, err := conn.Read(buf); err == nil || errors.Is(err, syscall.ECONNREFUSED) {
+			// Refused or answered, the node is reachable either way
+			result.Received++
+			result.RTTs = append(result.RTTs, time.Since(start))
+		}
+	}
+	return result
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP
+	case *net.IPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// sleep waits d and reports false when ctx ends first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,244 @@
+package reports
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+// Render writes the report in the given format (json, csv or html)
+func Render(w io.Writer, report *models.Report, format string) error {
+	switch strings.ToLower(format) {
+	case "", "json":
+		return RenderJSON(w, report)
+	case "csv":
+		return RenderCSV(w, report)
+	case "html":
+		return RenderHTML(w, report)
+	}
+	return fmt.Errorf("unsupported format %q", format)
+}
+
+// RenderJSON writes the report as indented JSON
+func RenderJSON(w io.Writer, report *models.Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// RenderCSV writes the report as a single CSV document made of sections.
+// Each section starts with a one-cell title row followed by a header row.
+func RenderCSV(w io.Writer, report *models.Report) error {
+	cw := csv.NewWriter(w)
+	data := reportData(report)
+
+	section := func(title string, header []string) {
+		cw.Write([]string{title})
+		cw.Write(header)
+	}
+
+	section("Report", []string{"network", "cidr", "period", "period_start", "period_end", "generated_at"})
+	cw.Write([]string{report.NetworkName, data.NetworkCIDR, report.Period,
+		formatTime(report.PeriodStart), formatTime(report.PeriodEnd), formatTime(report.CreatedAt)})
+	cw.Write(nil)
+
+	section("Node Summary", []string{"total", "previous_total", "change", "created", "expired"})
+	cw.Write([]string{itoa(data.Nodes.Total), itoa(data.Nodes.PreviousTotal), itoa(data.Nodes.Change),
+		itoa(len(data.Nodes.Created)), itoa(len(data.Nodes.Expired))})
+	cw.Write(nil)
+
+	section("Nodes By Status", []string{"status", "count"})
+	for _, key := range sortedKeys(data.Nodes.ByStatus) {
+		cw.Write([]string{key, itoa(data.Nodes.ByStatus[key])})
+	}
+	cw.Write(nil)
+
+	nodeSection := func(title string, nodes []models.ReportNode) {
+		section(title, []string{"node_id", "name", "virtual_ip", "created_at", "expires_at"})
+		for _, n := range nodes {
+			expires := ""
+			if n.ExpiresAt != nil {
+				expires = formatTime(*n.ExpiresAt)
+			}
+			cw.Write([]string{n.ID, n.Name, n.VirtualIP, formatTime(n.CreatedAt), expires})
+		}
+		cw.Write(nil)
+	}
+	nodeSection("Created Nodes", data.Nodes.Created)
+	nodeSection("Expired Nodes", data.Nodes.Expired)
+
+	section("Top Talkers", []string{"node_id", "name", "virtual_ip", "rx_bytes", "tx_bytes", "total_bytes"})
+	for _, t := range data.TopTalkers {
+		cw.Write([]string{t.NodeID, t.Name, t.VirtualIP, i64toa(t.RxBytes), i64toa(t.TxBytes), i64toa(t.Total)})
+	}
+	cw.Write(nil)
+
+	section("Ban Statistics", []string{"bans", "unbans", "unique_ips"})
+	cw.Write([]string{itoa(data.Bans.Bans), itoa(data.Bans.Unbans), itoa(data.Bans.UniqueIPs)})
+	cw.Write(nil)
+
+	section("Bans By Jail", []string{"jail", "bans"})
+	for _, key := range sortedKeys(data.Bans.ByJail) {
+		cw.Write([]string{key, itoa(data.Bans.ByJail[key])})
+	}
+	cw.Write(nil)
+
+	if len(data.Bans.ByCountry) > 0 {
+		section("Bans By Country", []string{"country", "bans"})
+		for _, key := range sortedKeys(data.Bans.ByCountry) {
+			cw.Write([]string{key, itoa(data.Bans.ByCountry[key])})
+		}
+		cw.Write(nil)
+	}
+
+	section("Firewall Changes", []string{"time", "action", "user_ip", "details"})
+	for _, c := range data.FirewallChanges {
+		details, _ := json.Marshal(c.Details)
+		cw.Write([]string{formatTime(c.Time), c.Action, c.UserIP, string(details)})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+var htmlTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time":  formatTime,
+	"bytes": formatBytes,
+	"keys":  sortedKeys,
+	"json": func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return string(b)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>NovusGate Report - {{.Report.NetworkName}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+th { background: #f3f3f3; }
+</style>
+</head>
+<body>
+<h1>{{.Report.NetworkName}} ({{.Data.NetworkCIDR}})</h1>
+<p>{{.Report.Period}} report: {{time .Report.PeriodStart}} &ndash; {{time .Report.PeriodEnd}}<br>
+Generated {{time .Report.CreatedAt}}</p>
+
+<h2>Nodes</h2>
+<table>
+<tr><th>Total</th><th>Previous</th><th>Change</th><th>Created</th><th>Expired</th></tr>
+<tr><td>{{.Data.Nodes.Total}}</td><td>{{.Data.Nodes.PreviousTotal}}</td><td>{{.Data.Nodes.Change}}</td><td>{{len .Data.Nodes.Created}}</td><td>{{len .Data.Nodes.Expired}}</td></tr>
+</table>
+<table>
+<tr><th>Status</th><th>Count</th></tr>
+{{range $k := keys .Data.Nodes.ByStatus}}<tr><td>{{$k}}</td><td>{{index $.Data.Nodes.ByStatus $k}}</td></tr>
+{{end}}</table>
+
+<h3>Created</h3>
+<table>
+<tr><th>Name</th><th>Virtual IP</th><th>Created</th></tr>
+{{range .Data.Nodes.Created}}<tr><td>{{.Name}}</td><td>{{.VirtualIP}}</td><td>{{time .CreatedAt}}</td></tr>
+{{end}}</table>
+
+<h3>Expired</h3>
+<table>
+<tr><th>Name</th><th>Virtual IP</th><th>Expired</th></tr>
+{{range .Data.Nodes.Expired}}<tr><td>{{.Name}}</td><td>{{.VirtualIP}}</td><td>{{if .ExpiresAt}}{{time .ExpiresAt}}{{end}}</td></tr>
+{{end}}</table>
+
+<h2>Top Talkers</h2>
+<table>
+<tr><th>Name</th><th>Virtual IP</th><th>Received</th><th>Sent</th><th>Total</th></tr>
+{{range .Data.TopTalkers}}<tr><td>{{.Name}}</td><td>{{.VirtualIP}}</td><td>{{bytes .RxBytes}}</td><td>{{bytes .TxBytes}}</td><td>{{bytes .Total}}</td></tr>
+{{end}}</table>
+
+<h2>Bans</h2>
+<table>
+<tr><th>Bans</th><th>Unbans</th><th>Unique IPs</th></tr>
+<tr><td>{{.Data.Bans.Bans}}</td><td>{{.Data.Bans.Unbans}}</td><td>{{.Data.Bans.UniqueIPs}}</td></tr>
+</table>
+<table>
+<tr><th>Jail</th><th>Bans</th></tr>
+{{range $k := keys .Data.Bans.ByJail}}<tr><td>{{$k}}</td><td>{{index $.Data.Bans.ByJail $k}}</td></tr>
+{{end}}</table>
+{{if .Data.Bans.ByCountry}}<table>
+<tr><th>Country</th><th>Bans</th></tr>
+{{range $k := keys .Data.Bans.ByCountry}}<tr><td>{{$k}}</td><td>{{index $.Data.Bans.ByCountry $k}}</td></tr>
+{{end}}</table>{{end}}
+
+<h2>Firewall Changes</h2>
+<table>
+<tr><th>Time</th><th>Action</th><th>User IP</th><th>Details</th></tr>
+{{range .Data.FirewallChanges}}<tr><td>{{time .Time}}</td><td>{{.Action}}</td><td>{{.UserIP}}</td><td>{{json .Details}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// RenderHTML writes the report as a standalone HTML page
+func RenderHTML(w io.Writer, report *models.Report) error {
+	return htmlTemplate.Execute(w, map[string]interface{}{
+		"Report": report,
+		"Data":   reportData(report),
+	})
+}
+
+func reportData(report *models.Report) *models.ReportData {
+	if report.Data == nil {
+		return &models.ReportData{}
+	}
+	return report.Data
+}
+
+func formatTime(v interface{}) string {
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC().Format(time.RFC3339)
+	case *time.Time:
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	return ""
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func itoa(n int) string {
+	return strconv.Itoa(n)
+}
+
+func i64toa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
@@ -0,0 +1,63 @@
+// Package reports computes report periods and renders generated reports as
+// JSON, CSV or HTML.
+package reports
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Supported schedules
+const (
+	ScheduleDaily   = "daily"
+	ScheduleWeekly  = "weekly"
+	ScheduleMonthly = "monthly"
+)
+
+// ValidSchedule reports whether s is a supported schedule name
+func ValidSchedule(s string) bool {
+	switch s {
+	case ScheduleDaily, ScheduleWeekly, ScheduleMonthly:
+		return true
+	}
+	return false
+}
+
+// LastCompletedPeriod returns the most recent period of the given schedule that
+// ended at or before now. Periods are aligned to UTC calendar boundaries:
+// days start at midnight, weeks on Monday and months on the 1st.
+func LastCompletedPeriod(schedule string, now time.Time) (start, end time.Time, err error) {
+	now = now.UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch schedule {
+	case ScheduleDaily:
+		end = today
+		start = end.AddDate(0, 0, -1)
+	case ScheduleWeekly:
+		// time.Weekday: Sunday = 0; shift so Monday = 0
+		offset := (int(today.Weekday()) + 6) % 7
+		end = today.AddDate(0, 0, -offset)
+		start = end.AddDate(0, 0, -7)
+	case ScheduleMonthly:
+		end = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		start = end.AddDate(0, -1, 0)
+	default:
+		return time.Time{}, time.Time{}, fmt.Errorf("unknown report schedule %q", schedule)
+	}
+	return start, end, nil
+}
+
+// ContentType returns the MIME type and file extension for a render format
+func ContentType(format string) (string, string, error) {
+	switch strings.ToLower(format) {
+	case "", "json":
+		return "application/json", "json", nil
+	case "csv":
+		return "text/csv", "csv", nil
+	case "html":
+		return "text/html; charset=utf-8", "html", nil
+	}
+	return "", "", fmt.Errorf("unsupported format %q: must be json, csv or html", format)
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+// Enrollment token errors
+var (
+	ErrEnrollmentTokenInvalid = errors.New("enrollment token is invalid, used or expired")
+	ErrPublicKeyInUse         = errors.New("public key is already in use")
+)
+
+// Enrollment token operations
+
+const enrollmentTokenColumns = `id, network_id, name, labels, client_config, host(virtual_ip), host(virtual_ip6), preshared_key,
+	node_expires_at, expires_at, used_at, node_id, created_at`
+
+// CreateEnrollmentToken stores a token by the SHA-256 hash of its secret
+func (s *Store) CreateEnrollmentToken(ctx context.Context, token *models.EnrollmentToken, tokenHash string) error {
+	if token.ID == "" {
+		token.ID = uuid.New().String()
+	}
+	token.CreatedAt = time.Now()
+	token.Status = token.State()
+
+	labelsJSON, _ := json.Marshal(token.Labels)
+	var clientConfigJSON []byte
+	if token.ClientConfig != nil {
+		clientConfigJSON, _ = json.Marshal(token.ClientConfig)
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO enrollment_tokens (id, network_id, token_hash, name, labels, client_config, virtual_ip, virtual_ip6,
+			preshared_key, node_expires_at, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`, token.ID, token.NetworkID, tokenHash, token.Name, labelsJSON, clientConfigJSON, nullIP(token.VirtualIP), nullIP(token.VirtualIP6),
+		token.PresharedKey, token.NodeExpiresAt, token.ExpiresAt, token.CreatedAt)
+	return err
+}
+
+// GetEnrollmentTokenByHash retrieves a token by the hash of its secret
+func (s *Store) GetEnrollmentTokenByHash(ctx context.Context, tokenHash string) (*models.EnrollmentToken, error) {
+	return getEnrollmentToken(ctx, s.db, `token_hash = $1`, tokenHash)
+}
+
+// GetEnrollmentToken retrieves a token by ID
+func (s *Store) GetEnrollmentToken(ctx context.Context, id string) (*models.EnrollmentToken, error) {
+	return getEnrollmentToken(ctx, s.db, `id = $1`, id)
+}
+
+// ListEnrollmentTokens lists the tokens of a network, newest first
+func (s *Store) ListEnrollmentTokens(ctx context.Context, networkID string) ([]*models.EnrollmentToken, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+enrollmentTokenColumns+`
+		FROM enrollment_tokens WHERE network_id = $1
+		ORDER BY created_at DESC
+	`, networkID)
+	if err != nil {
+		return nil, err
+	}
+	return scanEnrollmentTokens(rows)
+}
+
+// DeleteEnrollmentToken deletes a token; nodes enrolled with it are kept
+func (s *Store) DeleteEnrollmentToken(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM enrollment_tokens WHERE id = $1`, id)
+	return err
+}
+
+// EnrollNode creates a node with the settings and addresses of an unused,
+// unexpired token and marks the token used, all in one transaction. The
+// node brings its own public key, which must not belong to another node, and
+// gets the node token whose hash is nodeTokenHash.
+func (s *Store) EnrollNode(ctx context.Context, tokenID string, node *models.Node, nodeTokenHash string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the token row makes concurrent enrollments with it fail
+	token, err := getEnrollmentToken(ctx, tx, `id = $1 FOR UPDATE`, tokenID)
+	if err != nil {
+		return err
+	}
+	if token == nil || token.State() != "active" {
+		return ErrEnrollmentTokenInvalid
+	}
+
+	inUse, err := publicKeyInUse(ctx, tx, node.PublicKey)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrPublicKeyInUse
+	}
+
+	node.NetworkID = token.NetworkID
+	if err := createNodeWithAddresses(ctx, tx, node, token.VirtualIP, token.VirtualIP6); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE nodes SET token_hash = $2 WHERE id = $1`, node.ID, nodeTokenHash); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE enrollment_tokens SET used_at = NOW(), node_id = $2 WHERE id = $1
+	`, token.ID, node.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PublicKeyInUse reports whether a node has the key as its current or
+// previous public key
+func (s *Store) PublicKeyInUse(ctx context.Context, publicKey string) (bool, error) {
+	return publicKeyInUse(ctx, s.db, publicKey)
+}
+
+func publicKeyInUse(ctx context.Context, q queryer, publicKey string) (bool, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT 1 FROM nodes WHERE public_key = $1 OR previous_public_key = $1 LIMIT 1
+	`, publicKey)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	return rows.Next(), rows.Err()
+}
+
+func getEnrollmentToken(ctx context.Context, q queryer, where string, arg interface{}) (*models.EnrollmentToken, error) {
+	rows, err := q.QueryContext(ctx, `SELECT `+enrollmentTokenColumns+` FROM enrollment_tokens WHERE `+where, arg)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := scanEnrollmentTokens(rows)
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	return tokens[0], nil
+}
+
+func scanEnrollmentTokens(rows *sql.Rows) ([]*models.EnrollmentToken, error) {
+	defer rows.Close()
+
+	tokens := []*models.EnrollmentToken{}
+	for rows.Next() {
+		var t models.EnrollmentToken
+		var labelsJSON, clientConfigJSON []byte
+		var virtualIP, virtualIP6, nodeID sql.NullString
+		if err := rows.Scan(&t.ID, &t.NetworkID, &t.Name, &labelsJSON, &clientConfigJSON, &virtualIP, &virtualIP6, &t.PresharedKey,
+			&t.NodeExpiresAt, &t.ExpiresAt, &t.UsedAt, &nodeID, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		json.Unmarshal(labelsJSON, &t.Labels)
+		if len(clientConfigJSON) > 0 {
+			json.Unmarshal(clientConfigJSON, &t.ClientConfig)
+		}
+		if virtualIP.Valid {
+			t.VirtualIP = net.ParseIP(virtualIP.String)
+		}
+		if virtualIP6.Valid {
+			t.VirtualIP6 = net.ParseIP(virtualIP6.String)
+		}
+		if nodeID.Valid {
+			t.NodeID = &nodeID.String
+		}
+		t.Status = t.State()
+		tokens = append(tokens, &t)
+	}
+	return tokens, rows.Err()
+}
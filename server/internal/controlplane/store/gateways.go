@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+// Gateway operations
+
+// CreateGateway stores a new gateway of a network
+func (s *Store) CreateGateway(ctx context.Context, gateway *models.Gateway) error {
+	if gateway.ID == "" {
+		gateway.ID = uuid.New().String()
+	}
+	if gateway.Status == "" {
+		gateway.Status = models.GatewayStatusUnknown
+	}
+	gateway.CreatedAt = time.Now()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO gateways (id, network_id, name, endpoint, private_key, public_key, priority, status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, gateway.ID, gateway.NetworkID, gateway.Name, gateway.Endpoint, gateway.PrivateKey, gateway.PublicKey,
+		gateway.Priority, gateway.Status, gateway.CreatedAt)
+	return err
+}
+
+// GetGateway retrieves a gateway by ID
+func (s *Store) GetGateway(ctx context.Context, id string) (*models.Gateway, error) {
+	var gateway models.Gateway
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, network_id, name, endpoint, private_key, public_key, priority, status, last_seen, created_at
+		FROM gateways WHERE id = $1
+	`, id).Scan(&gateway.ID, &gateway.NetworkID, &gateway.Name, &gateway.Endpoint, &gateway.PrivateKey, &gateway.PublicKey,
+		&gateway.Priority, &gateway.Status, &gateway.LastSeen, &gateway.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &gateway, nil
+}
+
+// ListGateways lists the gateways of a network, preferred first
+func (s *Store) ListGateways(ctx context.Context, networkID string) ([]*models.Gateway, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, network_id, name, endpoint, private_key, public_key, priority, status, last_seen, created_at
+		FROM gateways WHERE network_id = $1
+		ORDER BY priority, name
+	`, networkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gateways []*models.Gateway
+	for rows.Next() {
+		var gateway models.Gateway
+		if err := rows.Scan(&gateway.ID, &gateway.NetworkID, &gateway.Name, &gateway.Endpoint, &gateway.PrivateKey, &gateway.PublicKey,
+			&gateway.Priority, &gateway.Status, &gateway.LastSeen, &gateway.CreatedAt); err != nil {
+			return nil, err
+		}
+		gateways = append(gateways, &gateway)
+	}
+	return gateways, rows.Err()
+}
+
+// GetGatewayByTokenHash retrieves the gateway an agent token belongs to
+func (s *Store) GetGatewayByTokenHash(ctx context.Context, tokenHash string) (*models.Gateway, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM gateways WHERE token_hash = $1`, tokenHash).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetGateway(ctx, id)
+}
+
+// SetGatewayTokenHash replaces the agent token of a gateway
+func (s *Store) SetGatewayTokenHash(ctx context.Context, id, tokenHash string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE gateways SET token_hash = $2 WHERE id = $1`, id, tokenHash)
+	return err
+}
+
+// RecordGatewayHeartbeat marks a gateway healthy as of now
+func (s *Store) RecordGatewayHeartbeat(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE gateways SET status = $2, last_seen = $3 WHERE id = $1
+	`, id, models.GatewayStatusHealthy, time.Now())
+	return err
+}
+
+// UpdateGatewayStatus updates the health status of a gateway
+func (s *Store) UpdateGatewayStatus(ctx context.Context, id string, status models.GatewayStatus) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE gateways SET status = $2 WHERE id = $1`, id, status)
+	return err
+}
+
+// DeleteGateway deletes a gateway; a network it was active for falls back to
+// the built-in hub
+func (s *Store) DeleteGateway(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM gateways WHERE id = $1`, id)
+	return err
+}
+
+// SetNetworkActiveGateway sets the gateway clients of a network route
+// through; nil selects the built-in hub
+func (s *Store) SetNetworkActiveGateway(ctx context.Context, networkID string, gatewayID *string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE networks SET active_gateway_id = $2, updated_at = $3 WHERE id = $1
+	`, networkID, gatewayID, time.Now())
+	return err
+}
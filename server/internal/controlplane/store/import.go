@@ -0,0 +1,39 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+// ImportNetwork creates a network with existing keys together with its
+// nodes and their approved subnet routes in one transaction
+func (s *Store) ImportNetwork(ctx context.Context, network *models.Network, nodes []*models.Node) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertNetwork(ctx, tx, network); err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		node.NetworkID = network.ID
+		if err := insertNode(ctx, tx, node); err != nil {
+			return err
+		}
+		if len(node.AdvertisedRoutes) == 0 {
+			continue
+		}
+		advertisedJSON, _ := json.Marshal(node.AdvertisedRoutes)
+		approvedJSON, _ := json.Marshal(node.ApprovedRoutes)
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE nodes SET advertised_routes = $2, approved_routes = $3 WHERE id = $1
+		`, node.ID, advertisedJSON, approvedJSON); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,244 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/shared/netutil"
+)
+
+// ErrAddressUnavailable is returned when a requested node address cannot be
+// assigned or a network range has no free address left
+var ErrAddressUnavailable = errors.New("address unavailable")
+
+// queryer is implemented by *sql.DB and *sql.Tx
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// IP reservation operations
+
+// CreateIPReservation stores a reserved or excluded address range
+func (s *Store) CreateIPReservation(ctx context.Context, reservation *models.IPReservation) error {
+	if reservation.ID == "" {
+		reservation.ID = uuid.New().String()
+	}
+	reservation.CreatedAt = time.Now()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO ip_reservations (id, network_id, start_ip, end_ip, kind, description, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, reservation.ID, reservation.NetworkID, reservation.Start.String(), reservation.End.String(),
+		reservation.Kind, reservation.Description, reservation.CreatedAt)
+	return err
+}
+
+// GetIPReservation retrieves a reservation by ID
+func (s *Store) GetIPReservation(ctx context.Context, id string) (*models.IPReservation, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, network_id, host(start_ip), host(end_ip), kind, description, created_at
+		FROM ip_reservations WHERE id = $1
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	reservations, err := scanIPReservations(rows)
+	if err != nil || len(reservations) == 0 {
+		return nil, err
+	}
+	return reservations[0], nil
+}
+
+// ListIPReservations lists the reservations of a network in address order
+func (s *Store) ListIPReservations(ctx context.Context, networkID string) ([]*models.IPReservation, error) {
+	return listIPReservations(ctx, s.db, networkID)
+}
+
+// DeleteIPReservation deletes a reservation
+func (s *Store) DeleteIPReservation(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM ip_reservations WHERE id = $1`, id)
+	return err
+}
+
+func listIPReservations(ctx context.Context, q queryer, networkID string) ([]*models.IPReservation, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, network_id, host(start_ip), host(end_ip), kind, description, created_at
+		FROM ip_reservations WHERE network_id = $1
+		ORDER BY family(start_ip), start_ip
+	`, networkID)
+	if err != nil {
+		return nil, err
+	}
+	return scanIPReservations(rows)
+}
+
+func scanIPReservations(rows *sql.Rows) ([]*models.IPReservation, error) {
+	defer rows.Close()
+
+	reservations := []*models.IPReservation{}
+	for rows.Next() {
+		var r models.IPReservation
+		var start, end string
+		if err := rows.Scan(&r.ID, &r.NetworkID, &start, &end, &r.Kind, &r.Description, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.Start, r.End = net.ParseIP(start), net.ParseIP(end)
+		reservations = append(reservations, &r)
+	}
+	return reservations, rows.Err()
+}
+
+// CreateNodeWithAddresses creates a node with addresses from its network's
+// ranges: the requested ones when set, otherwise the lowest free addresses
+// outside reservations. The network row is locked for the transaction, so
+// concurrent node creations in a network get distinct addresses.
+func (s *Store) CreateNodeWithAddresses(ctx context.Context, node *models.Node, requested, requested6 net.IP) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createNodeWithAddresses(ctx, tx, node, requested, requested6); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// createNodeWithAddresses is CreateNodeWithAddresses within a transaction
+func createNodeWithAddresses(ctx context.Context, tx *sql.Tx, node *models.Node, requested, requested6 net.IP) error {
+	var cidr, cidr6 string
+	err := tx.QueryRowContext(ctx, `
+		SELECT cidr, cidr6 FROM networks WHERE id = $1 FOR UPDATE
+	`, node.NetworkID).Scan(&cidr, &cidr6)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("network not found")
+	}
+	if err != nil {
+		return err
+	}
+	reservations, err := listIPReservations(ctx, tx, node.NetworkID)
+	if err != nil {
+		return err
+	}
+
+	if node.VirtualIP, err = assignAddress(ctx, tx, node.NetworkID, cidr, "virtual_ip", requested, reservations); err != nil {
+		return err
+	}
+	node.VirtualIP6 = nil
+	if cidr6 != "" {
+		if node.VirtualIP6, err = assignAddress(ctx, tx, node.NetworkID, cidr6, "virtual_ip6", requested6, reservations); err != nil {
+			return err
+		}
+	} else if requested6 != nil {
+		return fmt.Errorf("%w: the network has no IPv6 range", ErrAddressUnavailable)
+	}
+
+	return insertNode(ctx, tx, node)
+}
+
+// assignAddress checks a requested address of one range, or picks the lowest
+// free one
+func assignAddress(ctx context.Context, q queryer, networkID, cidr, column string, requested net.IP, reservations []*models.IPReservation) (net.IP, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network CIDR: %w", err)
+	}
+	used, err := usedAddresses(ctx, q, networkID, column)
+	if err != nil {
+		return nil, err
+	}
+
+	if requested != nil {
+		if err := CheckNodeAddress(ipNet, requested, used, reservations); err != nil {
+			return nil, err
+		}
+		return requested, nil
+	}
+
+	first, _ := netutil.NodeRange(ipNet)
+	ip := NextFreeIP(ipNet, first, used, reservations)
+	if ip == nil {
+		return nil, fmt.Errorf("%w: no available IPs in %s", ErrAddressUnavailable, cidr)
+	}
+	return ip, nil
+}
+
+// usedAddresses returns the addresses of a network's nodes in column
+func usedAddresses(ctx context.Context, q queryer, networkID, column string) (map[string]bool, error) {
+	// host() drops the prefix length
+	rows, err := q.QueryContext(ctx, `
+		SELECT host(`+column+`) FROM nodes WHERE network_id = $1 AND `+column+` IS NOT NULL
+	`, networkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	used := make(map[string]bool)
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		if parsed := net.ParseIP(ip); parsed != nil {
+			ip = parsed.String()
+		}
+		used[ip] = true
+	}
+	return used, rows.Err()
+}
+
+// CheckNodeAddress reports why ip cannot be given to a node of ipNet: outside
+// the node range, already used or excluded. Reserved addresses are allowed.
+func CheckNodeAddress(ipNet *net.IPNet, ip net.IP, used map[string]bool, reservations []*models.IPReservation) error {
+	if !ipNet.Contains(ip) {
+		return fmt.Errorf("%w: %s is not in %s", ErrAddressUnavailable, ip, ipNet)
+	}
+	first, last := netutil.NodeRange(ipNet)
+	if netutil.CompareIP(ip, first) < 0 || netutil.CompareIP(ip, last) > 0 {
+		return fmt.Errorf("%w: %s is the network, hub or broadcast address", ErrAddressUnavailable, ip)
+	}
+	if used[ip.String()] {
+		return fmt.Errorf("%w: %s is already assigned", ErrAddressUnavailable, ip)
+	}
+	if r := ReservationAt(reservations, ip); r != nil && r.Kind == models.IPReservationExcluded {
+		return fmt.Errorf("%w: %s is excluded", ErrAddressUnavailable, ip)
+	}
+	return nil
+}
+
+// ReservationAt returns the reservation containing ip, or nil
+func ReservationAt(reservations []*models.IPReservation, ip net.IP) *models.IPReservation {
+	for _, r := range reservations {
+		if r.Contains(ip) {
+			return r
+		}
+	}
+	return nil
+}
+
+// NextFreeIP returns the first address from 'from' on that automatic
+// allocation may hand out in ipNet, or nil when the range is full
+func NextFreeIP(ipNet *net.IPNet, from net.IP, used map[string]bool, reservations []*models.IPReservation) net.IP {
+	_, last := netutil.NodeRange(ipNet)
+	ip := from
+	for ipNet.Contains(ip) && netutil.CompareIP(ip, last) <= 0 {
+		if r := ReservationAt(reservations, ip); r != nil {
+			ip = netutil.NextIP(r.End) // Skip the whole range
+			continue
+		}
+		if !used[ip.String()] {
+			return ip
+		}
+		ip = netutil.NextIP(ip)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+func mustCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ipNet
+}
+
+func reservation(start, end, kind string) *models.IPReservation {
+	return &models.IPReservation{Start: net.ParseIP(start), End: net.ParseIP(end), Kind: kind}
+}
+
+func TestNextFreeIP(t *testing.T) {
+	tests := []struct {
+		name         string
+		cidr         string
+		from         string
+		used         []string
+		reservations []*models.IPReservation
+		want         string
+	}{
+		{
+			name: "empty network",
+			cidr: "10.0.0.0/24",
+			from: "10.0.0.2",
+			want: "10.0.0.2",
+		},
+		{
+			name: "skips used addresses",
+			cidr: "10.0.0.0/24",
+			from: "10.0.0.2",
+			used: []string{"10.0.0.2", "10.0.0.3"},
+			want: "10.0.0.4",
+		},
+		{
+			name:         "skips reserved and excluded ranges",
+			cidr:         "10.0.0.0/24",
+			from:         "10.0.0.2",
+			used:         []string{"10.0.0.21"},
+			reservations: []*models.IPReservation{reservation("10.0.0.2", "10.0.0.9", models.IPReservationReserved), reservation("10.0.0.10", "10.0.0.20", models.IPReservationExcluded)},
+			want:         "10.0.0.22",
+		},
+		{
+			name: "starts at from",
+			cidr: "10.0.0.0/24",
+			from: "10.0.0.100",
+			want: "10.0.0.100",
+		},
+		{
+			name: "broadcast address is never handed out",
+			cidr: "10.0.0.0/30",
+			from: "10.0.0.2",
+			used: []string{"10.0.0.2"},
+		},
+		{
+			name:         "reservation up to the end fills the range",
+			cidr:         "10.0.0.0/29",
+			from:         "10.0.0.2",
+			reservations: []*models.IPReservation{reservation("10.0.0.2", "10.0.0.6", models.IPReservationReserved)},
+		},
+		{
+			name: "IPv6",
+			cidr: "fd00::/64",
+			from: "fd00::2",
+			used: []string{"fd00::2"},
+			want: "fd00::3",
+		},
+		{
+			name: "IPv6 last address can be handed out",
+			cidr: "fd00::/126",
+			from: "fd00::3",
+			want: "fd00::3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			used := map[string]bool{}
+			for _, ip := range tt.used {
+				used[ip] = true
+			}
+			got := NextFreeIP(mustCIDR(t, tt.cidr), net.ParseIP(tt.from), used, tt.reservations)
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("NextFreeIP() = %s, want nil", got)
+				}
+				return
+			}
+			if got == nil || got.String() != tt.want {
+				t.Errorf("NextFreeIP() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckNodeAddress(t *testing.T) {
+	reservations := []*models.IPReservation{
+		reservation("10.0.0.10", "10.0.0.19", models.IPReservationReserved),
+		reservation("10.0.0.20", "10.0.0.29", models.IPReservationExcluded),
+	}
+	tests := []struct {
+		name    string
+		cidr    string
+		ip      string
+		wantErr bool
+	}{
+		{name: "free address", cidr: "10.0.0.0/24", ip: "10.0.0.5"},
+		{name: "reserved address on request", cidr: "10.0.0.0/24", ip: "10.0.0.15"},
+		{name: "excluded address", cidr: "10.0.0.0/24", ip: "10.0.0.25", wantErr: true},
+		{name: "used address", cidr: "10.0.0.0/24", ip: "10.0.0.7", wantErr: true},
+		{name: "outside the network", cidr: "10.0.0.0/24", ip: "10.0.1.5", wantErr: true},
+		{name: "network address", cidr: "10.0.0.0/24", ip: "10.0.0.0", wantErr: true},
+		{name: "hub address", cidr: "10.0.0.0/24", ip: "10.0.0.1", wantErr: true},
+		{name: "broadcast address", cidr: "10.0.0.0/24", ip: "10.0.0.255", wantErr: true},
+		{name: "IPv6 address", cidr: "fd00::/64", ip: "fd00::ffff"},
+		{name: "IPv6 hub address", cidr: "fd00::/64", ip: "fd00::1", wantErr: true},
+		{name: "IPv6 last address", cidr: "fd00::/64", ip: "fd00::ffff:ffff:ffff:ffff"},
+	}
+
+	used := map[string]bool{"10.0.0.7": true}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckNodeAddress(mustCIDR(t, tt.cidr), net.ParseIP(tt.ip), used, reservations)
+			if tt.wantErr {
+				if !errors.Is(err, ErrAddressUnavailable) {
+					t.Errorf("CheckNodeAddress() error = %v, want ErrAddressUnavailable", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("CheckNodeAddress() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+// Key rotation operations
+
+// RotateNetworkKey switches a network to a new server keypair in one
+// transaction: it updates the network, records the rotation and queues a
+// server_key_rotated event (with the given payload and the node's config_path)
+// for every node.
+func (s *Store) RotateNetworkKey(ctx context.Context, rotation *models.KeyRotation, newPrivateKey, endpoint string, payload map[string]interface{}) error {
+	if rotation.ID == "" {
+		rotation.ID = uuid.New().String()
+	}
+	rotation.CreatedAt = time.Now()
+	if payload == nil {
+		payload = map[string]interface{}{}
+	}
+	payload["rotation_id"] = rotation.ID
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE networks
+		SET server_private_key = $1, server_public_key = $2, listen_port = $3, server_endpoint = $4, updated_at = $5
+		WHERE id = $6
+	`, newPrivateKey, rotation.NewPublicKey, rotation.NewListenPort, endpoint, rotation.CreatedAt, rotation.NetworkID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO network_key_rotations (id, network_id, old_public_key, new_public_key, old_private_key,
+			old_listen_port, new_listen_port, overlap_interface, overlap_until, status, initiated_by, created_at, completed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`, rotation.ID, rotation.NetworkID, rotation.OldPublicKey, rotation.NewPublicKey, rotation.OldPrivateKey,
+		rotation.OldListenPort, rotation.NewListenPort, rotation.OverlapInterface, rotation.OverlapUntil,
+		rotation.Status, nullString(rotation.InitiatedBy), rotation.CreatedAt, rotation.CompletedAt); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO node_events (node_id, type, payload, created_at)
+		SELECT id, $2, $3::jsonb || jsonb_build_object('config_path', '/api/v1/nodes/' || id || '/config'), $4
+		FROM nodes WHERE network_id = $1
+	`, rotation.NetworkID, models.NodeEventServerKeyRotated, payloadJSON, rotation.CreatedAt); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const keyRotationColumns = `id, network_id, old_public_key, new_public_key, old_private_key, old_listen_port,
+	new_listen_port, overlap_interface, overlap_until, status, initiated_by, created_at, completed_at`
+
+// scanKeyRotation scans a row selected with keyRotationColumns
+func scanKeyRotation(row interface{ Scan(...interface{}) error }) (*models.KeyRotation, error) {
+	var rotation models.KeyRotation
+	var initiatedBy sql.NullString
+	if err := row.Scan(&rotation.ID, &rotation.NetworkID, &rotation.OldPublicKey, &rotation.NewPublicKey,
+		&rotation.OldPrivateKey, &rotation.OldListenPort, &rotation.NewListenPort, &rotation.OverlapInterface,
+		&rotation.OverlapUntil, &rotation.Status, &initiatedBy, &rotation.CreatedAt, &rotation.CompletedAt); err != nil {
+		return nil, err
+	}
+	rotation.InitiatedBy = initiatedBy.String
+	return &rotation, nil
+}
+
+// ListKeyRotations lists the key rotations of a network, newest first
+func (s *Store) ListKeyRotations(ctx context.Context, networkID string) ([]*models.KeyRotation, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+keyRotationColumns+`
+		FROM network_key_rotations WHERE network_id = $1 ORDER BY created_at DESC
+	`, networkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rotations []*models.KeyRotation
+	for rows.Next() {
+		rotation, err := scanKeyRotation(rows)
+		if err != nil {
+			return nil, err
+		}
+		rotations = append(rotations, rotation)
+	}
+	return rotations, rows.Err()
+}
+
+// ListOverlappingKeyRotations lists rotations whose old key is still served
+func (s *Store) ListOverlappingKeyRotations(ctx context.Context) ([]*models.KeyRotation, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+keyRotationColumns+`
+		FROM network_key_rotations WHERE status = $1 ORDER BY created_at
+	`, models.KeyRotationOverlap)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rotations []*models.KeyRotation
+	for rows.Next() {
+		rotation, err := scanKeyRotation(rows)
+		if err != nil {
+			return nil, err
+		}
+		rotations = append(rotations, rotation)
+	}
+	return rotations, rows.Err()
+}
+
+// CompleteKeyRotation marks a rotation completed and forgets the old private key
+func (s *Store) CompleteKeyRotation(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE network_key_rotations
+		SET status = $2, old_private_key = '', completed_at = $3
+		WHERE id = $1
+	`, id, models.KeyRotationCompleted, time.Now())
+	return err
+}
+
+// MarkNodeConfigFetched records that a node fetched a config containing the
+// given server public key
+func (s *Store) MarkNodeConfigFetched(ctx context.Context, nodeID, serverPublicKey string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE nodes SET config_server_key = $2, config_fetched_at = $3 WHERE id = $1
+	`, nodeID, serverPublicKey, time.Now())
+	return err
+}
+
+// ListNodeConfigStatus returns which server key each node of a network last
+// fetched, compared with the network's current server key
+func (s *Store) ListNodeConfigStatus(ctx context.Context, networkID string) ([]*models.NodeConfigStatus, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT n.id, n.name, n.config_server_key, n.config_fetched_at,
+		       n.config_server_key <> '' AND n.config_server_key = COALESCE(net.server_public_key, '')
+		FROM nodes n JOIN networks net ON net.id = n.network_id
+		WHERE n.network_id = $1 ORDER BY n.name
+	`, networkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var statuses []*models.NodeConfigStatus
+	for rows.Next() {
+		var status models.NodeConfigStatus
+		if err := rows.Scan(&status.NodeID, &status.Name, &status.ConfigServerKey, &status.ConfigFetchedAt, &status.UpToDate); err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, &status)
+	}
+	return statuses, rows.Err()
+}
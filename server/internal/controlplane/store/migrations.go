@@ -41,19 +41,11 @@ func (s *Store) Migrate(ctx context.Context) error {
 	}
 
 	// 3. Read migration files
-	entries, err := migrationFS.ReadDir("migrations_sql")
+	filenames, err := migrationFiles()
 	if err != nil {
-		return fmt.Errorf("failed to read migrations: %w", err)
+		return err
 	}
 
-	var filenames []string
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sql") {
-			filenames = append(filenames, entry.Name())
-		}
-	}
-	sort.Strings(filenames)
-
 	// 4. Apply only new migrations
 	newCount := 0
 	for _, filename := range filenames {
@@ -88,3 +80,55 @@ func (s *Store) Migrate(ctx context.Context) error {
 
 	return nil
 }
+
+// migrationFiles returns the embedded migration file names in apply order
+func migrationFiles() ([]string, error) {
+	entries, err := migrationFS.ReadDir("migrations_sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	var filenames []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".sql") {
+			filenames = append(filenames, entry.Name())
+		}
+	}
+	sort.Strings(filenames)
+	return filenames, nil
+}
+
+// MigrationStatus reports which embedded migrations have been applied and which are still pending
+func (s *Store) MigrationStatus(ctx context.Context) (applied []string, pending []string, err error) {
+	filenames, err := migrationFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	done := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, nil, err
+		}
+		done[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	for _, filename := range filenames {
+		if done[filename] {
+			applied = append(applied, filename)
+		} else {
+			pending = append(pending, filename)
+		}
+	}
+	return applied, pending, nil
+}
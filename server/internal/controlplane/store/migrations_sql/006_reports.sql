@@ -0,0 +1,17 @@
+-- Migration: 006_reports.sql
+-- Purpose: Store generated usage and inventory reports per network
+
+CREATE TABLE IF NOT EXISTS reports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    network_id UUID NOT NULL REFERENCES networks(id) ON DELETE CASCADE,
+    network_name VARCHAR(255) NOT NULL,
+    period VARCHAR(20) NOT NULL,
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    period_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    data JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(network_id, period_start, period_end)
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_network ON reports(network_id);
+CREATE INDEX IF NOT EXISTS idx_reports_period_end ON reports(period_end);
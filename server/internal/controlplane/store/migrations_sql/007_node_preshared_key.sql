@@ -0,0 +1,4 @@
+-- Migration: 007_node_preshared_key.sql
+-- Purpose: Optional per-node WireGuard preshared key (post-quantum hardening)
+
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS preshared_key TEXT NOT NULL DEFAULT '';
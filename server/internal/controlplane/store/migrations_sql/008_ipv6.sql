@@ -0,0 +1,12 @@
+-- Migration: 008_ipv6.sql
+-- Purpose: IPv6 and dual-stack VPN networks
+
+-- IPv6 CIDRs do not fit in VARCHAR(18)
+ALTER TABLE networks ALTER COLUMN cidr TYPE VARCHAR(64);
+
+-- Optional IPv6 range of a dual-stack network (primary cidr is IPv4)
+ALTER TABLE networks ADD COLUMN IF NOT EXISTS cidr6 VARCHAR(64) NOT NULL DEFAULT '';
+
+-- IPv6 address of a node in a dual-stack network
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS virtual_ip6 INET;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_nodes_network_virtual_ip6 ON nodes(network_id, virtual_ip6);
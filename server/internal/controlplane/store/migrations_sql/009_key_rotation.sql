@@ -0,0 +1,39 @@
+-- Migration: 009_key_rotation.sql
+-- Purpose: Network server key rotation, config fetch tracking and node events
+
+-- Server public key contained in the last config a node fetched
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS config_server_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS config_fetched_at TIMESTAMP WITH TIME ZONE;
+
+-- Server key rotations per network. While status is 'overlap' the old key
+-- keeps running on a second interface until overlap_until.
+CREATE TABLE IF NOT EXISTS network_key_rotations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    network_id UUID NOT NULL REFERENCES networks(id) ON DELETE CASCADE,
+    old_public_key TEXT NOT NULL DEFAULT '',
+    new_public_key TEXT NOT NULL,
+    old_private_key TEXT NOT NULL DEFAULT '', -- Only kept while the overlap interface runs
+    old_listen_port INTEGER NOT NULL DEFAULT 0,
+    new_listen_port INTEGER NOT NULL DEFAULT 0,
+    overlap_interface VARCHAR(15) NOT NULL DEFAULT '',
+    overlap_until TIMESTAMP WITH TIME ZONE,
+    status VARCHAR(20) NOT NULL DEFAULT 'completed',
+    initiated_by VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_key_rotations_network ON network_key_rotations(network_id);
+CREATE INDEX IF NOT EXISTS idx_key_rotations_status ON network_key_rotations(status);
+
+-- Events queued for nodes, delivered in check-in responses
+CREATE TABLE IF NOT EXISTS node_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    node_id UUID NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    payload JSONB DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_events_pending ON node_events(node_id) WHERE delivered_at IS NULL;
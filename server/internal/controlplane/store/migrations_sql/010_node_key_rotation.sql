@@ -0,0 +1,11 @@
+-- Migration: 010_node_key_rotation.sql
+-- Purpose: Per-node key rotation with a grace period and optional schedule
+
+-- Key replaced by the last rotation; accepted until the node uses the new
+-- key or previous_key_expires_at passes
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS previous_public_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS previous_key_expires_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS key_rotated_at TIMESTAMP WITH TIME ZONE;
+
+-- Automatic rotation interval in days (0 = manual only)
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS key_rotation_days INTEGER NOT NULL DEFAULT 0;
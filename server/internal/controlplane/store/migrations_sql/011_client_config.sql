@@ -0,0 +1,6 @@
+-- Migration: 011_client_config.sql
+-- Purpose: Client config options (DNS, MTU, keepalive, ListenPort, AllowedIPs
+-- mode) as network defaults with per-node overrides
+
+ALTER TABLE networks ADD COLUMN IF NOT EXISTS client_config JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS client_config JSONB;
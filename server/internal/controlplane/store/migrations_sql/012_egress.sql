@@ -0,0 +1,5 @@
+-- Migration: 012_egress.sql
+-- Purpose: Per-network internet egress (NAT via an uplink interface, optional
+-- SNAT source address, DNS leak prevention)
+
+ALTER TABLE networks ADD COLUMN IF NOT EXISTS egress JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,14 @@
+-- Migration: 013_subnet_routes.sql
+-- Purpose: Subnet routers - LAN subnets advertised by nodes and approved by an
+-- admin, usable as VPN firewall rule endpoints
+
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS advertised_routes JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS approved_routes JSONB NOT NULL DEFAULT '[]';
+
+-- "subnet" endpoints reference the router node (and optionally one of its subnets)
+ALTER TABLE firewall_rules DROP CONSTRAINT IF EXISTS firewall_rules_source_type_check;
+ALTER TABLE firewall_rules ADD CONSTRAINT firewall_rules_source_type_check
+    CHECK (source_type IN ('any', 'network', 'node', 'subnet', 'custom'));
+ALTER TABLE firewall_rules DROP CONSTRAINT IF EXISTS firewall_rules_dest_type_check;
+ALTER TABLE firewall_rules ADD CONSTRAINT firewall_rules_dest_type_check
+    CHECK (dest_type IN ('any', 'network', 'node', 'subnet', 'custom'));
@@ -0,0 +1,6 @@
+-- Migration: 014_exit_nodes.sql
+-- Purpose: Exit nodes - peers that carry the internet traffic of networks
+-- (networks.egress exit_node mode) or of individual nodes
+
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS exit_node BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS exit_node_id UUID REFERENCES nodes(id) ON DELETE SET NULL;
@@ -0,0 +1,5 @@
+-- Migration: 015_mesh.sql
+-- Purpose: Mesh mode - generated configs list the other nodes of the network
+-- as direct peers, using the endpoints the nodes report on check-in
+
+ALTER TABLE networks ADD COLUMN IF NOT EXISTS mesh BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,22 @@
+-- Migration: 016_gateways.sql
+-- Purpose: Additional gateway hubs per network (own endpoint and keypair) with
+-- heartbeat based health checks and failover of the active gateway
+
+CREATE TABLE IF NOT EXISTS gateways (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    network_id UUID NOT NULL REFERENCES networks(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    endpoint VARCHAR(255) NOT NULL,
+    private_key TEXT NOT NULL,
+    public_key VARCHAR(44) NOT NULL,
+    priority INTEGER NOT NULL DEFAULT 100,
+    status VARCHAR(20) NOT NULL DEFAULT 'unknown',
+    last_seen TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(network_id, name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_gateways_network ON gateways(network_id);
+
+-- NULL means the built-in hub on the control plane host is active
+ALTER TABLE networks ADD COLUMN IF NOT EXISTS active_gateway_id UUID REFERENCES gateways(id) ON DELETE SET NULL;
@@ -0,0 +1,8 @@
+-- Migration: 017_gateway_agents.sql
+-- Purpose: Remote gateway agents - per-gateway access tokens, and networks
+-- served only by remote gateways (no interface on the control plane host)
+
+ALTER TABLE gateways ADD COLUMN IF NOT EXISTS token_hash TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_gateways_token_hash ON gateways(token_hash);
+
+ALTER TABLE networks ADD COLUMN IF NOT EXISTS remote_hub BOOLEAN NOT NULL DEFAULT false;
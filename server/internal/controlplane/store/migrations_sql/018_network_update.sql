@@ -0,0 +1,11 @@
+-- Migration: 018_network_update.sql
+-- Purpose: Renumbering networks - node address uniqueness is checked at commit,
+-- so nodes can swap addresses inside one transaction
+
+ALTER TABLE nodes DROP CONSTRAINT IF EXISTS nodes_network_id_virtual_ip_key;
+ALTER TABLE nodes ADD CONSTRAINT nodes_network_id_virtual_ip_key
+    UNIQUE (network_id, virtual_ip) DEFERRABLE INITIALLY IMMEDIATE;
+
+DROP INDEX IF EXISTS idx_nodes_network_virtual_ip6;
+ALTER TABLE nodes ADD CONSTRAINT nodes_network_id_virtual_ip6_key
+    UNIQUE (network_id, virtual_ip6) DEFERRABLE INITIALLY IMMEDIATE;
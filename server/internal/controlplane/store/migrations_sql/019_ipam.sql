@@ -0,0 +1,15 @@
+-- Migration: 019_ipam.sql
+-- Purpose: IP address management - reserved ranges (only assigned on request)
+-- and excluded addresses (never assigned) per network
+
+CREATE TABLE IF NOT EXISTS ip_reservations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    network_id UUID NOT NULL REFERENCES networks(id) ON DELETE CASCADE,
+    start_ip INET NOT NULL,
+    end_ip INET NOT NULL,
+    kind VARCHAR(16) NOT NULL DEFAULT 'reserved',
+    description TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_ip_reservations_network ON ip_reservations(network_id);
@@ -0,0 +1,23 @@
+-- Migration: 020_latency_probes.sql
+-- Purpose: Latency probing - the hub probes online nodes over the tunnel and
+-- keeps RTT, jitter and loss per node as a time series
+
+ALTER TABLE networks ADD COLUMN IF NOT EXISTS probe_interval INTEGER NOT NULL DEFAULT 30;
+
+CREATE TABLE IF NOT EXISTS node_probes (
+    id BIGSERIAL PRIMARY KEY,
+    node_id UUID NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    probed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    method VARCHAR(10) NOT NULL,
+    target INET NOT NULL,
+    sent INTEGER NOT NULL,
+    received INTEGER NOT NULL,
+    rtt_avg_ms DOUBLE PRECISION,
+    rtt_min_ms DOUBLE PRECISION,
+    rtt_max_ms DOUBLE PRECISION,
+    jitter_ms DOUBLE PRECISION,
+    loss_percent DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_probes_node_time ON node_probes(node_id, probed_at DESC);
+CREATE INDEX IF NOT EXISTS idx_node_probes_time ON node_probes(probed_at);
@@ -0,0 +1,28 @@
+-- Migration: 021_enrollment_tokens.sql
+-- Purpose: Enrollment tokens - nodes generate their keypair locally and
+-- register only the public key; private keys are no longer kept in labels
+
+CREATE TABLE IF NOT EXISTS enrollment_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    network_id UUID NOT NULL REFERENCES networks(id) ON DELETE CASCADE,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    labels JSONB NOT NULL DEFAULT '{}',
+    client_config JSONB,
+    virtual_ip INET,
+    virtual_ip6 INET,
+    preshared_key BOOLEAN NOT NULL DEFAULT false,
+    node_expires_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    node_id UUID REFERENCES nodes(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_enrollment_tokens_network ON enrollment_tokens(network_id);
+
+-- Scheduled node key rotations ask the node for a new public key
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS key_rotation_requested_at TIMESTAMP WITH TIME ZONE;
+
+-- Private keys generated by the server before enrollment tokens
+UPDATE nodes SET labels = labels - 'wireguard_private_key' WHERE labels ? 'wireguard_private_key';
@@ -0,0 +1,15 @@
+-- Migration: 022_traffic_samples.sql
+-- Purpose: Periodic snapshots of per-node WireGuard transfer counters, so
+-- reports can compute bandwidth within their period
+
+CREATE TABLE IF NOT EXISTS node_traffic_samples (
+    id BIGSERIAL PRIMARY KEY,
+    node_id UUID NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    network_id UUID NOT NULL REFERENCES networks(id) ON DELETE CASCADE,
+    sampled_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    rx_bytes BIGINT NOT NULL,
+    tx_bytes BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_traffic_samples_network_time ON node_traffic_samples(network_id, sampled_at);
+CREATE INDEX IF NOT EXISTS idx_node_traffic_samples_time ON node_traffic_samples(sampled_at);
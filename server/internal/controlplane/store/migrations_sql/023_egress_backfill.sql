@@ -0,0 +1,9 @@
+-- Migration: 023_egress_backfill.sql
+-- Purpose: Keep internet egress working for networks created before egress
+-- became a setting. Their interface configs NATed everything out of the
+-- default route's uplink, which is what nat mode without an interface does.
+-- Networks created since store an explicit mode, so '{}' only marks old ones.
+
+UPDATE networks SET egress = '{"mode": "nat"}' WHERE egress = '{}'::jsonb;
+
+ALTER TABLE networks ALTER COLUMN egress SET DEFAULT '{"mode": "disabled"}';
@@ -0,0 +1,6 @@
+-- Migration: 024_node_tokens.sql
+-- Purpose: Per-node access tokens - node agents call their own node endpoints
+-- (peers, gateways, check-in, key rotation) without the admin credentials
+
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS token_hash TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_nodes_token_hash ON nodes(token_hash);
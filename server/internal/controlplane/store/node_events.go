@@ -0,0 +1,63 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+// Node event operations
+
+// CreateNodeEvent queues an event for a node
+func (s *Store) CreateNodeEvent(ctx context.Context, event *models.NodeEvent) error {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	event.CreatedAt = time.Now()
+
+	payloadJSON, err := json.Marshal(event.Payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO node_events (id, node_id, type, payload, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, event.ID, event.NodeID, event.Type, payloadJSON, event.CreatedAt)
+	return err
+}
+
+// TakeNodeEvents returns the undelivered events of a node, oldest first, and
+// marks them delivered
+func (s *Store) TakeNodeEvents(ctx context.Context, nodeID string) ([]*models.NodeEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		UPDATE node_events SET delivered_at = $2
+		WHERE node_id = $1 AND delivered_at IS NULL
+		RETURNING id, node_id, type, payload, created_at, delivered_at
+	`, nodeID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*models.NodeEvent
+	for rows.Next() {
+		var event models.NodeEvent
+		var payloadJSON []byte
+		if err := rows.Scan(&event.ID, &event.NodeID, &event.Type, &payloadJSON, &event.CreatedAt, &event.DeliveredAt); err != nil {
+			return nil, err
+		}
+		json.Unmarshal(payloadJSON, &event.Payload)
+		events = append(events, &event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].CreatedAt.Before(events[j].CreatedAt) })
+	return events, nil
+}
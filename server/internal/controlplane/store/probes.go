@@ -0,0 +1,86 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"time"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+// Latency probe operations
+
+const nodeProbeColumns = `node_id, probed_at, method, host(target), sent, received, rtt_avg_ms, rtt_min_ms, rtt_max_ms, jitter_ms, loss_percent`
+
+// CreateNodeProbe stores the result of a probe round
+func (s *Store) CreateNodeProbe(ctx context.Context, probe *models.NodeProbe) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO node_probes (node_id, probed_at, method, target, sent, received, rtt_avg_ms, rtt_min_ms, rtt_max_ms, jitter_ms, loss_percent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`, probe.NodeID, probe.ProbedAt, probe.Method, probe.Target.String(), probe.Sent, probe.Received,
+		probe.RTTAvgMs, probe.RTTMinMs, probe.RTTMaxMs, probe.JitterMs, probe.LossPercent)
+	return err
+}
+
+// ListNodeProbes returns the probe rounds of a node since a time, oldest first
+func (s *Store) ListNodeProbes(ctx context.Context, nodeID string, since time.Time) ([]*models.NodeProbe, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+nodeProbeColumns+`
+		FROM node_probes WHERE node_id = $1 AND probed_at >= $2
+		ORDER BY probed_at
+	`, nodeID, since)
+	if err != nil {
+		return nil, err
+	}
+	return scanNodeProbes(rows)
+}
+
+// LatestNodeProbes returns the latest probe round of every probed node
+func (s *Store) LatestNodeProbes(ctx context.Context) ([]*models.NodeProbe, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT ON (node_id) `+nodeProbeColumns+`
+		FROM node_probes ORDER BY node_id, probed_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	return scanNodeProbes(rows)
+}
+
+// DeleteNodeProbesBefore drops probe rounds older than a time
+func (s *Store) DeleteNodeProbesBefore(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM node_probes WHERE probed_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func scanNodeProbes(rows *sql.Rows) ([]*models.NodeProbe, error) {
+	defer rows.Close()
+	probes := []*models.NodeProbe{}
+	for rows.Next() {
+		var probe models.NodeProbe
+		var target string
+		var avg, min, max, jitter sql.NullFloat64
+		if err := rows.Scan(&probe.NodeID, &probe.ProbedAt, &probe.Method, &target, &probe.Sent, &probe.Received,
+			&avg, &min, &max, &jitter, &probe.LossPercent); err != nil {
+			return nil, err
+		}
+		probe.Target = net.ParseIP(target)
+		probe.RTTAvgMs = nullFloat(avg)
+		probe.RTTMinMs = nullFloat(min)
+		probe.RTTMaxMs = nullFloat(max)
+		probe.JitterMs = nullFloat(jitter)
+		probes = append(probes, &probe)
+	}
+	return probes, rows.Err()
+}
+
+func nullFloat(v sql.NullFloat64) *float64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Float64
+}
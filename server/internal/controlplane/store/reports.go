@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+// Report operations
+
+// SaveReport stores a report. Regenerating a report for the same network and
+// period replaces the previous one.
+func (s *Store) SaveReport(ctx context.Context, report *models.Report) error {
+	if report.ID == "" {
+		report.ID = uuid.New().String()
+	}
+	report.CreatedAt = time.Now()
+
+	dataJSON, err := json.Marshal(report.Data)
+	if err != nil {
+		return err
+	}
+
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO reports (id, network_id, network_name, period, period_start, period_end, data, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (network_id, period_start, period_end) DO UPDATE
+		SET network_name = EXCLUDED.network_name, period = EXCLUDED.period,
+		    data = EXCLUDED.data, created_at = EXCLUDED.created_at
+		RETURNING id
+	`, report.ID, report.NetworkID, report.NetworkName, report.Period, report.PeriodStart, report.PeriodEnd,
+		dataJSON, report.CreatedAt).Scan(&report.ID)
+}
+
+// GetReport retrieves a report including its data
+func (s *Store) GetReport(ctx context.Context, id string) (*models.Report, error) {
+	var report models.Report
+	var dataJSON []byte
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, network_id, network_name, period, period_start, period_end, data, created_at
+		FROM reports WHERE id = $1
+	`, id).Scan(&report.ID, &report.NetworkID, &report.NetworkName, &report.Period,
+		&report.PeriodStart, &report.PeriodEnd, &dataJSON, &report.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report.Data = &models.ReportData{}
+	json.Unmarshal(dataJSON, report.Data)
+	return &report, nil
+}
+
+// GetLatestReport returns the most recent report for a network that ended at or before the given time
+func (s *Store) GetLatestReport(ctx context.Context, networkID string, before time.Time) (*models.Report, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id FROM reports
+		WHERE network_id = $1 AND period_end <= $2
+		ORDER BY period_end DESC LIMIT 1
+	`, networkID, before).Scan(&id)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.GetReport(ctx, id)
+}
+
+// ReportExists checks whether a report for the network and period was already generated
+func (s *Store) ReportExists(ctx context.Context, networkID string, start, end time.Time) (bool, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM reports WHERE network_id = $1 AND period_start = $2 AND period_end = $3)
+	`, networkID, start, end).Scan(&exists)
+	return exists, err
+}
+
+// ListReports lists reports (without data), newest first. An empty networkID lists all networks.
+func (s *Store) ListReports(ctx context.Context, networkID string) ([]*models.Report, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, network_id, network_name, period, period_start, period_end, created_at
+		FROM reports
+		WHERE ($1 = '' OR network_id::text = $1)
+		ORDER BY period_end DESC, network_name
+	`, networkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []*models.Report
+	for rows.Next() {
+		var report models.Report
+		if err := rows.Scan(&report.ID, &report.NetworkID, &report.NetworkName, &report.Period,
+			&report.PeriodStart, &report.PeriodEnd, &report.CreatedAt); err != nil {
+			return nil, err
+		}
+		reports = append(reports, &report)
+	}
+	return reports, rows.Err()
+}
+
+// DeleteReport deletes a report
+func (s *Store) DeleteReport(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM reports WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListFirewallAuditLogs returns firewall audit entries created in [since, until)
+func (s *Store) ListFirewallAuditLogs(ctx context.Context, since, until time.Time) ([]*models.FirewallAuditLog, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, action, details, user_ip, created_at
+		FROM firewall_audit_log
+		WHERE created_at >= $1 AND created_at < $2
+		ORDER BY created_at ASC
+	`, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []*models.FirewallAuditLog
+	for rows.Next() {
+		var entry models.FirewallAuditLog
+		var detailsJSON []byte
+		var userIP sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.Action, &detailsJSON, &userIP, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.UserIP = userIP.String
+		json.Unmarshal(detailsJSON, &entry.Details)
+		logs = append(logs, &entry)
+	}
+	return logs, rows.Err()
+}
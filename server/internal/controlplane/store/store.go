@@ -42,20 +42,35 @@ func (s *Store) Close() error {
 	return s.db.Close()
 }
 
+// Ping verifies the database connection is alive
+func (s *Store) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
 // Network operations
 
 // CreateNetwork creates a new network
 func (s *Store) CreateNetwork(ctx context.Context, network *models.Network) error {
+	return insertNetwork(ctx, s.db, network)
+}
+
+func insertNetwork(ctx context.Context, q queryer, network *models.Network) error {
 	if network.ID == "" {
 		network.ID = uuid.New().String()
 	}
 	network.CreatedAt = time.Now()
 	network.UpdatedAt = time.Now()
 	
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO networks (id, name, cidr, server_private_key, server_public_key, server_endpoint, listen_port, interface_name, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-	`, network.ID, network.Name, network.CIDR, network.ServerPrivateKey, network.ServerPublicKey, network.ServerEndpoint, network.ListenPort, network.InterfaceName, network.CreatedAt, network.UpdatedAt)
+	clientConfigJSON, _ := json.Marshal(network.ClientConfig)
+	if network.Egress.Mode == "" {
+		network.Egress.Mode = models.EgressDisabled
+	}
+	egressJSON, _ := json.Marshal(network.Egress)
+	
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO networks (id, name, cidr, cidr6, server_private_key, server_public_key, server_endpoint, listen_port, interface_name, client_config, egress, mesh, remote_hub, probe_interval, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+	`, network.ID, network.Name, network.CIDR, network.CIDR6, network.ServerPrivateKey, network.ServerPublicKey, network.ServerEndpoint, network.ListenPort, network.InterfaceName, clientConfigJSON, egressJSON, network.Mesh, network.RemoteHub, network.ProbeInterval, network.CreatedAt, network.UpdatedAt)
 	
 	return err
 }
@@ -64,10 +79,11 @@ func (s *Store) CreateNetwork(ctx context.Context, network *models.Network) erro
 func (s *Store) GetNetwork(ctx context.Context, id string) (*models.Network, error) {
 	var network models.Network
 	var serverPrivateKey, serverPublicKey, serverEndpoint sql.NullString
+	var clientConfigJSON, egressJSON []byte
 	err := s.db.QueryRowContext(ctx, `
-		SELECT id, name, cidr, server_private_key, server_public_key, server_endpoint, listen_port, interface_name, created_at, updated_at
+		SELECT id, name, cidr, cidr6, server_private_key, server_public_key, server_endpoint, listen_port, interface_name, client_config, egress, mesh, active_gateway_id, remote_hub, probe_interval, created_at, updated_at
 		FROM networks WHERE id = $1
-	`, id).Scan(&network.ID, &network.Name, &network.CIDR, &serverPrivateKey, &serverPublicKey, &serverEndpoint, &network.ListenPort, &network.InterfaceName, &network.CreatedAt, &network.UpdatedAt)
+	`, id).Scan(&network.ID, &network.Name, &network.CIDR, &network.CIDR6, &serverPrivateKey, &serverPublicKey, &serverEndpoint, &network.ListenPort, &network.InterfaceName, &clientConfigJSON, &egressJSON, &network.Mesh, &network.ActiveGatewayID, &network.RemoteHub, &network.ProbeInterval, &network.CreatedAt, &network.UpdatedAt)
 	
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -75,6 +91,8 @@ func (s *Store) GetNetwork(ctx context.Context, id string) (*models.Network, err
 	network.ServerPrivateKey = serverPrivateKey.String
 	network.ServerPublicKey = serverPublicKey.String
 	network.ServerEndpoint = serverEndpoint.String
+	json.Unmarshal(clientConfigJSON, &network.ClientConfig)
+	json.Unmarshal(egressJSON, &network.Egress)
 	return &network, err
 }
 
@@ -82,10 +100,11 @@ func (s *Store) GetNetwork(ctx context.Context, id string) (*models.Network, err
 func (s *Store) GetNetworkByName(ctx context.Context, name string) (*models.Network, error) {
 	var network models.Network
 	var serverPrivateKey, serverPublicKey, serverEndpoint sql.NullString
+	var clientConfigJSON, egressJSON []byte
 	err := s.db.QueryRowContext(ctx, `
-		SELECT id, name, cidr, server_private_key, server_public_key, server_endpoint, listen_port, interface_name, created_at, updated_at
+		SELECT id, name, cidr, cidr6, server_private_key, server_public_key, server_endpoint, listen_port, interface_name, client_config, egress, mesh, active_gateway_id, remote_hub, probe_interval, created_at, updated_at
 		FROM networks WHERE name = $1
-	`, name).Scan(&network.ID, &network.Name, &network.CIDR, &serverPrivateKey, &serverPublicKey, &serverEndpoint, &network.ListenPort, &network.InterfaceName, &network.CreatedAt, &network.UpdatedAt)
+	`, name).Scan(&network.ID, &network.Name, &network.CIDR, &network.CIDR6, &serverPrivateKey, &serverPublicKey, &serverEndpoint, &network.ListenPort, &network.InterfaceName, &clientConfigJSON, &egressJSON, &network.Mesh, &network.ActiveGatewayID, &network.RemoteHub, &network.ProbeInterval, &network.CreatedAt, &network.UpdatedAt)
 	
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -93,13 +112,15 @@ func (s *Store) GetNetworkByName(ctx context.Context, name string) (*models.Netw
 	network.ServerPrivateKey = serverPrivateKey.String
 	network.ServerPublicKey = serverPublicKey.String
 	network.ServerEndpoint = serverEndpoint.String
+	json.Unmarshal(clientConfigJSON, &network.ClientConfig)
+	json.Unmarshal(egressJSON, &network.Egress)
 	return &network, err
 }
 
 // ListNetworks lists all networks
 func (s *Store) ListNetworks(ctx context.Context) ([]*models.Network, error) {
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, name, cidr, server_private_key, server_public_key, server_endpoint, listen_port, interface_name, created_at, updated_at
+		SELECT id, name, cidr, cidr6, server_private_key, server_public_key, server_endpoint, listen_port, interface_name, client_config, egress, mesh, active_gateway_id, remote_hub, probe_interval, created_at, updated_at
 		FROM networks ORDER BY name
 	`)
 	if err != nil {
@@ -111,12 +132,15 @@ func (s *Store) ListNetworks(ctx context.Context) ([]*models.Network, error) {
 	for rows.Next() {
 		var network models.Network
 		var serverPrivateKey, serverPublicKey, serverEndpoint sql.NullString
-		if err := rows.Scan(&network.ID, &network.Name, &network.CIDR, &serverPrivateKey, &serverPublicKey, &serverEndpoint, &network.ListenPort, &network.InterfaceName, &network.CreatedAt, &network.UpdatedAt); err != nil {
+		var clientConfigJSON, egressJSON []byte
+		if err := rows.Scan(&network.ID, &network.Name, &network.CIDR, &network.CIDR6, &serverPrivateKey, &serverPublicKey, &serverEndpoint, &network.ListenPort, &network.InterfaceName, &clientConfigJSON, &egressJSON, &network.Mesh, &network.ActiveGatewayID, &network.RemoteHub, &network.ProbeInterval, &network.CreatedAt, &network.UpdatedAt); err != nil {
 			return nil, err
 		}
 		network.ServerPrivateKey = serverPrivateKey.String
 		network.ServerPublicKey = serverPublicKey.String
 		network.ServerEndpoint = serverEndpoint.String
+		json.Unmarshal(clientConfigJSON, &network.ClientConfig)
+		json.Unmarshal(egressJSON, &network.Egress)
 		networks = append(networks, &network)
 	}
 	return networks, rows.Err()
@@ -134,6 +158,38 @@ func (s *Store) UpdateNetworkCIDR(ctx context.Context, id, cidr string) error {
 	return err
 }
 
+// UpdateNetworkCIDR6 updates the IPv6 range of a dual-stack network
+func (s *Store) UpdateNetworkCIDR6(ctx context.Context, id, cidr6 string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE networks SET cidr6 = $1, updated_at = $2 WHERE id = $3`, cidr6, time.Now(), id)
+	return err
+}
+
+// UpdateNetworkClientConfig updates the client config defaults of a network
+func (s *Store) UpdateNetworkClientConfig(ctx context.Context, id string, options models.ClientConfigOptions) error {
+	optionsJSON, _ := json.Marshal(options)
+	_, err := s.db.ExecContext(ctx, `UPDATE networks SET client_config = $1, updated_at = $2 WHERE id = $3`, optionsJSON, time.Now(), id)
+	return err
+}
+
+// UpdateNetworkEgress updates the internet egress settings of a network
+func (s *Store) UpdateNetworkEgress(ctx context.Context, id string, egress models.EgressConfig) error {
+	egressJSON, _ := json.Marshal(egress)
+	_, err := s.db.ExecContext(ctx, `UPDATE networks SET egress = $1, updated_at = $2 WHERE id = $3`, egressJSON, time.Now(), id)
+	return err
+}
+
+// UpdateNetworkMesh turns mesh mode of a network on or off
+func (s *Store) UpdateNetworkMesh(ctx context.Context, id string, mesh bool) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE networks SET mesh = $1, updated_at = $2 WHERE id = $3`, mesh, time.Now(), id)
+	return err
+}
+
+// UpdateNetworkProbeInterval sets the latency probe interval of a network
+func (s *Store) UpdateNetworkProbeInterval(ctx context.Context, id string, seconds int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE networks SET probe_interval = $1, updated_at = $2 WHERE id = $3`, seconds, time.Now(), id)
+	return err
+}
+
 // UpdateNetworkKeys updates the WireGuard keys of a network
 func (s *Store) UpdateNetworkKeys(ctx context.Context, id, privateKey, publicKey string) error {
 	_, err := s.db.ExecContext(ctx, `
@@ -143,10 +199,67 @@ func (s *Store) UpdateNetworkKeys(ctx context.Context, id, privateKey, publicKey
 	return err
 }
 
+// UpdateNetworkSettings stores the name, endpoint, port and address ranges of
+// a network together with the new node addresses of a renumbering. With a
+// payload, every node of the network gets a network_updated event carrying it
+// and the node's config_path.
+func (s *Store) UpdateNetworkSettings(ctx context.Context, network *models.Network, changes []*models.NodeAddressChange, payload map[string]interface{}) error {
+	network.UpdatedAt = time.Now()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Renumbered nodes may take each other's addresses
+	if _, err := tx.ExecContext(ctx, `
+		SET CONSTRAINTS nodes_network_id_virtual_ip_key, nodes_network_id_virtual_ip6_key DEFERRED
+	`); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE networks
+		SET name = $2, server_endpoint = $3, listen_port = $4, cidr = $5, cidr6 = $6, updated_at = $7
+		WHERE id = $1
+	`, network.ID, network.Name, network.ServerEndpoint, network.ListenPort, network.CIDR, network.CIDR6, network.UpdatedAt); err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE nodes SET virtual_ip = $2, virtual_ip6 = $3 WHERE id = $1
+		`, change.NodeID, change.NewIP.String(), nullIP(change.NewIP6)); err != nil {
+			return fmt.Errorf("failed to renumber node %s: %w", change.NodeName, err)
+		}
+	}
+
+	if payload != nil {
+		payloadJSON, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO node_events (node_id, type, payload, created_at)
+			SELECT id, $2, $3::jsonb || jsonb_build_object('config_path', '/api/v1/nodes/' || id || '/config'), $4
+			FROM nodes WHERE network_id = $1
+		`, network.ID, models.NodeEventNetworkUpdated, payloadJSON, network.UpdatedAt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 // Node operations
 
-// CreateNode creates a new node
+// CreateNode creates a new node with the addresses already set on it
 func (s *Store) CreateNode(ctx context.Context, node *models.Node) error {
+	return insertNode(ctx, s.db, node)
+}
+
+func insertNode(ctx context.Context, q queryer, node *models.Node) error {
 	if node.ID == "" {
 		node.ID = uuid.New().String()
 	}
@@ -155,11 +268,11 @@ func (s *Store) CreateNode(ctx context.Context, node *models.Node) error {
 	labelsJSON, _ := json.Marshal(node.Labels)
 	nodeInfoJSON, _ := json.Marshal(node.NodeInfo)
 	
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO nodes (id, network_id, name, virtual_ip, public_key, labels, status, node_info, expires_at, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-	`, node.ID, node.NetworkID, node.Name, node.VirtualIP.String(), node.PublicKey, 
-	   labelsJSON, node.Status, nodeInfoJSON, node.ExpiresAt, node.CreatedAt)
+	_, err := q.ExecContext(ctx, `
+		INSERT INTO nodes (id, network_id, name, virtual_ip, virtual_ip6, public_key, preshared_key, labels, status, node_info, expires_at, client_config, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+	`, node.ID, node.NetworkID, node.Name, node.VirtualIP.String(), nullIP(node.VirtualIP6), node.PublicKey, node.PresharedKey,
+	   labelsJSON, node.Status, nodeInfoJSON, node.ExpiresAt, nodeClientConfigJSON(node), node.CreatedAt)
 	
 	return err
 }
@@ -168,14 +281,17 @@ func (s *Store) CreateNode(ctx context.Context, node *models.Node) error {
 func (s *Store) GetNode(ctx context.Context, id string) (*models.Node, error) {
 	var node models.Node
 	var virtualIP string
-	var labelsJSON, nodeInfoJSON []byte
+	var virtualIP6 sql.NullString
+	var labelsJSON, nodeInfoJSON, clientConfigJSON, advertisedJSON, approvedJSON, endpointsJSON []byte
 	var lastSeen sql.NullTime
 	
 	err := s.db.QueryRowContext(ctx, `
-		SELECT id, network_id, name, virtual_ip, public_key, labels, status, last_seen, node_info, expires_at, created_at
+		SELECT id, network_id, name, virtual_ip, virtual_ip6, public_key, preshared_key, labels, status, last_seen, node_info, expires_at,
+		       previous_public_key, previous_key_expires_at, key_rotated_at, key_rotation_days, client_config, advertised_routes, approved_routes, exit_node, exit_node_id, endpoints, created_at
 		FROM nodes WHERE id = $1
-	`, id).Scan(&node.ID, &node.NetworkID, &node.Name, &virtualIP, &node.PublicKey,
-		&labelsJSON, &node.Status, &lastSeen, &nodeInfoJSON, &node.ExpiresAt, &node.CreatedAt)
+	`, id).Scan(&node.ID, &node.NetworkID, &node.Name, &virtualIP, &virtualIP6, &node.PublicKey, &node.PresharedKey,
+		&labelsJSON, &node.Status, &lastSeen, &nodeInfoJSON, &node.ExpiresAt,
+		&node.PreviousPublicKey, &node.PreviousKeyExpiresAt, &node.KeyRotatedAt, &node.KeyRotationDays, &clientConfigJSON, &advertisedJSON, &approvedJSON, &node.ExitNode, &node.ExitNodeID, &endpointsJSON, &node.CreatedAt)
 	
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -185,11 +301,20 @@ func (s *Store) GetNode(ctx context.Context, id string) (*models.Node, error) {
 	}
 	
 	node.VirtualIP = net.ParseIP(virtualIP)
+	if virtualIP6.Valid {
+		node.VirtualIP6 = net.ParseIP(virtualIP6.String)
+	}
 	if lastSeen.Valid {
 		node.LastSeen = lastSeen.Time
 	}
 	json.Unmarshal(labelsJSON, &node.Labels)
 	json.Unmarshal(nodeInfoJSON, &node.NodeInfo)
+	if len(clientConfigJSON) > 0 {
+		json.Unmarshal(clientConfigJSON, &node.ClientConfig)
+	}
+	json.Unmarshal(advertisedJSON, &node.AdvertisedRoutes)
+	json.Unmarshal(approvedJSON, &node.ApprovedRoutes)
+	json.Unmarshal(endpointsJSON, &node.Endpoints)
 	
 	return &node, nil
 }
@@ -198,14 +323,17 @@ func (s *Store) GetNode(ctx context.Context, id string) (*models.Node, error) {
 func (s *Store) GetNodeByName(ctx context.Context, networkID, name string) (*models.Node, error) {
 	var node models.Node
 	var virtualIP string
-	var labelsJSON, nodeInfoJSON []byte
+	var virtualIP6 sql.NullString
+	var labelsJSON, nodeInfoJSON, clientConfigJSON, advertisedJSON, approvedJSON, endpointsJSON []byte
 	var lastSeen sql.NullTime
 	
 	err := s.db.QueryRowContext(ctx, `
-		SELECT id, network_id, name, virtual_ip, public_key, labels, status, last_seen, node_info, expires_at, created_at
+		SELECT id, network_id, name, virtual_ip, virtual_ip6, public_key, preshared_key, labels, status, last_seen, node_info, expires_at,
+		       previous_public_key, previous_key_expires_at, key_rotated_at, key_rotation_days, client_config, advertised_routes, approved_routes, exit_node, exit_node_id, endpoints, created_at
 		FROM nodes WHERE network_id = $1 AND name = $2
-	`, networkID, name).Scan(&node.ID, &node.NetworkID, &node.Name, &virtualIP, &node.PublicKey,
-		&labelsJSON, &node.Status, &lastSeen, &nodeInfoJSON, &node.ExpiresAt, &node.CreatedAt)
+	`, networkID, name).Scan(&node.ID, &node.NetworkID, &node.Name, &virtualIP, &virtualIP6, &node.PublicKey, &node.PresharedKey,
+		&labelsJSON, &node.Status, &lastSeen, &nodeInfoJSON, &node.ExpiresAt,
+		&node.PreviousPublicKey, &node.PreviousKeyExpiresAt, &node.KeyRotatedAt, &node.KeyRotationDays, &clientConfigJSON, &advertisedJSON, &approvedJSON, &node.ExitNode, &node.ExitNodeID, &endpointsJSON, &node.CreatedAt)
 	
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -215,11 +343,20 @@ func (s *Store) GetNodeByName(ctx context.Context, networkID, name string) (*mod
 	}
 	
 	node.VirtualIP = net.ParseIP(virtualIP)
+	if virtualIP6.Valid {
+		node.VirtualIP6 = net.ParseIP(virtualIP6.String)
+	}
 	if lastSeen.Valid {
 		node.LastSeen = lastSeen.Time
 	}
 	json.Unmarshal(labelsJSON, &node.Labels)
 	json.Unmarshal(nodeInfoJSON, &node.NodeInfo)
+	if len(clientConfigJSON) > 0 {
+		json.Unmarshal(clientConfigJSON, &node.ClientConfig)
+	}
+	json.Unmarshal(advertisedJSON, &node.AdvertisedRoutes)
+	json.Unmarshal(approvedJSON, &node.ApprovedRoutes)
+	json.Unmarshal(endpointsJSON, &node.Endpoints)
 	
 	return &node, nil
 }
@@ -227,7 +364,8 @@ func (s *Store) GetNodeByName(ctx context.Context, networkID, name string) (*mod
 // ListNodes lists all nodes in a network
 func (s *Store) ListNodes(ctx context.Context, networkID string) ([]*models.Node, error) {
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, network_id, name, virtual_ip, public_key, labels, status, last_seen, node_info, expires_at, created_at
+		SELECT id, network_id, name, virtual_ip, virtual_ip6, public_key, preshared_key, labels, status, last_seen, node_info, expires_at,
+		       previous_public_key, previous_key_expires_at, key_rotated_at, key_rotation_days, client_config, advertised_routes, approved_routes, exit_node, exit_node_id, endpoints, created_at
 		FROM nodes WHERE network_id = $1 ORDER BY name
 	`, networkID)
 	if err != nil {
@@ -239,20 +377,31 @@ func (s *Store) ListNodes(ctx context.Context, networkID string) ([]*models.Node
 	for rows.Next() {
 		var node models.Node
 		var virtualIP string
-		var labelsJSON, nodeInfoJSON []byte
+		var virtualIP6 sql.NullString
+		var labelsJSON, nodeInfoJSON, clientConfigJSON, advertisedJSON, approvedJSON, endpointsJSON []byte
 		var lastSeen sql.NullTime
 		
-		if err := rows.Scan(&node.ID, &node.NetworkID, &node.Name, &virtualIP, &node.PublicKey,
-			&labelsJSON, &node.Status, &lastSeen, &nodeInfoJSON, &node.ExpiresAt, &node.CreatedAt); err != nil {
+		if err := rows.Scan(&node.ID, &node.NetworkID, &node.Name, &virtualIP, &virtualIP6, &node.PublicKey, &node.PresharedKey,
+			&labelsJSON, &node.Status, &lastSeen, &nodeInfoJSON, &node.ExpiresAt,
+		&node.PreviousPublicKey, &node.PreviousKeyExpiresAt, &node.KeyRotatedAt, &node.KeyRotationDays, &clientConfigJSON, &advertisedJSON, &approvedJSON, &node.ExitNode, &node.ExitNodeID, &endpointsJSON, &node.CreatedAt); err != nil {
 			return nil, err
 		}
 		
 		node.VirtualIP = net.ParseIP(virtualIP)
+		if virtualIP6.Valid {
+			node.VirtualIP6 = net.ParseIP(virtualIP6.String)
+		}
 		if lastSeen.Valid {
 			node.LastSeen = lastSeen.Time
 		}
 		json.Unmarshal(labelsJSON, &node.Labels)
 		json.Unmarshal(nodeInfoJSON, &node.NodeInfo)
+		if len(clientConfigJSON) > 0 {
+			json.Unmarshal(clientConfigJSON, &node.ClientConfig)
+		}
+		json.Unmarshal(advertisedJSON, &node.AdvertisedRoutes)
+		json.Unmarshal(approvedJSON, &node.ApprovedRoutes)
+		json.Unmarshal(endpointsJSON, &node.Endpoints)
 		
 		nodes = append(nodes, &node)
 	}
@@ -274,76 +423,124 @@ func (s *Store) UpdateNode(ctx context.Context, node *models.Node) error {
 	
 	_, err := s.db.ExecContext(ctx, `
 		UPDATE nodes 
-		SET name = $2, labels = $3, status = $4, node_info = $5, expires_at = $6
+		SET name = $2, labels = $3, status = $4, node_info = $5, expires_at = $6, key_rotation_days = $7, client_config = $8,
+		    exit_node = $9, exit_node_id = $10
 		WHERE id = $1
-	`, node.ID, node.Name, labelsJSON, node.Status, nodeInfoJSON, node.ExpiresAt)
+	`, node.ID, node.Name, labelsJSON, node.Status, nodeInfoJSON, node.ExpiresAt, node.KeyRotationDays, nodeClientConfigJSON(node),
+		node.ExitNode, node.ExitNodeID)
 	
 	return err
 }
 
-// UpdateNodeEndpoints updates node endpoints
-func (s *Store) UpdateNodeEndpoints(ctx context.Context, id string, endpoints []string) error {
-	endpointsJSON, _ := json.Marshal(endpoints)
+// UpdateNodeKey stores a rotated node keypair (the private key lives in the
+// labels) together with the grace period state of the previous key
+func (s *Store) UpdateNodeKey(ctx context.Context, node *models.Node) error {
+	labelsJSON, _ := json.Marshal(node.Labels)
 	_, err := s.db.ExecContext(ctx, `
-		UPDATE nodes SET endpoints = $2 WHERE id = $1
-	`, id, endpointsJSON)
+		UPDATE nodes
+		SET public_key = $2, labels = $3, previous_public_key = $4, previous_key_expires_at = $5, key_rotated_at = $6,
+		    key_rotation_requested_at = NULL
+		WHERE id = $1
+	`, node.ID, node.PublicKey, labelsJSON, node.PreviousPublicKey, node.PreviousKeyExpiresAt, node.KeyRotatedAt)
 	return err
 }
 
-// DeleteNode deletes a node
-func (s *Store) DeleteNode(ctx context.Context, id string) error {
-	_, err := s.db.ExecContext(ctx, `DELETE FROM nodes WHERE id = $1`, id)
+// ClearNodePreviousKey ends the grace period of a node's previous key
+func (s *Store) ClearNodePreviousKey(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE nodes SET previous_public_key = '', previous_key_expires_at = NULL WHERE id = $1
+	`, id)
 	return err
 }
 
-// AllocateIP allocates the next available IP in the network
-func (s *Store) AllocateIP(ctx context.Context, networkID string) (net.IP, error) {
-	// Get network CIDR
-	network, err := s.GetNetwork(ctx, networkID)
+// ListNodesInKeyGrace returns the IDs of nodes whose previous key is still
+// configured after a key rotation
+func (s *Store) ListNodesInKeyGrace(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM nodes WHERE previous_public_key <> ''`)
 	if err != nil {
 		return nil, err
 	}
-	if network == nil {
-		return nil, fmt.Errorf("network not found")
-	}
-	
-	_, ipNet, err := net.ParseCIDR(network.CIDR)
-	if err != nil {
-		return nil, fmt.Errorf("invalid network CIDR: %w", err)
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
 	}
-	
-	// Get all allocated IPs
+	return ids, rows.Err()
+}
+
+// ListNodesDueForKeyRotation returns the IDs of nodes whose automatic key
+// rotation interval has elapsed, that are not in a grace period and that were
+// not asked for a new key within the last retry interval
+func (s *Store) ListNodesDueForKeyRotation(ctx context.Context, retry time.Duration) ([]string, error) {
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT virtual_ip FROM nodes WHERE network_id = $1
-	`, networkID)
+		SELECT id FROM nodes
+		WHERE key_rotation_days > 0 AND previous_public_key = '' AND status <> 'expired'
+		  AND (key_rotation_requested_at IS NULL OR key_rotation_requested_at <= NOW() - $1 * INTERVAL '1 second')
+		  AND COALESCE(key_rotated_at, created_at) + key_rotation_days * INTERVAL '1 day' <= NOW()
+	`, int(retry.Seconds()))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	
-	allocated := make(map[string]bool)
+
+	var ids []string
 	for rows.Next() {
-		var ip string
-		if err := rows.Scan(&ip); err != nil {
+		var id string
+		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		allocated[ip] = true
+		ids = append(ids, id)
 	}
-	
-	// Find next available IP (skip network and broadcast addresses)
-	ip := ipNet.IP.Mask(ipNet.Mask)
-	for i := 0; i < 3; i++ { // Skip first 3 IPs (network, gateway, reserved)
-		ip = nextIP(ip)
+	return ids, rows.Err()
+}
+
+// MarkNodeKeyRotationRequested records that a node was asked for a new key;
+// UpdateNodeKey clears it
+func (s *Store) MarkNodeKeyRotationRequested(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE nodes SET key_rotation_requested_at = NOW() WHERE id = $1
+	`, id)
+	return err
+}
+
+// GetNodeByTokenHash retrieves the node an agent token belongs to
+func (s *Store) GetNodeByTokenHash(ctx context.Context, tokenHash string) (*models.Node, error) {
+	var id string
+	err := s.db.QueryRowContext(ctx, `SELECT id FROM nodes WHERE token_hash = $1`, tokenHash).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-	
-	for ipNet.Contains(ip) {
-		if !allocated[ip.String()] {
-			return ip, nil
-		}
-		ip = nextIP(ip)
+	if err != nil {
+		return nil, err
 	}
-	
-	return nil, fmt.Errorf("no available IPs in network")
+	return s.GetNode(ctx, id)
+}
+
+// SetNodeTokenHash replaces the agent token of a node
+func (s *Store) SetNodeTokenHash(ctx context.Context, id, tokenHash string) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE nodes SET token_hash = $2 WHERE id = $1`, id, tokenHash)
+	return err
+}
+
+// UpdateNodeEndpoints stores the endpoints (host:port) a node reported as
+// reachable for direct peer connections
+func (s *Store) UpdateNodeEndpoints(ctx context.Context, id string, endpoints []string) error {
+	endpointsJSON, _ := json.Marshal(endpoints)
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE nodes SET endpoints = $2 WHERE id = $1
+	`, id, endpointsJSON)
+	return err
+}
+
+// DeleteNode deletes a node
+func (s *Store) DeleteNode(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM nodes WHERE id = $1`, id)
+	return err
 }
 
 // User operations
@@ -653,6 +850,43 @@ func (s *Store) CreateFirewallAuditLog(ctx context.Context, action string, detai
 	return err
 }
 
+// ListExitNodeUsers returns the IDs of nodes that route their internet traffic
+// through the given exit node
+func (s *Store) ListExitNodeUsers(ctx context.Context, exitNodeID string) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM nodes WHERE exit_node_id = $1 ORDER BY name`, exitNodeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// nodeClientConfigJSON encodes a node's client config overrides, NULL when unset
+func nodeClientConfigJSON(node *models.Node) []byte {
+	if node.ClientConfig == nil {
+		return nil
+	}
+	b, _ := json.Marshal(node.ClientConfig)
+	return b
+}
+
+// nullIP maps an unset IP to SQL NULL
+func nullIP(ip net.IP) sql.NullString {
+	if ip == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: ip.String(), Valid: true}
+}
+
 // Helper functions for nullable strings
 func nullString(s string) sql.NullString {
 	if s == "" {
@@ -667,18 +901,3 @@ func nullStringPtr(s *string) sql.NullString {
 	}
 	return sql.NullString{String: *s, Valid: true}
 }
-
-// Helper functions
-
-func nextIP(ip net.IP) net.IP {
-	next := make(net.IP, len(ip))
-	copy(next, ip)
-	
-	for i := len(next) - 1; i >= 0; i-- {
-		next[i]++
-		if next[i] != 0 {
-			break
-		}
-	}
-	return next
-}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+// Subnet route operations
+
+// UpdateNodeRoutes stores the subnets a node advertises and the approved subset
+func (s *Store) UpdateNodeRoutes(ctx context.Context, id string, advertised, approved []string) error {
+	if advertised == nil {
+		advertised = []string{}
+	}
+	if approved == nil {
+		approved = []string{}
+	}
+	advertisedJSON, _ := json.Marshal(advertised)
+	approvedJSON, _ := json.Marshal(approved)
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE nodes SET advertised_routes = $2, approved_routes = $3 WHERE id = $1
+	`, id, advertisedJSON, approvedJSON)
+	return err
+}
+
+// ListSubnetRoutes lists the advertised subnets of all nodes, or of the nodes
+// of one network when networkID is set
+func (s *Store) ListSubnetRoutes(ctx context.Context, networkID string) ([]*models.SubnetRoute, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, network_id, advertised_routes, approved_routes
+		FROM nodes
+		WHERE advertised_routes <> '[]'::jsonb AND ($1 = '' OR network_id::text = $1)
+		ORDER BY name
+	`, networkID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	routes := []*models.SubnetRoute{}
+	for rows.Next() {
+		var nodeID, nodeName, nodeNetworkID string
+		var advertisedJSON, approvedJSON []byte
+		if err := rows.Scan(&nodeID, &nodeName, &nodeNetworkID, &advertisedJSON, &approvedJSON); err != nil {
+			return nil, err
+		}
+		var advertised, approved []string
+		json.Unmarshal(advertisedJSON, &advertised)
+		json.Unmarshal(approvedJSON, &approved)
+
+		isApproved := make(map[string]bool, len(approved))
+		for _, cidr := range approved {
+			isApproved[cidr] = true
+		}
+		for _, cidr := range advertised {
+			routes = append(routes, &models.SubnetRoute{
+				NodeID:    nodeID,
+				NodeName:  nodeName,
+				NetworkID: nodeNetworkID,
+				CIDR:      cidr,
+				Approved:  isApproved[cidr],
+			})
+		}
+	}
+	return routes, rows.Err()
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+)
+
+// Traffic sample operations
+
+// CreateTrafficSamples stores a snapshot of the transfer counters of a
+// network's nodes, keyed by node ID
+func (s *Store) CreateTrafficSamples(ctx context.Context, networkID string, sampledAt time.Time, counters map[string]models.ReportCounter) error {
+	if len(counters) == 0 {
+		return nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for nodeID, counter := range counters {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO node_traffic_samples (node_id, network_id, sampled_at, rx_bytes, tx_bytes)
+			VALUES ($1, $2, $3, $4, $5)
+		`, nodeID, networkID, sampledAt, counter.Rx, counter.Tx); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// NodeTrafficBetween sums the traffic of a network's nodes between samples
+// taken in [start, end). Each sample contributes its difference to the
+// node's previous sample (up to a day earlier); a counter that went down was
+// reset, so its new value counts in full.
+func (s *Store) NodeTrafficBetween(ctx context.Context, networkID string, start, end time.Time) (map[string]models.ReportCounter, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT node_id,
+			SUM(CASE WHEN rx_bytes >= prev_rx THEN rx_bytes - prev_rx ELSE rx_bytes END),
+			SUM(CASE WHEN tx_bytes >= prev_tx THEN tx_bytes - prev_tx ELSE tx_bytes END)
+		FROM (
+			SELECT node_id, sampled_at, rx_bytes, tx_bytes,
+				LAG(rx_bytes) OVER w AS prev_rx,
+				LAG(tx_bytes) OVER w AS prev_tx
+			FROM node_traffic_samples
+			WHERE network_id = $1 AND sampled_at >= $2::timestamptz - INTERVAL '1 day' AND sampled_at < $3
+			WINDOW w AS (PARTITION BY node_id ORDER BY sampled_at)
+		) samples
+		WHERE sampled_at >= $2 AND prev_rx IS NOT NULL
+		GROUP BY node_id
+	`, networkID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	traffic := make(map[string]models.ReportCounter)
+	for rows.Next() {
+		var nodeID string
+		var counter models.ReportCounter
+		if err := rows.Scan(&nodeID, &counter.Rx, &counter.Tx); err != nil {
+			return nil, err
+		}
+		traffic[nodeID] = counter
+	}
+	return traffic, rows.Err()
+}
+
+// DeleteTrafficSamplesBefore drops traffic samples older than a time
+func (s *Store) DeleteTrafficSamplesBefore(ctx context.Context, before time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM node_traffic_samples WHERE sampled_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
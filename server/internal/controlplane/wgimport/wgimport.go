@@ -0,0 +1,473 @@
+// Package wgimport turns existing wg-quick configurations, a hub's server
+// config and optionally the configs of its clients, into a network and its
+// nodes, keeping keys, addresses and ports.
+package wgimport
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/novusgate/novusgate/internal/controlplane/store"
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/shared/netutil"
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+// ClientFile is the wg-quick config of one client
+type ClientFile struct {
+	Name   string `json:"name"` // Node name, e.g. the file name without .conf
+	Config string `json:"config"`
+}
+
+// Options describe what to import
+type Options struct {
+	Name          string // Network name
+	ServerConfig  string
+	Clients       []ClientFile
+	Endpoint      string // Public host or host:port; defaults to the clients' Endpoint, then WG_SERVER_ENDPOINT
+	InterfaceName string
+}
+
+// Peer summarizes a node that will be created
+type Peer struct {
+	Name       string   `json:"name"`
+	PublicKey  string   `json:"public_key"`
+	VirtualIP  string   `json:"virtual_ip"`
+	VirtualIP6 string   `json:"virtual_ip6,omitempty"`
+	Routes     []string `json:"routes,omitempty"`      // Subnets behind the peer, imported as approved routes
+	ClientFile string   `json:"client_file,omitempty"` // Client config matched to the peer
+}
+
+// Plan is the network and nodes an import creates. It must not be applied
+// while Conflicts is not empty.
+type Plan struct {
+	Network   *models.Network `json:"network"`
+	Peers     []Peer          `json:"peers"`
+	Nodes     []*models.Node  `json:"-"`
+	Conflicts []string        `json:"conflicts"`
+	Warnings  []string        `json:"warnings"`
+}
+
+// client is a parsed client config
+type client struct {
+	file     ClientFile
+	cfg      *wireguard.DeviceConfig
+	matched  bool
+	endpoint string // Endpoint of the server peer
+}
+
+// Build parses the configs into a plan. Invalid configs are errors; addresses
+// that cannot be kept are reported as conflicts.
+func Build(opts Options) (*Plan, error) {
+	name := strings.TrimSpace(opts.Name)
+	if name == "" {
+		return nil, fmt.Errorf("network name is required")
+	}
+	cfg, err := wireguard.ParseDeviceConfig(opts.ServerConfig)
+	if err != nil {
+		return nil, fmt.Errorf("server config: %w", err)
+	}
+	if cfg.PrivateKey == "" {
+		return nil, fmt.Errorf("server config: [Interface] has no PrivateKey")
+	}
+	publicKey, err := wireguard.PublicKeyFromPrivate(cfg.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("server config: %w", err)
+	}
+
+	plan := &Plan{Peers: []Peer{}, Conflicts: []string{}, Warnings: []string{}}
+
+	// The hub addresses give the network ranges: the first IPv4 and IPv6 one
+	var net4, net6 *net.IPNet
+	for _, address := range cfg.Addresses {
+		ip, ipNet, err := net.ParseCIDR(address)
+		if err != nil {
+			return nil, fmt.Errorf("server config: invalid Address %q", address)
+		}
+		target := &net4
+		if ip.To4() == nil {
+			target = &net6
+		}
+		if *target != nil {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("Address %s ignored, a network has one range per address family", address))
+			continue
+		}
+		*target = ipNet
+		if hub := netutil.NextIP(ipNet.IP); !hub.Equal(ip) {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("hub address %s becomes %s (the first host of %s)", ip, hub, ipNet))
+		}
+	}
+	primary, secondary := net4, net6
+	if primary == nil {
+		primary, secondary = net6, nil
+	}
+	if primary == nil {
+		return nil, fmt.Errorf("server config: [Interface] has no Address")
+	}
+
+	port := cfg.ListenPort
+	if port == 0 {
+		port = wireguard.DefaultServerPort
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("server config has no ListenPort, using %d", port))
+	}
+	if len(cfg.PostUp) > 0 || len(cfg.PostDown) > 0 || len(cfg.PreUp) > 0 || len(cfg.PreDown) > 0 {
+		plan.Warnings = append(plan.Warnings, "Pre/PostUp and Pre/PostDown hooks are not imported; set up NAT with the network's egress settings")
+	}
+
+	network := &models.Network{
+		Name:             name,
+		CIDR:             primary.String(),
+		ServerPrivateKey: cfg.PrivateKey,
+		ServerPublicKey:  publicKey,
+		ListenPort:       port,
+		InterfaceName:    opts.InterfaceName,
+		Egress:           models.EgressConfig{Mode: models.EgressDisabled},
+		ProbeInterval:    models.DefaultProbeInterval,
+	}
+	if secondary != nil {
+		network.CIDR6 = secondary.String()
+	}
+	plan.Network = network
+
+	clients, err := parseClients(opts.Clients, publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	comments := peerComments(opts.ServerConfig)
+	used := map[string]bool{}
+	names := map[string]bool{}
+	for i, peer := range cfg.Peers {
+		node, warnings := PeerNode(peer, primary, secondary)
+		plan.Warnings = append(plan.Warnings, warnings...)
+		routes := node.ApprovedRoutes
+
+		c := clients[peer.PublicKey]
+		if c != nil {
+			node.Name = UniqueName(names, c.file.Name)
+		} else {
+			comment := ""
+			if i < len(comments) {
+				comment = comments[i]
+			}
+			node.Name = UniqueName(names, PeerName(comment, node, i))
+		}
+		node.NodeInfo = &models.NodeInfo{Hostname: node.Name, OS: "unknown", Architecture: "unknown"}
+
+		if c != nil {
+			c.matched = true
+			if node.PresharedKey == "" {
+				node.PresharedKey = c.presharedKey(publicKey)
+			}
+			node.ClientConfig = c.clientConfig(network)
+		}
+
+		if node.VirtualIP == nil {
+			plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("peer %s (%s) has no address in %s", node.Name, peer.PublicKey, primary))
+		} else if err := store.CheckNodeAddress(primary, node.VirtualIP, used, nil); err != nil {
+			plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("peer %s: %v", node.Name, err))
+		}
+		if node.VirtualIP6 != nil {
+			if err := store.CheckNodeAddress(secondary, node.VirtualIP6, used, nil); err != nil {
+				plan.Conflicts = append(plan.Conflicts, fmt.Sprintf("peer %s: %v", node.Name, err))
+			}
+		} else if secondary != nil {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("peer %s has no IPv6 address", node.Name))
+		}
+		for _, ip := range node.Addresses() {
+			used[ip.String()] = true
+		}
+
+		plan.Nodes = append(plan.Nodes, node)
+		summary := Peer{
+			Name:      node.Name,
+			PublicKey: node.PublicKey,
+			Routes:    routes,
+		}
+		if node.VirtualIP != nil {
+			summary.VirtualIP = node.VirtualIP.String()
+		}
+		if node.VirtualIP6 != nil {
+			summary.VirtualIP6 = node.VirtualIP6.String()
+		}
+		if c != nil {
+			summary.ClientFile = c.file.Name
+		}
+		plan.Peers = append(plan.Peers, summary)
+	}
+
+	var endpoint string
+	for _, c := range sortedClients(clients) {
+		if !c.matched {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("client config %s matches no peer of the server config", c.file.Name))
+		} else if endpoint == "" {
+			endpoint = c.endpoint
+		}
+	}
+	if opts.Endpoint != "" {
+		endpoint = opts.Endpoint
+	}
+	network.ServerEndpoint, err = serverEndpoint(endpoint, port)
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// PeerNode turns a [Peer] of a hub into a node of a network with the ranges
+// primary and secondary (nil for single-stack networks). Single-host
+// AllowedIPs inside the ranges become its addresses and other subnets its
+// approved routes; entries that cannot be kept are returned as warnings.
+func PeerNode(peer wireguard.PeerConfig, primary, secondary *net.IPNet) (*models.Node, []string) {
+	node := &models.Node{
+		PublicKey:    peer.PublicKey,
+		PresharedKey: peer.PresharedKey,
+		Labels:       map[string]string{},
+		Status:       models.NodeStatusPending,
+	}
+
+	var routes, warnings []string
+	for _, entry := range strings.Split(peer.AllowedIPs, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		ip, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("peer %s: invalid AllowedIPs entry %q ignored", peer.PublicKey, entry))
+			continue
+		}
+		ones, bits := ipNet.Mask.Size()
+		switch {
+		case ones == bits && primary.Contains(ip) && node.VirtualIP == nil:
+			node.VirtualIP = ip
+		case ones == bits && secondary != nil && secondary.Contains(ip) && node.VirtualIP6 == nil:
+			node.VirtualIP6 = ip
+		case ones == 0:
+			warnings = append(warnings, fmt.Sprintf("peer %s: %s ignored, mark the node as an exit node instead", peer.PublicKey, entry))
+		case overlaps(ipNet, primary) || (secondary != nil && overlaps(ipNet, secondary)):
+			warnings = append(warnings, fmt.Sprintf("peer %s: %s inside the network range ignored", peer.PublicKey, entry))
+		default:
+			routes = append(routes, ipNet.String())
+		}
+	}
+	node.AdvertisedRoutes = routes
+	node.ApprovedRoutes = routes
+	return node, warnings
+}
+
+// PeerName names the node of the index-th [Peer] without a client config:
+// after its name comment, else its address
+func PeerName(comment string, node *models.Node, index int) string {
+	switch {
+	case comment != "":
+		return comment
+	case node.VirtualIP != nil:
+		return "peer-" + node.VirtualIP.String()
+	default:
+		return fmt.Sprintf("peer-%d", index+1)
+	}
+}
+
+// UniqueName returns name, or name with the lowest "-2", "-3"... suffix that
+// is not in names, and adds it to names
+func UniqueName(names map[string]bool, name string) string {
+	candidate := name
+	for i := 2; names[candidate]; i++ {
+		candidate = fmt.Sprintf("%s-%d", name, i)
+	}
+	names[candidate] = true
+	return candidate
+}
+
+// PeerConfigs returns the hub peers of the imported nodes
+func (p *Plan) PeerConfigs() []wireguard.PeerConfig {
+	peers := make([]wireguard.PeerConfig, 0, len(p.Nodes))
+	for _, node := range p.Nodes {
+		var allowed []string
+		if node.VirtualIP != nil {
+			allowed = append(allowed, netutil.HostCIDR(node.VirtualIP))
+		}
+		if node.VirtualIP6 != nil {
+			allowed = append(allowed, netutil.HostCIDR(node.VirtualIP6))
+		}
+		allowed = append(allowed, node.ApprovedRoutes...)
+		peers = append(peers, wireguard.PeerConfig{
+			PublicKey:    node.PublicKey,
+			PresharedKey: node.PresharedKey,
+			AllowedIPs:   strings.Join(allowed, ","),
+		})
+	}
+	return peers
+}
+
+// parseClients parses the client configs by public key
+func parseClients(files []ClientFile, serverPublicKey string) (map[string]*client, error) {
+	clients := make(map[string]*client, len(files))
+	for _, file := range files {
+		file.Name = strings.TrimSpace(file.Name)
+		cfg, err := wireguard.ParseDeviceConfig(file.Config)
+		if err != nil {
+			return nil, fmt.Errorf("client config %s: %w", file.Name, err)
+		}
+		if cfg.PrivateKey == "" {
+			return nil, fmt.Errorf("client config %s: [Interface] has no PrivateKey", file.Name)
+		}
+		key, err := wireguard.PublicKeyFromPrivate(cfg.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("client config %s: %w", file.Name, err)
+		}
+		if file.Name == "" {
+			file.Name = "client-" + key[:8]
+		}
+		c := &client{file: file, cfg: cfg}
+		for _, peer := range cfg.Peers {
+			if peer.PublicKey == serverPublicKey {
+				c.endpoint = peer.Endpoint
+			}
+		}
+		clients[key] = c
+	}
+	return clients, nil
+}
+
+// presharedKey returns the PSK the client uses with the server
+func (c *client) presharedKey(serverPublicKey string) string {
+	for _, peer := range c.cfg.Peers {
+		if peer.PublicKey == serverPublicKey {
+			return peer.PresharedKey
+		}
+	}
+	return ""
+}
+
+// clientConfig keeps the client's DNS, MTU, ListenPort, keepalive and
+// AllowedIPs as node overrides
+func (c *client) clientConfig(network *models.Network) *models.ClientConfigOptions {
+	opts := &models.ClientConfigOptions{
+		DNS:        c.cfg.DNS,
+		MTU:        c.cfg.MTU,
+		ListenPort: c.cfg.ListenPort,
+	}
+	for _, peer := range c.cfg.Peers {
+		if peer.PublicKey != network.ServerPublicKey {
+			continue
+		}
+		if peer.PersistentKeepalive > 0 {
+			keepalive := peer.PersistentKeepalive
+			opts.PersistentKeepalive = &keepalive
+		}
+
+		allowed := strings.Split(peer.AllowedIPs, ",")
+		for i := range allowed {
+			allowed[i] = strings.TrimSpace(allowed[i])
+		}
+		switch {
+		case contains(allowed, "0.0.0.0/0") || contains(allowed, "::/0"):
+			opts.AllowedIPsMode = models.AllowedIPsFull
+		case !sameSet(allowed, network.CIDRs()):
+			opts.AllowedIPsMode = models.AllowedIPsCustom
+			opts.CustomAllowedIPs = allowed
+		}
+	}
+	return opts
+}
+
+// peerComments returns the name comment of each [Peer] section in order: a
+// comment right above the section header or inside it, like "### Client
+// alice" or "# Name = alice"
+func peerComments(content string) []string {
+	var names []string
+	pending := ""
+	naming := false // In a [Peer] section before its first key
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			text := strings.TrimSpace(strings.TrimLeft(line, "#"))
+			lower := strings.ToLower(text)
+			for _, prefix := range []string{"client", "name", "peer"} {
+				if !strings.HasPrefix(lower, prefix) {
+					continue
+				}
+				rest := text[len(prefix):]
+				if trimmed := strings.TrimLeft(rest, " :="); trimmed != "" && trimmed != rest {
+					text = trimmed
+					break
+				}
+			}
+			if naming && names[len(names)-1] == "" {
+				names[len(names)-1] = text
+			} else if !naming {
+				pending = text
+			}
+		case strings.EqualFold(line, "[peer]"):
+			names = append(names, pending)
+			pending = ""
+			naming = true
+		default:
+			pending = ""
+			naming = false
+		}
+	}
+	return names
+}
+
+// serverEndpoint completes host or host:port with the listen port
+func serverEndpoint(endpoint string, port int) (string, error) {
+	if endpoint == "" {
+		endpoint = wireguard.GetServerEndpoint()
+	}
+	if host, p, err := net.SplitHostPort(endpoint); err == nil {
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 || host == "" {
+			return "", fmt.Errorf("invalid endpoint %q", endpoint)
+		}
+		return endpoint, nil
+	}
+	return net.JoinHostPort(strings.Trim(endpoint, "[]"), strconv.Itoa(port)), nil
+}
+
+func sortedClients(clients map[string]*client) []*client {
+	list := make([]*client, 0, len(clients))
+	for _, c := range clients {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].file.Name < list[j].file.Name })
+	return list
+}
+
+func overlaps(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, item := range a {
+		if !contains(b, item) {
+			return false
+		}
+	}
+	return true
+}
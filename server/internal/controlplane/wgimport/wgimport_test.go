@@ -0,0 +1,380 @@
+package wgimport
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/novusgate/novusgate/internal/wireguard"
+)
+
+func mustCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+	if cidr == "" {
+		return nil
+	}
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ipNet
+}
+
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func TestPeerNode(t *testing.T) {
+	tests := []struct {
+		name       string
+		allowedIPs string
+		secondary  string
+		wantIP     string
+		wantIP6    string
+		wantRoutes []string
+		warnings   int
+	}{
+		{
+			name:       "host address",
+			allowedIPs: "10.8.0.2/32",
+			wantIP:     "10.8.0.2",
+		},
+		{
+			name:       "address without prefix length",
+			allowedIPs: "10.8.0.2",
+			wantIP:     "10.8.0.2",
+		},
+		{
+			name:       "dual stack with a route behind the peer",
+			allowedIPs: "10.8.0.3/32, fd08::3/128, 192.168.10.0/24",
+			secondary:  "fd08::/64",
+			wantIP:     "10.8.0.3",
+			wantIP6:    "fd08::3",
+			wantRoutes: []string{"192.168.10.0/24"},
+		},
+		{
+			name:       "IPv6 address on a single-stack network is a route",
+			allowedIPs: "10.8.0.3/32, fd08::3/128",
+			wantIP:     "10.8.0.3",
+			wantRoutes: []string{"fd08::3/128"},
+		},
+		{
+			name:       "second host address inside the range is ignored",
+			allowedIPs: "10.8.0.4/32, 10.8.0.5/32",
+			wantIP:     "10.8.0.4",
+			warnings:   1,
+		},
+		{
+			name:       "default route and invalid entries are ignored",
+			allowedIPs: "10.8.0.6/32, 0.0.0.0/0, nonsense",
+			wantIP:     "10.8.0.6",
+			warnings:   2,
+		},
+		{
+			name:       "no address in the range",
+			allowedIPs: "172.16.0.0/16",
+			wantRoutes: []string{"172.16.0.0/16"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			peer := wireguard.PeerConfig{PublicKey: "peer", PresharedKey: "psk", AllowedIPs: tt.allowedIPs}
+			node, warnings := PeerNode(peer, mustCIDR(t, "10.8.0.0/24"), mustCIDR(t, tt.secondary))
+			if got := ipString(node.VirtualIP); got != tt.wantIP {
+				t.Errorf("VirtualIP = %q, want %q", got, tt.wantIP)
+			}
+			if got := ipString(node.VirtualIP6); got != tt.wantIP6 {
+				t.Errorf("VirtualIP6 = %q, want %q", got, tt.wantIP6)
+			}
+			if !reflect.DeepEqual(node.ApprovedRoutes, tt.wantRoutes) {
+				t.Errorf("ApprovedRoutes = %v, want %v", node.ApprovedRoutes, tt.wantRoutes)
+			}
+			if !reflect.DeepEqual(node.AdvertisedRoutes, tt.wantRoutes) {
+				t.Errorf("AdvertisedRoutes = %v, want %v", node.AdvertisedRoutes, tt.wantRoutes)
+			}
+			if len(warnings) != tt.warnings {
+				t.Errorf("warnings = %q, want %d", warnings, tt.warnings)
+			}
+			if node.PublicKey != "peer" || node.PresharedKey != "psk" || node.Status != models.NodeStatusPending {
+				t.Errorf("node = %+v, want the peer's keys and pending status", node)
+			}
+		})
+	}
+}
+
+func TestPeerName(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment string
+		ip      net.IP
+		want    string
+	}{
+		{name: "comment", comment: "alice", ip: net.ParseIP("10.8.0.2"), want: "alice"},
+		{name: "address", ip: net.ParseIP("10.8.0.2"), want: "peer-10.8.0.2"},
+		{name: "index", want: "peer-3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PeerName(tt.comment, &models.Node{VirtualIP: tt.ip}, 2); got != tt.want {
+				t.Errorf("PeerName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	names := map[string]bool{}
+	var got []string
+	for _, name := range []string{"alice", "alice", "bob", "alice", "alice-2"} {
+		got = append(got, UniqueName(names, name))
+	}
+	want := []string{"alice", "alice-2", "bob", "alice-3", "alice-2-2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UniqueName() = %q, want %q", got, want)
+	}
+}
+
+func TestPeerComments(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "comment above the section",
+			content: "[Interface]\nPrivateKey = k\n\n### Client alice\n[Peer]\nPublicKey = a\n",
+			want:    []string{"alice"},
+		},
+		{
+			name:    "name key inside the section",
+			content: "[Peer]\n# Name = bob\nPublicKey = b\n",
+			want:    []string{"bob"},
+		},
+		{
+			name:    "plain comment",
+			content: "# office router\n[Peer]\nPublicKey = c\n",
+			want:    []string{"office router"},
+		},
+		{
+			name:    "comment separated by a key is not a name",
+			content: "# generated by a script\nListenPort = 51820\n[Peer]\nPublicKey = d\n[Peer]\nPublicKey = e\n# peer: after\n",
+			want:    []string{"", ""},
+		},
+		{
+			name:    "comments after the first key are ignored",
+			content: "[peer]\nPublicKey = f\n# not a name\n",
+			want:    []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := peerComments(tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("peerComments() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     string
+		wantErr  bool
+	}{
+		{endpoint: "vpn.example.com", want: "vpn.example.com:51820"},
+		{endpoint: "vpn.example.com:4500", want: "vpn.example.com:4500"},
+		{endpoint: "2001:db8::1", want: "[2001:db8::1]:51820"},
+		{endpoint: "[2001:db8::1]", want: "[2001:db8::1]:51820"},
+		{endpoint: "[2001:db8::1]:4500", want: "[2001:db8::1]:4500"},
+		{endpoint: "vpn.example.com:0", wantErr: true},
+		{endpoint: ":4500", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			got, err := serverEndpoint(tt.endpoint, 51820)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("serverEndpoint() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("serverEndpoint() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	serverKey, serverPublicKey := mustKeys(t)
+	aliceKey, alicePublicKey := mustKeys(t)
+	_, bobPublicKey := mustKeys(t)
+	_, carolPublicKey := mustKeys(t)
+
+	serverConfig := fmt.Sprintf(`[Interface]
+PrivateKey = %s
+Address = 10.8.0.1/24
+ListenPort = 51821
+
+[Peer]
+PublicKey = %s
+AllowedIPs = 10.8.0.2/32
+
+### Client bob
+[Peer]
+PublicKey = %s
+AllowedIPs = 10.8.0.3/32, 192.168.50.0/24
+`, serverKey, alicePublicKey, bobPublicKey)
+	aliceConfig := fmt.Sprintf(`[Interface]
+PrivateKey = %s
+Address = 10.8.0.2/24
+DNS = 1.1.1.1
+
+[Peer]
+PublicKey = %s
+PresharedKey = psk-alice
+AllowedIPs = 0.0.0.0/0
+Endpoint = vpn.example.com:51821
+PersistentKeepalive = 25
+`, aliceKey, serverPublicKey)
+
+	tests := []struct {
+		name          string
+		opts          Options
+		wantPeers     []Peer
+		wantEndpoint  string
+		wantConflicts int
+		wantErr       string
+	}{
+		{
+			name: "server and client configs",
+			opts: Options{Name: "office", ServerConfig: serverConfig, Clients: []ClientFile{{Name: "alice", Config: aliceConfig}}},
+			wantPeers: []Peer{
+				{Name: "alice", PublicKey: alicePublicKey, VirtualIP: "10.8.0.2", ClientFile: "alice"},
+				{Name: "bob", PublicKey: bobPublicKey, VirtualIP: "10.8.0.3", Routes: []string{"192.168.50.0/24"}},
+			},
+			wantEndpoint: "vpn.example.com:51821",
+		},
+		{
+			name: "endpoint option wins over the clients",
+			opts: Options{Name: "office", ServerConfig: serverConfig, Endpoint: "203.0.113.1", Clients: []ClientFile{{Name: "alice", Config: aliceConfig}}},
+			wantPeers: []Peer{
+				{Name: "alice", PublicKey: alicePublicKey, VirtualIP: "10.8.0.2", ClientFile: "alice"},
+				{Name: "bob", PublicKey: bobPublicKey, VirtualIP: "10.8.0.3", Routes: []string{"192.168.50.0/24"}},
+			},
+			wantEndpoint: "203.0.113.1:51821",
+		},
+		{
+			name: "duplicate and hub addresses are conflicts",
+			opts: Options{Name: "office", Endpoint: "203.0.113.1", ServerConfig: serverConfig + fmt.Sprintf(`
+[Peer]
+PublicKey = %s
+AllowedIPs = 10.8.0.3/32
+
+[Peer]
+PublicKey = %s
+AllowedIPs = 10.8.0.1/32
+`, carolPublicKey, serverPublicKey)},
+			wantPeers: []Peer{
+				{Name: "peer-10.8.0.2", PublicKey: alicePublicKey, VirtualIP: "10.8.0.2"},
+				{Name: "bob", PublicKey: bobPublicKey, VirtualIP: "10.8.0.3", Routes: []string{"192.168.50.0/24"}},
+				{Name: "peer-10.8.0.3", PublicKey: carolPublicKey, VirtualIP: "10.8.0.3"},
+				{Name: "peer-10.8.0.1", PublicKey: serverPublicKey, VirtualIP: "10.8.0.1"},
+			},
+			wantEndpoint:  "203.0.113.1:51821",
+			wantConflicts: 2,
+		},
+		{
+			name:    "missing network name",
+			opts:    Options{ServerConfig: serverConfig},
+			wantErr: "network name is required",
+		},
+		{
+			name:    "server config without an address",
+			opts:    Options{Name: "office", ServerConfig: "[Interface]\nPrivateKey = " + serverKey + "\n"},
+			wantErr: "server config: [Interface] has no Address",
+		},
+		{
+			name:    "invalid client config",
+			opts:    Options{Name: "office", ServerConfig: serverConfig, Clients: []ClientFile{{Name: "broken", Config: "[Interface]\n"}}},
+			wantErr: "client config broken: [Interface] has no PrivateKey",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := Build(tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Build() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if !reflect.DeepEqual(plan.Peers, tt.wantPeers) {
+				t.Errorf("Peers = %+v, want %+v", plan.Peers, tt.wantPeers)
+			}
+			if plan.Network.ServerEndpoint != tt.wantEndpoint {
+				t.Errorf("ServerEndpoint = %q, want %q", plan.Network.ServerEndpoint, tt.wantEndpoint)
+			}
+			if len(plan.Conflicts) != tt.wantConflicts {
+				t.Errorf("Conflicts = %q, want %d", plan.Conflicts, tt.wantConflicts)
+			}
+			if plan.Network.CIDR != "10.8.0.0/24" || plan.Network.ListenPort != 51821 || plan.Network.ServerPublicKey != serverPublicKey {
+				t.Errorf("Network = %+v", plan.Network)
+			}
+		})
+	}
+}
+
+func TestBuildKeepsClientSettings(t *testing.T) {
+	serverKey, serverPublicKey := mustKeys(t)
+	clientKey, clientPublicKey := mustKeys(t)
+	serverConfig := fmt.Sprintf("[Interface]\nPrivateKey = %s\nAddress = 10.8.0.1/24\n\n[Peer]\nPublicKey = %s\nAllowedIPs = 10.8.0.2/32\n", serverKey, clientPublicKey)
+	clientConfig := fmt.Sprintf("[Interface]\nPrivateKey = %s\nDNS = 1.1.1.1\nMTU = 1380\n\n[Peer]\nPublicKey = %s\nPresharedKey = psk\nAllowedIPs = 10.8.0.0/24, 192.168.1.0/24\nEndpoint = vpn.example.com:51820\nPersistentKeepalive = 25\n", clientKey, serverPublicKey)
+
+	plan, err := Build(Options{Name: "home", ServerConfig: serverConfig, Clients: []ClientFile{{Name: " laptop ", Config: clientConfig}}})
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if len(plan.Nodes) != 1 {
+		t.Fatalf("Nodes = %d, want 1", len(plan.Nodes))
+	}
+	node := plan.Nodes[0]
+	if node.Name != "laptop" || node.PresharedKey != "psk" {
+		t.Errorf("node name %q and PSK %q, want laptop and the client's PSK", node.Name, node.PresharedKey)
+	}
+	opts := node.ClientConfig
+	if opts == nil || !reflect.DeepEqual(opts.DNS, []string{"1.1.1.1"}) || opts.MTU != 1380 ||
+		opts.PersistentKeepalive == nil || *opts.PersistentKeepalive != 25 ||
+		opts.AllowedIPsMode != models.AllowedIPsCustom || !reflect.DeepEqual(opts.CustomAllowedIPs, []string{"10.8.0.0/24", "192.168.1.0/24"}) {
+		t.Errorf("ClientConfig = %+v", opts)
+	}
+	if got := plan.PeerConfigs(); len(got) != 1 || got[0].AllowedIPs != "10.8.0.2/32" || got[0].PresharedKey != "psk" {
+		t.Errorf("PeerConfigs() = %+v", got)
+	}
+	if !strings.HasSuffix(plan.Network.ServerEndpoint, ":51820") {
+		t.Errorf("ServerEndpoint = %q", plan.Network.ServerEndpoint)
+	}
+}
+
+func mustKeys(t *testing.T) (string, string) {
+	t.Helper()
+	private, public, err := wireguard.GenerateKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private, public
+}
@@ -0,0 +1,129 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/novusgate/novusgate/internal/shared/models"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// record covers the fields we use from both the City/Country and the ASN
+// MaxMind databases. Fields that a given database doesn't carry stay empty.
+type record struct {
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// DB resolves IP addresses to country, city and ASN using one or more
+// local MaxMind-format (.mmdb) database files
+type DB struct {
+	readers []*maxminddb.Reader
+}
+
+// Open opens the given database files. Empty paths are ignored, so callers can
+// pass optional files (e.g. a separate ASN database) straight from config.
+func Open(paths ...string) (*DB, error) {
+	db := &DB{}
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to open GeoIP database %s: %w", path, err)
+		}
+		db.readers = append(db.readers, reader)
+	}
+	if len(db.readers) == 0 {
+		return nil, fmt.Errorf("no GeoIP database configured")
+	}
+	return db, nil
+}
+
+// Close releases all database readers
+func (d *DB) Close() error {
+	if d == nil {
+		return nil
+	}
+	for _, reader := range d.readers {
+		reader.Close()
+	}
+	d.readers = nil
+	return nil
+}
+
+// Enabled reports whether lookups can return data
+func (d *DB) Enabled() bool {
+	return d != nil && len(d.readers) > 0
+}
+
+// Lookup returns location and ASN info for an address (with or without port).
+// It returns nil when GeoIP is disabled, the address is invalid or private,
+// or none of the databases know about it.
+func (d *DB) Lookup(addr string) *models.GeoInfo {
+	if !d.Enabled() {
+		return nil
+	}
+
+	ip := parseAddr(addr)
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return nil
+	}
+
+	info := &models.GeoInfo{}
+	found := false
+	for _, reader := range d.readers {
+		var rec record
+		if err := reader.Lookup(ip, &rec); err != nil {
+			continue
+		}
+		if rec.Country.ISOCode != "" {
+			info.CountryCode = rec.Country.ISOCode
+			info.Country = rec.Country.Names["en"]
+			found = true
+		}
+		if city := rec.City.Names["en"]; city != "" {
+			info.City = city
+			found = true
+		}
+		if rec.ASN != 0 {
+			info.ASN = rec.ASN
+			info.ASOrg = rec.ASOrg
+			found = true
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	return info
+}
+
+// parseAddr accepts "1.2.3.4", "1.2.3.4:51820", "[2001:db8::1]:51820", "[2001:db8::1]" and CIDRs
+func parseAddr(addr string) net.IP {
+	addr = strings.TrimSpace(addr)
+	if addr == "" {
+		return nil
+	}
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return net.ParseIP(host)
+	}
+	if ip, _, err := net.ParseCIDR(addr); err == nil {
+		return ip
+	}
+	return net.ParseIP(strings.Trim(addr, "[]"))
+}
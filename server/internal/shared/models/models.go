@@ -1,6 +1,7 @@
 package models
 
 import (
+	"bytes"
 	"net"
 	"time"
 )
@@ -20,34 +21,152 @@ type Network struct {
 	ID               string    `json:"id"`
 	Name             string    `json:"name"`
 	CIDR             string    `json:"cidr"`
+	CIDR6            string    `json:"cidr6,omitempty"`             // Optional IPv6 range of a dual-stack network
 	ServerPrivateKey string    `json:"-"`                           // Hub's private key (never sent to client)
 	ServerPublicKey  string    `json:"server_public_key,omitempty"` // Hub's public key (sent to peers)
 	ServerEndpoint   string    `json:"server_endpoint,omitempty"`   // Hub's endpoint (IP:Port)
 	ListenPort       int       `json:"listen_port"`                 // UDP port (e.g., 51820)
 	InterfaceName    string    `json:"interface_name"`              // Interface name (e.g., wg0)
+	ClientConfig     ClientConfigOptions `json:"client_config"`     // Defaults for generated client configs
+	Egress           EgressConfig        `json:"egress"`            // Internet egress through the hub
+	Mesh             bool                `json:"mesh"`              // Nodes peer directly, the hub relays the rest
+	ActiveGatewayID  *string             `json:"active_gateway_id,omitempty"` // Gateway clients route through, nil = built-in hub
+	RemoteHub        bool                `json:"remote_hub"`        // Served only by remote gateways, no interface on the control plane host
+	ProbeInterval    int                 `json:"probe_interval"`    // Seconds between latency probes of online nodes, 0 disables
 	CreatedAt        time.Time `json:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at"`
 }
 
+// CIDRs returns the address ranges of the network (IPv4 and/or IPv6)
+func (n *Network) CIDRs() []string {
+	cidrs := []string{n.CIDR}
+	if n.CIDR6 != "" {
+		cidrs = append(cidrs, n.CIDR6)
+	}
+	return cidrs
+}
+
 // Node represents a peer (spoke) in the VPN network
 type Node struct {
 	ID        string            `json:"id"`
 	NetworkID string            `json:"network_id"`
 	Name      string            `json:"name"`
 	VirtualIP net.IP            `json:"virtual_ip"`
+	VirtualIP6 net.IP           `json:"virtual_ip6,omitempty"` // Set in dual-stack networks
 	PublicKey string            `json:"public_key"`
+	PresharedKey string         `json:"-"` // Optional WireGuard PSK (never sent in node listings)
 	Labels    map[string]string `json:"labels"`
 	Status    NodeStatus        `json:"status"`
 	LastSeen  time.Time         `json:"last_seen"`
 	PublicIP  string            `json:"public_ip,omitempty"`
+	Geo       *GeoInfo          `json:"geo,omitempty"`
 	TransferRx int64            `json:"transfer_rx,omitempty"`
 	TransferTx int64            `json:"transfer_tx,omitempty"`
 	ExpiresAt *time.Time        `json:"expires_at,omitempty"`
 	NodeInfo  *NodeInfo         `json:"node_info,omitempty"`
-	Endpoints []string          `json:"endpoints"`
+	Endpoints []string          `json:"endpoints"` // Reachable host:port addresses reported on check-in (mesh mode)
+	PreviousPublicKey    string     `json:"previous_public_key,omitempty"`     // Old key still accepted during a rotation grace period
+	PreviousKeyExpiresAt *time.Time `json:"previous_key_expires_at,omitempty"` // End of the grace period
+	KeyRotatedAt         *time.Time `json:"key_rotated_at,omitempty"`
+	KeyRotationDays      int        `json:"key_rotation_days,omitempty"` // Automatic rotation interval, 0 = manual
+	ClientConfig         *ClientConfigOptions `json:"client_config,omitempty"` // Overrides of the network's client config defaults
+	AdvertisedRoutes     []string   `json:"advertised_routes,omitempty"` // LAN subnets the node offers to route
+	ApprovedRoutes       []string   `json:"approved_routes,omitempty"`   // Advertised subnets approved by an admin
+	ExitNode             bool       `json:"exit_node"`                   // Internet traffic of other nodes may leave through this node
+	ExitNodeID           *string    `json:"exit_node_id,omitempty"`      // Exit node this node's internet traffic uses (overrides the network)
+	Latency              *NodeProbe `json:"latency,omitempty"`           // Latest latency probe from the hub
 	CreatedAt time.Time         `json:"created_at"`
 }
 
+// Addresses returns the node's VPN addresses (IPv4 and/or IPv6)
+func (n *Node) Addresses() []net.IP {
+	var addrs []net.IP
+	if n.VirtualIP != nil {
+		addrs = append(addrs, n.VirtualIP)
+	}
+	if n.VirtualIP6 != nil {
+		addrs = append(addrs, n.VirtualIP6)
+	}
+	return addrs
+}
+
+// AllowedIPs modes of generated client configs
+const (
+	AllowedIPsSplit  = "split"  // Only the VPN networks the node may reach (default)
+	AllowedIPsFull   = "full"   // All traffic through the hub (0.0.0.0/0, ::/0)
+	AllowedIPsCustom = "custom" // CustomAllowedIPs
+)
+
+// DefaultPersistentKeepalive is used when no keepalive is configured
+const DefaultPersistentKeepalive = 25
+
+// ClientConfigOptions shapes the [Interface] and [Peer] sections of generated
+// client configs. Zero values mean "not set": on a network they fall back to
+// the built-in defaults, on a node to the network's value.
+type ClientConfigOptions struct {
+	DNS                 []string `json:"dns,omitempty"`
+	MTU                 int      `json:"mtu,omitempty"`
+	PersistentKeepalive *int     `json:"persistent_keepalive,omitempty"` // 0 disables keepalive
+	ListenPort          int      `json:"listen_port,omitempty"`          // Client-side UDP port
+	AllowedIPsMode      string   `json:"allowed_ips_mode,omitempty"`     // split, full or custom
+	CustomAllowedIPs    []string `json:"custom_allowed_ips,omitempty"`   // Used with the custom mode
+}
+
+// Merge returns o with every field that is set in override replaced
+func (o ClientConfigOptions) Merge(override *ClientConfigOptions) ClientConfigOptions {
+	if override == nil {
+		return o
+	}
+	if len(override.DNS) > 0 {
+		o.DNS = override.DNS
+	}
+	if override.MTU != 0 {
+		o.MTU = override.MTU
+	}
+	if override.PersistentKeepalive != nil {
+		o.PersistentKeepalive = override.PersistentKeepalive
+	}
+	if override.ListenPort != 0 {
+		o.ListenPort = override.ListenPort
+	}
+	if override.AllowedIPsMode != "" {
+		o.AllowedIPsMode = override.AllowedIPsMode
+		o.CustomAllowedIPs = override.CustomAllowedIPs
+	}
+	return o
+}
+
+// Keepalive returns the effective PersistentKeepalive in seconds
+func (o ClientConfigOptions) Keepalive() int {
+	if o.PersistentKeepalive == nil {
+		return DefaultPersistentKeepalive
+	}
+	return *o.PersistentKeepalive
+}
+
+// Egress modes of a network
+const (
+	EgressDisabled = "disabled"  // No internet egress through the hub (default)
+	EgressNAT      = "nat"       // NAT client traffic out of an uplink interface
+	EgressExitNode = "exit_node" // Route client traffic to an exit node peer
+)
+
+// EgressConfig controls internet egress of a network's clients through the hub.
+// The NAT and DNS rules are managed by the control plane's firewall code.
+type EgressConfig struct {
+	Mode          string `json:"mode,omitempty"`            // disabled or nat
+	Interface     string `json:"interface,omitempty"`       // Uplink interface, e.g. eth0; empty uses the default route's
+	SourceIP      string `json:"source_ip,omitempty"`       // SNAT source address, MASQUERADE when empty
+	SourceIP6     string `json:"source_ip6,omitempty"`      // SNAT source address for the IPv6 range
+	BlockDNSLeaks bool   `json:"block_dns_leaks,omitempty"` // Only allow DNS to the client config's DNS servers
+	ExitNodeID    string `json:"exit_node_id,omitempty"`    // Exit node used in exit_node mode
+}
+
+// Enabled reports whether the hub NATs the network's internet traffic
+func (e EgressConfig) Enabled() bool {
+	return e.Mode == EgressNAT
+}
+
 // NodeInfo contains metadata about the node's system
 type NodeInfo struct {
 	OS           string `json:"os"`
@@ -55,6 +174,15 @@ type NodeInfo struct {
 	Hostname     string `json:"hostname"`
 }
 
+// GeoInfo describes where a public IP address is located (from GeoIP lookup)
+type GeoInfo struct {
+	CountryCode string `json:"country_code,omitempty"`
+	Country     string `json:"country,omitempty"`
+	City        string `json:"city,omitempty"`
+	ASN         uint   `json:"asn,omitempty"`
+	ASOrg       string `json:"as_org,omitempty"`
+}
+
 // User represents a system user (admin)
 type User struct {
 	ID           string    `json:"id"`
@@ -86,13 +214,13 @@ type VPNFirewallRule struct {
 	Description     string    `json:"description,omitempty"`
 	
 	// Source configuration
-	SourceType      string    `json:"source_type"`       // any, network, node, custom
+	SourceType      string    `json:"source_type"`       // any, network, node, subnet, custom
 	SourceNetworkID *string   `json:"source_network_id,omitempty"`
 	SourceNodeID    *string   `json:"source_node_id,omitempty"`
 	SourceIP        string    `json:"source_ip,omitempty"`
 	
 	// Destination configuration
-	DestType        string    `json:"dest_type"`         // any, network, node, custom
+	DestType        string    `json:"dest_type"`         // any, network, node, subnet, custom
 	DestNetworkID   *string   `json:"dest_network_id,omitempty"`
 	DestNodeID      *string   `json:"dest_node_id,omitempty"`
 	DestIP          string    `json:"dest_ip,omitempty"`
@@ -123,3 +251,321 @@ type FirewallAuditLog struct {
 	UserIP    string                 `json:"user_ip,omitempty"`
 	CreatedAt time.Time              `json:"created_at"`
 }
+
+// Report is a generated usage and inventory report for one network and period
+type Report struct {
+	ID          string      `json:"id"`
+	NetworkID   string      `json:"network_id"`
+	NetworkName string      `json:"network_name"`
+	Period      string      `json:"period"` // daily, weekly, monthly, custom
+	PeriodStart time.Time   `json:"period_start"`
+	PeriodEnd   time.Time   `json:"period_end"`
+	Data        *ReportData `json:"data,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+}
+
+// ReportData is the content of a report
+type ReportData struct {
+	NetworkCIDR     string                 `json:"network_cidr"`
+	Nodes           ReportNodeSummary      `json:"nodes"`
+	TopTalkers      []ReportTalker         `json:"top_talkers"`
+	Bans            ReportBanSummary       `json:"bans"`
+	FirewallChanges []ReportFirewallChange `json:"firewall_changes"`
+}
+
+// ReportNodeSummary describes node inventory changes within the period
+type ReportNodeSummary struct {
+	Total         int            `json:"total"`
+	PreviousTotal int            `json:"previous_total"`
+	Change        int            `json:"change"`
+	ByStatus      map[string]int `json:"by_status"`
+	Created       []ReportNode   `json:"created"`
+	Expired       []ReportNode   `json:"expired"`
+}
+
+// ReportNode is a node reference in a report
+type ReportNode struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	VirtualIP string     `json:"virtual_ip"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ReportTalker is a node's bandwidth usage within the period
+type ReportTalker struct {
+	NodeID    string `json:"node_id"`
+	Name      string `json:"name"`
+	VirtualIP string `json:"virtual_ip"`
+	RxBytes   int64  `json:"rx_bytes"`
+	TxBytes   int64  `json:"tx_bytes"`
+	Total     int64  `json:"total_bytes"`
+}
+
+// ReportCounter is a pair of WireGuard transfer byte counts
+type ReportCounter struct {
+	Rx int64 `json:"rx"`
+	Tx int64 `json:"tx"`
+}
+
+// ReportBanSummary aggregates fail2ban activity within the period
+type ReportBanSummary struct {
+	Bans      int            `json:"bans"`
+	Unbans    int            `json:"unbans"`
+	UniqueIPs int            `json:"unique_ips"`
+	ByJail    map[string]int `json:"by_jail"`
+	ByCountry map[string]int `json:"by_country,omitempty"`
+}
+
+// ReportFirewallChange is a firewall audit log entry within the period
+type ReportFirewallChange struct {
+	Time    time.Time              `json:"time"`
+	Action  string                 `json:"action"`
+	Details map[string]interface{} `json:"details,omitempty"`
+	UserIP  string                 `json:"user_ip,omitempty"`
+}
+
+// Key rotation statuses
+const (
+	KeyRotationOverlap   = "overlap"   // Old key still served on the overlap interface
+	KeyRotationCompleted = "completed" // Old key retired
+)
+
+// KeyRotation records a rotation of a network's server keypair
+type KeyRotation struct {
+	ID               string     `json:"id"`
+	NetworkID        string     `json:"network_id"`
+	OldPublicKey     string     `json:"old_public_key"`
+	NewPublicKey     string     `json:"new_public_key"`
+	OldPrivateKey    string     `json:"-"` // Kept only while the overlap interface runs
+	OldListenPort    int        `json:"old_listen_port"`
+	NewListenPort    int        `json:"new_listen_port"`
+	OverlapInterface string     `json:"overlap_interface,omitempty"`
+	OverlapUntil     *time.Time `json:"overlap_until,omitempty"`
+	Status           string     `json:"status"`
+	InitiatedBy      string     `json:"initiated_by,omitempty"`
+	CreatedAt        time.Time  `json:"created_at"`
+	CompletedAt      *time.Time `json:"completed_at,omitempty"`
+}
+
+// NodeConfigStatus tracks which server key a node's last fetched config contains
+type NodeConfigStatus struct {
+	NodeID          string     `json:"node_id"`
+	Name            string     `json:"name"`
+	ConfigServerKey string     `json:"config_server_key,omitempty"`
+	ConfigFetchedAt *time.Time `json:"config_fetched_at,omitempty"`
+	UpToDate        bool       `json:"up_to_date"`
+}
+
+// Node event types
+const (
+	NodeEventServerKeyRotated = "server_key_rotated"
+	NodeEventNodeKeyRotated   = "node_key_rotated"
+	NodeEventKeyRotationDue   = "node_key_rotation_due" // The node should submit a new public key
+	NodeEventGatewayFailover  = "gateway_failover"
+	NodeEventNetworkUpdated   = "network_updated"
+)
+
+// IP reservation kinds
+const (
+	IPReservationReserved = "reserved" // Skipped by automatic allocation, assignable on request
+	IPReservationExcluded = "excluded" // Never assigned to nodes
+)
+
+// IPReservation is an address range of a network kept out of automatic
+// allocation
+type IPReservation struct {
+	ID          string    `json:"id"`
+	NetworkID   string    `json:"network_id"`
+	Start       net.IP    `json:"start"`
+	End         net.IP    `json:"end"`
+	Kind        string    `json:"kind"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Contains reports whether ip lies in the reservation
+func (r *IPReservation) Contains(ip net.IP) bool {
+	return bytes.Compare(ip.To16(), r.Start.To16()) >= 0 && bytes.Compare(ip.To16(), r.End.To16()) <= 0
+}
+
+// AddressBlock is a run of addresses with the same use in a network's
+// address map; node blocks are single addresses
+type AddressBlock struct {
+	Start    net.IP `json:"start"`
+	End      net.IP `json:"end"`
+	Size     string `json:"size"`   // Decimal, IPv6 blocks exceed 64 bits
+	Status   string `json:"status"` // network, hub, broadcast, node, reserved, excluded or free
+	NodeID   string `json:"node_id,omitempty"`
+	NodeName string `json:"node_name,omitempty"`
+}
+
+// AddressUtilization summarizes one address range of a network
+type AddressUtilization struct {
+	CIDR     string          `json:"cidr"`
+	Total    string          `json:"total"` // Counts are decimal strings, IPv6 ranges exceed 64 bits
+	Used     string          `json:"used"`
+	Free     string          `json:"free"`
+	Reserved string          `json:"reserved"`
+	Excluded string          `json:"excluded"`
+	Map      []*AddressBlock `json:"map"`
+}
+
+// NodeAddressChange is one node's entry in the renumber plan of a network
+// whose address ranges change
+type NodeAddressChange struct {
+	NodeID   string `json:"node_id"`
+	NodeName string `json:"node_name"`
+	OldIP    net.IP `json:"old_ip,omitempty"`
+	NewIP    net.IP `json:"new_ip,omitempty"`
+	OldIP6   net.IP `json:"old_ip6,omitempty"`
+	NewIP6   net.IP `json:"new_ip6,omitempty"`
+}
+
+// SubnetRoute is a LAN subnet advertised by a node acting as subnet router
+type SubnetRoute struct {
+	NodeID    string `json:"node_id"`
+	NodeName  string `json:"node_name"`
+	NetworkID string `json:"network_id"`
+	CIDR      string `json:"cidr"`
+	Approved  bool   `json:"approved"`
+}
+
+// GatewayStatus is the health of a gateway, derived from its heartbeats
+type GatewayStatus string
+
+const (
+	GatewayStatusUnknown   GatewayStatus = "unknown" // No heartbeat yet
+	GatewayStatusHealthy   GatewayStatus = "healthy"
+	GatewayStatusUnhealthy GatewayStatus = "unhealthy"
+)
+
+// Gateway is an additional hub of a network on another host, with its own
+// endpoint and keypair. Clients keep it as a standby peer until it becomes the
+// network's active gateway.
+type Gateway struct {
+	ID         string        `json:"id"`
+	NetworkID  string        `json:"network_id"`
+	Name       string        `json:"name"`
+	Endpoint   string        `json:"endpoint"` // host:port
+	PrivateKey string        `json:"-"`
+	PublicKey  string        `json:"public_key"`
+	Priority   int           `json:"priority"` // Lower is preferred; the built-in hub is 0
+	Status     GatewayStatus `json:"status"`
+	LastSeen   *time.Time    `json:"last_seen,omitempty"` // Last heartbeat
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// GatewayPeer is a node peer a gateway must configure on its interface
+type GatewayPeer struct {
+	NodeID       string `json:"node_id"`
+	PublicKey    string `json:"public_key"`
+	PresharedKey string `json:"preshared_key,omitempty"`
+	AllowedIPs   string `json:"allowed_ips"` // Comma separated; empty for a new key during a rotation grace period
+}
+
+// GatewayState is the desired state a gateway agent applies to its host
+type GatewayState struct {
+	GatewayID string            `json:"gateway_id"`
+	NetworkID string            `json:"network_id"`
+	Active    bool              `json:"active"` // Clients currently route through this gateway
+	Interface GatewayInterface  `json:"interface"`
+	Peers     []GatewayPeer     `json:"peers"`
+	Firewall  []FirewallCommand `json:"firewall"` // VPN firewall rules, in order
+}
+
+// GatewayInterface is the WireGuard interface a gateway agent runs
+type GatewayInterface struct {
+	Name       string `json:"name"`
+	PrivateKey string `json:"private_key"`
+	Address    string `json:"address"` // Comma separated, the network's hub addresses
+	ListenPort int    `json:"listen_port"`
+}
+
+// FirewallCommand is one iptables/ip6tables invocation
+type FirewallCommand struct {
+	Command string   `json:"command"` // iptables or ip6tables
+	Args    []string `json:"args"`
+}
+
+// GatewayPeerStats is the live state of one peer reported by a gateway agent
+type GatewayPeerStats struct {
+	PublicKey       string `json:"public_key"`
+	Endpoint        string `json:"endpoint,omitempty"`
+	LatestHandshake int64  `json:"latest_handshake"` // Unix seconds, 0 = never
+	TransferRx      int64  `json:"transfer_rx"`
+	TransferTx      int64  `json:"transfer_tx"`
+}
+
+// MeshPeer is a node another node of a mesh network connects to directly
+type MeshPeer struct {
+	NodeID     string   `json:"node_id"`
+	Name       string   `json:"name"`
+	PublicKey    string   `json:"public_key"`
+	PresharedKey string   `json:"preshared_key,omitempty"` // Shared by the pair, derived from both nodes' keys
+	Endpoint     string   `json:"endpoint"`                // First reported endpoint
+	Endpoints    []string `json:"endpoints"`               // All reported endpoints
+	AllowedIPs   []string `json:"allowed_ips"`
+}
+
+// NodeEvent is a notification queued for a node and delivered on check-in
+type NodeEvent struct {
+	ID          string                 `json:"id"`
+	NodeID      string                 `json:"node_id"`
+	Type        string                 `json:"type"`
+	Payload     map[string]interface{} `json:"payload,omitempty"`
+	CreatedAt   time.Time              `json:"created_at"`
+	DeliveredAt *time.Time             `json:"delivered_at,omitempty"`
+}
+
+// DefaultProbeInterval is the latency probe interval of new networks in seconds
+const DefaultProbeInterval = 30
+
+// NodeProbe is one round of latency probes from the hub to a node. RTTs and
+// jitter are nil when no probe was answered.
+type NodeProbe struct {
+	NodeID      string    `json:"node_id"`
+	ProbedAt    time.Time `json:"probed_at"`
+	Method      string    `json:"method"` // icmp or udp
+	Target      net.IP    `json:"target"`
+	Sent        int       `json:"sent"`
+	Received    int       `json:"received"`
+	RTTAvgMs    *float64  `json:"rtt_avg_ms,omitempty"`
+	RTTMinMs    *float64  `json:"rtt_min_ms,omitempty"`
+	RTTMaxMs    *float64  `json:"rtt_max_ms,omitempty"`
+	JitterMs    *float64  `json:"jitter_ms,omitempty"`
+	LossPercent float64   `json:"loss_percent"`
+}
+
+// EnrollmentToken lets a node register itself with a locally generated
+// public key. It can be used once before it expires; the node gets the
+// token's name, labels, addresses and client config.
+type EnrollmentToken struct {
+	ID            string               `json:"id"`
+	NetworkID     string               `json:"network_id"`
+	Token         string               `json:"token,omitempty"` // Only returned on creation, stored as a hash
+	Name          string               `json:"name,omitempty"`  // Node name, empty lets the node choose
+	Labels        map[string]string    `json:"labels,omitempty"`
+	ClientConfig  *ClientConfigOptions `json:"client_config,omitempty"`
+	VirtualIP     net.IP               `json:"virtual_ip,omitempty"`
+	VirtualIP6    net.IP               `json:"virtual_ip6,omitempty"`
+	PresharedKey  bool                 `json:"preshared_key"`
+	NodeExpiresAt *time.Time           `json:"node_expires_at,omitempty"`
+	ExpiresAt     time.Time            `json:"expires_at"`
+	UsedAt        *time.Time           `json:"used_at,omitempty"`
+	NodeID        *string              `json:"node_id,omitempty"` // Node created with the token
+	Status        string               `json:"status"`            // active, used or expired
+	CreatedAt     time.Time            `json:"created_at"`
+}
+
+// State returns active, used or expired
+func (t *EnrollmentToken) State() string {
+	switch {
+	case t.UsedAt != nil:
+		return "used"
+	case time.Now().After(t.ExpiresAt):
+		return "expired"
+	default:
+		return "active"
+	}
+}
@@ -0,0 +1,135 @@
+// Package netutil contains address helpers shared by the control plane that
+// work the same for IPv4 and IPv6 networks.
+package netutil
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+)
+
+// IsIPv6 reports whether an address or CIDR string is an IPv6 one
+func IsIPv6(addr string) bool {
+	if ip, _, err := net.ParseCIDR(addr); err == nil {
+		return ip.To4() == nil
+	}
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip.To4() == nil
+	}
+	return strings.Contains(addr, ":")
+}
+
+// NextIP returns the address following ip, wrapping on overflow
+func NextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// HostCIDR returns ip as a single-host prefix (/32 for IPv4, /128 for IPv6)
+func HostCIDR(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String() + "/32"
+	}
+	return ip.String() + "/128"
+}
+
+// ServerAddress returns the hub's interface address for a network CIDR:
+// the first host address, keeping the network prefix length
+// (10.99.0.0/24 -> 10.99.0.1/24, fd00:99::/64 -> fd00:99::1/64).
+func ServerAddress(cidr string) (string, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+	}
+	ones, _ := ipNet.Mask.Size()
+	return fmt.Sprintf("%s/%d", NextIP(ipNet.IP).String(), ones), nil
+}
+
+// ServerAddresses returns the hub's interface addresses for every non-empty
+// CIDR, e.g. the IPv4 and IPv6 ranges of a dual-stack network
+func ServerAddresses(cidrs ...string) ([]string, error) {
+	var addrs []string
+	for _, cidr := range cidrs {
+		if cidr == "" {
+			continue
+		}
+		addr, err := ServerAddress(cidr)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// RemapIP moves ip from one network to another, keeping its host part
+// (10.0.0.7 from 10.0.0.0/24 to 10.5.0.0/16 -> 10.5.0.7). It returns nil when
+// ip is not in from, or its host part does not fit in to.
+func RemapIP(ip net.IP, from, to *net.IPNet) net.IP {
+	if !from.Contains(ip) || len(from.IP) != len(to.IP) {
+		return nil
+	}
+	if len(from.IP) == net.IPv4len {
+		ip = ip.To4()
+	} else {
+		ip = ip.To16()
+	}
+
+	out := make(net.IP, len(to.IP))
+	for i := range out {
+		host := ip[i] &^ from.Mask[i]
+		if host&to.Mask[i] != 0 {
+			return nil
+		}
+		out[i] = to.IP[i] | host
+	}
+	return out
+}
+
+// CompareIP orders two addresses of the same family like bytes.Compare
+func CompareIP(a, b net.IP) int {
+	return bytes.Compare(a.To16(), b.To16())
+}
+
+// NodeRange returns the addresses of a network range that can be given to
+// nodes: everything after the network address and the hub, except the IPv4
+// broadcast address
+func NodeRange(ipNet *net.IPNet) (first, last net.IP) {
+	first = NextIP(NextIP(ipNet.IP.Mask(ipNet.Mask)))
+	last = make(net.IP, len(ipNet.IP))
+	for i := range last {
+		last[i] = ipNet.IP[i] | ^ipNet.Mask[i]
+	}
+	if ones, bits := ipNet.Mask.Size(); bits == 32 && ones < 31 {
+		last = PrevIP(last)
+	}
+	return first, last
+}
+
+// PrevIP returns the address preceding ip, wrapping on underflow
+func PrevIP(ip net.IP) net.IP {
+	prev := make(net.IP, len(ip))
+	copy(prev, ip)
+	for i := len(prev) - 1; i >= 0; i-- {
+		prev[i]--
+		if prev[i] != 0xff {
+			break
+		}
+	}
+	return prev
+}
+
+// RangeSize returns the number of addresses from start to end, inclusive
+func RangeSize(start, end net.IP) *big.Int {
+	size := new(big.Int).Sub(new(big.Int).SetBytes(end.To16()), new(big.Int).SetBytes(start.To16()))
+	return size.Add(size, big.NewInt(1))
+}
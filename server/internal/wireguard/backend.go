@@ -0,0 +1,57 @@
+package wireguard
+
+// Backend is the set of WireGuard operations the control plane needs for one
+// interface. Manager implements it on top of the host (netlink or CLI);
+// SimulatedBackend keeps everything in memory for development.
+type Backend interface {
+	// Init verifies the backend is usable
+	Init() error
+	// Up brings the interface up from its stored configuration; an interface
+	// that is already up is not an error
+	Up() error
+	// Down brings the interface down; an interface that isn't up is not an error
+	Down() error
+	// IsUp reports whether the interface currently exists
+	IsUp() bool
+	// CreateServerConfigWithKey stores the interface configuration and (re)starts it
+	CreateServerConfigWithKey(privateKey string, addressCIDR string, port int) error
+
+	// AddPeer adds or updates a peer; presharedKey may be empty
+	AddPeer(publicKey, allowedIPs, presharedKey string) error
+	AddPeers(peers []PeerConfig) error
+	UpdatePeerAllowedIPs(publicKey, allowedIPs string) error
+	RemovePeer(publicKey string) error
+	RemovePeers(publicKeys []string) error
+
+	// GetPeers returns the live status of all peers keyed by public key
+	GetPeers() (map[string]PeerStatus, error)
+	// GetPublicKey returns the interface's public key
+	GetPublicKey() (string, error)
+}
+
+// NewBackend creates the backend of the given kind for an interface.
+// An empty kind selects netlink.
+func NewBackend(kind, interfaceName string) Backend {
+	if kind == BackendSimulated {
+		return NewSimulatedBackend(interfaceName)
+	}
+	return NewManager(interfaceName, kind)
+}
+
+// CheckBackend verifies that a backend kind is usable on this host without
+// setting up a backend for an interface
+func CheckBackend(kind string) error {
+	switch kind {
+	case BackendSimulated:
+		return nil
+	case BackendCLI:
+		return (&cliDevice{}).check()
+	default:
+		return (&netlinkDevice{}).check()
+	}
+}
+
+var (
+	_ Backend = (*Manager)(nil)
+	_ Backend = (*SimulatedBackend)(nil)
+)
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"os"
 	"strings"
+
+	"github.com/novusgate/novusgate/internal/shared/netutil"
 )
 
 // GetServerEndpoint returns the server endpoint from environment or default
@@ -28,7 +30,8 @@ func NewConfigGenerator() *ConfigGenerator {
 	return &ConfigGenerator{}
 }
 
-// GenerateServerConfig generates the wg0.conf content for the server
+// GenerateServerConfig generates the wg0.conf content for the server.
+// addressCIDR may list several comma separated addresses (dual-stack).
 func (g *ConfigGenerator) GenerateServerConfig(privateKey string, port int, addressCIDR string) string {
 	var sb strings.Builder
 
@@ -37,25 +40,97 @@ func (g *ConfigGenerator) GenerateServerConfig(privateKey string, port int, addr
 	sb.WriteString(fmt.Sprintf("Address = %s\n", addressCIDR))
 	sb.WriteString(fmt.Sprintf("ListenPort = %d\n", port))
 	sb.WriteString("SaveConfig = false\n") // We manage peers manually/via DB
-	sb.WriteString("PostUp = iptables -A FORWARD -i %i -j ACCEPT; iptables -A FORWARD -o %i -j ACCEPT; iptables -t nat -A POSTROUTING -o $(ip route get 8.8.8.8 | awk '{print $5; exit}') -j MASQUERADE\n")
-	sb.WriteString("PostDown = iptables -D FORWARD -i %i -j ACCEPT; iptables -D FORWARD -o %i -j ACCEPT; iptables -t nat -D POSTROUTING -o $(ip route get 8.8.8.8 | awk '{print $5; exit}') -j MASQUERADE\n")
+	// Routes are managed by the control plane; an exit node peer's 0.0.0.0/0
+	// must not become the host's default route
+	sb.WriteString("Table = off\n")
+	// Egress NAT is managed by the control plane, per network
+	sb.WriteString("PostUp = iptables -A FORWARD -i %i -j ACCEPT; iptables -A FORWARD -o %i -j ACCEPT\n")
+	sb.WriteString("PostDown = iptables -D FORWARD -i %i -j ACCEPT; iptables -D FORWARD -o %i -j ACCEPT\n")
+	if hasIPv6Address(addressCIDR) {
+		// Same forwarding for the IPv6 range of the interface
+		sb.WriteString("PostUp = sysctl -q -w net.ipv6.conf.all.forwarding=1; ip6tables -A FORWARD -i %i -j ACCEPT; ip6tables -A FORWARD -o %i -j ACCEPT\n")
+		sb.WriteString("PostDown = ip6tables -D FORWARD -i %i -j ACCEPT; ip6tables -D FORWARD -o %i -j ACCEPT\n")
+	}
 
 	return sb.String()
 }
 
-// GeneratePeerConfig generates the client/peer configuration file content
-func (g *ConfigGenerator) GeneratePeerConfig(clientPrivateKey, serverPublicKey, serverEndpoint, clientIP string, allowedIPs string) string {
+// hasIPv6Address reports whether a comma separated Address value contains an
+// IPv6 address
+func hasIPv6Address(addresses string) bool {
+	for _, addr := range splitList(addresses) {
+		if netutil.IsIPv6(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerConfigOptions are the optional settings of a client config. Zero
+// values are omitted from the generated file.
+type PeerConfigOptions struct {
+	DNS                 []string
+	MTU                 int
+	ListenPort          int
+	PersistentKeepalive int
+	PostUp              []string
+	PostDown            []string
+	Peers               []PeerConfig // Additional peers after the hub (standby gateways, mesh peers)
+}
+
+// GeneratePeerConfig generates the client/peer configuration file content.
+// presharedKey is optional and omitted from the config when empty. clientIP
+// may list both addresses of a dual-stack node, comma separated.
+func (g *ConfigGenerator) GeneratePeerConfig(clientPrivateKey, serverPublicKey, presharedKey, serverEndpoint, clientIP string, allowedIPs string, opts PeerConfigOptions) string {
 	var sb strings.Builder
 
 	sb.WriteString("[Interface]\n")
 	sb.WriteString(fmt.Sprintf("PrivateKey = %s\n", clientPrivateKey))
 	sb.WriteString(fmt.Sprintf("Address = %s\n", clientIP))
+	if opts.ListenPort > 0 {
+		sb.WriteString(fmt.Sprintf("ListenPort = %d\n", opts.ListenPort))
+	}
+	if len(opts.DNS) > 0 {
+		sb.WriteString(fmt.Sprintf("DNS = %s\n", strings.Join(opts.DNS, ", ")))
+	}
+	if opts.MTU > 0 {
+		sb.WriteString(fmt.Sprintf("MTU = %d\n", opts.MTU))
+	}
+	for _, hook := range opts.PostUp {
+		sb.WriteString(fmt.Sprintf("PostUp = %s\n", hook))
+	}
+	for _, hook := range opts.PostDown {
+		sb.WriteString(fmt.Sprintf("PostDown = %s\n", hook))
+	}
 
 	sb.WriteString("\n[Peer]\n")
 	sb.WriteString(fmt.Sprintf("PublicKey = %s\n", serverPublicKey))
+	if presharedKey != "" {
+		sb.WriteString(fmt.Sprintf("PresharedKey = %s\n", presharedKey))
+	}
 	sb.WriteString(fmt.Sprintf("Endpoint = %s\n", serverEndpoint))
 	sb.WriteString(fmt.Sprintf("AllowedIPs = %s\n", allowedIPs))
-	sb.WriteString("PersistentKeepalive = 25\n")
+	if opts.PersistentKeepalive > 0 {
+		sb.WriteString(fmt.Sprintf("PersistentKeepalive = %d\n", opts.PersistentKeepalive))
+	}
+
+	for _, peer := range opts.Peers {
+		sb.WriteString("\n[Peer]\n")
+		sb.WriteString(fmt.Sprintf("PublicKey = %s\n", peer.PublicKey))
+		if peer.PresharedKey != "" {
+			sb.WriteString(fmt.Sprintf("PresharedKey = %s\n", peer.PresharedKey))
+		}
+		if peer.Endpoint != "" {
+			sb.WriteString(fmt.Sprintf("Endpoint = %s\n", peer.Endpoint))
+		}
+		if peer.AllowedIPs != "" {
+			// Standby gateways have no AllowedIPs until an agent fails over
+			sb.WriteString(fmt.Sprintf("AllowedIPs = %s\n", peer.AllowedIPs))
+		}
+		if peer.PersistentKeepalive > 0 {
+			sb.WriteString(fmt.Sprintf("PersistentKeepalive = %d\n", peer.PersistentKeepalive))
+		}
+	}
 
 	return sb.String()
 }
@@ -0,0 +1,225 @@
+package wireguard
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// cliDevice drives WireGuard through the wg, wg-quick and ip tools
+type cliDevice struct {
+	name string
+}
+
+func (d *cliDevice) check() error {
+	for _, bin := range []string{"wg", "wg-quick"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			return fmt.Errorf("%s command not found", bin)
+		}
+	}
+	return nil
+}
+
+func (d *cliDevice) up(configPath string) error {
+	output, err := exec.Command("wg-quick", "up", d.name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("wg-quick up failed: %s: %w", strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+func (d *cliDevice) down(configPath string) error {
+	output, err := exec.Command("wg-quick", "down", d.name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("wg-quick down failed: %s: %w", strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+// sync runs `wg syncconf` with the stripped config and replaces the
+// addresses, removing the ones no longer in the config (e.g. after renumbering)
+func (d *cliDevice) sync(configPath string) error {
+	stripped, err := exec.Command("wg-quick", "strip", configPath).Output()
+	if err != nil {
+		return fmt.Errorf("wg-quick strip failed: %w", err)
+	}
+	tmp, err := writeTempKey(string(stripped))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	output, err := exec.Command("wg", "syncconf", d.name, tmp).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("wg syncconf failed: %s: %w", strings.TrimSpace(string(output)), err)
+	}
+
+	cfg, err := readDeviceConfig(configPath)
+	if err != nil {
+		return err
+	}
+	wanted := make(map[string]bool, len(cfg.Addresses))
+	for _, addr := range cfg.Addresses {
+		output, err := exec.Command("ip", "address", "replace", addr, "dev", d.name).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to set address %s: %s: %w", addr, strings.TrimSpace(string(output)), err)
+		}
+		wanted[normalizeAddress(addr)] = true
+	}
+
+	// `ip -o address show` prints one address per line: index, name, family, address
+	listing, err := exec.Command("ip", "-o", "address", "show", "dev", d.name).Output()
+	if err != nil {
+		return fmt.Errorf("failed to list addresses of %s: %w", d.name, err)
+	}
+	for _, line := range strings.Split(string(listing), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 4 || (fields[2] != "inet" && fields[2] != "inet6") {
+			continue
+		}
+		addr := normalizeAddress(fields[3])
+		if wanted[addr] || strings.HasPrefix(addr, "fe80:") {
+			continue
+		}
+		// The route of the address's prefix goes with it
+		output, err := exec.Command("ip", "address", "del", fields[3], "dev", d.name).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("failed to remove address %s: %s: %w", fields[3], strings.TrimSpace(string(output)), err)
+		}
+	}
+	return nil
+}
+
+// normalizeAddress formats an address with prefix length (10.0.0.1/24) the
+// way ip prints it, so config and interface addresses compare equal
+func normalizeAddress(addr string) string {
+	addr = strings.TrimSpace(addr)
+	if ip := net.ParseIP(addr); ip != nil {
+		// ip adds a host prefix to addresses without one
+		if ip.To4() != nil {
+			return ip.String() + "/32"
+		}
+		return ip.String() + "/128"
+	}
+	ip, ipNet, err := net.ParseCIDR(addr)
+	if err != nil {
+		return addr
+	}
+	ones, _ := ipNet.Mask.Size()
+	return fmt.Sprintf("%s/%d", ip, ones)
+}
+
+func (d *cliDevice) exists() bool {
+	return exec.Command("ip", "link", "show", d.name).Run() == nil
+}
+
+// configurePeers issues a single `wg set` with one peer clause per peer.
+// wg only reads preshared keys from files, so they go through 0600 temp files;
+// /dev/null removes a key.
+func (d *cliDevice) configurePeers(peers []PeerConfig) error {
+	args := []string{"set", d.name}
+	for _, p := range peers {
+		args = append(args, "peer", p.PublicKey)
+		if p.PresharedKey != "" {
+			pskFile, err := writeTempKey(p.PresharedKey)
+			if err != nil {
+				return err
+			}
+			defer os.Remove(pskFile)
+			args = append(args, "preshared-key", pskFile)
+		} else if p.ClearPresharedKey {
+			args = append(args, "preshared-key", "/dev/null")
+		}
+		if p.AllowedIPs != "" {
+			args = append(args, "allowed-ips", p.AllowedIPs)
+		}
+		if p.Endpoint != "" {
+			args = append(args, "endpoint", p.Endpoint)
+		}
+		if p.PersistentKeepalive > 0 {
+			args = append(args, "persistent-keepalive", strconv.Itoa(p.PersistentKeepalive))
+		}
+	}
+	output, err := exec.Command("wg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w", strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+func (d *cliDevice) removePeers(publicKeys []string) error {
+	args := []string{"set", d.name}
+	for _, key := range publicKeys {
+		args = append(args, "peer", key, "remove")
+	}
+	output, err := exec.Command("wg", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w", strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+// peers parses `wg show <iface> dump`. The first line describes the interface;
+// every other line is a tab separated peer record:
+// public-key preshared-key endpoint allowed-ips latest-handshake rx tx keepalive
+func (d *cliDevice) peers() (map[string]PeerStatus, error) {
+	output, err := exec.Command("wg", "show", d.name, "dump").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump wg status: %w", err)
+	}
+
+	peers := make(map[string]PeerStatus)
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	for i, line := range lines {
+		if i == 0 {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 8 {
+			continue
+		}
+
+		status := PeerStatus{
+			PublicKey: fields[0],
+		}
+		if fields[1] != "(none)" {
+			status.PresharedKey = fields[1]
+		}
+		if fields[2] != "(none)" {
+			status.Endpoint = fields[2]
+		}
+		if fields[3] != "(none)" {
+			status.AllowedIPs = fields[3]
+		}
+		status.LatestHandshakeTime, _ = strconv.ParseInt(fields[4], 10, 64)
+		status.TransferRx, _ = strconv.ParseInt(fields[5], 10, 64)
+		status.TransferTx, _ = strconv.ParseInt(fields[6], 10, 64)
+
+		peers[status.PublicKey] = status
+	}
+
+	return peers, nil
+}
+
+// writeTempKey writes a key (or any secret content) to a private temporary
+// file and returns its path
+func writeTempKey(key string) (string, error) {
+	f, err := os.CreateTemp("", "novusgate-psk-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create key file: %w", err)
+	}
+	defer f.Close()
+
+	if err := f.Chmod(0600); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to secure key file: %w", err)
+	}
+	if _, err := f.WriteString(key + "\n"); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write key file: %w", err)
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,402 @@
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// defaultMTU matches what wg-quick picks for a standard 1500 byte uplink
+const defaultMTU = 1420
+
+// wgClient is the wgctrl client shared by all netlink devices of the process.
+// Managers are created freely (per request, per health check), so a client
+// per device would leak a netlink socket each time.
+var (
+	wgClientMu sync.Mutex
+	wgClient   *wgctrl.Client
+)
+
+// netlinkDevice configures WireGuard through the kernel netlink API, without
+// spawning processes. Only PreUp/PostUp/PreDown/PostDown hooks from the config
+// file are run through the shell, like wg-quick does.
+type netlinkDevice struct {
+	name string
+}
+
+// wg returns the shared wgctrl client, opening it on first use
+func (d *netlinkDevice) wg() (*wgctrl.Client, error) {
+	wgClientMu.Lock()
+	defer wgClientMu.Unlock()
+
+	if wgClient == nil {
+		client, err := wgctrl.New()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open wireguard netlink: %w", err)
+		}
+		wgClient = client
+	}
+	return wgClient, nil
+}
+
+func (d *netlinkDevice) check() error {
+	_, err := d.wg()
+	return err
+}
+
+func (d *netlinkDevice) up(configPath string) error {
+	cfg, err := readDeviceConfig(configPath)
+	if err != nil {
+		return err
+	}
+	if d.exists() {
+		return fmt.Errorf("interface %s already exists", d.name)
+	}
+
+	client, err := d.wg()
+	if err != nil {
+		return err
+	}
+
+	if err := d.runHooks(cfg.PreUp); err != nil {
+		return err
+	}
+
+	mtu := cfg.MTU
+	if mtu == 0 {
+		mtu = defaultMTU
+	}
+	link := &netlink.Wireguard{LinkAttrs: netlink.LinkAttrs{Name: d.name, MTU: mtu}}
+	if err := netlink.LinkAdd(link); err != nil {
+		return fmt.Errorf("failed to create interface %s: %w", d.name, err)
+	}
+
+	// Undo the half-configured interface on any error below
+	fail := func(err error) error {
+		netlink.LinkDel(link)
+		return err
+	}
+
+	wgCfg := wgtypes.Config{ReplacePeers: true}
+	if cfg.PrivateKey != "" {
+		key, err := wgtypes.ParseKey(cfg.PrivateKey)
+		if err != nil {
+			return fail(fmt.Errorf("invalid private key: %w", err))
+		}
+		wgCfg.PrivateKey = &key
+	}
+	if cfg.ListenPort != 0 {
+		port := cfg.ListenPort
+		wgCfg.ListenPort = &port
+	}
+	for _, p := range cfg.Peers {
+		pc, err := toPeerConfig(p)
+		if err != nil {
+			return fail(err)
+		}
+		wgCfg.Peers = append(wgCfg.Peers, pc)
+	}
+	if err := client.ConfigureDevice(d.name, wgCfg); err != nil {
+		return fail(fmt.Errorf("failed to configure %s: %w", d.name, err))
+	}
+
+	for _, address := range cfg.Addresses {
+		addr, err := netlink.ParseAddr(address)
+		if err != nil {
+			return fail(fmt.Errorf("invalid address %q: %w", address, err))
+		}
+		if err := netlink.AddrReplace(link, addr); err != nil {
+			return fail(fmt.Errorf("failed to add address %s: %w", address, err))
+		}
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fail(fmt.Errorf("failed to bring %s up: %w", d.name, err))
+	}
+
+	return d.runHooks(cfg.PostUp)
+}
+
+func (d *netlinkDevice) down(configPath string) error {
+	// Hooks are best effort: the interface is removed even without a config
+	cfg, err := readDeviceConfig(configPath)
+	if err != nil {
+		cfg = &DeviceConfig{}
+	}
+
+	link, err := netlink.LinkByName(d.name)
+	if err != nil {
+		var notFound netlink.LinkNotFoundError
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to look up %s: %w", d.name, err)
+	}
+
+	if err := d.runHooks(cfg.PreDown); err != nil {
+		return err
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("failed to delete interface %s: %w", d.name, err)
+	}
+	return d.runHooks(cfg.PostDown)
+}
+
+// sync applies the config file to the running interface. Peers are diffed
+// against the live device so unchanged peers keep their sessions.
+func (d *netlinkDevice) sync(configPath string) error {
+	cfg, err := readDeviceConfig(configPath)
+	if err != nil {
+		return err
+	}
+	client, err := d.wg()
+	if err != nil {
+		return err
+	}
+	dev, err := client.Device(d.name)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", d.name, err)
+	}
+
+	wgCfg := wgtypes.Config{}
+	if cfg.PrivateKey != "" {
+		key, err := wgtypes.ParseKey(cfg.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("invalid private key: %w", err)
+		}
+		if key != dev.PrivateKey {
+			wgCfg.PrivateKey = &key
+		}
+	}
+	if cfg.ListenPort != 0 && cfg.ListenPort != dev.ListenPort {
+		port := cfg.ListenPort
+		wgCfg.ListenPort = &port
+	}
+
+	desired := make(map[wgtypes.Key]bool, len(cfg.Peers))
+	for _, p := range cfg.Peers {
+		pc, err := toPeerConfig(p)
+		if err != nil {
+			return err
+		}
+		if pc.PresharedKey == nil {
+			// Clear a preshared key that was removed from the config
+			pc.PresharedKey = &wgtypes.Key{}
+		}
+		desired[pc.PublicKey] = true
+		wgCfg.Peers = append(wgCfg.Peers, pc)
+	}
+	for _, p := range dev.Peers {
+		if !desired[p.PublicKey] {
+			wgCfg.Peers = append(wgCfg.Peers, wgtypes.PeerConfig{PublicKey: p.PublicKey, Remove: true})
+		}
+	}
+	if err := client.ConfigureDevice(d.name, wgCfg); err != nil {
+		return fmt.Errorf("failed to configure %s: %w", d.name, err)
+	}
+
+	return d.syncLink(cfg)
+}
+
+// syncLink makes the interface addresses and MTU match the config
+func (d *netlinkDevice) syncLink(cfg *DeviceConfig) error {
+	link, err := netlink.LinkByName(d.name)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %w", d.name, err)
+	}
+
+	if cfg.MTU != 0 && link.Attrs().MTU != cfg.MTU {
+		if err := netlink.LinkSetMTU(link, cfg.MTU); err != nil {
+			return fmt.Errorf("failed to set MTU on %s: %w", d.name, err)
+		}
+	}
+
+	wanted := make(map[string]bool, len(cfg.Addresses))
+	for _, address := range cfg.Addresses {
+		addr, err := netlink.ParseAddr(address)
+		if err != nil {
+			return fmt.Errorf("invalid address %q: %w", address, err)
+		}
+		wanted[addr.IPNet.String()] = true
+		if err := netlink.AddrReplace(link, addr); err != nil {
+			return fmt.Errorf("failed to add address %s: %w", address, err)
+		}
+	}
+
+	current, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list addresses of %s: %w", d.name, err)
+	}
+	for _, addr := range current {
+		if wanted[addr.IPNet.String()] || addr.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if err := netlink.AddrDel(link, &addr); err != nil {
+			return fmt.Errorf("failed to remove address %s: %w", addr.IPNet, err)
+		}
+	}
+	return nil
+}
+
+func (d *netlinkDevice) exists() bool {
+	_, err := netlink.LinkByName(d.name)
+	return err == nil
+}
+
+func (d *netlinkDevice) configurePeers(peers []PeerConfig) error {
+	client, err := d.wg()
+	if err != nil {
+		return err
+	}
+
+	cfg := wgtypes.Config{}
+	for _, p := range peers {
+		pc, err := toPeerConfig(p)
+		if err != nil {
+			return err
+		}
+		cfg.Peers = append(cfg.Peers, pc)
+	}
+	return client.ConfigureDevice(d.name, cfg)
+}
+
+func (d *netlinkDevice) removePeers(publicKeys []string) error {
+	client, err := d.wg()
+	if err != nil {
+		return err
+	}
+
+	cfg := wgtypes.Config{}
+	for _, k := range publicKeys {
+		key, err := wgtypes.ParseKey(k)
+		if err != nil {
+			return fmt.Errorf("invalid public key %q: %w", k, err)
+		}
+		cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{PublicKey: key, Remove: true})
+	}
+	return client.ConfigureDevice(d.name, cfg)
+}
+
+func (d *netlinkDevice) peers() (map[string]PeerStatus, error) {
+	client, err := d.wg()
+	if err != nil {
+		return nil, err
+	}
+
+	dev, err := client.Device(d.name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", d.name, err)
+	}
+
+	peers := make(map[string]PeerStatus, len(dev.Peers))
+	for _, p := range dev.Peers {
+		status := PeerStatus{
+			PublicKey:  p.PublicKey.String(),
+			TransferRx: p.ReceiveBytes,
+			TransferTx: p.TransmitBytes,
+		}
+		if p.Endpoint != nil {
+			status.Endpoint = p.Endpoint.String()
+		}
+		if p.PresharedKey != (wgtypes.Key{}) {
+			status.PresharedKey = p.PresharedKey.String()
+		}
+		ips := make([]string, 0, len(p.AllowedIPs))
+		for _, ipNet := range p.AllowedIPs {
+			ips = append(ips, ipNet.String())
+		}
+		status.AllowedIPs = strings.Join(ips, ",")
+		if !p.LastHandshakeTime.IsZero() {
+			status.LatestHandshakeTime = p.LastHandshakeTime.Unix()
+		}
+		peers[status.PublicKey] = status
+	}
+	return peers, nil
+}
+
+// runHooks runs wg-quick style hook commands, substituting %i with the interface name
+func (d *netlinkDevice) runHooks(hooks []string) error {
+	for _, hook := range hooks {
+		command := strings.ReplaceAll(hook, "%i", d.name)
+		output, err := exec.Command("sh", "-c", command).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("hook %q failed: %s: %w", command, strings.TrimSpace(string(output)), err)
+		}
+	}
+	return nil
+}
+
+// readDeviceConfig reads and parses a wg-quick config file
+func readDeviceConfig(path string) (*DeviceConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	cfg, err := ParseDeviceConfig(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// toPeerConfig converts a PeerConfig to its wgctrl form. Allowed IPs replace
+// the peer's current list, matching `wg set ... allowed-ips`.
+func toPeerConfig(p PeerConfig) (wgtypes.PeerConfig, error) {
+	key, err := wgtypes.ParseKey(p.PublicKey)
+	if err != nil {
+		return wgtypes.PeerConfig{}, fmt.Errorf("invalid public key %q: %w", p.PublicKey, err)
+	}
+	pc := wgtypes.PeerConfig{PublicKey: key}
+
+	if p.PresharedKey != "" {
+		psk, err := wgtypes.ParseKey(p.PresharedKey)
+		if err != nil {
+			return wgtypes.PeerConfig{}, fmt.Errorf("invalid preshared key for %s: %w", p.PublicKey, err)
+		}
+		pc.PresharedKey = &psk
+	} else if p.ClearPresharedKey {
+		pc.PresharedKey = &wgtypes.Key{}
+	}
+
+	if p.AllowedIPs != "" {
+		pc.ReplaceAllowedIPs = true
+		for _, cidr := range splitList(p.AllowedIPs) {
+			if !strings.Contains(cidr, "/") {
+				if strings.Contains(cidr, ":") {
+					cidr += "/128"
+				} else {
+					cidr += "/32"
+				}
+			}
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return wgtypes.PeerConfig{}, fmt.Errorf("invalid allowed IP %q: %w", cidr, err)
+			}
+			pc.AllowedIPs = append(pc.AllowedIPs, *ipNet)
+		}
+	}
+
+	if p.Endpoint != "" {
+		addr, err := net.ResolveUDPAddr("udp", p.Endpoint)
+		if err != nil {
+			return wgtypes.PeerConfig{}, fmt.Errorf("invalid endpoint %q: %w", p.Endpoint, err)
+		}
+		pc.Endpoint = addr
+	}
+
+	if p.PersistentKeepalive > 0 {
+		interval := time.Duration(p.PersistentKeepalive) * time.Second
+		pc.PersistentKeepaliveInterval = &interval
+	}
+
+	return pc, nil
+}
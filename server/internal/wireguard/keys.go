@@ -1,31 +1,73 @@
 package wireguard
 
 import (
-	"bytes"
-	"os/exec"
-	"strings"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/curve25519"
 )
 
-// GenerateKeys generates a new WireGuard private and public key pair using the 'wg' command-line tool.
+// KeyLen is the length in bytes of WireGuard private, public and preshared keys
+const KeyLen = 32
+
+// PrivateKeyPlaceholder stands in for the private key in node configs: the
+// key is generated on the node and never sent to the control plane
+const PrivateKeyPlaceholder = "<PRIVATE_KEY>"
+
+// GenerateKeys generates a new WireGuard private and public key pair
 func GenerateKeys() (privateKey string, publicKey string, err error) {
-	// Generate Private Key
-	cmdGenKey := exec.Command("wg", "genkey")
-	var outPriv bytes.Buffer
-	cmdGenKey.Stdout = &outPriv
-	if err := cmdGenKey.Run(); err != nil {
-		return "", "", err
+	var priv [KeyLen]byte
+	if _, err := rand.Read(priv[:]); err != nil {
+		return "", "", fmt.Errorf("failed to read random bytes: %w", err)
 	}
-	privateKey = strings.TrimSpace(outPriv.String())
-
-	// Generate Public Key
-	cmdPubKey := exec.Command("wg", "pubkey")
-	cmdPubKey.Stdin = strings.NewReader(privateKey)
-	var outPub bytes.Buffer
-	cmdPubKey.Stdout = &outPub
-	if err := cmdPubKey.Run(); err != nil {
+	// Clamp as described in RFC 7748 (same as `wg genkey`)
+	priv[0] &= 248
+	priv[31] = (priv[31] & 127) | 64
+
+	privateKey = base64.StdEncoding.EncodeToString(priv[:])
+	publicKey, err = PublicKeyFromPrivate(privateKey)
+	if err != nil {
 		return "", "", err
 	}
-	publicKey = strings.TrimSpace(outPub.String())
-
 	return privateKey, publicKey, nil
 }
+
+// PublicKeyFromPrivate derives the base64 public key of a base64 private key (like `wg pubkey`)
+func PublicKeyFromPrivate(privateKey string) (string, error) {
+	priv, err := parseKey(privateKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid private key: %w", err)
+	}
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive public key: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(pub), nil
+}
+
+// GeneratePresharedKey generates a random symmetric key (like `wg genpsk`)
+func GeneratePresharedKey() (string, error) {
+	var key [KeyLen]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(key[:]), nil
+}
+
+// ValidKey reports whether s is a base64 encoded 32 byte key
+func ValidKey(s string) bool {
+	_, err := parseKey(s)
+	return err == nil
+}
+
+func parseKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != KeyLen {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeyLen, len(key))
+	}
+	return key, nil
+}
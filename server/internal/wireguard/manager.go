@@ -1,75 +1,182 @@
 package wireguard
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
-	"os/exec"
 	"strings"
+	"sync"
 )
 
+// Supported backends for applying WireGuard configuration
+const (
+	// BackendNetlink talks to the kernel directly over netlink (default)
+	BackendNetlink = "netlink"
+	// BackendCLI shells out to wg, wg-quick and ip
+	BackendCLI = "cli"
+	// BackendSimulated keeps interfaces in memory, for development without root
+	BackendSimulated = "simulated"
+)
+
+// ValidBackend reports whether kind is a supported backend name
+func ValidBackend(kind string) bool {
+	switch kind {
+	case BackendNetlink, BackendCLI, BackendSimulated:
+		return true
+	}
+	return false
+}
+
+// device performs the host-facing operations of a Manager
+type device interface {
+	// check verifies the backend is usable on this host
+	check() error
+	// up creates and configures the interface from the config file
+	up(configPath string) error
+	// sync applies the config file to the running interface without
+	// disturbing sessions of unchanged peers (like `wg syncconf`)
+	sync(configPath string) error
+	// down removes the interface; it is not an error if it doesn't exist
+	down(configPath string) error
+	exists() bool
+	// configurePeers adds or updates peers in a single operation
+	configurePeers(peers []PeerConfig) error
+	// removePeers removes peers in a single operation
+	removePeers(publicKeys []string) error
+	peers() (map[string]PeerStatus, error)
+}
+
+// configLocks serializes read-modify-write cycles on each config file
+var configLocks sync.Map
+
+func lockConfig(path string) (unlock func()) {
+	mu, _ := configLocks.LoadOrStore(path, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
 // Manager controls the WireGuard interface
 type Manager struct {
 	InterfaceName string
 	ConfigPath    string
+	dev           device
 }
 
-// NewManager creates a new WireGuard manager
-func NewManager(interfaceName string) *Manager {
+// NewManager creates a new WireGuard manager using the given backend.
+// An empty backend selects netlink.
+func NewManager(interfaceName, backend string) *Manager {
+	if backend == "" {
+		backend = BackendNetlink
+	}
+
+	var dev device
+	switch backend {
+	case BackendCLI:
+		dev = &cliDevice{name: interfaceName}
+	default:
+		dev = &netlinkDevice{name: interfaceName}
+	}
+
 	return &Manager{
 		InterfaceName: interfaceName,
 		ConfigPath:    fmt.Sprintf("/etc/wireguard/%s.conf", interfaceName),
+		dev:           dev,
 	}
 }
 
-// Init verifies wireguard tools are available
+// Init verifies the backend is available
 func (m *Manager) Init() error {
-	_, err := exec.LookPath("wg")
-	if err != nil {
-		return fmt.Errorf("wg command not found")
-	}
-	return nil
+	return m.dev.check()
 }
 
-// SetupInterface writes the config and brings up the interface
+// SetupInterface writes the interface config and applies it. Peers already in
+// the existing config file are kept when configContent has none. A running
+// interface is updated in place so connected clients are not dropped.
 func (m *Manager) SetupInterface(configContent string) error {
-	// Write config file
-	err := os.WriteFile(m.ConfigPath, []byte(configContent), 0600)
+	cfg, err := ParseDeviceConfig(configContent)
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	unlock := lockConfig(m.ConfigPath)
+	if existing, err := readDeviceConfig(m.ConfigPath); err == nil && len(cfg.Peers) == 0 {
+		cfg.Peers = existing.Peers
+	}
+	err = writeFileAtomic(m.ConfigPath, []byte(RenderDeviceConfig(cfg)), 0600)
+	unlock()
 	if err != nil {
 		return fmt.Errorf("failed to write config: %w", err)
 	}
 
-	// Check if interface exists
-	if m.isInterfaceUp() {
-		// Sync config (restart)
-		// Usually `wg-quick strip wg0 | wg syncconf wg0 /dev/stdin` is better but for MVP we restart
-		m.Down()
+	if m.IsUp() {
+		if err := m.dev.sync(m.ConfigPath); err != nil {
+			return fmt.Errorf("failed to sync %s: %w", m.InterfaceName, err)
+		}
+		return nil
 	}
 
 	return m.Up()
 }
 
-// Up brings the interface up using wg-quick
-func (m *Manager) Up() error {
-	cmd := exec.Command("wg-quick", "up", m.InterfaceName)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("wg-quick up failed: %s: %w", string(output), err)
+// updatePeers persists a peer change to the config file and applies it to
+// the running interface. Without a config file the change is applied live only.
+func (m *Manager) updatePeers(upsert []PeerConfig, remove []string) error {
+	unlock := lockConfig(m.ConfigPath)
+	cfg, err := readDeviceConfig(m.ConfigPath)
+	switch {
+	case err == nil:
+		cfg.mergePeers(upsert, remove)
+		if err := writeFileAtomic(m.ConfigPath, []byte(RenderDeviceConfig(cfg)), 0600); err != nil {
+			unlock()
+			return fmt.Errorf("failed to persist peers: %w", err)
+		}
+	case errors.Is(err, fs.ErrNotExist):
+		fmt.Printf("Warning: %s not found, peer change for %s is not persisted\n", m.ConfigPath, m.InterfaceName)
+	default:
+		unlock()
+		return err
+	}
+	unlock()
+
+	// A stopped interface picks the peers up from the file on Up
+	if !m.IsUp() {
+		return nil
+	}
+	if len(upsert) > 0 {
+		if err := m.dev.configurePeers(upsert); err != nil {
+			return err
+		}
+	}
+	if len(remove) > 0 {
+		if err := m.dev.removePeers(remove); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-// Down brings the interface down
+// Up brings the interface up from its config file. An interface that is
+// already up is left alone.
+func (m *Manager) Up() error {
+	if m.IsUp() {
+		return nil
+	}
+	return m.dev.up(m.ConfigPath)
+}
+
+// Down brings the interface down. An interface that doesn't exist is already down.
 func (m *Manager) Down() error {
-	cmd := exec.Command("wg-quick", "down", m.InterfaceName)
-	// Ignore error if down fails (maybe already down)
-	_ = cmd.Run()
-	return nil
+	if !m.IsUp() {
+		return nil
+	}
+	return m.dev.down(m.ConfigPath)
 }
 
 // CreateServerConfig generates and writes the server configuration (generates new key)
 func (m *Manager) CreateServerConfig(addressCIDR string, port int, peers []string) error {
 	// 1. Generate Private Key
-	privKey, err := m.generatePrivateKey()
+	privKey, _, err := GenerateKeys()
 	if err != nil {
 		return fmt.Errorf("failed to generate private key: %w", err)
 	}
@@ -92,53 +199,119 @@ func (m *Manager) CreateServerConfigWithKey(privateKey string, addressCIDR strin
 	return m.SetupInterface(configContent)
 }
 
-func (m *Manager) generatePrivateKey() (string, error) {
-	cmd := exec.Command("wg", "genkey")
-	output, err := cmd.Output()
+// RemoveNATHooks drops the MASQUERADE commands that older releases put in the
+// PostUp/PostDown hooks, now that egress NAT is managed by the control plane,
+// and sets Table = off. On a running interface it returns the removed PostDown
+// commands (with %i substituted), which the caller runs once so the old NAT
+// rules don't outlive the hooks.
+func (m *Manager) RemoveNATHooks() ([]string, error) {
+	unlock := lockConfig(m.ConfigPath)
+	cfg, err := readDeviceConfig(m.ConfigPath)
 	if err != nil {
-		return "", err
+		unlock()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
 	}
-	return strings.TrimSpace(string(output)), nil
-}
 
-// AddPeer adds a peer to the running interface
-func (m *Manager) AddPeer(publicKey, allowedIPs string) error {
-	// wg set wg0 peer <key> allowed-ips <ips>
-	cmd := exec.Command("wg", "set", m.InterfaceName, "peer", publicKey, "allowed-ips", allowedIPs)
-	output, err := cmd.CombinedOutput()
+	var removedDown []string
+	cfg.PostUp, _ = stripNATCommands(cfg.PostUp)
+	cfg.PostDown, removedDown = stripNATCommands(cfg.PostDown)
+	if len(removedDown) == 0 && cfg.Table == "off" {
+		unlock()
+		return nil, nil
+	}
+	// Routes are managed by the control plane as well
+	cfg.Table = "off"
+	err = writeFileAtomic(m.ConfigPath, []byte(RenderDeviceConfig(cfg)), 0600)
+	unlock()
 	if err != nil {
-		return fmt.Errorf("failed to add peer: %s: %w", string(output), err)
+		return nil, fmt.Errorf("failed to write config: %w", err)
+	}
+
+	if !m.IsUp() {
+		return nil, nil
+	}
+	for i, command := range removedDown {
+		removedDown[i] = strings.ReplaceAll(command, "%i", m.InterfaceName)
+	}
+	return removedDown, nil
+}
+
+// stripNATCommands removes the MASQUERADE commands from `;` separated hooks
+// and returns the kept hooks and the removed commands
+func stripNATCommands(hooks []string) (kept, removed []string) {
+	for _, hook := range hooks {
+		var commands []string
+		for _, command := range strings.Split(hook, ";") {
+			command = strings.TrimSpace(command)
+			if command == "" {
+				continue
+			}
+			if strings.Contains(command, "MASQUERADE") {
+				removed = append(removed, command)
+				continue
+			}
+			commands = append(commands, command)
+		}
+		if len(commands) > 0 {
+			kept = append(kept, strings.Join(commands, "; "))
+		}
+	}
+	return kept, removed
+}
+
+// AddPeer adds a peer to the running interface. presharedKey is optional.
+func (m *Manager) AddPeer(publicKey, allowedIPs, presharedKey string) error {
+	peer := PeerConfig{PublicKey: publicKey, AllowedIPs: allowedIPs, PresharedKey: presharedKey}
+	if err := m.updatePeers([]PeerConfig{peer}, nil); err != nil {
+		return fmt.Errorf("failed to add peer: %w", err)
+	}
+	return nil
+}
+
+// AddPeers adds or updates several peers in one operation
+func (m *Manager) AddPeers(peers []PeerConfig) error {
+	if len(peers) == 0 {
+		return nil
+	}
+	if err := m.updatePeers(peers, nil); err != nil {
+		return fmt.Errorf("failed to add peers: %w", err)
 	}
 	return nil
 }
 
 // UpdatePeerAllowedIPs updates the allowed-ips for an existing peer
 func (m *Manager) UpdatePeerAllowedIPs(publicKey, allowedIPs string) error {
-	// wg set wg0 peer <key> allowed-ips <ips>
-	// This command updates the peer if it exists
-	cmd := exec.Command("wg", "set", m.InterfaceName, "peer", publicKey, "allowed-ips", allowedIPs)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to update peer allowed-ips: %s: %w", string(output), err)
+	if err := m.updatePeers([]PeerConfig{{PublicKey: publicKey, AllowedIPs: allowedIPs}}, nil); err != nil {
+		return fmt.Errorf("failed to update peer allowed-ips: %w", err)
 	}
 	return nil
 }
 
 // RemovePeer removes a peer from the running interface
 func (m *Manager) RemovePeer(publicKey string) error {
-	// wg set wg0 peer <key> remove
-	cmd := exec.Command("wg", "set", m.InterfaceName, "peer", publicKey, "remove")
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to remove peer: %s: %w", string(output), err)
+	if err := m.updatePeers(nil, []string{publicKey}); err != nil {
+		return fmt.Errorf("failed to remove peer: %w", err)
+	}
+	return nil
+}
+
+// RemovePeers removes several peers in one operation
+func (m *Manager) RemovePeers(publicKeys []string) error {
+	if len(publicKeys) == 0 {
+		return nil
+	}
+	if err := m.updatePeers(nil, publicKeys); err != nil {
+		return fmt.Errorf("failed to remove peers: %w", err)
 	}
 	return nil
 }
 
-func (m *Manager) isInterfaceUp() bool {
-	// Simple check using ip link
-	cmd := exec.Command("ip", "link", "show", m.InterfaceName)
-	return cmd.Run() == nil
+// IsUp reports whether the interface exists
+func (m *Manager) IsUp() bool {
+	return m.dev.exists()
 }
 
 // GetPublicKey retrieves the server's public key by deriving it from the private key in the config
@@ -149,7 +322,7 @@ func (m *Manager) GetPublicKey() (string, error) {
 	if err != nil {
 		// Config doesn't exist - generate keys and create minimal config
 		fmt.Printf("[INFO] Config file not found, generating new server keys...\n")
-		privateKey, err := m.generatePrivateKey()
+		privateKey, _, err := GenerateKeys()
 		if err != nil {
 			return "", fmt.Errorf("failed to generate private key: %w", err)
 		}
@@ -164,18 +337,12 @@ func (m *Manager) GetPublicKey() (string, error) {
 			return "", fmt.Errorf("failed to create config directory: %w", err)
 		}
 		
-		if err := os.WriteFile(m.ConfigPath, []byte(configContent), 0600); err != nil {
+		if err := writeFileAtomic(m.ConfigPath, []byte(configContent), 0600); err != nil {
 			return "", fmt.Errorf("failed to write config: %w", err)
 		}
 		
 		// Now derive public key from the new private key
-		cmd := exec.Command("wg", "pubkey")
-		cmd.Stdin = strings.NewReader(privateKey)
-		output, err := cmd.Output()
-		if err != nil {
-			return "", fmt.Errorf("failed to derive public key: %w", err)
-		}
-		return strings.TrimSpace(string(output)), nil
+		return PublicKeyFromPrivate(privateKey)
 	}
 	
 	// 2. Parse existing config for PrivateKey
@@ -184,8 +351,8 @@ func (m *Manager) GetPublicKey() (string, error) {
 	for _, line := range lines {
 		trimmed := strings.TrimSpace(line)
 		if strings.HasPrefix(trimmed, "PrivateKey") {
-			parts := strings.Split(trimmed, "=")
-			if len(parts) >= 2 {
+			parts := strings.SplitN(trimmed, "=", 2)
+			if len(parts) == 2 {
 				privateKey = strings.TrimSpace(parts[1])
 				break
 			}
@@ -197,17 +364,7 @@ func (m *Manager) GetPublicKey() (string, error) {
 	}
 
 	// 3. Derive Public Key
-	cmd := exec.Command("wg", "pubkey")
-	cmd.Stdin = strings.NewReader(privateKey)
-	output, err := cmd.Output()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return "", fmt.Errorf("wg pubkey failed: %v, stderr: %s", err, string(exitErr.Stderr))
-		}
-		return "", fmt.Errorf("failed to derive public key: %w", err)
-	}
-	
-	return strings.TrimSpace(string(output)), nil
+	return PublicKeyFromPrivate(privateKey)
 }
 
 // PeerStatus contains real-time information about a WireGuard peer
@@ -215,6 +372,7 @@ type PeerStatus struct {
 	PublicKey           string
 	Endpoint            string
 	AllowedIPs          string 
+	PresharedKey        string // Empty if the peer has none
 	LatestHandshakeTime int64
 	TransferRx          int64
 	TransferTx          int64
@@ -222,36 +380,5 @@ type PeerStatus struct {
 
 // GetPeers returns the status of all peers on the interface
 func (m *Manager) GetPeers() (map[string]PeerStatus, error) {
-	cmd := exec.Command("wg", "show", m.InterfaceName, "dump")
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, fmt.Errorf("failed to dump wg status: %w", err)
-	}
-
-	peers := make(map[string]PeerStatus)
-	lines := strings.Split(string(output), "\n")
-	
-	for _, line := range lines {
-		fields := strings.Fields(line)
-		// Skip interface config line (usually the first one) or peers with too few fields
-		if len(fields) < 8 {
-			continue
-		}
-
-		status := PeerStatus{
-			PublicKey:  fields[0],
-			Endpoint:   fields[2],
-			AllowedIPs: fields[3],
-		}
-		
-		fmt.Sscanf(fields[4], "%d", &status.LatestHandshakeTime)
-		fmt.Sscanf(fields[5], "%d", &status.TransferRx)
-		fmt.Sscanf(fields[6], "%d", &status.TransferTx)
-
-		peers[status.PublicKey] = status
-	}
-
-	return peers, nil
+	return m.dev.peers()
 }
-
-
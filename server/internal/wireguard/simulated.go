@@ -0,0 +1,265 @@
+package wireguard
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// simRekeyInterval mirrors WireGuard's REKEY_AFTER_TIME
+	simRekeyInterval = 2 * time.Minute
+	// simConnectDelay is how long a new peer takes to "connect"
+	simConnectDelay = 5 * time.Second
+)
+
+// SimulatedBackend is an in-memory Backend for running the control plane
+// without WireGuard, root or a Linux kernel. Peers that are added get a fake
+// endpoint, periodic handshakes and growing transfer counters; a small share
+// of them stays or drops offline so the UI shows every state.
+//
+// State is shared per interface name for the lifetime of the process, so
+// backends created lazily for the same interface see the same peers.
+type SimulatedBackend struct {
+	iface *simInterface
+}
+
+type simInterface struct {
+	mu         sync.Mutex
+	name       string
+	up         bool
+	privateKey string
+	address    string
+	port       int
+	peers      map[string]*simPeer
+}
+
+type simPeer struct {
+	allowedIPs   string
+	presharedKey string
+	endpoint     string
+	addedAt      time.Time
+	handshake    time.Time
+	rx, tx       int64
+	rate         int64 // bytes per second while online
+	online       bool
+	lastTick     time.Time
+}
+
+var (
+	simMu         sync.Mutex
+	simInterfaces = make(map[string]*simInterface)
+)
+
+// NewSimulatedBackend returns the simulated backend for an interface
+func NewSimulatedBackend(interfaceName string) *SimulatedBackend {
+	simMu.Lock()
+	defer simMu.Unlock()
+
+	iface := simInterfaces[interfaceName]
+	if iface == nil {
+		iface = &simInterface{name: interfaceName, peers: make(map[string]*simPeer)}
+		simInterfaces[interfaceName] = iface
+	}
+	return &SimulatedBackend{iface: iface}
+}
+
+// Init always succeeds
+func (b *SimulatedBackend) Init() error {
+	return nil
+}
+
+// Up marks the interface as up
+func (b *SimulatedBackend) Up() error {
+	b.iface.mu.Lock()
+	defer b.iface.mu.Unlock()
+
+	b.iface.up = true
+	return nil
+}
+
+// Down marks the interface as down and drops its peers, like deleting a real interface
+func (b *SimulatedBackend) Down() error {
+	b.iface.mu.Lock()
+	defer b.iface.mu.Unlock()
+
+	b.iface.up = false
+	b.iface.peers = make(map[string]*simPeer)
+	return nil
+}
+
+// IsUp reports whether the interface is up
+func (b *SimulatedBackend) IsUp() bool {
+	b.iface.mu.Lock()
+	defer b.iface.mu.Unlock()
+	return b.iface.up
+}
+
+// CreateServerConfigWithKey stores the interface settings and restarts it
+func (b *SimulatedBackend) CreateServerConfigWithKey(privateKey string, addressCIDR string, port int) error {
+	if !ValidKey(privateKey) {
+		return fmt.Errorf("invalid private key")
+	}
+
+	b.Down()
+
+	b.iface.mu.Lock()
+	b.iface.privateKey = privateKey
+	b.iface.address = addressCIDR
+	b.iface.port = port
+	b.iface.mu.Unlock()
+
+	return b.Up()
+}
+
+// AddPeer adds or updates a peer
+func (b *SimulatedBackend) AddPeer(publicKey, allowedIPs, presharedKey string) error {
+	return b.AddPeers([]PeerConfig{{PublicKey: publicKey, AllowedIPs: allowedIPs, PresharedKey: presharedKey}})
+}
+
+// AddPeers adds or updates several peers
+func (b *SimulatedBackend) AddPeers(peers []PeerConfig) error {
+	for _, p := range peers {
+		if !ValidKey(p.PublicKey) {
+			return fmt.Errorf("invalid public key %q", p.PublicKey)
+		}
+		if p.PresharedKey != "" && !ValidKey(p.PresharedKey) {
+			return fmt.Errorf("invalid preshared key for %s", p.PublicKey)
+		}
+	}
+
+	b.iface.mu.Lock()
+	defer b.iface.mu.Unlock()
+
+	if !b.iface.up {
+		return fmt.Errorf("interface %s is not up", b.iface.name)
+	}
+
+	now := time.Now()
+	for _, p := range peers {
+		if existing, ok := b.iface.peers[p.PublicKey]; ok {
+			if p.AllowedIPs != "" {
+				existing.allowedIPs = p.AllowedIPs
+			}
+			if p.PresharedKey != "" {
+				existing.presharedKey = p.PresharedKey
+			} else if p.ClearPresharedKey {
+				existing.presharedKey = ""
+			}
+			continue
+		}
+
+		seed := simSeed(p.PublicKey)
+		rng := rand.New(rand.NewSource(int64(seed)))
+		b.iface.peers[p.PublicKey] = &simPeer{
+			allowedIPs:   p.AllowedIPs,
+			presharedKey: p.PresharedKey,
+			endpoint:     fmt.Sprintf("203.0.113.%d:%d", seed%254+1, 30000+seed%30000),
+			addedAt:      now,
+			lastTick:     now,
+			rate:         1024 + rng.Int63n(64*1024),
+			// Roughly one in five peers never connects
+			online: seed%5 != 0,
+		}
+	}
+	return nil
+}
+
+// UpdatePeerAllowedIPs replaces a peer's allowed IPs
+func (b *SimulatedBackend) UpdatePeerAllowedIPs(publicKey, allowedIPs string) error {
+	return b.AddPeer(publicKey, allowedIPs, "")
+}
+
+// RemovePeer removes a peer
+func (b *SimulatedBackend) RemovePeer(publicKey string) error {
+	return b.RemovePeers([]string{publicKey})
+}
+
+// RemovePeers removes several peers
+func (b *SimulatedBackend) RemovePeers(publicKeys []string) error {
+	b.iface.mu.Lock()
+	defer b.iface.mu.Unlock()
+
+	for _, key := range publicKeys {
+		delete(b.iface.peers, key)
+	}
+	return nil
+}
+
+// GetPeers advances the simulation and returns peer statuses
+func (b *SimulatedBackend) GetPeers() (map[string]PeerStatus, error) {
+	b.iface.mu.Lock()
+	defer b.iface.mu.Unlock()
+
+	if !b.iface.up {
+		return nil, fmt.Errorf("interface %s is not up", b.iface.name)
+	}
+
+	now := time.Now()
+	peers := make(map[string]PeerStatus, len(b.iface.peers))
+	for key, p := range b.iface.peers {
+		p.tick(now)
+
+		status := PeerStatus{
+			PublicKey:    key,
+			AllowedIPs:   p.allowedIPs,
+			PresharedKey: p.presharedKey,
+			TransferRx:   p.rx,
+			TransferTx:   p.tx,
+		}
+		if !p.handshake.IsZero() {
+			status.Endpoint = p.endpoint
+			status.LatestHandshakeTime = p.handshake.Unix()
+		}
+		peers[key] = status
+	}
+	return peers, nil
+}
+
+// GetPublicKey derives the public key from the stored private key, generating one if needed
+func (b *SimulatedBackend) GetPublicKey() (string, error) {
+	b.iface.mu.Lock()
+	defer b.iface.mu.Unlock()
+
+	if b.iface.privateKey == "" {
+		privateKey, _, err := GenerateKeys()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate private key: %w", err)
+		}
+		b.iface.privateKey = privateKey
+	}
+	return PublicKeyFromPrivate(b.iface.privateKey)
+}
+
+// tick advances a peer's handshake and counters to now
+func (p *simPeer) tick(now time.Time) {
+	elapsed := now.Sub(p.lastTick)
+	p.lastTick = now
+
+	// Occasionally flip online state so peers go offline and come back
+	if rand.Intn(200) == 0 {
+		p.online = !p.online
+	}
+	if !p.online || now.Sub(p.addedAt) < simConnectDelay {
+		return
+	}
+
+	if p.handshake.IsZero() || now.Sub(p.handshake) >= simRekeyInterval {
+		p.handshake = now.Add(-time.Duration(rand.Intn(5)) * time.Second)
+	}
+
+	secs := elapsed.Seconds()
+	jitter := 0.5 + rand.Float64()
+	p.rx += int64(float64(p.rate) * secs * jitter)
+	p.tx += int64(float64(p.rate) * secs * jitter / 3)
+}
+
+// simSeed derives a stable per-peer number from its public key
+func simSeed(publicKey string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(strings.TrimSpace(publicKey)))
+	return h.Sum32()
+}
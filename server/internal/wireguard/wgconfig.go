@@ -0,0 +1,300 @@
+package wireguard
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DeviceConfig is a parsed wg-quick style configuration file
+type DeviceConfig struct {
+	PrivateKey string
+	Addresses  []string // CIDR notation, e.g. 10.99.0.1/24
+	ListenPort int
+	MTU        int
+	DNS        []string // Only used by client configs
+	Table      string   // wg-quick routing table; "off" keeps wg-quick from adding routes
+	PreUp      []string
+	PostUp     []string
+	PreDown    []string
+	PostDown   []string
+	Peers      []PeerConfig
+}
+
+// PeerConfig is the desired configuration of a single peer
+type PeerConfig struct {
+	PublicKey           string
+	PresharedKey        string
+	AllowedIPs          string // Comma separated CIDRs
+	Endpoint            string
+	PersistentKeepalive int
+	// ClearPresharedKey removes the preshared key of an existing peer, which
+	// an empty PresharedKey leaves alone. It is not written to configs.
+	ClearPresharedKey bool
+}
+
+// ParseDeviceConfig parses the content of a wg-quick configuration file.
+// Unknown keys are ignored so files written by other tools can be read.
+func ParseDeviceConfig(content string) (*DeviceConfig, error) {
+	cfg := &DeviceConfig{}
+	var peer *PeerConfig
+	section := ""
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if idx := strings.Index(line, "#"); idx != -1 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			switch section {
+			case "interface":
+			case "peer":
+				cfg.Peers = append(cfg.Peers, PeerConfig{})
+				peer = &cfg.Peers[len(cfg.Peers)-1]
+			default:
+				return nil, fmt.Errorf("line %d: unknown section [%s]", lineNo, section)
+			}
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		switch section {
+		case "interface":
+			switch key {
+			case "privatekey":
+				cfg.PrivateKey = value
+			case "address":
+				cfg.Addresses = append(cfg.Addresses, splitList(value)...)
+			case "listenport":
+				port, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid ListenPort %q", lineNo, value)
+				}
+				cfg.ListenPort = port
+			case "mtu":
+				mtu, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid MTU %q", lineNo, value)
+				}
+				cfg.MTU = mtu
+			case "dns":
+				cfg.DNS = append(cfg.DNS, splitList(value)...)
+			case "table":
+				cfg.Table = value
+			case "preup":
+				cfg.PreUp = append(cfg.PreUp, value)
+			case "postup":
+				cfg.PostUp = append(cfg.PostUp, value)
+			case "predown":
+				cfg.PreDown = append(cfg.PreDown, value)
+			case "postdown":
+				cfg.PostDown = append(cfg.PostDown, value)
+			}
+		case "peer":
+			switch key {
+			case "publickey":
+				peer.PublicKey = value
+			case "presharedkey":
+				peer.PresharedKey = value
+			case "allowedips":
+				ips := splitList(value)
+				if peer.AllowedIPs != "" {
+					ips = append([]string{peer.AllowedIPs}, ips...)
+				}
+				peer.AllowedIPs = strings.Join(ips, ",")
+			case "endpoint":
+				peer.Endpoint = value
+			case "persistentkeepalive":
+				if value == "off" {
+					continue
+				}
+				keepalive, err := strconv.Atoi(value)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid PersistentKeepalive %q", lineNo, value)
+				}
+				peer.PersistentKeepalive = keepalive
+			}
+		default:
+			return nil, fmt.Errorf("line %d: key outside of a section", lineNo)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, p := range cfg.Peers {
+		if p.PublicKey == "" {
+			return nil, fmt.Errorf("peer %d has no PublicKey", i+1)
+		}
+	}
+	return cfg, nil
+}
+
+// splitList splits a comma separated config value and drops empty entries
+func splitList(value string) []string {
+	var out []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// RenderDeviceConfig renders a DeviceConfig as a wg-quick configuration file.
+// SaveConfig is always off: the control plane owns the file.
+func RenderDeviceConfig(cfg *DeviceConfig) string {
+	var sb strings.Builder
+
+	sb.WriteString("[Interface]\n")
+	if cfg.PrivateKey != "" {
+		sb.WriteString(fmt.Sprintf("PrivateKey = %s\n", cfg.PrivateKey))
+	}
+	if len(cfg.Addresses) > 0 {
+		sb.WriteString(fmt.Sprintf("Address = %s\n", strings.Join(cfg.Addresses, ", ")))
+	}
+	if cfg.ListenPort != 0 {
+		sb.WriteString(fmt.Sprintf("ListenPort = %d\n", cfg.ListenPort))
+	}
+	if cfg.MTU != 0 {
+		sb.WriteString(fmt.Sprintf("MTU = %d\n", cfg.MTU))
+	}
+	if len(cfg.DNS) > 0 {
+		sb.WriteString(fmt.Sprintf("DNS = %s\n", strings.Join(cfg.DNS, ", ")))
+	}
+	if cfg.Table != "" {
+		sb.WriteString(fmt.Sprintf("Table = %s\n", cfg.Table))
+	}
+	sb.WriteString("SaveConfig = false\n")
+	for _, hook := range cfg.PreUp {
+		sb.WriteString(fmt.Sprintf("PreUp = %s\n", hook))
+	}
+	for _, hook := range cfg.PostUp {
+		sb.WriteString(fmt.Sprintf("PostUp = %s\n", hook))
+	}
+	for _, hook := range cfg.PreDown {
+		sb.WriteString(fmt.Sprintf("PreDown = %s\n", hook))
+	}
+	for _, hook := range cfg.PostDown {
+		sb.WriteString(fmt.Sprintf("PostDown = %s\n", hook))
+	}
+
+	for _, p := range cfg.Peers {
+		sb.WriteString("\n[Peer]\n")
+		sb.WriteString(fmt.Sprintf("PublicKey = %s\n", p.PublicKey))
+		if p.PresharedKey != "" {
+			sb.WriteString(fmt.Sprintf("PresharedKey = %s\n", p.PresharedKey))
+		}
+		if p.AllowedIPs != "" {
+			sb.WriteString(fmt.Sprintf("AllowedIPs = %s\n", strings.Join(splitList(p.AllowedIPs), ", ")))
+		}
+		if p.Endpoint != "" {
+			sb.WriteString(fmt.Sprintf("Endpoint = %s\n", p.Endpoint))
+		}
+		if p.PersistentKeepalive > 0 {
+			sb.WriteString(fmt.Sprintf("PersistentKeepalive = %d\n", p.PersistentKeepalive))
+		}
+	}
+
+	return sb.String()
+}
+
+// mergePeers applies upserts and removals to cfg.Peers. Empty fields of an
+// upsert leave the existing value unchanged, like `wg set`.
+func (cfg *DeviceConfig) mergePeers(upsert []PeerConfig, remove []string) {
+	removed := make(map[string]bool, len(remove))
+	for _, key := range remove {
+		removed[key] = true
+	}
+
+	index := make(map[string]int, len(cfg.Peers))
+	peers := cfg.Peers[:0]
+	for _, p := range cfg.Peers {
+		if removed[p.PublicKey] {
+			continue
+		}
+		index[p.PublicKey] = len(peers)
+		peers = append(peers, p)
+	}
+
+	for _, u := range upsert {
+		i, ok := index[u.PublicKey]
+		if !ok {
+			index[u.PublicKey] = len(peers)
+			peers = append(peers, u)
+			continue
+		}
+		p := &peers[i]
+		if u.AllowedIPs != "" {
+			p.AllowedIPs = u.AllowedIPs
+		}
+		if u.PresharedKey != "" {
+			p.PresharedKey = u.PresharedKey
+		} else if u.ClearPresharedKey {
+			p.PresharedKey = ""
+		}
+		if u.Endpoint != "" {
+			p.Endpoint = u.Endpoint
+		}
+		if u.PersistentKeepalive > 0 {
+			p.PersistentKeepalive = u.PersistentKeepalive
+		}
+	}
+	cfg.Peers = peers
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it over path, so readers never see a partially written config
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp) // No-op after a successful rename
+
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package wireguard
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDeviceConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    *DeviceConfig
+		wantErr string
+	}{
+		{
+			name: "server config",
+			content: `[Interface]
+PrivateKey = server-private
+Address = 10.99.0.1/24, fd00::1/64
+ListenPort = 51820
+MTU = 1420
+Table = off
+SaveConfig = false
+PostUp = iptables -A FORWARD -i %i -j ACCEPT
+
+[Peer]
+# laptop
+PublicKey = peer-a
+PresharedKey = psk-a
+AllowedIPs = 10.99.0.2/32
+AllowedIPs = fd00::2/128
+PersistentKeepalive = 25
+
+[Peer]
+PublicKey = peer-b
+AllowedIPs = 10.99.0.3/32 # phone
+Endpoint = 203.0.113.7:51820
+PersistentKeepalive = off
+`,
+			want: &DeviceConfig{
+				PrivateKey: "server-private",
+				Addresses:  []string{"10.99.0.1/24", "fd00::1/64"},
+				ListenPort: 51820,
+				MTU:        1420,
+				Table:      "off",
+				PostUp:     []string{"iptables -A FORWARD -i %i -j ACCEPT"},
+				Peers: []PeerConfig{
+					{PublicKey: "peer-a", PresharedKey: "psk-a", AllowedIPs: "10.99.0.2/32,fd00::2/128", PersistentKeepalive: 25},
+					{PublicKey: "peer-b", AllowedIPs: "10.99.0.3/32", Endpoint: "203.0.113.7:51820"},
+				},
+			},
+		},
+		{
+			name:    "keys are case insensitive and unknown keys ignored",
+			content: "[interface]\nprivatekey = k\nFwMark = 51820\ndns = 1.1.1.1,, 8.8.8.8\n",
+			want:    &DeviceConfig{PrivateKey: "k", DNS: []string{"1.1.1.1", "8.8.8.8"}},
+		},
+		{
+			name:    "unknown section",
+			content: "[Interface]\n[Wat]\n",
+			wantErr: "line 2: unknown section [wat]",
+		},
+		{
+			name:    "key outside of a section",
+			content: "PrivateKey = k\n",
+			wantErr: "line 1: key outside of a section",
+		},
+		{
+			name:    "missing value separator",
+			content: "[Interface]\nPrivateKey\n",
+			wantErr: "line 2: expected key = value",
+		},
+		{
+			name:    "invalid listen port",
+			content: "[Interface]\nListenPort = http\n",
+			wantErr: `line 2: invalid ListenPort "http"`,
+		},
+		{
+			name:    "invalid keepalive",
+			content: "[Interface]\n[Peer]\nPublicKey = p\nPersistentKeepalive = soon\n",
+			wantErr: `line 4: invalid PersistentKeepalive "soon"`,
+		},
+		{
+			name:    "peer without public key",
+			content: "[Interface]\n[Peer]\nAllowedIPs = 10.0.0.2/32\n",
+			wantErr: "peer 1 has no PublicKey",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDeviceConfig(tt.content)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("ParseDeviceConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDeviceConfig() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDeviceConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDeviceConfigRoundTrip(t *testing.T) {
+	cfg := &DeviceConfig{
+		PrivateKey: "server-private",
+		Addresses:  []string{"10.99.0.1/24"},
+		ListenPort: 51820,
+		PostUp:     []string{"sysctl -q -w net.ipv4.ip_forward=1"},
+		Peers: []PeerConfig{
+			{PublicKey: "peer-a", PresharedKey: "psk-a", AllowedIPs: "10.99.0.2/32,fd00::2/128", PersistentKeepalive: 25},
+		},
+	}
+	got, err := ParseDeviceConfig(RenderDeviceConfig(cfg))
+	if err != nil {
+		t.Fatalf("ParseDeviceConfig() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, cfg) {
+		t.Errorf("round trip = %+v, want %+v", got, cfg)
+	}
+}
+
+func TestMergePeers(t *testing.T) {
+	existing := func() []PeerConfig {
+		return []PeerConfig{
+			{PublicKey: "a", PresharedKey: "psk-a", AllowedIPs: "10.0.0.2/32", Endpoint: "198.51.100.1:51820", PersistentKeepalive: 25},
+			{PublicKey: "b", AllowedIPs: "10.0.0.3/32"},
+		}
+	}
+	tests := []struct {
+		name   string
+		upsert []PeerConfig
+		remove []string
+		want   []PeerConfig
+	}{
+		{
+			name: "no changes",
+			want: existing(),
+		},
+		{
+			name:   "empty fields keep existing values",
+			upsert: []PeerConfig{{PublicKey: "a"}},
+			want:   existing(),
+		},
+		{
+			name:   "set fields replace existing values",
+			upsert: []PeerConfig{{PublicKey: "a", AllowedIPs: "10.0.0.9/32", PresharedKey: "psk-new", Endpoint: "198.51.100.2:51820", PersistentKeepalive: 10}},
+			want: []PeerConfig{
+				{PublicKey: "a", PresharedKey: "psk-new", AllowedIPs: "10.0.0.9/32", Endpoint: "198.51.100.2:51820", PersistentKeepalive: 10},
+				{PublicKey: "b", AllowedIPs: "10.0.0.3/32"},
+			},
+		},
+		{
+			name:   "clear preshared key",
+			upsert: []PeerConfig{{PublicKey: "a", ClearPresharedKey: true}},
+			want: []PeerConfig{
+				{PublicKey: "a", AllowedIPs: "10.0.0.2/32", Endpoint: "198.51.100.1:51820", PersistentKeepalive: 25},
+				{PublicKey: "b", AllowedIPs: "10.0.0.3/32"},
+			},
+		},
+		{
+			name:   "new preshared key wins over clearing",
+			upsert: []PeerConfig{{PublicKey: "b", PresharedKey: "psk-b", ClearPresharedKey: true}},
+			want: []PeerConfig{
+				existing()[0],
+				{PublicKey: "b", PresharedKey: "psk-b", AllowedIPs: "10.0.0.3/32"},
+			},
+		},
+		{
+			name:   "new peers are appended",
+			upsert: []PeerConfig{{PublicKey: "c", AllowedIPs: "10.0.0.4/32"}, {PublicKey: "c", Endpoint: "198.51.100.3:51820"}},
+			want: append(existing(),
+				PeerConfig{PublicKey: "c", AllowedIPs: "10.0.0.4/32", Endpoint: "198.51.100.3:51820"}),
+		},
+		{
+			name:   "removal keeps the order of the rest",
+			remove: []string{"a", "unknown"},
+			want:   []PeerConfig{{PublicKey: "b", AllowedIPs: "10.0.0.3/32"}},
+		},
+		{
+			name:   "removed and upserted peer is added again",
+			upsert: []PeerConfig{{PublicKey: "a", AllowedIPs: "10.0.0.8/32"}},
+			remove: []string{"a"},
+			want: []PeerConfig{
+				{PublicKey: "b", AllowedIPs: "10.0.0.3/32"},
+				{PublicKey: "a", AllowedIPs: "10.0.0.8/32"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &DeviceConfig{Peers: existing()}
+			cfg.mergePeers(tt.upsert, tt.remove)
+			if !reflect.DeepEqual(cfg.Peers, tt.want) {
+				t.Errorf("mergePeers() = %+v, want %+v", cfg.Peers, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderDeviceConfigDisablesSaveConfig(t *testing.T) {
+	out := RenderDeviceConfig(&DeviceConfig{PrivateKey: "k"})
+	if !strings.Contains(out, "SaveConfig = false\n") {
+		t.Errorf("RenderDeviceConfig() = %q, want SaveConfig = false", out)
+	}
+}
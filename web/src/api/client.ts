@@ -4,6 +4,7 @@ import type {
   BlockIPRequest,
   ClosePortRequest,
   CreateNetworkForm,
+  CreateNodeForm,
   DeleteRuleRequest,
   Fail2BanLogs,
   Fail2BanStatus,
@@ -19,6 +20,35 @@ import type {
 } from '@/types'
 
 const API_URL = import.meta.env.VITE_API_URL || 'http://localhost:8080'
+
+// Placeholder for the private key in configs rendered by the server
+export const PRIVATE_KEY_PLACEHOLDER = '<PRIVATE_KEY>'
+
+const base64urlToBase64 = (value: string) => {
+  const base64 = value.replace(/-/g, '+').replace(/_/g, '/')
+  return base64 + '='.repeat((4 - (base64.length % 4)) % 4)
+}
+
+// generateKeyPair creates a WireGuard (X25519) keypair in the browser, so the
+// private key never reaches the server. WebCrypto X25519 needs a secure context
+// (HTTPS or localhost) and a recent browser.
+export async function generateKeyPair(): Promise<{ privateKey: string; publicKey: string }> {
+  if (!window.crypto?.subtle) {
+    throw new Error('Key generation needs HTTPS; paste a public key generated on the device')
+  }
+  const pair = (await window.crypto.subtle.generateKey({ name: 'X25519' }, true, [
+    'deriveBits',
+  ])) as CryptoKeyPair
+  const jwk = await window.crypto.subtle.exportKey('jwk', pair.privateKey)
+  const raw = await window.crypto.subtle.exportKey('raw', pair.publicKey)
+  if (!jwk.d) {
+    throw new Error('Failed to export the private key')
+  }
+  return {
+    privateKey: base64urlToBase64(jwk.d),
+    publicKey: btoa(String.fromCharCode(...new Uint8Array(raw))),
+  }
+}
 const API_KEY = import.meta.env.VITE_API_KEY || ''
 
 class ApiClient {
@@ -103,7 +133,11 @@ class ApiClient {
     return res
   }
 
-  async createServerWithConfig(networkId: string, data: any): Promise<any> {
+  // The returned config carries PRIVATE_KEY_PLACEHOLDER instead of the private key
+  async createServerWithConfig(
+    networkId: string,
+    data: CreateNodeForm
+  ): Promise<{ node: Node; config: string }> {
     const { data: res } = await this.client.post(`/networks/${networkId}/servers`, data)
     return res
   }
@@ -115,12 +149,6 @@ class ApiClient {
     return data
   }
 
-  async getNodeQrCode(nodeId: string): Promise<Blob> {
-    const { data } = await this.client.get(`/nodes/${nodeId}/qrcode`, {
-      responseType: 'blob',
-    })
-    return data
-  }
   // User Management
   async updatePassword(username: string, oldPass: string, newPass: string): Promise<void> {
     await this.client.put('/auth/password', {
@@ -426,7 +454,7 @@ export function useDeleteNode() {
 export function useCreateServerWithConfig() {
   const queryClient = useQueryClient()
   return useMutation({
-    mutationFn: (vars: { networkId: string; data: any }) =>
+    mutationFn: (vars: { networkId: string; data: CreateNodeForm }) =>
       api.createServerWithConfig(vars.networkId, vars.data),
     onSuccess: () => {
       queryClient.invalidateQueries({ queryKey: ['nodes'] })
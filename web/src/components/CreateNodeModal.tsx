@@ -6,8 +6,10 @@ import { Button, Input, Modal, Select } from '@/components/ui'
 interface CreateNodeModalProps {
   isOpen: boolean
   onClose: () => void
-  onSubmit: (data: { name: string; expires_at?: string }) => void
+  // Without a public key the caller generates a keypair in the browser
+  onSubmit: (data: { name: string; expires_at?: string; public_key?: string }) => void
   isLoading?: boolean
+  error?: string
 }
 
 const expiryOptions = [
@@ -20,8 +22,15 @@ const expiryOptions = [
 
 type ExpiryValueProp = (typeof expiryOptions)[number]['value']
 
-export const CreateNodeModal = ({ isOpen, onClose, onSubmit, isLoading }: CreateNodeModalProps) => {
+export const CreateNodeModal = ({
+  isOpen,
+  onClose,
+  onSubmit,
+  isLoading,
+  error,
+}: CreateNodeModalProps) => {
   const [name, setName] = useState('')
+  const [publicKey, setPublicKey] = useState('')
   const [expiryType, setExpiryType] = useState<ExpiryValueProp>('forever')
   const [customDate, setCustomDate] = useState('')
 
@@ -41,7 +50,7 @@ export const CreateNodeModal = ({ isOpen, onClose, onSubmit, isLoading }: Create
       expiresAt = new Date(customDate).toISOString()
     }
 
-    onSubmit({ name, expires_at: expiresAt })
+    onSubmit({ name, expires_at: expiresAt, public_key: publicKey.trim() || undefined })
   }
 
   return (
@@ -85,6 +94,19 @@ export const CreateNodeModal = ({ isOpen, onClose, onSubmit, isLoading }: Create
           />
         )}
 
+        <div className="space-y-1">
+          <Input
+            label="Public Key (optional)"
+            placeholder="Output of: wg genkey | tee privatekey | wg pubkey"
+            value={publicKey}
+            onChange={(e) => setPublicKey(e.target.value)}
+          />
+          <p className="text-xs text-gray-500 dark:text-gray-400">
+            Leave empty to generate the keypair in this browser; the private key is never sent
+            to the server and phones scan the QR code shown next.
+          </p>
+        </div>
+
         {expiryType !== 'forever' && expiryType !== 'custom' && (
           <div className="flex items-center gap-2 text-xs text-blue-600 bg-blue-50 dark:bg-blue-900/20 p-2 rounded-lg">
             <Clock className="w-4 h-4" />
@@ -92,6 +114,8 @@ export const CreateNodeModal = ({ isOpen, onClose, onSubmit, isLoading }: Create
           </div>
         )}
 
+        {error && <div className="text-sm text-red-600 dark:text-red-400">{error}</div>}
+
         <div className="flex justify-end gap-3 mt-6">
           <Button variant="secondary" type="button" onClick={onClose}>
             Cancel
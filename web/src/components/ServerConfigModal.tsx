@@ -1,14 +1,18 @@
 import { Box, Check, Copy, Download, Monitor, QrCode as QrIcon, Terminal } from 'lucide-react'
+import QRCode from 'qrcode'
 import type React from 'react'
 import { useCallback, useEffect, useState } from 'react'
-import { api } from '@/api/client'
-import { Button, Modal } from '@/components/ui'
+import { api, PRIVATE_KEY_PLACEHOLDER } from '@/api/client'
+import { Button, Input, Modal } from '@/components/ui'
 
 interface ServerConfigModalProps {
   isOpen: boolean
   onClose: () => void
   nodeId: string
   nodeName: string
+  // Config of a node just created with a keypair made in the browser; the
+  // private key is not stored, so it cannot be fetched again
+  initialConfig?: string
 }
 
 const InstallTab: React.FC<{
@@ -36,11 +40,14 @@ export const ServerConfigModal: React.FC<ServerConfigModalProps> = ({
   onClose,
   nodeId,
   nodeName,
+  initialConfig,
 }) => {
   const [activeTab, setActiveTab] = useState<'general' | 'windows' | 'mac' | 'linux' | 'docker'>(
     'general'
   )
   const [config, setConfig] = useState<string>('')
+  // Private key pasted for a stored config; it stays in this browser
+  const [privateKey, setPrivateKey] = useState('')
   const [qrCodeUrl, setQrCodeUrl] = useState<string>('')
   const [loading, setLoading] = useState(false)
   const [error, setError] = useState('')
@@ -50,35 +57,52 @@ export const ServerConfigModal: React.FC<ServerConfigModalProps> = ({
     setLoading(true)
     setError('')
     try {
-      // Fetch Config Text
-      const text = await api.getNodeConfig(nodeId)
-      setConfig(text)
-
-      // Fetch QR Code as base64 data URL (avoids blob URL security warning)
-      const blob = await api.getNodeQrCode(nodeId)
-      const reader = new FileReader()
-      reader.onloadend = () => {
-        setQrCodeUrl(reader.result as string)
+      if (initialConfig) {
+        setConfig(initialConfig)
+      } else {
+        // Stored configs carry a <PRIVATE_KEY> placeholder
+        setConfig(await api.getNodeConfig(nodeId))
       }
-      reader.readAsDataURL(blob)
     } catch (err) {
       setError(err.message || 'Error loading configuration')
     } finally {
       setLoading(false)
     }
-  }, [nodeId])
+  }, [nodeId, initialConfig])
 
   useEffect(() => {
     if (isOpen && nodeId) {
       loadConfig()
       setActiveTab('general')
+      setPrivateKey('')
     }
   }, [isOpen, nodeId, loadConfig])
 
+  const filledConfig = privateKey.trim()
+    ? config.replace(PRIVATE_KEY_PLACEHOLDER, privateKey.trim())
+    : config
+  const hasPrivateKey = filledConfig !== '' && !filledConfig.includes(PRIVATE_KEY_PLACEHOLDER)
+
+  // The QR code is rendered here, as only the browser has the private key
+  useEffect(() => {
+    if (!hasPrivateKey) {
+      setQrCodeUrl('')
+      return
+    }
+    let cancelled = false
+    QRCode.toDataURL(filledConfig, { errorCorrectionLevel: 'M', width: 256, margin: 1 })
+      .then((url) => !cancelled && setQrCodeUrl(url))
+      // Large mesh configs may not fit in a QR code
+      .catch(() => !cancelled && setQrCodeUrl(''))
+    return () => {
+      cancelled = true
+    }
+  }, [filledConfig, hasPrivateKey])
+
   const handleDownload = () => {
     const element = document.createElement('a')
     // Use data URL instead of blob URL to avoid security warning
-    const base64 = btoa(unescape(encodeURIComponent(config)))
+    const base64 = btoa(unescape(encodeURIComponent(filledConfig)))
     element.href = `data:text/plain;base64,${base64}`
     element.download = `${nodeName.replace(/\s+/g, '-').toLowerCase()}.conf`
     document.body.appendChild(element)
@@ -127,7 +151,7 @@ export const ServerConfigModal: React.FC<ServerConfigModalProps> = ({
                 </label>
                 <button
                   type="button"
-                  onClick={() => copyToClipboard(config)}
+                  onClick={() => copyToClipboard(filledConfig)}
                   className="text-xs flex items-center gap-1 text-primary-600 hover:text-primary-700"
                 >
                   {copied ? <Check className="w-3 h-3" /> : <Copy className="w-3 h-3" />}
@@ -137,7 +161,7 @@ export const ServerConfigModal: React.FC<ServerConfigModalProps> = ({
               <textarea
                 className="w-full h-64 p-3 text-xs font-mono bg-gray-50 dark:bg-gray-900 border border-gray-200 dark:border-gray-700 rounded-lg resize-none focus:ring-2 focus:ring-primary-500 outline-none"
                 readOnly
-                value={config}
+                value={filledConfig}
               />
               <Button
                 onClick={handleDownload}
@@ -157,9 +181,29 @@ export const ServerConfigModal: React.FC<ServerConfigModalProps> = ({
                   <QrIcon className="w-10 h-10 opacity-50" />
                 </div>
               )}
-              <p className="text-xs text-center text-gray-500 dark:text-gray-400">
-                WireGuard tətbiqini açın və skan edin
-              </p>
+              {qrCodeUrl ? (
+                <p className="text-xs text-center text-gray-500 dark:text-gray-400">
+                  Open the WireGuard app and scan the code
+                </p>
+              ) : hasPrivateKey ? (
+                <p className="text-xs text-center text-gray-500 dark:text-gray-400">
+                  This config is too large for a QR code; import the .conf file instead
+                </p>
+              ) : (
+                <div className="w-full space-y-1">
+                  <Input
+                    label="Private Key"
+                    type="password"
+                    placeholder="Private key of the device"
+                    value={privateKey}
+                    onChange={(e) => setPrivateKey(e.target.value)}
+                  />
+                  <p className="text-xs text-gray-500 dark:text-gray-400">
+                    Stored configs carry no private key. Paste it to show the QR code; it stays in
+                    this browser.
+                  </p>
+                </div>
+              )}
             </div>
           </div>
         )
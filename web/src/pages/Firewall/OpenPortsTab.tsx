@@ -135,7 +135,7 @@ export const OpenPortsTab = ({ openPorts, onClosePort, closeLoading }: OpenPorts
           <div>
             <h4 className="font-medium text-blue-700 dark:text-blue-300">Protected Ports</h4>
             <p className="text-sm text-blue-600 dark:text-blue-400 mt-1">
-              SSH (22), WireGuard (51820+) and Admin API ports are protected and cannot be closed.
+              SSH (22), the WireGuard ports of your networks and Admin API ports are protected and cannot be closed.
               Closing these ports could disconnect you from the server.
             </p>
           </div>
@@ -16,6 +16,8 @@ import type React from 'react'
 import { useState } from 'react'
 import { useNavigate, useParams } from 'react-router-dom'
 import {
+  generateKeyPair,
+  PRIVATE_KEY_PLACEHOLDER,
   useCreateServerWithConfig,
   useDeleteNode,
   useNetworks,
@@ -56,6 +58,8 @@ export const NodesPage: React.FC = () => {
   const [showConfigModal, setShowConfigModal] = useState(false)
   const [showCreateModal, setShowCreateModal] = useState(false)
   const [showEditModal, setShowEditModal] = useState(false)
+  const [createdConfig, setCreatedConfig] = useState<string | null>(null)
+  const [createError, setCreateError] = useState('')
 
   if (!networkId) {
     return (
@@ -75,7 +79,25 @@ export const NodesPage: React.FC = () => {
     }
   }
 
-  const handleCreatePeer = async (data: { name: string; expires_at?: string }) => {
+  const handleCreatePeer = async (data: {
+    name: string
+    expires_at?: string
+    public_key?: string
+  }) => {
+    setCreateError('')
+    // The server never generates node keys: without a key from the device the
+    // keypair is made here and the private key only goes into the shown config
+    let keys: { privateKey: string; publicKey: string } | null = null
+    if (!data.public_key) {
+      try {
+        keys = await generateKeyPair()
+      } catch (err) {
+        setCreateError(
+          `Cannot generate a keypair in this browser (${err.message}). Paste a public key generated on the device.`
+        )
+        return
+      }
+    }
     createServer.mutate(
       {
         networkId: networkId || '',
@@ -83,6 +105,7 @@ export const NodesPage: React.FC = () => {
           name: data.name,
           expires_at: data.expires_at,
           labels: { type: 'client' },
+          public_key: keys ? keys.publicKey : data.public_key || '',
         },
       },
       {
@@ -91,9 +114,16 @@ export const NodesPage: React.FC = () => {
           // Show the config download modal for the new node
           if (response.node) {
             setSelectedNode(response.node)
+            setCreatedConfig(
+              keys
+                ? response.config.replace(PRIVATE_KEY_PLACEHOLDER, keys.privateKey)
+                : response.config
+            )
             setShowConfigModal(true)
           }
         },
+        onError: (error: any) =>
+          setCreateError(error?.response?.data?.error || error?.message || 'Failed to create peer'),
       }
     )
   }
@@ -199,15 +229,6 @@ export const NodesPage: React.FC = () => {
       header: 'Config',
       className: 'w-10',
       render: (node: Node) => {
-        // Hide download button for imported nodes (they don't have private keys)
-        const hasPrivateKey = node.labels?.wireguard_private_key
-        if (!hasPrivateKey) {
-          return (
-            <span className="text-xs text-gray-400" title="Config not available for imported nodes">
-              -
-            </span>
-          )
-        }
         return (
           <button
             type="button"
@@ -367,9 +388,13 @@ export const NodesPage: React.FC = () => {
       {/* Peer creation modal */}
       <CreateNodeModal
         isOpen={showCreateModal}
-        onClose={() => setShowCreateModal(false)}
+        onClose={() => {
+          setShowCreateModal(false)
+          setCreateError('')
+        }}
         onSubmit={handleCreatePeer}
         isLoading={createServer.isPending}
+        error={createError}
       />
 
       {/* Config Modal */}
@@ -378,9 +403,11 @@ export const NodesPage: React.FC = () => {
         onClose={() => {
           setShowConfigModal(false)
           setSelectedNode(null)
+          setCreatedConfig(null)
         }}
         nodeId={selectedNode?.id || ''}
         nodeName={selectedNode?.name || ''}
+        initialConfig={createdConfig || undefined}
       />
 
       {/* Edit Node Modal */}
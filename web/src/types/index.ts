@@ -109,6 +109,9 @@ export interface CreateNetworkForm {
 export interface CreateNodeForm {
   name: string
   labels?: Record<string, string>
+  expires_at?: string
+  // Generated on the device or in the browser; the server never creates node keys
+  public_key: string
 }
 
 // System Info types